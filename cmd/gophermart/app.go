@@ -3,8 +3,14 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/agamariel/gofermart/internal/accrual"
@@ -26,20 +32,39 @@ type App struct {
 	dbPool *pgxpool.Pool
 	echo   *echo.Echo
 	worker *services.AccrualWorker
+	// reconciler сверяет балансы пользователей; nil, если RECONCILE_INTERVAL не задан.
+	reconciler *services.ReconcileWorker
+	// jsonLogger пишет структурированные события (медленные запросы, обработка заказов).
+	jsonLogger *slog.Logger
 
 	// Handlers
 	userHandler    *handlers.UserHandler
 	orderHandler   *handlers.OrderHandler
 	balanceHandler *handlers.BalanceHandler
+	healthHandler  *handlers.HealthHandler
+	maintenance    *handlers.MaintenanceHandler
+	tokenTTL       *handlers.TokenExpirationHandler
+	eventsHandler  *handlers.OrderEventsHandler
+
+	// conns - долгоживущие ответы (SSE), которые Shutdown закрывает до остановки сервера.
+	conns *handlers.ConnTracker
 }
 
+// connDrainTimeout ограничивает ожидание завершения долгоживущих ответов при остановке.
+const connDrainTimeout = 5 * time.Second
+
 // NewApp создаёт и инициализирует новое приложение.
 func NewApp(ctx context.Context, cfg *config.Config) (*App, error) {
 	app := &App{
-		cfg: cfg,
+		cfg:        cfg,
+		jsonLogger: slog.New(slog.NewJSONHandler(os.Stderr, nil)),
 	}
+	app.jsonLogger.Info("effective config", slog.Any("config", cfg))
 
-	if err := app.initDatabase(ctx); err != nil {
+	if cfg.Storage == config.StorageMemory {
+		log.Println("WARNING: STORAGE=memory keeps all data in process memory: it is lost on restart. " +
+			"DATABASE_URI and migrations are not used. Use for local development only.")
+	} else if err := app.initDatabase(ctx); err != nil {
 		return nil, fmt.Errorf("failed to initialize database: %w", err)
 	}
 
@@ -47,6 +72,10 @@ func NewApp(ctx context.Context, cfg *config.Config) (*App, error) {
 		return nil, fmt.Errorf("failed to initialize dependencies: %w", err)
 	}
 
+	if cfg.AccrualStartupProbe && cfg.AccrualSystemAddress != "" {
+		probeAccrualSystems(ctx, cfg.AccrualSystemAddress, cfg.AccrualTimeout, log.Default())
+	}
+
 	app.initServer()
 
 	return app, nil
@@ -58,21 +87,34 @@ func (app *App) initDatabase(ctx context.Context) error {
 		return fmt.Errorf("DATABASE_URI is required")
 	}
 
-	// Применение миграций
-	log.Println("Running database migrations...")
 	sqlDB, err := sql.Open("pgx", app.cfg.DatabaseURI)
 	if err != nil {
 		return fmt.Errorf("unable to open database connection: %w", err)
 	}
 	defer sqlDB.Close()
 
-	if err := migrations.Run(sqlDB); err != nil {
-		return fmt.Errorf("failed to run migrations: %w", err)
+	if err := app.prepareSchema(sqlDB); err != nil {
+		return err
+	}
+
+	if app.cfg.LoginCaseInsensitive {
+		if err := migrations.EnsureCaseInsensitiveLogins(ctx, sqlDB); err != nil {
+			return fmt.Errorf("failed to enable case-insensitive logins: %w", err)
+		}
+		log.Println("Case-insensitive logins enabled")
 	}
-	log.Println("Migrations completed successfully")
+
+	storage.SetSlowQueryLog(app.cfg.SlowQueryThreshold, app.jsonLogger)
 
 	// Подключение к базе данных через pgxpool
-	dbPool, err := pgxpool.New(ctx, app.cfg.DatabaseURI)
+	poolCfg, err := app.poolConfig()
+	if err != nil {
+		return err
+	}
+	log.Printf("Database pool settings: max_conns=%d, min_conns=%d, max_conn_lifetime=%s",
+		poolCfg.MaxConns, poolCfg.MinConns, poolCfg.MaxConnLifetime)
+
+	dbPool, err := pgxpool.NewWithConfig(ctx, poolCfg)
 	if err != nil {
 		return fmt.Errorf("unable to connect to database: %w", err)
 	}
@@ -87,65 +129,423 @@ func (app *App) initDatabase(ctx context.Context) error {
 	return nil
 }
 
+// Применение и проверка миграций; подменяются в тестах.
+var (
+	runMigrations = migrations.Run
+	verifySchema  = migrations.Verify
+)
+
+// prepareSchema применяет миграции или, при AUTO_MIGRATE=false, только проверяет,
+// что их уже применила внешняя задача.
+func (app *App) prepareSchema(db *sql.DB) error {
+	if !app.cfg.AutoMigrate {
+		if err := verifySchema(db); err != nil {
+			return fmt.Errorf("database schema check failed: %w", err)
+		}
+		log.Println("Automatic migrations disabled, database schema is up to date")
+		return nil
+	}
+
+	log.Println("Running database migrations...")
+	if err := runMigrations(db); err != nil {
+		return fmt.Errorf("failed to run migrations: %w", err)
+	}
+	log.Println("Migrations completed successfully")
+	return nil
+}
+
+// poolConfig собирает настройки пула соединений из DATABASE_URI и DB_* параметров.
+// Сессии работают в UTC, чтобы NOW() и приведение timestamptz к тексту не зависели
+// от часового пояса сервера БД.
+func (app *App) poolConfig() (*pgxpool.Config, error) {
+	poolCfg, err := pgxpool.ParseConfig(app.cfg.DatabaseURI)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse database config: %w", err)
+	}
+	poolCfg.ConnConfig.RuntimeParams["timezone"] = "UTC"
+	if app.cfg.DBMaxConns > 0 {
+		poolCfg.MaxConns = app.cfg.DBMaxConns
+	}
+	if app.cfg.DBMinConns > 0 && app.cfg.DBMinConns <= poolCfg.MaxConns {
+		poolCfg.MinConns = app.cfg.DBMinConns
+	}
+	if app.cfg.DBMaxConnLifetime > 0 {
+		poolCfg.MaxConnLifetime = app.cfg.DBMaxConnLifetime
+	}
+	if app.cfg.DBTrace {
+		poolCfg.ConnConfig.Tracer = storage.NewQueryTracer(app.jsonLogger)
+		log.Println("Database query tracing enabled")
+	}
+	return poolCfg, nil
+}
+
+// appStorages - хранилища приложения и источник транзакций, в которых они работают.
+type appStorages struct {
+	users       services.UserStorage
+	orders      services.OrderStorage
+	withdrawals services.WithdrawalStorage
+	audit       services.AuditLedger
+	// pinger проверяет готовность хранилища для /health.
+	pinger services.Pinger
+	tx     services.TxBeginner
+}
+
+// newStorages создаёт хранилища, выбранные в STORAGE.
+func (app *App) newStorages() appStorages {
+	opts := []storage.UserStorageOption{storage.WithMaxBalanceUpdate(app.cfg.MaxAccrualPerOrder)}
+	if app.cfg.Storage == config.StorageMemory {
+		users := storage.NewInMemoryUserStorage(opts...)
+		orders := storage.NewInMemoryOrderStorage()
+		return appStorages{
+			users:       users,
+			orders:      orders,
+			withdrawals: storage.NewInMemoryWithdrawalStorage(),
+			audit:       storage.NewInMemoryAuditStorage(users),
+			pinger:      orders,
+			tx:          storage.NewMemoryTxBeginner(),
+		}
+	}
+	orders := storage.NewPostgresOrderStorage(app.dbPool)
+	return appStorages{
+		users:       storage.NewPostgresUserStorage(app.dbPool, opts...),
+		orders:      orders,
+		withdrawals: storage.NewPostgresWithdrawalStorage(app.dbPool),
+		audit:       storage.NewPostgresAuditStorage(app.dbPool),
+		pinger:      orders,
+		tx:          app.dbPool,
+	}
+}
+
 // initDependencies инициализирует все зависимости приложения (storage, services, handlers).
 func (app *App) initDependencies() error {
 	// Storage layer
-	userStorage := storage.NewPostgresUserStorage(app.dbPool)
-	orderStorage := storage.NewPostgresOrderStorage(app.dbPool)
-	withdrawalStorage := storage.NewPostgresWithdrawalStorage(app.dbPool)
+	stores := app.newStorages()
+	userStorage := stores.users
+	orderStorage := stores.orders
+	withdrawalStorage := stores.withdrawals
 
 	// Service layer
-	userService := services.NewUserService(userStorage, app.cfg.JWTSecret, app.cfg.TokenExpiration)
-	orderService := services.NewOrderService(orderStorage)
-	balanceService := services.NewBalanceService(app.dbPool, userStorage, withdrawalStorage)
+	userOpts := []services.UserServiceOption{
+		services.WithTokenOptions(app.tokenOptions()...),
+		services.WithBcryptCost(app.cfg.BcryptCost),
+	}
+	if app.cfg.LoginCaseInsensitive {
+		userOpts = append(userOpts, services.WithCaseInsensitiveLogin())
+	}
+	if app.cfg.LoginMaxAttempts > 0 {
+		userOpts = append(userOpts, services.WithLoginLockout(app.cfg.LoginMaxAttempts, app.cfg.LoginLockDuration))
+	}
+	userService := services.NewUserService(userStorage, app.cfg.JWTSecret, app.cfg.TokenExpiration, userOpts...)
+	orderOpts := []services.OrderServiceOption{services.WithMaxOrdersPerUser(app.cfg.MaxOrdersPerUser)}
+	balanceOpts := []services.BalanceServiceOption{
+		services.WithMaxWithdrawal(app.cfg.MaxWithdrawal),
+		services.WithWithdrawalAudit(stores.audit),
+	}
+	if app.cfg.StrictWithdrawalOrder {
+		balanceOpts = append(balanceOpts, services.WithStrictWithdrawalOrder())
+	}
+	if app.cfg.DisableLuhn {
+		orderOpts = append(orderOpts, services.WithoutOrderLuhnCheck())
+		balanceOpts = append(balanceOpts, services.WithoutWithdrawalLuhnCheck())
+	}
+	orderService := services.NewOrderService(orderStorage, orderOpts...)
+	balanceService := services.NewBalanceService(stores.tx, userStorage, withdrawalStorage, orderStorage, balanceOpts...)
 
 	// Handler layer
-	app.userHandler = handlers.NewUserHandler(userService)
+	cookieCfg, err := handlers.NewCookieConfig(app.cfg.CookieSecure, app.cfg.CookieSameSite, app.cfg.TokenExpiration)
+	if err != nil {
+		return fmt.Errorf("invalid cookie config: %w", err)
+	}
+	app.userHandler = handlers.NewUserHandler(userService, cookieCfg.WithPath(app.cfg.BasePath))
 	app.orderHandler = handlers.NewOrderHandler(orderService)
 	app.balanceHandler = handlers.NewBalanceHandler(balanceService)
+	app.healthHandler = handlers.NewHealthHandler(stores.pinger)
+	app.maintenance = handlers.NewMaintenanceHandler(app.cfg.Maintenance)
+	app.tokenTTL = handlers.NewTokenExpirationHandler(userService)
+	if app.cfg.Maintenance {
+		log.Println("WARNING: starting in maintenance mode: write endpoints return 503")
+	}
+
+	// События изменения статусов заказов: воркер публикует, SSE-handler раздаёт владельцам
+	orderEvents := services.NewOrderEventBroker()
+	app.conns = handlers.NewConnTracker()
+	app.eventsHandler = handlers.NewOrderEventsHandler(orderEvents, app.conns)
 
 	// Воркер начислений
 	if app.cfg.AccrualSystemAddress != "" {
 		log.Printf("Initializing accrual worker with address: %s", app.cfg.AccrualSystemAddress)
-		client := accrual.NewHTTPAccrualClient(app.cfg.AccrualSystemAddress, 5*time.Second)
-		app.worker = services.NewAccrualWorker(app.dbPool, orderStorage, userStorage, client, 5*time.Second, log.Default())
+		clientOpts := []accrual.ClientOption{accrual.WithOrderPath(app.cfg.AccrualOrderPath)}
+		if app.cfg.AccrualDebug {
+			clientOpts = append(clientOpts, accrual.WithDebugLog(app.jsonLogger))
+		}
+		client := newAccrualClient(app.cfg.AccrualSystemAddress, app.cfg.AccrualTimeout, clientOpts...)
+		if app.cfg.AccrualBreakerThreshold > 0 {
+			client = accrual.NewCircuitBreaker(client, app.cfg.AccrualBreakerThreshold, app.cfg.AccrualBreakerCooldown, log.Default())
+		}
+		app.worker = services.NewAccrualWorker(stores.tx, orderStorage, userStorage, client, app.cfg.AccrualPollInterval, log.Default(),
+			services.WithStuckReaper(app.cfg.StuckOrderThreshold, time.Minute),
+			services.WithOrderLogger(app.jsonLogger),
+			services.WithFetchTimeout(app.cfg.AccrualFetchTimeout),
+			services.WithOrderEvents(orderEvents),
+			services.WithMaxAccrual(app.cfg.MaxAccrualPerOrder),
+			services.WithTickJitter(app.cfg.AccrualPollJitter),
+			services.WithAccrualAudit(stores.audit))
 		log.Println("Accrual worker initialized successfully")
 	} else {
 		log.Println("WARNING: AccrualSystemAddress is not configured. Orders will not be processed for accruals!")
 	}
 
+	// Сверка балансов
+	if app.cfg.ReconcileInterval > 0 {
+		reconcileOpts := []services.ReconcileOption{services.WithDiscrepancyLogger(app.jsonLogger)}
+		if app.cfg.ReconcileAutofix {
+			reconcileOpts = append(reconcileOpts, services.WithReconcileAutofix())
+		}
+		app.reconciler = services.NewReconcileWorker(stores.tx, userStorage, orderStorage, withdrawalStorage, stores.audit,
+			app.cfg.ReconcileInterval, log.Default(), reconcileOpts...)
+	}
+
 	return nil
 }
 
 // initServer инициализирует HTTP-сервер и настраивает маршруты.
 func (app *App) initServer() {
 	e := echo.New()
+	e.Validator = handlers.NewRequestValidator()
+	e.HTTPErrorHandler = handlers.NewHTTPErrorHandler(e.DefaultHTTPErrorHandler)
+	e.IPExtractor = ipExtractor(app.cfg.TrustedProxies, log.Default())
+
+	// Таймауты соединений; Load всегда задаёт их положительными (нулевые и отрицательные
+	// значения заменяются значениями по умолчанию), поэтому отключить их нельзя
+	e.Server.ReadTimeout = app.cfg.ServerReadTimeout
+	e.Server.ReadHeaderTimeout = app.cfg.ServerReadHeaderTimeout
+	e.Server.WriteTimeout = app.cfg.ServerWriteTimeout
+	e.Server.IdleTimeout = app.cfg.ServerIdleTimeout
 
 	// Middleware
-	e.Use(middleware.Logger())
+	e.Use(auth.RequestLogger(app.jsonLogger))
 	e.Use(middleware.Recover())
-	e.Use(middleware.Gzip())
-	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-		AllowOrigins: []string{"*"},
-		AllowMethods: []string{echo.GET, echo.POST, echo.PUT, echo.DELETE},
-	}))
+	e.Use(middleware.GzipWithConfig(gzipConfig(app.cfg)))
+	e.Use(middleware.CORSWithConfig(corsConfig(app.cfg)))
 
-	// Публичные маршруты (не требуют аутентификации)
-	e.POST("/api/user/register", app.userHandler.Register)
-	e.POST("/api/user/login", app.userHandler.Login)
-
-	// Защищённые маршруты (требуют аутентификации)
-	protected := e.Group("/api/user")
-	protected.Use(auth.JWTMiddleware(app.cfg.JWTSecret))
-	protected.GET("/balance", app.userHandler.GetBalance)
-	protected.POST("/orders", app.orderHandler.SubmitOrder)
-	protected.GET("/orders", app.orderHandler.GetOrders)
-	protected.POST("/balance/withdraw", app.balanceHandler.Withdraw)
-	protected.GET("/withdrawals", app.balanceHandler.GetWithdrawals)
+	app.registerRoutes(e)
 
 	app.echo = e
 }
 
+// ipExtractor определяет IP клиента. Заголовки X-Forwarded-For и X-Real-IP учитываются,
+// только если соединение пришло от доверенного прокси из trusted (IP или CIDR);
+// без доверенных прокси используется адрес соединения. Некорректные записи пропускаются
+// с предупреждением в logger.
+func ipExtractor(trusted []string, logger *log.Logger) echo.IPExtractor {
+	var ranges []*net.IPNet
+	for _, entry := range trusted {
+		// Отдельный адрес - подсеть из одного адреса
+		if ip := net.ParseIP(entry); ip != nil {
+			if ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			logger.Printf("WARNING: ignoring invalid trusted proxy %q", entry)
+			continue
+		}
+		ranges = append(ranges, ipNet)
+	}
+	if len(ranges) == 0 {
+		return echo.ExtractIPDirect()
+	}
+
+	// Доверяем только перечисленным прокси: встроенное доверие к loopback и частным сетям отключено
+	opts := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, ipNet := range ranges {
+		opts = append(opts, echo.TrustIPRange(ipNet))
+	}
+	fromXFF := echo.ExtractIPFromXFFHeader(opts...)
+	direct := echo.ExtractIPDirect()
+	return func(req *http.Request) string {
+		if req.Header.Get(echo.HeaderXForwardedFor) != "" {
+			return fromXFF(req)
+		}
+		// echo.ExtractIPFromRealIPHeader проверяет доверие к адресу из заголовка, а не к прокси,
+		// поэтому X-Real-IP принимаем сами, только если соединение пришло от доверенного прокси
+		directIP := direct(req)
+		realIP := strings.Trim(req.Header.Get(echo.HeaderXRealIP), "[]")
+		if net.ParseIP(realIP) == nil {
+			return directIP
+		}
+		if peer := net.ParseIP(directIP); peer != nil {
+			for _, ipNet := range ranges {
+				if ipNet.Contains(peer) {
+					return realIP
+				}
+			}
+		}
+		return directIP
+	}
+}
+
+// newAccrualClient создаёт клиент сервиса начислений. Если в address перечислено
+// несколько адресов через запятую, они опрашиваются по порядку с переходом к следующему при сбое.
+func newAccrualClient(address string, timeout time.Duration, opts ...accrual.ClientOption) accrual.AccrualClient {
+	opts = append([]accrual.ClientOption{accrual.WithResponseCache(1024, time.Minute)}, opts...)
+	var clients []accrual.AccrualClient
+	for _, addr := range strings.Split(address, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			clients = append(clients, accrual.NewHTTPAccrualClient(addr, timeout, opts...))
+		}
+	}
+	if len(clients) == 1 {
+		return clients[0]
+	}
+	return accrual.NewMultiAccrualClient(clients...)
+}
+
+// probeAccrualSystems проверяет доступность каждого адреса сервиса начислений и пишет
+// предупреждение о недоступных. Запуск не прерывается: сервис может подняться позже,
+// и воркер начнёт обрабатывать заказы, как только он ответит.
+func probeAccrualSystems(ctx context.Context, address string, timeout time.Duration, logger *log.Logger) {
+	for _, addr := range strings.Split(address, ",") {
+		if addr = strings.TrimSpace(addr); addr == "" {
+			continue
+		}
+		if err := accrual.Probe(ctx, addr, timeout); err != nil {
+			logger.Printf("WARNING: accrual system is unreachable at startup, orders will wait until it is available: %v", err)
+		}
+	}
+}
+
+// requestTimeoutConfig ограничивает обработку запроса временем cfg.RequestTimeout: контекст запроса
+// истекает, и запросы к БД прерываются вместе с ним. Если обработчик вернул ошибку истёкшего
+// контекста, клиент получает 503; отмена самим клиентом остаётся 499. Поток событий заказов
+// открыт, пока клиент подключён, а выгрузки CSV (format=csv) пишутся потоком после заголовка 200:
+// истёкший контекст незаметно оборвал бы файл. Поэтому они не ограничиваются.
+func requestTimeoutConfig(cfg *config.Config) middleware.ContextTimeoutConfig {
+	return middleware.ContextTimeoutConfig{
+		Timeout: cfg.RequestTimeout,
+		Skipper: func(c echo.Context) bool {
+			return strings.HasSuffix(c.Path(), "/orders/events") || c.QueryParam("format") == "csv"
+		},
+		ErrorHandler: func(err error, c echo.Context) error {
+			if errors.Is(err, context.DeadlineExceeded) && errors.Is(c.Request().Context().Err(), context.DeadlineExceeded) {
+				// Без исходной ошибки: иначе обработчик ошибок принял бы её за отмену клиентом (499)
+				return echo.NewHTTPError(http.StatusServiceUnavailable, "request timed out")
+			}
+			return err
+		},
+	}
+}
+
+// gzipMinLength - ответы короче этого размера (например, 204 или небольшой JSON) не сжимаются:
+// выигрыш в трафике не окупает затраты CPU.
+const gzipMinLength = 1024
+
+// gzipConfig строит настройки сжатия ответов. Потоки Server-Sent Events не сжимаются,
+// чтобы события доходили до клиента сразу, а не после заполнения буфера.
+func gzipConfig(cfg *config.Config) middleware.GzipConfig {
+	return middleware.GzipConfig{
+		Level:     cfg.GzipLevel,
+		MinLength: gzipMinLength,
+		Skipper: func(c echo.Context) bool {
+			return strings.Contains(c.Request().Header.Get(echo.HeaderAccept), "text/event-stream")
+		},
+	}
+}
+
+// corsConfig строит настройки CORS. Без явного списка источников разрешены все ("*")
+// без credentials; при заданных источниках middleware возвращает совпавший Origin.
+func corsConfig(cfg *config.Config) middleware.CORSConfig {
+	origins := cfg.CORSAllowedOrigins
+	if len(origins) == 0 {
+		origins = []string{"*"}
+	}
+	methods := cfg.CORSAllowedMethods
+	if len(methods) == 0 {
+		methods = []string{echo.GET, echo.POST, echo.PUT, echo.DELETE}
+	}
+
+	return middleware.CORSConfig{
+		AllowOrigins:     origins,
+		AllowMethods:     methods,
+		AllowCredentials: cfg.CORSAllowCredentials && len(cfg.CORSAllowedOrigins) > 0,
+		// Курсор следующей страницы заказов передаётся в заголовке
+		ExposeHeaders: []string{handlers.HeaderNextCursor, handlers.HeaderETag},
+	}
+}
+
+// tokenOptions возвращает настройки issuer и audience для выпуска и проверки токенов.
+func (app *App) tokenOptions() []auth.TokenOption {
+	return []auth.TokenOption{
+		auth.WithIssuer(app.cfg.JWTIssuer),
+		auth.WithAudience(app.cfg.JWTAudience),
+	}
+}
+
+// adminLogins возвращает логины администраторов в том виде, в котором логины попадают в токен:
+// при LOGIN_CASE_INSENSITIVE они хранятся в нижнем регистре.
+func (app *App) adminLogins() []string {
+	if !app.cfg.LoginCaseInsensitive {
+		return app.cfg.AdminLogins
+	}
+	logins := make([]string, len(app.cfg.AdminLogins))
+	for i, login := range app.cfg.AdminLogins {
+		logins[i] = strings.ToLower(login)
+	}
+	return logins
+}
+
+// registerRoutes регистрирует маршруты API с учётом базового пути.
+func (app *App) registerRoutes(e *echo.Echo) {
+	root := e.Group(app.cfg.BasePath)
+
+	// Проверка готовности: доступность хранилища, через которое работает приложение
+	root.GET("/readyz", app.healthHandler.Ready)
+
+	// В режиме обслуживания маршруты, изменяющие данные, отвечают 503; чтение и вход работают
+	write := app.maintenance.Middleware()
+
+	// Публичные маршруты (не требуют аутентификации)
+	root.POST("/api/user/register", app.userHandler.Register, write)
+	root.POST("/api/user/login", app.userHandler.Login)
+
+	// Защищённые маршруты (требуют аутентификации).
+	// Чтение баланса, заказов и списаний доступно и через HEAD для систем мониторинга:
+	// ответ тот же, тело отбрасывает HTTP-сервер.
+	protected := root.Group("/api/user")
+	protected.Use(auth.JWTMiddleware(app.cfg.JWTSecret, app.cfg.AuthHeaderName, app.tokenOptions()...))
+	if app.cfg.RequestTimeout > 0 {
+		protected.Use(middleware.ContextTimeoutWithConfig(requestTimeoutConfig(app.cfg)))
+	}
+	readMethods := []string{http.MethodGet, http.MethodHead}
+	protected.Match(readMethods, "/balance", app.userHandler.GetBalance)
+	protected.POST("/password", app.userHandler.ChangePassword, write)
+	protected.POST("/deactivate", app.userHandler.Deactivate, write)
+	protected.GET("/balance/summary", app.balanceHandler.GetBalanceSummary)
+	protected.POST("/orders", app.orderHandler.SubmitOrder, write)
+	protected.POST("/orders/validate", app.orderHandler.ValidateOrder)
+	protected.POST("/orders/bulk", app.orderHandler.SubmitOrders, write)
+	protected.Match(readMethods, "/orders", app.orderHandler.GetOrders)
+	protected.GET("/orders/search", app.orderHandler.SearchOrders)
+	protected.GET("/orders/events", app.eventsHandler.Stream)
+	protected.POST("/balance/withdraw", app.balanceHandler.Withdraw, write)
+	protected.Match(readMethods, "/withdrawals", app.balanceHandler.GetWithdrawals)
+
+	// Административные маршруты
+	admin := root.Group("/api/admin")
+	admin.Use(auth.JWTMiddleware(app.cfg.JWTSecret, app.cfg.AuthHeaderName, app.tokenOptions()...))
+	admin.Use(auth.AdminMiddleware(app.adminLogins()))
+	admin.POST("/orders/:number/reprocess", app.orderHandler.ReprocessOrder, write)
+	admin.POST("/users/:id/withdrawals/:number/reverse", app.balanceHandler.ReverseWithdrawal, write)
+	admin.POST("/users/:id/balance/adjust", app.balanceHandler.AdjustBalance, write)
+	admin.GET("/maintenance", app.maintenance.GetStatus)
+	admin.PUT("/maintenance", app.maintenance.SetStatus)
+	admin.GET("/token-expiration", app.tokenTTL.GetExpiration)
+	admin.PUT("/token-expiration", app.tokenTTL.SetExpiration)
+}
+
 // Start запускает приложение.
 func (app *App) Start(ctx context.Context) error {
 	// Запуск воркера начислений
@@ -156,10 +556,15 @@ func (app *App) Start(ctx context.Context) error {
 	} else {
 		log.Println("Accrual worker is not configured")
 	}
+	if app.reconciler != nil {
+		app.reconciler.Start(ctx)
+		log.Printf("Balance reconciliation started, interval %s, autofix %t", app.cfg.ReconcileInterval, app.cfg.ReconcileAutofix)
+	}
 
-	// Запуск сервера
+	// Запуск сервера. ErrServerClosed означает штатную остановку через Shutdown,
+	// любая другая ошибка (например, занятый адрес) возвращается вызывающему.
 	log.Printf("Starting server on %s", app.cfg.RunAddress)
-	if err := app.echo.Start(app.cfg.RunAddress); err != nil {
+	if err := app.echo.Start(app.cfg.RunAddress); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		return fmt.Errorf("server stopped: %w", err)
 	}
 
@@ -170,6 +575,16 @@ func (app *App) Start(ctx context.Context) error {
 func (app *App) Shutdown(ctx context.Context) error {
 	log.Println("Shutting down server...")
 
+	// Потоки SSE не освобождают соединения сами, и без этого echo.Shutdown ждал бы до дедлайна
+	if app.conns != nil {
+		drainCtx, cancel := context.WithTimeout(ctx, connDrainTimeout)
+		err := app.conns.Close(drainCtx)
+		cancel()
+		if err != nil {
+			log.Printf("Long-lived connections did not close in time: %v", err)
+		}
+	}
+
 	if err := app.echo.Shutdown(ctx); err != nil {
 		return fmt.Errorf("failed to shutdown server: %w", err)
 	}
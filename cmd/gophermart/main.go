@@ -6,13 +6,15 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
 	"github.com/agamariel/gofermart/internal/config"
 )
 
 func main() {
 	cfg := config.Load()
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
 	rootCtx, rootCancel := context.WithCancel(context.Background())
 	defer rootCancel()
 
@@ -22,24 +24,33 @@ func main() {
 		log.Fatalf("Failed to initialize application: %v", err)
 	}
 
-	// Запуск сервера в отдельной горутине
+	// Запуск сервера в отдельной горутине; ошибка запуска завершает процесс
+	serverErr := make(chan error, 1)
 	go func() {
-		if err := app.Start(rootCtx); err != nil {
-			log.Printf("Server error: %v", err)
-		}
+		serverErr <- app.Start(rootCtx)
 	}()
 
 	// Graceful shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+
+	exitCode := 0
+	select {
+	case <-quit:
+	case err := <-serverErr:
+		if err != nil {
+			log.Printf("Server error: %v", err)
+			exitCode = 1
+		}
+	}
 	rootCancel()
 
-	// Остановка приложения
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	// Остановка приложения: один дедлайн на остановку сервера и всех зависимостей
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 	defer cancel()
 
 	if err := app.Shutdown(ctx); err != nil {
 		log.Fatal(err)
 	}
+	os.Exit(exitCode)
 }
@@ -0,0 +1,159 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen возвращается, пока breaker разомкнут и запросы к сервису не выполняются.
+var ErrCircuitOpen = errors.New("accrual circuit breaker is open")
+
+// BreakerState - состояние circuit breaker.
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// CircuitBreaker оборачивает AccrualClient и перестаёт обращаться к сервису
+// после threshold подряд неудачных запросов. Через cooldown пропускается один
+// пробный запрос: при успехе breaker замыкается, при ошибке снова размыкается.
+type CircuitBreaker struct {
+	client    AccrualClient
+	threshold int
+	cooldown  time.Duration
+	logger    *log.Logger
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+
+	nowFunc func() time.Time
+}
+
+// NewCircuitBreaker создаёт breaker вокруг client.
+func NewCircuitBreaker(client AccrualClient, threshold int, cooldown time.Duration, logger *log.Logger) *CircuitBreaker {
+	if threshold <= 0 {
+		threshold = 1
+	}
+	if logger == nil {
+		logger = log.Default()
+	}
+	return &CircuitBreaker{
+		client:    client,
+		threshold: threshold,
+		cooldown:  cooldown,
+		logger:    logger,
+		nowFunc:   time.Now,
+	}
+}
+
+// GetOrderAccrual выполняет запрос, если breaker это разрешает.
+func (b *CircuitBreaker) GetOrderAccrual(ctx context.Context, orderNumber string) (*AccrualResponse, error) {
+	if !b.allow() {
+		return nil, ErrCircuitOpen
+	}
+
+	resp, err := b.client.GetOrderAccrual(ctx, orderNumber)
+	b.record(isBreakerFailure(err))
+	return resp, err
+}
+
+// State возвращает текущее состояние breaker.
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// allow решает, можно ли выполнить запрос, и переводит breaker в half-open по истечении cooldown.
+func (b *CircuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if b.nowFunc().Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.setState(BreakerHalfOpen)
+		b.probing = true
+		return true
+	case BreakerHalfOpen:
+		// Пока идёт пробный запрос, остальные не пропускаем
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// record учитывает результат запроса.
+func (b *CircuitBreaker) record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+
+	if !failed {
+		b.failures = 0
+		if b.state != BreakerClosed {
+			b.setState(BreakerClosed)
+		}
+		return
+	}
+
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		b.openedAt = b.nowFunc()
+		if b.state != BreakerOpen {
+			b.setState(BreakerOpen)
+		}
+	}
+}
+
+// setState меняет состояние и логирует переход. Вызывается под mu.
+func (b *CircuitBreaker) setState(state BreakerState) {
+	b.logger.Printf("accrual circuit breaker: %s -> %s (consecutive failures: %d)", b.state, state, b.failures)
+	b.state = state
+}
+
+// isBreakerFailure сообщает, говорит ли ошибка о недоступности сервиса.
+// 204 и 429 - штатные ответы работающего сервиса и отказом не считаются.
+func isBreakerFailure(err error) bool {
+	if err == nil || errors.Is(err, ErrNotFound) {
+		return false
+	}
+	var rl RateLimitError
+	if errors.As(err, &rl) {
+		return false
+	}
+	// Отмена запроса вызывающей стороной не говорит о состоянии сервиса
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	return true
+}
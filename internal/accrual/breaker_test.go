@@ -0,0 +1,119 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+type stubClient struct {
+	err   error
+	calls int
+}
+
+func (s *stubClient) GetOrderAccrual(ctx context.Context, orderNumber string) (*AccrualResponse, error) {
+	s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &AccrualResponse{Order: orderNumber, Status: "PROCESSED"}, nil
+}
+
+func newTestBreaker(client AccrualClient, threshold int, cooldown time.Duration) (*CircuitBreaker, *time.Time) {
+	now := time.Now()
+	b := NewCircuitBreaker(client, threshold, cooldown, log.New(io.Discard, "", 0))
+	b.nowFunc = func() time.Time { return now }
+	return b, &now
+}
+
+func TestCircuitBreaker_TripsAfterConsecutiveFailures(t *testing.T) {
+	ctx := context.Background()
+	stub := &stubClient{err: ErrAccrualServerError}
+	b, _ := newTestBreaker(stub, 3, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		if _, err := b.GetOrderAccrual(ctx, "1"); !errors.Is(err, ErrAccrualServerError) {
+			t.Fatalf("call #%d: expected server error, got %v", i, err)
+		}
+	}
+	if b.State() != BreakerOpen {
+		t.Fatalf("state = %v, want open", b.State())
+	}
+
+	if _, err := b.GetOrderAccrual(ctx, "1"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+	if stub.calls != 3 {
+		t.Errorf("client calls = %d, want 3", stub.calls)
+	}
+}
+
+func TestCircuitBreaker_RecoversAfterCooldown(t *testing.T) {
+	ctx := context.Background()
+	stub := &stubClient{err: ErrAccrualServerError}
+	b, now := newTestBreaker(stub, 2, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		_, _ = b.GetOrderAccrual(ctx, "1")
+	}
+	if b.State() != BreakerOpen {
+		t.Fatalf("state = %v, want open", b.State())
+	}
+
+	// Пробный запрос после cooldown снова неудачен - breaker опять разомкнут
+	*now = now.Add(time.Minute)
+	if _, err := b.GetOrderAccrual(ctx, "1"); !errors.Is(err, ErrAccrualServerError) {
+		t.Fatalf("expected probe to reach client, got %v", err)
+	}
+	if b.State() != BreakerOpen {
+		t.Fatalf("state after failed probe = %v, want open", b.State())
+	}
+	if _, err := b.GetOrderAccrual(ctx, "1"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+
+	// Сервис восстановился: успешная проба замыкает breaker
+	stub.err = nil
+	*now = now.Add(time.Minute)
+	if _, err := b.GetOrderAccrual(ctx, "1"); err != nil {
+		t.Fatalf("expected successful probe, got %v", err)
+	}
+	if b.State() != BreakerClosed {
+		t.Fatalf("state after successful probe = %v, want closed", b.State())
+	}
+}
+
+func TestCircuitBreaker_IgnoresNonFailures(t *testing.T) {
+	ctx := context.Background()
+
+	for _, err := range []error{ErrNotFound, RateLimitError{RetryAfter: time.Second}} {
+		stub := &stubClient{err: err}
+		b, _ := newTestBreaker(stub, 1, time.Minute)
+
+		for i := 0; i < 3; i++ {
+			_, _ = b.GetOrderAccrual(ctx, "1")
+		}
+		if b.State() != BreakerClosed {
+			t.Errorf("%v: state = %v, want closed", err, b.State())
+		}
+	}
+}
+
+func TestCircuitBreaker_SuccessResetsFailures(t *testing.T) {
+	ctx := context.Background()
+	stub := &stubClient{err: ErrAccrualServerError}
+	b, _ := newTestBreaker(stub, 2, time.Minute)
+
+	_, _ = b.GetOrderAccrual(ctx, "1")
+	stub.err = nil
+	_, _ = b.GetOrderAccrual(ctx, "1")
+	stub.err = ErrAccrualServerError
+	_, _ = b.GetOrderAccrual(ctx, "1")
+
+	if b.State() != BreakerClosed {
+		t.Errorf("state = %v, want closed", b.State())
+	}
+}
@@ -0,0 +1,82 @@
+package accrual
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// responseCache - потокобезопасный LRU-кэш ответов сервиса начислений с TTL.
+type responseCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	items   map[string]*list.Element
+	order   *list.List
+	nowFunc func() time.Time
+}
+
+type cacheEntry struct {
+	key       string
+	resp      AccrualResponse
+	expiresAt time.Time
+}
+
+func newResponseCache(size int, ttl time.Duration) *responseCache {
+	return &responseCache{
+		size:    size,
+		ttl:     ttl,
+		items:   make(map[string]*list.Element, size),
+		order:   list.New(),
+		nowFunc: time.Now,
+	}
+}
+
+// get возвращает копию закэшированного ответа, если он есть и не истёк.
+func (c *responseCache) get(key string) (*AccrualResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := el.Value.(*cacheEntry)
+	if c.nowFunc().After(entry.expiresAt) {
+		c.removeElement(el)
+		return nil, false
+	}
+
+	c.order.MoveToFront(el)
+	resp := entry.resp
+	return &resp, true
+}
+
+// put сохраняет ответ, вытесняя самый давно использованный элемент при переполнении.
+func (c *responseCache) put(key string, resp AccrualResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expiresAt := c.nowFunc().Add(c.ttl)
+	if el, ok := c.items[key]; ok {
+		entry := el.Value.(*cacheEntry)
+		entry.resp = resp
+		entry.expiresAt = expiresAt
+		c.order.MoveToFront(el)
+		return
+	}
+
+	el := c.order.PushFront(&cacheEntry{key: key, resp: resp, expiresAt: expiresAt})
+	c.items[key] = el
+
+	for c.order.Len() > c.size {
+		c.removeElement(c.order.Back())
+	}
+}
+
+func (c *responseCache) removeElement(el *list.Element) {
+	entry := el.Value.(*cacheEntry)
+	delete(c.items, entry.key)
+	c.order.Remove(el)
+}
@@ -1,13 +1,18 @@
 package accrual
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/shopspring/decimal"
@@ -16,9 +21,19 @@ import (
 var (
 	ErrNotFound    = errors.New("accrual not found")
 	ErrRateLimited = errors.New("accrual rate limited")
+	// ErrAccrualServerError - сервис начислений вернул 5xx; запрос имеет смысл повторить позже.
+	ErrAccrualServerError = errors.New("accrual server error")
+)
+
+const (
+	// OrderNumberPlaceholder заменяется в шаблоне пути номером заказа.
+	OrderNumberPlaceholder = "{number}"
+	// DefaultOrderPath - путь к заказу в сервисе начислений по умолчанию.
+	DefaultOrderPath = "/api/orders/" + OrderNumberPlaceholder
 )
 
 // RateLimitError содержит паузу, которую рекомендует сервис.
+// Нулевой RetryAfter означает, что сервис не передал (или передал некорректный) Retry-After.
 type RateLimitError struct {
 	RetryAfter time.Duration
 }
@@ -39,43 +54,191 @@ type AccrualClient interface {
 	GetOrderAccrual(ctx context.Context, orderNumber string) (*AccrualResponse, error)
 }
 
+// AttemptHook вызывается после каждой HTTP-попытки с её номером (начиная с 1) и результатом.
+type AttemptHook func(orderNumber string, attempt int, err error)
+
 type HTTPAccrualClient struct {
 	baseURL    string
+	orderPath  string
 	httpClient *http.Client
+	cache      *responseCache
+
+	// Повтор запроса при 5xx и сетевых ошибках: всего maxAttempts попыток с паузой retryDelay.
+	maxAttempts int
+	retryDelay  time.Duration
+	onAttempt   AttemptHook
+
+	// При debug каждый запрос (URL, статус, начало тела ответа) пишется в debugLogger.
+	debug       bool
+	debugLogger *slog.Logger
+}
+
+// debugBodyLimit - сколько байт тела ответа попадает в отладочный журнал.
+const debugBodyLimit = 1024
+
+// ClientOption настраивает HTTPAccrualClient.
+type ClientOption func(*HTTPAccrualClient)
+
+// WithResponseCache включает LRU-кэш ответов со статусом PROCESSED размером size на время ttl.
+// INVALID не кэшируется: заказ в этом статусе можно вернуть в NEW (повторная загрузка
+// владельцем, переобработка администратором), и воркер должен сразу запросить его заново.
+func WithResponseCache(size int, ttl time.Duration) ClientOption {
+	return func(c *HTTPAccrualClient) {
+		if size <= 0 || ttl <= 0 {
+			return
+		}
+		c.cache = newResponseCache(size, ttl)
+	}
+}
+
+// WithRetry включает повтор запроса при 5xx и сетевых ошибках: всего attempts попыток
+// с паузой delay между ними. По умолчанию выполняется одна попытка.
+func WithRetry(attempts int, delay time.Duration) ClientOption {
+	return func(c *HTTPAccrualClient) {
+		if attempts > 1 {
+			c.maxAttempts = attempts
+		}
+		if delay > 0 {
+			c.retryDelay = delay
+		}
+	}
+}
+
+// WithOnAttempt задаёт хук, вызываемый после каждой HTTP-попытки (например, для метрик).
+func WithOnAttempt(hook AttemptHook) ClientOption {
+	return func(c *HTTPAccrualClient) {
+		c.onAttempt = hook
+	}
+}
+
+// WithDebugLog включает отладочный журнал запросов к сервису начислений (ACCRUAL_DEBUG):
+// URL без учётных данных, статус, длительность и тело ответа, обрезанное до debugBodyLimit байт.
+// Заголовки запроса и ответа не пишутся.
+func WithDebugLog(logger *slog.Logger) ClientOption {
+	return func(c *HTTPAccrualClient) {
+		if logger == nil {
+			return
+		}
+		c.debug = true
+		c.debugLogger = logger
+	}
+}
+
+// WithOrderPath задаёт шаблон пути к заказу относительно базового адреса (ACCRUAL_ORDER_PATH),
+// например "/accrual/v2/orders/{number}". Шаблон без OrderNumberPlaceholder игнорируется.
+func WithOrderPath(template string) ClientOption {
+	return func(c *HTTPAccrualClient) {
+		if strings.Contains(template, OrderNumberPlaceholder) {
+			c.orderPath = "/" + strings.TrimPrefix(template, "/")
+		}
+	}
 }
 
 // NewHTTPAccrualClient создаёт HTTP-клиент.
-func NewHTTPAccrualClient(baseURL string, timeout time.Duration) *HTTPAccrualClient {
+func NewHTTPAccrualClient(baseURL string, timeout time.Duration, opts ...ClientOption) *HTTPAccrualClient {
 	if timeout <= 0 {
 		timeout = 5 * time.Second
 	}
-	return &HTTPAccrualClient{
+	c := &HTTPAccrualClient{
 		baseURL: baseURL,
 		httpClient: &http.Client{
 			Timeout: timeout,
 		},
+		orderPath:   DefaultOrderPath,
+		maxAttempts: 1,
+	}
+	for _, opt := range opts {
+		opt(c)
 	}
+	return c
 }
 
 // GetOrderAccrual получает данные по заказу.
 func (c *HTTPAccrualClient) GetOrderAccrual(ctx context.Context, orderNumber string) (*AccrualResponse, error) {
+	if c.cache != nil {
+		if cached, ok := c.cache.get(orderNumber); ok {
+			return cached, nil
+		}
+	}
+
+	resp, err := c.fetchWithRetry(ctx, orderNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.cache != nil && isCacheableStatus(resp.Status) {
+		c.cache.put(orderNumber, *resp)
+	}
+	return resp, nil
+}
+
+// fetchWithRetry выполняет запрос, повторяя его при временных ошибках сервиса.
+func (c *HTTPAccrualClient) fetchWithRetry(ctx context.Context, orderNumber string) (*AccrualResponse, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := c.fetchOrderAccrual(ctx, orderNumber)
+		if c.onAttempt != nil {
+			c.onAttempt(orderNumber, attempt, err)
+		}
+
+		if err == nil || attempt >= c.maxAttempts || !isRetryable(ctx, err) {
+			return resp, err
+		}
+
+		timer := time.NewTimer(c.retryDelay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// isRetryable сообщает, имеет ли смысл повторить запрос сразу же.
+// 204 и 429 не повторяются: первое - штатный ответ, второе обрабатывает вызывающий.
+func isRetryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if errors.Is(err, ErrAccrualServerError) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// fetchOrderAccrual выполняет HTTP-запрос к сервису начислений.
+func (c *HTTPAccrualClient) fetchOrderAccrual(ctx context.Context, orderNumber string) (*AccrualResponse, error) {
 	u, err := url.Parse(c.baseURL)
 	if err != nil {
 		return nil, fmt.Errorf("invalid accrual base url: %w", err)
 	}
-	u.Path = fmt.Sprintf("%s/api/orders/%s", u.Path, orderNumber)
+	u.Path = strings.TrimSuffix(u.Path, "/") + strings.ReplaceAll(c.orderPath, OrderNumberPlaceholder, orderNumber)
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
 	if err != nil {
 		return nil, fmt.Errorf("build request: %w", err)
 	}
 
+	started := time.Now()
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
+		if c.debug {
+			c.debugLogger.Info("accrual request failed",
+				slog.String("url", u.Redacted()),
+				slog.Int64("duration_ms", time.Since(started).Milliseconds()),
+				slog.String("error", err.Error()))
+		}
 		return nil, err
 	}
 	defer resp.Body.Close()
 
+	if c.debug {
+		if err := c.logResponse(resp, u, started); err != nil {
+			return nil, fmt.Errorf("read accrual response: %w", err)
+		}
+	}
+
 	switch resp.StatusCode {
 	case http.StatusOK:
 		var payload AccrualResponse
@@ -88,24 +251,56 @@ func (c *HTTPAccrualClient) GetOrderAccrual(ctx context.Context, orderNumber str
 	case http.StatusTooManyRequests:
 		retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"))
 		return nil, RateLimitError{RetryAfter: retryAfter}
-	case http.StatusInternalServerError:
-		return nil, fmt.Errorf("accrual service error 500")
 	default:
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return nil, fmt.Errorf("%w: status %d", ErrAccrualServerError, resp.StatusCode)
+		}
 		return nil, fmt.Errorf("unexpected accrual status: %d", resp.StatusCode)
 	}
 }
 
+// logResponse пишет ответ в отладочный журнал. Тело читается целиком и подменяется копией,
+// чтобы его можно было разобрать как обычно.
+func (c *HTTPAccrualClient) logResponse(resp *http.Response, u *url.URL, started time.Time) error {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	truncated := len(body) > debugBodyLimit
+	if truncated {
+		body = body[:debugBodyLimit]
+	}
+	c.debugLogger.Info("accrual request",
+		slog.String("url", u.Redacted()),
+		slog.Int("status", resp.StatusCode),
+		slog.Int64("duration_ms", time.Since(started).Milliseconds()),
+		slog.String("body", string(body)),
+		slog.Bool("body_truncated", truncated))
+	return nil
+}
+
+// isCacheableStatus сообщает, что ответ можно кэшировать: расчёт завершён начислением
+// и повторно по этому заказу не запрашивается.
+func isCacheableStatus(status string) bool {
+	return status == "PROCESSED"
+}
+
+// parseRetryAfter разбирает заголовок Retry-After; возвращает 0, если пауза не указана.
 func parseRetryAfter(val string) time.Duration {
 	if val == "" {
-		return 5 * time.Second
+		return 0
 	}
 	// support seconds value
-	if secs, err := strconv.Atoi(val); err == nil {
+	if secs, err := strconv.Atoi(val); err == nil && secs > 0 {
 		return time.Duration(secs) * time.Second
 	}
 	// try http-date
 	if t, err := http.ParseTime(val); err == nil {
-		return time.Until(t)
+		if d := time.Until(t); d > 0 {
+			return d
+		}
 	}
-	return 5 * time.Second
+	return 0
 }
@@ -0,0 +1,338 @@
+package accrual
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newCountingServer(t *testing.T, status string) (*httptest.Server, *int32) {
+	t.Helper()
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		number := strings.TrimPrefix(r.URL.Path, "/api/orders/")
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"order":%q,"status":%q,"accrual":500}`, number, status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func TestHTTPAccrualClient_CachesProcessedStatus(t *testing.T) {
+	srv, calls := newCountingServer(t, "PROCESSED")
+	client := NewHTTPAccrualClient(srv.URL, time.Second, WithResponseCache(10, time.Minute))
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.GetOrderAccrual(context.Background(), "79927398713")
+		if err != nil {
+			t.Fatalf("GetOrderAccrual() error = %v", err)
+		}
+		if resp.Status != "PROCESSED" {
+			t.Fatalf("status = %v, want PROCESSED", resp.Status)
+		}
+	}
+
+	if got := atomic.LoadInt32(calls); got != 1 {
+		t.Errorf("HTTP calls = %d, want 1", got)
+	}
+}
+
+// Промежуточные статусы ещё изменятся, а INVALID не кэшируется, чтобы после сброса
+// заказа в NEW воркер получил свежий ответ.
+func TestHTTPAccrualClient_DoesNotCacheOtherStatuses(t *testing.T) {
+	for _, status := range []string{"REGISTERED", "PROCESSING", "INVALID"} {
+		t.Run(status, func(t *testing.T) {
+			srv, calls := newCountingServer(t, status)
+			client := NewHTTPAccrualClient(srv.URL, time.Second, WithResponseCache(10, time.Minute))
+
+			for i := 0; i < 2; i++ {
+				if _, err := client.GetOrderAccrual(context.Background(), "79927398713"); err != nil {
+					t.Fatalf("GetOrderAccrual() error = %v", err)
+				}
+			}
+
+			if got := atomic.LoadInt32(calls); got != 2 {
+				t.Errorf("HTTP calls = %d, want 2", got)
+			}
+		})
+	}
+}
+
+func TestHTTPAccrualClient_NoCacheByDefault(t *testing.T) {
+	srv, calls := newCountingServer(t, "PROCESSED")
+	client := NewHTTPAccrualClient(srv.URL, time.Second)
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.GetOrderAccrual(context.Background(), "79927398713"); err != nil {
+			t.Fatalf("GetOrderAccrual() error = %v", err)
+		}
+	}
+
+	if got := atomic.LoadInt32(calls); got != 2 {
+		t.Errorf("HTTP calls = %d, want 2", got)
+	}
+}
+
+func TestResponseCache_ExpiryAndEviction(t *testing.T) {
+	now := time.Now()
+	cache := newResponseCache(2, time.Minute)
+	cache.nowFunc = func() time.Time { return now }
+
+	cache.put("1", AccrualResponse{Order: "1", Status: "PROCESSED"})
+	cache.put("2", AccrualResponse{Order: "2", Status: "PROCESSED"})
+	// "1" становится самым свежим, поэтому вытесняется "2"
+	if _, ok := cache.get("1"); !ok {
+		t.Fatal("expected entry 1 in cache")
+	}
+	cache.put("3", AccrualResponse{Order: "3", Status: "INVALID"})
+
+	if _, ok := cache.get("2"); ok {
+		t.Error("entry 2 should have been evicted")
+	}
+	if _, ok := cache.get("3"); !ok {
+		t.Error("expected entry 3 in cache")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, ok := cache.get("1"); ok {
+		t.Error("entry 1 should have expired")
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		name string
+		val  string
+		want time.Duration
+	}{
+		{name: "missing", val: "", want: 0},
+		{name: "seconds", val: "7", want: 7 * time.Second},
+		{name: "garbage", val: "soon", want: 0},
+		{name: "negative", val: "-3", want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRetryAfter(tt.val); got != tt.want {
+				t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.val, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPAccrualClient_ServerErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		retryable bool
+	}{
+		{name: "500", status: http.StatusInternalServerError, retryable: true},
+		{name: "502", status: http.StatusBadGateway, retryable: true},
+		{name: "503", status: http.StatusServiceUnavailable, retryable: true},
+		{name: "400", status: http.StatusBadRequest, retryable: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			client := NewHTTPAccrualClient(srv.URL, time.Second)
+			_, err := client.GetOrderAccrual(context.Background(), "79927398713")
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if got := errors.Is(err, ErrAccrualServerError); got != tt.retryable {
+				t.Errorf("errors.Is(%v, ErrAccrualServerError) = %v, want %v", err, got, tt.retryable)
+			}
+			if tt.retryable && !strings.Contains(err.Error(), fmt.Sprint(tt.status)) {
+				t.Errorf("error %q doesn't contain status %d", err, tt.status)
+			}
+		})
+	}
+}
+
+type attemptRecord struct {
+	attempt int
+	err     error
+}
+
+func newAttemptRecorder() (*[]attemptRecord, AttemptHook) {
+	var records []attemptRecord
+	return &records, func(orderNumber string, attempt int, err error) {
+		records = append(records, attemptRecord{attempt: attempt, err: err})
+	}
+}
+
+func TestHTTPAccrualClient_OnAttempt(t *testing.T) {
+	t.Run("retries server errors until success", func(t *testing.T) {
+		var calls int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&calls, 1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprint(w, `{"order":"79927398713","status":"PROCESSED","accrual":10}`)
+		}))
+		defer srv.Close()
+
+		records, hook := newAttemptRecorder()
+		client := NewHTTPAccrualClient(srv.URL, time.Second, WithRetry(5, time.Millisecond), WithOnAttempt(hook))
+
+		if _, err := client.GetOrderAccrual(context.Background(), "79927398713"); err != nil {
+			t.Fatalf("GetOrderAccrual() error = %v", err)
+		}
+		if len(*records) != 3 {
+			t.Fatalf("hook calls = %d, want 3", len(*records))
+		}
+		for i, r := range *records {
+			if r.attempt != i+1 {
+				t.Errorf("attempt #%d reported as %d", i+1, r.attempt)
+			}
+		}
+		if (*records)[0].err == nil || (*records)[2].err != nil {
+			t.Errorf("unexpected outcomes: %+v", *records)
+		}
+	})
+
+	t.Run("stops after max attempts", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer srv.Close()
+
+		records, hook := newAttemptRecorder()
+		client := NewHTTPAccrualClient(srv.URL, time.Second, WithRetry(3, time.Millisecond), WithOnAttempt(hook))
+
+		if _, err := client.GetOrderAccrual(context.Background(), "79927398713"); !errors.Is(err, ErrAccrualServerError) {
+			t.Fatalf("expected ErrAccrualServerError, got %v", err)
+		}
+		if len(*records) != 3 {
+			t.Errorf("hook calls = %d, want 3", len(*records))
+		}
+	})
+
+	t.Run("no retry for non-retryable responses", func(t *testing.T) {
+		for _, status := range []int{http.StatusNoContent, http.StatusTooManyRequests, http.StatusBadRequest} {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(status)
+			}))
+
+			records, hook := newAttemptRecorder()
+			client := NewHTTPAccrualClient(srv.URL, time.Second, WithRetry(3, time.Millisecond), WithOnAttempt(hook))
+			_, _ = client.GetOrderAccrual(context.Background(), "79927398713")
+			srv.Close()
+
+			if len(*records) != 1 {
+				t.Errorf("status %d: hook calls = %d, want 1", status, len(*records))
+			}
+		}
+	})
+
+	t.Run("single attempt by default and nil hook is safe", func(t *testing.T) {
+		var calls int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer srv.Close()
+
+		client := NewHTTPAccrualClient(srv.URL, time.Second)
+		if _, err := client.GetOrderAccrual(context.Background(), "79927398713"); err == nil {
+			t.Fatal("expected error, got nil")
+		}
+		if got := atomic.LoadInt32(&calls); got != 1 {
+			t.Errorf("HTTP calls = %d, want 1", got)
+		}
+	})
+}
+
+func TestHTTPAccrualClient_DebugLog(t *testing.T) {
+	body := `{"order":"79927398713","status":"PROCESSED","accrual":500,"note":"` + strings.Repeat("x", 2*debugBodyLimit) + `"}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, body)
+	}))
+	defer srv.Close()
+
+	// Учётные данные в адресе сервиса не должны попасть в журнал
+	baseURL := strings.Replace(srv.URL, "http://", "http://user:s3cret@", 1)
+
+	var buf bytes.Buffer
+	client := NewHTTPAccrualClient(baseURL, time.Second, WithDebugLog(slog.New(slog.NewJSONHandler(&buf, nil))))
+
+	resp, err := client.GetOrderAccrual(context.Background(), "79927398713")
+	if err != nil {
+		t.Fatalf("GetOrderAccrual() error = %v", err)
+	}
+	if resp.Status != "PROCESSED" {
+		t.Errorf("status = %v, want PROCESSED", resp.Status)
+	}
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("expected single JSON log entry, got %q: %v", buf.String(), err)
+	}
+	if strings.Contains(buf.String(), "s3cret") {
+		t.Errorf("log entry contains credentials: %s", buf.String())
+	}
+	if url, _ := entry["url"].(string); !strings.HasSuffix(url, "/api/orders/79927398713") {
+		t.Errorf("url = %v, want suffix /api/orders/79927398713", entry["url"])
+	}
+	if entry["status"] != float64(http.StatusOK) {
+		t.Errorf("status = %v, want %d", entry["status"], http.StatusOK)
+	}
+	if logged, _ := entry["body"].(string); len(logged) != debugBodyLimit || !strings.HasPrefix(body, logged) {
+		t.Errorf("body length = %d, want first %d bytes of response", len(logged), debugBodyLimit)
+	}
+	if entry["body_truncated"] != true {
+		t.Errorf("body_truncated = %v, want true", entry["body_truncated"])
+	}
+}
+
+func TestHTTPAccrualClient_OrderPath(t *testing.T) {
+	tests := []struct {
+		name     string
+		basePath string
+		opts     []ClientOption
+		wantPath string
+	}{
+		{name: "default", wantPath: "/api/orders/79927398713"},
+		{name: "custom template", opts: []ClientOption{WithOrderPath("/accrual/v2/orders/{number}")}, wantPath: "/accrual/v2/orders/79927398713"},
+		{name: "placeholder in the middle", opts: []ClientOption{WithOrderPath("v2/{number}/status")}, wantPath: "/v2/79927398713/status"},
+		{name: "base url with path", basePath: "/loyalty/", opts: []ClientOption{WithOrderPath("/accrual/v2/orders/{number}")}, wantPath: "/loyalty/accrual/v2/orders/79927398713"},
+		{name: "template without placeholder is ignored", opts: []ClientOption{WithOrderPath("/accrual/v2/orders")}, wantPath: "/api/orders/79927398713"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotPath string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotPath = r.URL.Path
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprint(w, `{"order":"79927398713","status":"PROCESSED","accrual":500}`)
+			}))
+			defer srv.Close()
+
+			client := NewHTTPAccrualClient(srv.URL+tt.basePath, time.Second, tt.opts...)
+			if _, err := client.GetOrderAccrual(context.Background(), "79927398713"); err != nil {
+				t.Fatalf("GetOrderAccrual() error = %v", err)
+			}
+			if gotPath != tt.wantPath {
+				t.Errorf("request path = %q, want %q", gotPath, tt.wantPath)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// ErrNoEndpoints возвращается MultiAccrualClient без единого клиента.
+var ErrNoEndpoints = errors.New("no accrual endpoints configured")
+
+// MultiAccrualClient опрашивает упорядоченный список сервисов начислений:
+// при сетевой ошибке или 5xx запрос повторяется на следующем сервисе.
+// Ответы 204 и 429 возвращаются сразу - это штатное поведение работающего сервиса.
+type MultiAccrualClient struct {
+	clients []AccrualClient
+}
+
+// NewMultiAccrualClient создаёт клиент; clients перебираются в переданном порядке.
+func NewMultiAccrualClient(clients ...AccrualClient) *MultiAccrualClient {
+	return &MultiAccrualClient{clients: clients}
+}
+
+// GetOrderAccrual запрашивает начисление, переходя к следующему сервису при его недоступности.
+func (m *MultiAccrualClient) GetOrderAccrual(ctx context.Context, orderNumber string) (*AccrualResponse, error) {
+	if len(m.clients) == 0 {
+		return nil, ErrNoEndpoints
+	}
+
+	errs := make([]error, 0, len(m.clients))
+	for i, client := range m.clients {
+		resp, err := client.GetOrderAccrual(ctx, orderNumber)
+		if err == nil || !isFailoverError(err) || ctx.Err() != nil {
+			return resp, err
+		}
+		errs = append(errs, fmt.Errorf("endpoint #%d: %w", i+1, err))
+	}
+
+	return nil, fmt.Errorf("all accrual endpoints failed: %w", errors.Join(errs...))
+}
+
+// isFailoverError сообщает, что сервис недоступен и стоит обратиться к следующему.
+func isFailoverError(err error) bool {
+	if errors.Is(err, ErrAccrualServerError) || errors.Is(err, ErrCircuitOpen) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,98 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMultiAccrualClient_FailsOverToNextEndpoint(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer primary.Close()
+
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"order":"12345678903","status":"PROCESSED","accrual":500}`))
+	}))
+	defer secondary.Close()
+
+	m := NewMultiAccrualClient(
+		NewHTTPAccrualClient(primary.URL, time.Second),
+		NewHTTPAccrualClient(secondary.URL, time.Second),
+	)
+
+	resp, err := m.GetOrderAccrual(context.Background(), "12345678903")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Status != "PROCESSED" || resp.Accrual.IntPart() != 500 {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+}
+
+func TestMultiAccrualClient_FailsOverOnNetworkError(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	downURL := down.URL
+	down.Close()
+
+	secondary := &stubClient{}
+	m := NewMultiAccrualClient(NewHTTPAccrualClient(downURL, time.Second), secondary)
+
+	if _, err := m.GetOrderAccrual(context.Background(), "1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if secondary.calls != 1 {
+		t.Errorf("secondary calls = %d, want 1", secondary.calls)
+	}
+}
+
+func TestMultiAccrualClient_AllEndpointsFail(t *testing.T) {
+	first := &stubClient{err: ErrAccrualServerError}
+	second := &stubClient{err: ErrAccrualServerError}
+	m := NewMultiAccrualClient(first, second)
+
+	_, err := m.GetOrderAccrual(context.Background(), "1")
+	if !errors.Is(err, ErrAccrualServerError) {
+		t.Fatalf("expected ErrAccrualServerError, got %v", err)
+	}
+	if first.calls != 1 || second.calls != 1 {
+		t.Errorf("calls = %d/%d, want 1/1", first.calls, second.calls)
+	}
+}
+
+func TestMultiAccrualClient_NoFailover(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{name: "not found", err: ErrNotFound},
+		{name: "rate limited", err: RateLimitError{RetryAfter: time.Second}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first := &stubClient{err: tt.err}
+			second := &stubClient{}
+			m := NewMultiAccrualClient(first, second)
+
+			_, err := m.GetOrderAccrual(context.Background(), "1")
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+			if second.calls != 0 {
+				t.Errorf("secondary calls = %d, want 0", second.calls)
+			}
+		})
+	}
+}
+
+func TestMultiAccrualClient_NoEndpoints(t *testing.T) {
+	if _, err := NewMultiAccrualClient().GetOrderAccrual(context.Background(), "1"); !errors.Is(err, ErrNoEndpoints) {
+		t.Fatalf("expected ErrNoEndpoints, got %v", err)
+	}
+}
@@ -0,0 +1,44 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Probe проверяет, что сервис начислений по адресу baseURL принимает соединения:
+// выполняет HEAD-запрос к базовому адресу. Любой HTTP-ответ, включая 404 и 405,
+// считается признаком доступности - ошибкой являются только сбои соединения и таймаут.
+func Probe(ctx context.Context, baseURL string, timeout time.Duration) error {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return fmt.Errorf("invalid accrual base url: %w", err)
+	}
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u.String(), nil)
+	if err != nil {
+		return fmt.Errorf("build probe request: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// Учётные данные из адреса не должны попасть в журнал вместе с ошибкой
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = u.Redacted()
+		}
+		return fmt.Errorf("probe accrual system: %w", err)
+	}
+	resp.Body.Close()
+
+	return nil
+}
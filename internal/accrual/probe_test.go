@@ -0,0 +1,60 @@
+package accrual
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProbe(t *testing.T) {
+	t.Run("any HTTP response means reachable", func(t *testing.T) {
+		var method string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			method = r.Method
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer server.Close()
+
+		if err := Probe(context.Background(), server.URL, time.Second); err != nil {
+			t.Fatalf("Probe() error = %v", err)
+		}
+		if method != http.MethodHead {
+			t.Errorf("method = %s, want %s", method, http.MethodHead)
+		}
+	})
+
+	t.Run("closed port", func(t *testing.T) {
+		if err := Probe(context.Background(), closedPortURL(t, "user:secret@"), time.Second); err == nil {
+			t.Fatal("Probe() error = nil, want connection error")
+		} else if strings.Contains(err.Error(), "secret") {
+			t.Errorf("error leaks credentials: %v", err)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		}))
+		defer server.Close()
+
+		if err := Probe(context.Background(), server.URL, 50*time.Millisecond); err == nil {
+			t.Fatal("Probe() error = nil, want timeout")
+		}
+	})
+}
+
+// closedPortURL возвращает адрес порта, на котором гарантированно никто не слушает.
+func closedPortURL(t *testing.T, userinfo string) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	return "http://" + userinfo + addr
+}
@@ -13,6 +13,7 @@ import (
 type Claims struct {
 	UserID uuid.UUID `json:"user_id"`
 	Login  string    `json:"login"`
+	Role   string    `json:"role"`
 	jwt.RegisteredClaims
 }
 
@@ -21,30 +22,86 @@ var (
 	ErrInvalidToken = errors.New("invalid token")
 )
 
+// TokenOption задаёт дополнительные claims токена и их проверку.
+type TokenOption func(*tokenOptions)
+
+type tokenOptions struct {
+	issuer   string
+	audience string
+}
+
+// WithIssuer записывает в токен iss и требует его совпадения при проверке.
+// Пустое значение отключает и запись, и проверку.
+func WithIssuer(issuer string) TokenOption {
+	return func(o *tokenOptions) {
+		o.issuer = issuer
+	}
+}
+
+// WithAudience записывает в токен aud и требует его наличия при проверке.
+// Пустое значение отключает и запись, и проверку.
+func WithAudience(audience string) TokenOption {
+	return func(o *tokenOptions) {
+		o.audience = audience
+	}
+}
+
+func newTokenOptions(opts []TokenOption) tokenOptions {
+	var o tokenOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
 // GenerateToken генерирует JWT токен для пользователя.
-func GenerateToken(user *models.User, secret string, expiration time.Duration) (string, error) {
+// Если роль пользователя не задана, в токен записывается models.RoleUser.
+func GenerateToken(user *models.User, secret string, expiration time.Duration, opts ...TokenOption) (string, error) {
+	o := newTokenOptions(opts)
+
+	role := user.Role
+	if role == "" {
+		role = models.RoleUser
+	}
+
 	claims := Claims{
 		UserID: user.ID,
 		Login:  user.Login,
+		Role:   role,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiration)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    o.issuer,
 		},
 	}
+	if o.audience != "" {
+		claims.Audience = jwt.ClaimStrings{o.audience}
+	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString([]byte(secret))
 }
 
 // ValidateToken валидирует JWT токен и возвращает claims.
-func ValidateToken(tokenString, secret string) (*Claims, error) {
+// Issuer и audience проверяются, только если заданы через опции.
+func ValidateToken(tokenString, secret string, opts ...TokenOption) (*Claims, error) {
+	o := newTokenOptions(opts)
+
+	var parserOpts []jwt.ParserOption
+	if o.issuer != "" {
+		parserOpts = append(parserOpts, jwt.WithIssuer(o.issuer))
+	}
+	if o.audience != "" {
+		parserOpts = append(parserOpts, jwt.WithAudience(o.audience))
+	}
+
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		// Проверка метода подписи
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, ErrInvalidToken
 		}
 		return []byte(secret), nil
-	})
+	}, parserOpts...)
 
 	if err != nil {
 		return nil, err
@@ -56,3 +113,16 @@ func ValidateToken(tokenString, secret string) (*Claims, error) {
 
 	return nil, ErrInvalidToken
 }
+
+// TokenExpiresAt возвращает время истечения токена без проверки подписи.
+// Предназначена для токенов, выпущенных самим сервисом.
+func TokenExpiresAt(tokenString string) (time.Time, error) {
+	claims := &Claims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(tokenString, claims); err != nil {
+		return time.Time{}, err
+	}
+	if claims.ExpiresAt == nil {
+		return time.Time{}, ErrInvalidToken
+	}
+	return claims.ExpiresAt.Time, nil
+}
@@ -326,3 +326,117 @@ func BenchmarkValidateToken(b *testing.B) {
 		_, _ = ValidateToken(token, secret)
 	}
 }
+
+func TestGenerateToken_Role(t *testing.T) {
+	secret := "test-secret"
+
+	tests := []struct {
+		name     string
+		role     string
+		wantRole string
+	}{
+		{name: "default role", role: "", wantRole: models.RoleUser},
+		{name: "user role", role: models.RoleUser, wantRole: models.RoleUser},
+		{name: "admin role", role: models.RoleAdmin, wantRole: models.RoleAdmin},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &models.User{ID: uuid.New(), Login: "user@example.com", Role: tt.role}
+			token, err := GenerateToken(user, secret, time.Hour)
+			if err != nil {
+				t.Fatalf("GenerateToken() error = %v", err)
+			}
+
+			claims, err := ValidateToken(token, secret)
+			if err != nil {
+				t.Fatalf("ValidateToken() error = %v", err)
+			}
+			if claims.Role != tt.wantRole {
+				t.Errorf("Role = %v, want %v", claims.Role, tt.wantRole)
+			}
+		})
+	}
+}
+
+func TestValidateToken_IssuerAudience(t *testing.T) {
+	secret := "test-secret"
+	user := &models.User{ID: uuid.New(), Login: "test@example.com"}
+
+	tests := []struct {
+		name         string
+		generateOpts []TokenOption
+		validateOpts []TokenOption
+		wantErr      bool
+	}{
+		{
+			name:         "matching issuer and audience",
+			generateOpts: []TokenOption{WithIssuer("gophermart"), WithAudience("dashboard")},
+			validateOpts: []TokenOption{WithIssuer("gophermart"), WithAudience("dashboard")},
+		},
+		{
+			name:         "issuer mismatch",
+			generateOpts: []TokenOption{WithIssuer("other-service")},
+			validateOpts: []TokenOption{WithIssuer("gophermart")},
+			wantErr:      true,
+		},
+		{
+			name:         "audience mismatch",
+			generateOpts: []TokenOption{WithAudience("billing")},
+			validateOpts: []TokenOption{WithAudience("dashboard")},
+			wantErr:      true,
+		},
+		{
+			name:         "missing issuer is rejected when required",
+			validateOpts: []TokenOption{WithIssuer("gophermart")},
+			wantErr:      true,
+		},
+		{
+			name:         "missing audience is rejected when required",
+			validateOpts: []TokenOption{WithAudience("dashboard")},
+			wantErr:      true,
+		},
+		{
+			name:         "claims are not checked when unset",
+			generateOpts: []TokenOption{WithIssuer("gophermart"), WithAudience("dashboard")},
+		},
+		{
+			name:         "empty values disable validation",
+			validateOpts: []TokenOption{WithIssuer(""), WithAudience("")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := GenerateToken(user, secret, time.Hour, tt.generateOpts...)
+			if err != nil {
+				t.Fatalf("GenerateToken() error = %v", err)
+			}
+
+			_, err = ValidateToken(token, secret, tt.validateOpts...)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGenerateToken_IssuerAudienceClaims(t *testing.T) {
+	user := &models.User{ID: uuid.New(), Login: "test@example.com"}
+
+	token, err := GenerateToken(user, "test-secret", time.Hour, WithIssuer("gophermart"), WithAudience("dashboard"))
+	if err != nil {
+		t.Fatalf("GenerateToken() error = %v", err)
+	}
+
+	claims, err := ValidateToken(token, "test-secret")
+	if err != nil {
+		t.Fatalf("ValidateToken() error = %v", err)
+	}
+	if claims.Issuer != "gophermart" {
+		t.Errorf("Issuer = %q, want %q", claims.Issuer, "gophermart")
+	}
+	if len(claims.Audience) != 1 || claims.Audience[0] != "dashboard" {
+		t.Errorf("Audience = %v, want [dashboard]", claims.Audience)
+	}
+}
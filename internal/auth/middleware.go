@@ -1,11 +1,15 @@
 package auth
 
 import (
+	"context"
+	"log/slog"
 	"net/http"
 	"strings"
 
+	"github.com/agamariel/gofermart/internal/models"
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
 )
 
 // ContextKey - тип для ключей контекста.
@@ -16,14 +20,22 @@ const (
 	UserIDKey ContextKey = "user_id"
 	// UserLoginKey - ключ для хранения логина пользователя в контексте.
 	UserLoginKey ContextKey = "user_login"
+	// UserRoleKey - ключ для хранения роли пользователя в контексте.
+	UserRoleKey ContextKey = "user_role"
 )
 
 // JWTMiddleware создаёт middleware для проверки JWT токена.
-func JWTMiddleware(secret string) echo.MiddlewareFunc {
+// Токен ищется в заголовке Authorization, затем в альтернативном заголовке altHeader
+// (если задан), затем в cookie. opts задают ожидаемые issuer и audience токена.
+func JWTMiddleware(secret, altHeader string, opts ...TokenOption) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			token := extractTokenFromHeader(c)
 
+			if token == "" && altHeader != "" {
+				token = extractTokenFromAltHeader(c, altHeader)
+			}
+
 			if token == "" {
 				token = extractTokenFromCookie(c)
 			}
@@ -32,7 +44,7 @@ func JWTMiddleware(secret string) echo.MiddlewareFunc {
 				return echo.NewHTTPError(http.StatusUnauthorized, "missing or invalid token")
 			}
 
-			claims, err := ValidateToken(token, secret)
+			claims, err := ValidateToken(token, secret, opts...)
 			if err != nil {
 				return echo.NewHTTPError(http.StatusUnauthorized, "invalid token")
 			}
@@ -40,12 +52,96 @@ func JWTMiddleware(secret string) echo.MiddlewareFunc {
 			// Сохранение данных пользователя в контексте
 			c.Set(string(UserIDKey), claims.UserID)
 			c.Set(string(UserLoginKey), claims.Login)
+			c.Set(string(UserRoleKey), claims.Role)
+
+			return next(c)
+		}
+	}
+}
+
+// RequestLogger пишет в logger запись о каждом запросе: метод, путь, статус, длительность
+// и IP клиента (c.RealIP(), с учётом IPExtractor сервера).
+// Запись делается после обработки запроса, поэтому при глобальном подключении в неё попадает
+// и user_id, сохранённый JWTMiddleware группы маршрутов; для публичных маршрутов поле отсутствует.
+func RequestLogger(logger *slog.Logger) echo.MiddlewareFunc {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
+		LogMethod:   true,
+		LogURI:      true,
+		LogStatus:   true,
+		LogLatency:  true,
+		LogRemoteIP: true,
+		LogError:    true,
+		HandleError: true,
+		LogValuesFunc: func(c echo.Context, v middleware.RequestLoggerValues) error {
+			attrs := []slog.Attr{
+				slog.String("method", v.Method),
+				slog.String("uri", v.URI),
+				slog.Int("status", v.Status),
+				slog.Int64("duration_ms", v.Latency.Milliseconds()),
+				slog.String("remote_ip", v.RemoteIP),
+			}
+			if userID, ok := c.Get(string(UserIDKey)).(uuid.UUID); ok {
+				attrs = append(attrs, slog.String("user_id", userID.String()))
+			}
+			if v.Error != nil {
+				attrs = append(attrs, slog.String("error", v.Error.Error()))
+			}
+			logger.LogAttrs(context.Background(), slog.LevelInfo, "request", attrs...)
+			return nil
+		},
+	})
+}
+
+// RequireRole пропускает только пользователей с ролью role.
+// Должен подключаться после JWTMiddleware.
+func RequireRole(role string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			current, err := GetUserRoleFromContext(c)
+			if err != nil {
+				return err
+			}
+
+			if current != role {
+				return echo.NewHTTPError(http.StatusForbidden, "insufficient role")
+			}
 
 			return next(c)
 		}
 	}
 }
 
+// AdminMiddleware пропускает пользователей из списка adminLogins, а остальных
+// проверяет через RequireRole(models.RoleAdmin).
+// Должен подключаться после JWTMiddleware.
+func AdminMiddleware(adminLogins []string) echo.MiddlewareFunc {
+	admins := make(map[string]struct{}, len(adminLogins))
+	for _, login := range adminLogins {
+		admins[login] = struct{}{}
+	}
+
+	requireAdmin := RequireRole(models.RoleAdmin)
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		byRole := requireAdmin(next)
+		return func(c echo.Context) error {
+			login, err := GetUserLoginFromContext(c)
+			if err != nil {
+				return err
+			}
+
+			if _, ok := admins[login]; ok {
+				return next(c)
+			}
+
+			return byRole(c)
+		}
+	}
+}
+
 // extractTokenFromHeader извлекает токен из заголовка Authorization.
 func extractTokenFromHeader(c echo.Context) string {
 	authHeader := c.Request().Header.Get("Authorization")
@@ -62,6 +158,25 @@ func extractTokenFromHeader(c echo.Context) string {
 	return ""
 }
 
+// extractTokenFromAltHeader извлекает токен из альтернативного заголовка.
+// Допускается как «голый» токен, так и формат "Bearer <token>".
+func extractTokenFromAltHeader(c echo.Context, name string) string {
+	value := strings.TrimSpace(c.Request().Header.Get(name))
+	if value == "" {
+		return ""
+	}
+
+	parts := strings.Split(value, " ")
+	switch {
+	case len(parts) == 1:
+		return parts[0]
+	case len(parts) == 2 && strings.ToLower(parts[0]) == "bearer":
+		return parts[1]
+	default:
+		return ""
+	}
+}
+
 // extractTokenFromCookie извлекает токен из cookie.
 func extractTokenFromCookie(c echo.Context) string {
 	cookie, err := c.Cookie("Authorization")
@@ -88,3 +203,12 @@ func GetUserLoginFromContext(c echo.Context) (string, error) {
 	}
 	return login, nil
 }
+
+// GetUserRoleFromContext извлекает роль пользователя из контекста.
+func GetUserRoleFromContext(c echo.Context) (string, error) {
+	role, ok := c.Get(string(UserRoleKey)).(string)
+	if !ok {
+		return "", echo.NewHTTPError(http.StatusUnauthorized, "user not found in context")
+	}
+	return role, nil
+}
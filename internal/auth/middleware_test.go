@@ -1,6 +1,9 @@
 package auth
 
 import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -96,7 +99,7 @@ func TestJWTMiddleware(t *testing.T) {
 			}
 
 			// Создаём middleware
-			middleware := JWTMiddleware(secret)
+			middleware := JWTMiddleware(secret, "")
 			h := middleware(handler)
 
 			// Вызываем
@@ -391,7 +394,7 @@ func TestJWTMiddlewarePriority(t *testing.T) {
 		return c.String(http.StatusOK, "success")
 	}
 
-	middleware := JWTMiddleware(secret)
+	middleware := JWTMiddleware(secret, "")
 	h := middleware(handler)
 
 	err := h(c)
@@ -399,3 +402,306 @@ func TestJWTMiddlewarePriority(t *testing.T) {
 		t.Errorf("Expected no error with valid header token, got %v", err)
 	}
 }
+
+func TestExtractTokenFromAltHeader(t *testing.T) {
+	e := echo.New()
+
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{
+			name:   "raw token",
+			header: "token123",
+			want:   "token123",
+		},
+		{
+			name:   "bearer token",
+			header: "Bearer token123",
+			want:   "token123",
+		},
+		{
+			name:   "empty header",
+			header: "",
+			want:   "",
+		},
+		{
+			name:   "unknown scheme",
+			header: "Basic token123",
+			want:   "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+
+			if tt.header != "" {
+				req.Header.Set("X-Auth-Token", tt.header)
+			}
+
+			got := extractTokenFromAltHeader(c, "X-Auth-Token")
+			if got != tt.want {
+				t.Errorf("extractTokenFromAltHeader() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJWTMiddlewareAltHeader(t *testing.T) {
+	secret := "test-secret"
+	user := &models.User{
+		ID:    uuid.New(),
+		Login: "test@example.com",
+	}
+
+	validToken, _ := GenerateToken(user, secret, time.Hour)
+	invalidToken := "invalid.token"
+
+	tests := []struct {
+		name      string
+		altHeader string
+		auth      string
+		alt       string
+		cookie    string
+		wantErr   bool
+	}{
+		{
+			name:      "alt header only",
+			altHeader: "X-Auth-Token",
+			alt:       validToken,
+		},
+		{
+			name:      "alt header disabled",
+			altHeader: "",
+			alt:       validToken,
+			wantErr:   true,
+		},
+		{
+			name:      "authorization beats alt header",
+			altHeader: "X-Auth-Token",
+			auth:      "Bearer " + validToken,
+			alt:       invalidToken,
+		},
+		{
+			name:      "alt header beats cookie",
+			altHeader: "X-Auth-Token",
+			alt:       validToken,
+			cookie:    invalidToken,
+		},
+		{
+			name:      "cookie used when headers missing",
+			altHeader: "X-Auth-Token",
+			cookie:    validToken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			if tt.alt != "" {
+				req.Header.Set("X-Auth-Token", tt.alt)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "Authorization", Value: tt.cookie})
+			}
+
+			handler := func(c echo.Context) error {
+				return c.String(http.StatusOK, "success")
+			}
+
+			err := JWTMiddleware(secret, tt.altHeader)(handler)(c)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("JWTMiddleware() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAdminMiddleware(t *testing.T) {
+	tests := []struct {
+		name       string
+		login      interface{}
+		role       string
+		wantStatus int
+	}{
+		{name: "admin", login: "admin@example.com", wantStatus: http.StatusOK},
+		{name: "regular user", login: "user@example.com", role: "user", wantStatus: http.StatusForbidden},
+		{name: "admin role", login: "root@example.com", role: "admin", wantStatus: http.StatusOK},
+		{name: "no user in context", login: nil, wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			if tt.login != nil {
+				c.Set(string(UserLoginKey), tt.login)
+			}
+			if tt.role != "" {
+				c.Set(string(UserRoleKey), tt.role)
+			}
+
+			handler := func(c echo.Context) error {
+				return c.NoContent(http.StatusOK)
+			}
+
+			err := AdminMiddleware([]string{"admin@example.com"})(handler)(c)
+			if tt.wantStatus == http.StatusOK {
+				if err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+				return
+			}
+
+			he, ok := err.(*echo.HTTPError)
+			if !ok || he.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %v", tt.wantStatus, err)
+			}
+		})
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	tests := []struct {
+		name       string
+		role       interface{}
+		wantStatus int
+	}{
+		{name: "matching role", role: "admin", wantStatus: http.StatusOK},
+		{name: "other role", role: "user", wantStatus: http.StatusForbidden},
+		{name: "no role in context", role: nil, wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			if tt.role != nil {
+				c.Set(string(UserRoleKey), tt.role)
+			}
+
+			handler := func(c echo.Context) error {
+				return c.NoContent(http.StatusOK)
+			}
+
+			err := RequireRole("admin")(handler)(c)
+			if tt.wantStatus == http.StatusOK {
+				if err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+				return
+			}
+
+			he, ok := err.(*echo.HTTPError)
+			if !ok || he.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %v", tt.wantStatus, err)
+			}
+		})
+	}
+}
+
+func TestJWTMiddlewareSetsRole(t *testing.T) {
+	secret := "test-secret"
+	user := &models.User{ID: uuid.New(), Login: "admin@example.com", Role: models.RoleAdmin}
+	token, err := GenerateToken(user, secret, time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateToken() error = %v", err)
+	}
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	handler := func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	}
+
+	mw := JWTMiddleware(secret, "")(RequireRole(models.RoleAdmin)(handler))
+	if err := mw(c); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if role, _ := GetUserRoleFromContext(c); role != models.RoleAdmin {
+		t.Errorf("role = %v, want %v", role, models.RoleAdmin)
+	}
+}
+
+func TestRequestLogger(t *testing.T) {
+	secret := "test-secret"
+	user := &models.User{ID: uuid.New(), Login: "test@example.com"}
+	token, err := GenerateToken(user, secret, time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateToken() error = %v", err)
+	}
+
+	var buf bytes.Buffer
+	e := echo.New()
+	e.Use(RequestLogger(slog.New(slog.NewJSONHandler(&buf, nil))))
+	e.GET("/public", func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+	protected := e.Group("/api/user")
+	protected.Use(JWTMiddleware(secret, ""))
+	protected.GET("/orders", func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+
+	tests := []struct {
+		name       string
+		path       string
+		token      string
+		wantStatus int
+		wantUserID string
+	}{
+		{name: "protected route", path: "/api/user/orders", token: token, wantStatus: http.StatusOK, wantUserID: user.ID.String()},
+		{name: "public route", path: "/public", wantStatus: http.StatusOK},
+		{name: "rejected token", path: "/api/user/orders", token: "invalid", wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			e.ServeHTTP(httptest.NewRecorder(), req)
+
+			var entry map[string]any
+			if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+				t.Fatalf("decode log entry %q: %v", buf.String(), err)
+			}
+			if entry["uri"] != tt.path {
+				t.Errorf("uri = %v, want %v", entry["uri"], tt.path)
+			}
+			if entry["status"] != float64(tt.wantStatus) {
+				t.Errorf("status = %v, want %v", entry["status"], tt.wantStatus)
+			}
+			// httptest.NewRequest использует адрес клиента 192.0.2.1
+			if entry["remote_ip"] != "192.0.2.1" {
+				t.Errorf("remote_ip = %v, want 192.0.2.1", entry["remote_ip"])
+			}
+
+			userID, ok := entry["user_id"]
+			if tt.wantUserID == "" {
+				if ok {
+					t.Errorf("unexpected user_id %v", userID)
+				}
+			} else if userID != tt.wantUserID {
+				t.Errorf("user_id = %v, want %v", userID, tt.wantUserID)
+			}
+		})
+	}
+}
@@ -1,18 +1,55 @@
 package auth
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"errors"
+	"unicode/utf8"
 
-const bcryptCost = 10
+	"golang.org/x/crypto/bcrypt"
+)
 
-// HashPassword хеширует пароль с использованием bcrypt.
+// DefaultBcryptCost - стоимость bcrypt, с которой HashPassword хеширует пароли.
+const DefaultBcryptCost = 10
+
+// MaxBcryptCost - наибольшая разумная стоимость bcrypt. bcrypt.MaxCost (31) допустим
+// формально, но хеширование с ним занимает часы; 14 - около секунды на пароль.
+const MaxBcryptCost = 14
+
+// MinPasswordLength - минимальная длина пароля (в символах).
+const MinPasswordLength = 8
+
+// ErrWeakPassword возвращается, если пароль не соответствует политике.
+var ErrWeakPassword = errors.New("password does not meet the policy")
+
+// ValidatePasswordPolicy проверяет пароль на соответствие политике.
+// Пароль должен быть не короче MinPasswordLength символов и не длиннее 72 байт (ограничение bcrypt).
+func ValidatePasswordPolicy(password string) error {
+	if utf8.RuneCountInString(password) < MinPasswordLength || len(password) > 72 {
+		return ErrWeakPassword
+	}
+	return nil
+}
+
+// HashPassword хеширует пароль с использованием bcrypt со стоимостью DefaultBcryptCost.
 func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+	return HashPasswordWithCost(password, DefaultBcryptCost)
+}
+
+// HashPasswordWithCost хеширует пароль с использованием bcrypt с заданной стоимостью.
+func HashPasswordWithCost(password string, cost int) (string, error) {
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), cost)
 	if err != nil {
 		return "", err
 	}
 	return string(bytes), nil
 }
 
+// NeedsRehash сообщает, что хеш создан со стоимостью ниже cost и его стоит пересчитать.
+// Нераспознанный хеш не пересчитывается: пароль по нему всё равно не проверится.
+func NeedsRehash(hash string, cost int) bool {
+	current, err := bcrypt.Cost([]byte(hash))
+	return err == nil && current < cost
+}
+
 // CheckPassword проверяет соответствие пароля хешу.
 func CheckPassword(password, hash string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
@@ -3,6 +3,8 @@ package auth
 import (
 	"strings"
 	"testing"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestHashPassword(t *testing.T) {
@@ -219,3 +221,54 @@ func BenchmarkCheckPassword(b *testing.B) {
 		CheckPassword(password, hash)
 	}
 }
+
+func TestValidatePasswordPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		wantErr  bool
+	}{
+		{name: "long enough", password: "password123", wantErr: false},
+		{name: "exactly minimum", password: "12345678", wantErr: false},
+		{name: "unicode counted by runes", password: "пароль12", wantErr: false},
+		{name: "too short", password: "short", wantErr: true},
+		{name: "empty", password: "", wantErr: true},
+		{name: "over bcrypt limit", password: strings.Repeat("a", 73), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidatePasswordPolicy(tt.password)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidatePasswordPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	hash, err := HashPasswordWithCost("password123", bcrypt.MinCost+1)
+	if err != nil {
+		t.Fatalf("HashPasswordWithCost() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		hash string
+		cost int
+		want bool
+	}{
+		{name: "cost increased", hash: hash, cost: bcrypt.MinCost + 2, want: true},
+		{name: "cost equal", hash: hash, cost: bcrypt.MinCost + 1, want: false},
+		{name: "cost decreased", hash: hash, cost: bcrypt.MinCost, want: false},
+		{name: "not a bcrypt hash", hash: "plain", cost: DefaultBcryptCost, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NeedsRehash(tt.hash, tt.cost); got != tt.want {
+				t.Errorf("NeedsRehash() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
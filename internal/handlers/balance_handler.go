@@ -3,12 +3,16 @@ package handlers
 import (
 	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/agamariel/gofermart/internal/auth"
 	"github.com/agamariel/gofermart/internal/models"
+	"github.com/agamariel/gofermart/internal/money"
 	"github.com/agamariel/gofermart/internal/services"
 	"github.com/agamariel/gofermart/internal/storage"
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	"github.com/shopspring/decimal"
 )
@@ -18,6 +22,25 @@ type BalanceHandler struct {
 	balanceService services.BalanceService
 }
 
+// InsufficientBalanceResponse - тело ответа о нехватке баланса: причина (message, error)
+// и текущий баланс пользователя (current; available оставлен для прежних клиентов).
+type InsufficientBalanceResponse struct {
+	Message   string  `json:"message"`
+	Error     string  `json:"error"`
+	Current   float64 `json:"current"`
+	Available float64 `json:"available"`
+}
+
+// newInsufficientBalanceResponse заполняет тело ответа о нехватке баланса.
+func newInsufficientBalanceResponse(message string, current decimal.Decimal) InsufficientBalanceResponse {
+	return InsufficientBalanceResponse{
+		Message:   message,
+		Error:     message,
+		Current:   money.ToFloat(current),
+		Available: money.ToFloat(current),
+	}
+}
+
 // NewBalanceHandler создаёт новый handler.
 func NewBalanceHandler(balanceService services.BalanceService) *BalanceHandler {
 	return &BalanceHandler{balanceService: balanceService}
@@ -31,45 +54,85 @@ func (h *BalanceHandler) Withdraw(c echo.Context) error {
 	}
 
 	var req models.WithdrawRequest
-	if err := c.Bind(&req); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "invalid request format")
-	}
-
-	sum := decimal.NewFromFloat(req.Sum)
-	if req.Sum <= 0 {
-		return echo.NewHTTPError(http.StatusUnprocessableEntity, "invalid sum")
+	if err := bindAndValidate(c, &req); err != nil {
+		return err
 	}
 
-	if err := h.balanceService.Withdraw(c.Request().Context(), userID, req.Order, sum); err != nil {
+	if err := h.balanceService.Withdraw(c.Request().Context(), userID, req.Order, req.Sum); err != nil {
+		var balanceErr *storage.InsufficientBalanceError
 		switch {
+		case errors.As(err, &balanceErr):
+			return echo.NewHTTPError(http.StatusPaymentRequired, newInsufficientBalanceResponse("insufficient balance", balanceErr.Available))
 		case errors.Is(err, services.ErrInvalidWithdrawalNumber):
 			return echo.NewHTTPError(http.StatusUnprocessableEntity, "invalid order number")
 		case errors.Is(err, services.ErrInvalidWithdrawalSum):
 			return echo.NewHTTPError(http.StatusUnprocessableEntity, "invalid sum")
+		case errors.Is(err, services.ErrWithdrawalTooLarge):
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, "sum exceeds the allowed maximum")
 		case errors.Is(err, storage.ErrInsufficientBalance):
-			return echo.NewHTTPError(http.StatusPaymentRequired, "insufficient balance")
+			return h.insufficientBalance(c, userID)
 		case errors.Is(err, storage.ErrUserNotFound):
 			return echo.NewHTTPError(http.StatusUnauthorized, "user not found")
 		case errors.Is(err, storage.ErrWithdrawalExists):
 			return echo.NewHTTPError(http.StatusUnprocessableEntity, "order already withdrawn")
 		default:
-			return echo.NewHTTPError(http.StatusInternalServerError, "internal server error")
+			return internalServerError(err)
 		}
 	}
 
 	return c.NoContent(http.StatusOK)
 }
 
+// insufficientBalance отвечает 402 на ошибку нехватки средств без сумм: текущий баланс
+// запрашивается отдельно. Если его получить не удалось, ответ остаётся 402 без баланса.
+func (h *BalanceHandler) insufficientBalance(c echo.Context, userID uuid.UUID) error {
+	summary, err := h.balanceService.GetBalance(c.Request().Context(), userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusPaymentRequired, "insufficient balance")
+	}
+	return echo.NewHTTPError(http.StatusPaymentRequired, newInsufficientBalanceResponse("insufficient balance", summary.Current))
+}
+
 // GetWithdrawals обрабатывает GET /api/user/withdrawals.
+// С параметром ?summary=true вместо массива возвращается объект со списком и общей суммой списаний.
+// Параметры from и to (RFC3339) ограничивают период; сумма в summary тогда считается за период.
+// С параметром format=csv списания за период выгружаются файлом CSV.
 func (h *BalanceHandler) GetWithdrawals(c echo.Context) error {
 	userID, err := auth.GetUserIDFromContext(c)
 	if err != nil {
 		return err
 	}
 
-	withdrawals, err := h.balanceService.GetWithdrawals(c.Request().Context(), userID)
+	from, err := parseTimeParam(c, "from")
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "internal server error")
+		return err
+	}
+	to, err := parseTimeParam(c, "to")
+	if err != nil {
+		return err
+	}
+	if !from.IsZero() && !to.IsZero() && from.After(to) {
+		return echo.NewHTTPError(http.StatusBadRequest, "from must not be after to")
+	}
+
+	switch c.QueryParam("format") {
+	case "", "json":
+	case "csv":
+		return h.exportWithdrawalsCSV(c, userID, from, to)
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid format value")
+	}
+
+	ranged := !from.IsZero() || !to.IsZero()
+
+	var withdrawals []*models.Withdrawal
+	if ranged {
+		withdrawals, err = h.balanceService.GetWithdrawalsBetween(c.Request().Context(), userID, from, to)
+	} else {
+		withdrawals, err = h.balanceService.GetWithdrawals(c.Request().Context(), userID)
+	}
+	if err != nil {
+		return internalServerError(err)
 	}
 
 	if len(withdrawals) == 0 {
@@ -78,18 +141,156 @@ func (h *BalanceHandler) GetWithdrawals(c echo.Context) error {
 
 	// Маппинг domain моделей в DTO
 	response := h.mapWithdrawalsToResponse(withdrawals)
+
+	if summary, _ := strconv.ParseBool(c.QueryParam("summary")); summary {
+		var total decimal.Decimal
+		if ranged {
+			for _, w := range withdrawals {
+				total = total.Add(w.Sum)
+			}
+		} else {
+			total, err = h.balanceService.GetWithdrawalsTotal(c.Request().Context(), userID)
+			if err != nil {
+				return internalServerError(err)
+			}
+		}
+		return c.JSON(http.StatusOK, &models.WithdrawalsSummaryResponse{
+			Withdrawals: response,
+			Total:       money.ToFloat(total),
+		})
+	}
+
 	return c.JSON(http.StatusOK, response)
 }
 
+// GetBalanceSummary обрабатывает GET /api/user/balance/summary.
+func (h *BalanceHandler) GetBalanceSummary(c echo.Context) error {
+	userID, err := auth.GetUserIDFromContext(c)
+	if err != nil {
+		return err
+	}
+
+	summary, err := h.balanceService.GetBalance(c.Request().Context(), userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return echo.NewHTTPError(http.StatusUnauthorized, "user not found")
+		}
+		return internalServerError(err)
+	}
+
+	return c.JSON(http.StatusOK, &models.BalanceSummaryResponse{
+		Current:   money.ToFloat(summary.Current),
+		Withdrawn: money.ToFloat(summary.Withdrawn),
+		Accrued:   money.ToFloat(summary.Accrued),
+	})
+}
+
+// ReverseWithdrawal обрабатывает POST /api/admin/users/:id/withdrawals/:number/reverse:
+// отменяет списание пользователя и возвращает отменённую запись.
+func (h *BalanceHandler) ReverseWithdrawal(c echo.Context) error {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid user id")
+	}
+	number := strings.TrimSpace(c.Param("number"))
+	if number == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "empty order number")
+	}
+
+	withdrawal, err := h.balanceService.ReverseWithdrawal(c.Request().Context(), userID, number)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrWithdrawalNotFound):
+			return echo.NewHTTPError(http.StatusNotFound, "withdrawal not found")
+		case errors.Is(err, storage.ErrUserNotFound):
+			return echo.NewHTTPError(http.StatusNotFound, "user not found")
+		default:
+			return internalServerError(err)
+		}
+	}
+
+	return c.JSON(http.StatusOK, h.mapWithdrawalsToResponse([]*models.Withdrawal{withdrawal})[0])
+}
+
+// AdjustBalance обрабатывает POST /api/admin/users/:id/balance/adjust: вручную изменяет
+// баланс пользователя на delta с указанием причины и возвращает запись журнала аудита.
+func (h *BalanceHandler) AdjustBalance(c echo.Context) error {
+	adminID, err := auth.GetUserIDFromContext(c)
+	if err != nil {
+		return err
+	}
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid user id")
+	}
+
+	var req models.BalanceAdjustmentRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return err
+	}
+
+	entry, err := h.balanceService.AdjustBalance(c.Request().Context(), adminID, userID, req.Delta, req.Reason)
+	if err != nil {
+		var balanceErr *storage.InsufficientBalanceError
+		switch {
+		case errors.As(err, &balanceErr):
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, newInsufficientBalanceResponse("balance cannot become negative", balanceErr.Available))
+		case errors.Is(err, services.ErrInvalidAdjustment):
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, "invalid delta")
+		case errors.Is(err, services.ErrAdjustmentReason):
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, "reason is required")
+		case errors.Is(err, storage.ErrUserNotFound):
+			return echo.NewHTTPError(http.StatusNotFound, "user not found")
+		default:
+			return internalServerError(err)
+		}
+	}
+
+	return c.JSON(http.StatusOK, &models.BalanceAdjustmentResponse{
+		UserID:        entry.UserID,
+		AdminID:       adminID,
+		Delta:         money.ToFloat(entry.BalanceDelta()),
+		BalanceBefore: money.ToFloat(entry.BalanceBefore),
+		BalanceAfter:  money.ToFloat(entry.BalanceAfter),
+		Reason:        entry.Reason,
+		CreatedAt:     entry.CreatedAt.UTC().Format(time.RFC3339),
+	})
+}
+
+// withdrawalsCSVHeader - заголовок выгрузки списаний в CSV.
+var withdrawalsCSVHeader = []string{"order", "sum", "processed_at"}
+
+// exportWithdrawalsCSV отдаёт списания пользователя за период [from, to] файлом CSV, новые первыми.
+func (h *BalanceHandler) exportWithdrawalsCSV(c echo.Context, userID uuid.UUID, from, to time.Time) error {
+	return streamCSV(c, "withdrawals.csv", withdrawalsCSVHeader, func(write func(record []string) error) error {
+		return h.balanceService.ExportWithdrawals(c.Request().Context(), userID, from, to, func(w *models.Withdrawal) error {
+			return write([]string{w.OrderNumber, csvAmount(w.Sum), csvTime(w.ProcessedAt)})
+		})
+	})
+}
+
+// parseTimeParam разбирает необязательный query-параметр в формате RFC3339;
+// отсутствующий параметр возвращается как нулевое время.
+func parseTimeParam(c echo.Context, name string) (time.Time, error) {
+	value := c.QueryParam(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, echo.NewHTTPError(http.StatusBadRequest, "invalid "+name+" value")
+	}
+	return t, nil
+}
+
 // mapWithdrawalsToResponse преобразует domain модели списаний в DTO для HTTP-ответа.
 func (h *BalanceHandler) mapWithdrawalsToResponse(withdrawals []*models.Withdrawal) []*models.WithdrawalResponse {
 	var response []*models.WithdrawalResponse
 	for _, w := range withdrawals {
-		sum, _ := w.Sum.Float64()
 		response = append(response, &models.WithdrawalResponse{
 			Order:       w.OrderNumber,
-			Sum:         sum,
-			ProcessedAt: w.ProcessedAt.Format(time.RFC3339),
+			Sum:         money.ToFloat(w.Sum),
+			ProcessedAt: w.ProcessedAt.UTC().Format(time.RFC3339),
 		})
 	}
 	return response
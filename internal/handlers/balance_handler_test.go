@@ -0,0 +1,721 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agamariel/gofermart/internal/models"
+	"github.com/agamariel/gofermart/internal/services"
+	"github.com/agamariel/gofermart/internal/storage"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/shopspring/decimal"
+)
+
+type mockBalanceService struct {
+	WithdrawFunc       func(ctx context.Context, userID uuid.UUID, orderNumber string, sum decimal.Decimal) error
+	GetWithdrawalsFunc func(ctx context.Context, userID uuid.UUID) ([]*models.Withdrawal, error)
+	GetBetweenFunc     func(ctx context.Context, userID uuid.UUID, from, to time.Time) ([]*models.Withdrawal, error)
+	GetBalanceFunc     func(ctx context.Context, userID uuid.UUID) (*models.BalanceSummary, error)
+	ReverseFunc        func(ctx context.Context, userID uuid.UUID, orderNumber string) (*models.Withdrawal, error)
+	ExportFunc         func(ctx context.Context, userID uuid.UUID, from, to time.Time, fn func(w *models.Withdrawal) error) error
+	AdjustFunc         func(ctx context.Context, adminID, userID uuid.UUID, delta decimal.Decimal, reason string) (*models.AuditEntry, error)
+
+	GetWithdrawalsTotalFunc func(ctx context.Context, userID uuid.UUID) (decimal.Decimal, error)
+}
+
+func (m *mockBalanceService) Withdraw(ctx context.Context, userID uuid.UUID, orderNumber string, sum decimal.Decimal) error {
+	if m.WithdrawFunc != nil {
+		return m.WithdrawFunc(ctx, userID, orderNumber, sum)
+	}
+	return nil
+}
+
+func (m *mockBalanceService) ReverseWithdrawal(ctx context.Context, userID uuid.UUID, orderNumber string) (*models.Withdrawal, error) {
+	if m.ReverseFunc != nil {
+		return m.ReverseFunc(ctx, userID, orderNumber)
+	}
+	return nil, storage.ErrWithdrawalNotFound
+}
+
+func (m *mockBalanceService) AdjustBalance(ctx context.Context, adminID, userID uuid.UUID, delta decimal.Decimal, reason string) (*models.AuditEntry, error) {
+	if m.AdjustFunc != nil {
+		return m.AdjustFunc(ctx, adminID, userID, delta, reason)
+	}
+	return nil, storage.ErrUserNotFound
+}
+
+func (m *mockBalanceService) ExportWithdrawals(ctx context.Context, userID uuid.UUID, from, to time.Time, fn func(w *models.Withdrawal) error) error {
+	if m.ExportFunc != nil {
+		return m.ExportFunc(ctx, userID, from, to, fn)
+	}
+	return nil
+}
+
+func (m *mockBalanceService) GetWithdrawals(ctx context.Context, userID uuid.UUID) ([]*models.Withdrawal, error) {
+	if m.GetWithdrawalsFunc != nil {
+		return m.GetWithdrawalsFunc(ctx, userID)
+	}
+	return []*models.Withdrawal{}, nil
+}
+
+func (m *mockBalanceService) GetWithdrawalsBetween(ctx context.Context, userID uuid.UUID, from, to time.Time) ([]*models.Withdrawal, error) {
+	if m.GetBetweenFunc != nil {
+		return m.GetBetweenFunc(ctx, userID, from, to)
+	}
+	return []*models.Withdrawal{}, nil
+}
+
+func (m *mockBalanceService) GetWithdrawalsTotal(ctx context.Context, userID uuid.UUID) (decimal.Decimal, error) {
+	if m.GetWithdrawalsTotalFunc != nil {
+		return m.GetWithdrawalsTotalFunc(ctx, userID)
+	}
+	return decimal.Zero, nil
+}
+
+func (m *mockBalanceService) GetBalance(ctx context.Context, userID uuid.UUID) (*models.BalanceSummary, error) {
+	if m.GetBalanceFunc != nil {
+		return m.GetBalanceFunc(ctx, userID)
+	}
+	return &models.BalanceSummary{}, nil
+}
+
+func TestBalanceHandler_Withdraw(t *testing.T) {
+	userID := uuid.New()
+
+	tests := []struct {
+		name           string
+		body           string
+		mockService    *mockBalanceService
+		expectedStatus int
+		wantBody       []string
+	}{
+		{
+			name:           "successful withdraw",
+			body:           `{"order":"2377225624","sum":751}`,
+			mockService:    &mockBalanceService{},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "missing order",
+			body:           `{"sum":751}`,
+			mockService:    &mockBalanceService{},
+			expectedStatus: http.StatusBadRequest,
+			wantBody:       []string{`"field":"order"`},
+		},
+		{
+			name:           "malformed JSON",
+			body:           `{"order":"2377225624",,"sum":751}`,
+			mockService:    &mockBalanceService{},
+			expectedStatus: http.StatusBadRequest,
+			wantBody:       []string{"malformed JSON at offset 23"},
+		},
+		{
+			name:           "order of wrong type",
+			body:           `{"order":2377225624,"sum":751}`,
+			mockService:    &mockBalanceService{},
+			expectedStatus: http.StatusBadRequest,
+			wantBody:       []string{"field order must be a string"},
+		},
+		{
+			name:           "non-positive sum",
+			body:           `{"order":"2377225624","sum":0}`,
+			mockService:    &mockBalanceService{},
+			expectedStatus: http.StatusBadRequest,
+			wantBody:       []string{`"field":"sum"`, `"rule":"positive"`},
+		},
+		{
+			name:           "too many decimal places",
+			body:           `{"order":"2377225624","sum":10.123}`,
+			mockService:    &mockBalanceService{},
+			expectedStatus: http.StatusBadRequest,
+			wantBody:       []string{`"field":"sum"`, `"rule":"max_places"`},
+		},
+		{
+			name: "insufficient balance",
+			body: `{"order":"2377225624","sum":751}`,
+			mockService: &mockBalanceService{
+				WithdrawFunc: func(ctx context.Context, uid uuid.UUID, number string, sum decimal.Decimal) error {
+					return storage.ErrInsufficientBalance
+				},
+			},
+			expectedStatus: http.StatusPaymentRequired,
+		},
+		{
+			name: "sum above limit",
+			body: `{"order":"2377225624","sum":5000000}`,
+			mockService: &mockBalanceService{
+				WithdrawFunc: func(ctx context.Context, uid uuid.UUID, number string, sum decimal.Decimal) error {
+					return services.ErrWithdrawalTooLarge
+				},
+			},
+			expectedStatus: http.StatusUnprocessableEntity,
+			wantBody:       []string{"sum exceeds the allowed maximum"},
+		},
+		{
+			name: "insufficient balance with amounts",
+			body: `{"order":"2377225624","sum":751}`,
+			mockService: &mockBalanceService{
+				WithdrawFunc: func(ctx context.Context, uid uuid.UUID, number string, sum decimal.Decimal) error {
+					return &storage.InsufficientBalanceError{Requested: sum, Available: decimal.RequireFromString("500.5")}
+				},
+			},
+			expectedStatus: http.StatusPaymentRequired,
+			wantBody:       []string{`"error":"insufficient balance"`, `"current":500.5`, `"message":"insufficient balance"`, `"available":500.5`},
+		},
+		{
+			name: "insufficient balance without amounts fetches current balance",
+			body: `{"order":"2377225624","sum":751}`,
+			mockService: &mockBalanceService{
+				WithdrawFunc: func(ctx context.Context, uid uuid.UUID, number string, sum decimal.Decimal) error {
+					return fmt.Errorf("withdraw: %w", storage.ErrInsufficientBalance)
+				},
+				GetBalanceFunc: func(ctx context.Context, uid uuid.UUID) (*models.BalanceSummary, error) {
+					return &models.BalanceSummary{Current: decimal.RequireFromString("42.10")}, nil
+				},
+			},
+			expectedStatus: http.StatusPaymentRequired,
+			wantBody:       []string{`"error":"insufficient balance"`, `"current":42.1`},
+		},
+		{
+			name: "insufficient balance when current balance is unavailable",
+			body: `{"order":"2377225624","sum":751}`,
+			mockService: &mockBalanceService{
+				WithdrawFunc: func(ctx context.Context, uid uuid.UUID, number string, sum decimal.Decimal) error {
+					return storage.ErrInsufficientBalance
+				},
+				GetBalanceFunc: func(ctx context.Context, uid uuid.UUID) (*models.BalanceSummary, error) {
+					return nil, errors.New("db error")
+				},
+			},
+			expectedStatus: http.StatusPaymentRequired,
+			wantBody:       []string{`"message":"insufficient balance"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			e.Validator = NewRequestValidator()
+			req := httptest.NewRequest(http.MethodPost, "/api/user/balance/withdraw", strings.NewReader(tt.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.Set("user_id", userID)
+
+			handler := NewBalanceHandler(tt.mockService)
+			if err := handler.Withdraw(c); err != nil {
+				e.HTTPErrorHandler(err, c)
+			}
+
+			if rec.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, rec.Code)
+			}
+			body := rec.Body.String()
+			for _, want := range tt.wantBody {
+				if !strings.Contains(body, want) {
+					t.Errorf("Response %s doesn't contain %s", body, want)
+				}
+			}
+		})
+	}
+}
+
+func TestBalanceHandler_WithdrawExactSum(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{name: "two places", body: `{"order":"2377225624","sum":10.10}`, want: "10.1"},
+		{name: "one tenth", body: `{"order":"2377225624","sum":0.1}`, want: "0.1"},
+		{name: "integer", body: `{"order":"2377225624","sum":751}`, want: "751"},
+		{name: "quoted", body: `{"order":"2377225624","sum":"0.30"}`, want: "0.3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			e.Validator = NewRequestValidator()
+			req := httptest.NewRequest(http.MethodPost, "/api/user/balance/withdraw", strings.NewReader(tt.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.Set("user_id", uuid.New())
+
+			var got decimal.Decimal
+			handler := NewBalanceHandler(&mockBalanceService{
+				WithdrawFunc: func(ctx context.Context, uid uuid.UUID, number string, sum decimal.Decimal) error {
+					got = sum
+					return nil
+				},
+			})
+			if err := handler.Withdraw(c); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if want := decimal.RequireFromString(tt.want); !got.Equal(want) {
+				t.Errorf("sum = %v, want %v", got, want)
+			}
+			// Сумма должна совпадать точно, без артефактов float64
+			if got.String() != tt.want {
+				t.Errorf("sum string = %s, want %s", got.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestBalanceHandler_GetBalanceSummary(t *testing.T) {
+	userID := uuid.New()
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/api/user/balance/summary", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.Set("user_id", userID)
+
+	handler := NewBalanceHandler(&mockBalanceService{
+		GetBalanceFunc: func(ctx context.Context, uid uuid.UUID) (*models.BalanceSummary, error) {
+			return &models.BalanceSummary{
+				Current:   decimal.NewFromFloat(500.5),
+				Withdrawn: decimal.NewFromInt(42),
+				Accrued:   decimal.NewFromFloat(542.5),
+			}, nil
+		},
+	})
+	if err := handler.GetBalanceSummary(c); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	want := `{"current":500.5,"withdrawn":42,"accrued":542.5}`
+	if got := strings.TrimSpace(rec.Body.String()); got != want {
+		t.Errorf("body = %s, want %s", got, want)
+	}
+}
+
+func TestBalanceHandler_GetWithdrawals(t *testing.T) {
+	userID := uuid.New()
+	// Время со смещением отдаётся в UTC с суффиксом Z
+	processedAt := time.Date(2024, 3, 1, 15, 0, 0, 0, time.FixedZone("MSK", 3*60*60))
+
+	service := &mockBalanceService{
+		GetWithdrawalsFunc: func(ctx context.Context, uid uuid.UUID) ([]*models.Withdrawal, error) {
+			return []*models.Withdrawal{
+				{UserID: uid, OrderNumber: "2377225624", Sum: decimal.NewFromInt(500), ProcessedAt: processedAt},
+				{UserID: uid, OrderNumber: "12345678903", Sum: decimal.NewFromFloat(42.5), ProcessedAt: processedAt},
+			}, nil
+		},
+		GetWithdrawalsTotalFunc: func(ctx context.Context, uid uuid.UUID) (decimal.Decimal, error) {
+			return decimal.NewFromFloat(542.5), nil
+		},
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{
+			name: "plain array by default",
+			want: `[{"order":"2377225624","sum":500,"processed_at":"2024-03-01T12:00:00Z"},` +
+				`{"order":"12345678903","sum":42.5,"processed_at":"2024-03-01T12:00:00Z"}]`,
+		},
+		{
+			name:  "summary false keeps plain array",
+			query: "?summary=false",
+			want: `[{"order":"2377225624","sum":500,"processed_at":"2024-03-01T12:00:00Z"},` +
+				`{"order":"12345678903","sum":42.5,"processed_at":"2024-03-01T12:00:00Z"}]`,
+		},
+		{
+			name:  "summary includes total",
+			query: "?summary=true",
+			want: `{"withdrawals":[{"order":"2377225624","sum":500,"processed_at":"2024-03-01T12:00:00Z"},` +
+				`{"order":"12345678903","sum":42.5,"processed_at":"2024-03-01T12:00:00Z"}],"total":542.5}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodGet, "/api/user/withdrawals"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.Set("user_id", userID)
+
+			if err := NewBalanceHandler(service).GetWithdrawals(c); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+			}
+			if got := strings.TrimSpace(rec.Body.String()); got != tt.want {
+				t.Errorf("body = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBalanceHandler_GetWithdrawalsRange(t *testing.T) {
+	march := func(day int) time.Time { return time.Date(2024, 3, day, 12, 0, 0, 0, time.UTC) }
+	all := []*models.Withdrawal{
+		{OrderNumber: "2377225624", Sum: decimal.NewFromInt(300), ProcessedAt: march(20)},
+		{OrderNumber: "12345678903", Sum: decimal.NewFromInt(200), ProcessedAt: march(10)},
+		{OrderNumber: "79927398713", Sum: decimal.NewFromInt(100), ProcessedAt: march(1)},
+	}
+
+	// Мок фильтрует так же, как хранилище: нулевая граница не ограничивает период
+	service := &mockBalanceService{
+		GetWithdrawalsFunc: func(ctx context.Context, uid uuid.UUID) ([]*models.Withdrawal, error) {
+			t.Error("GetWithdrawals must not be used when a range is given")
+			return all, nil
+		},
+		GetBetweenFunc: func(ctx context.Context, uid uuid.UUID, from, to time.Time) ([]*models.Withdrawal, error) {
+			var list []*models.Withdrawal
+			for _, w := range all {
+				if (!from.IsZero() && w.ProcessedAt.Before(from)) || (!to.IsZero() && w.ProcessedAt.After(to)) {
+					continue
+				}
+				list = append(list, w)
+			}
+			return list, nil
+		},
+		GetWithdrawalsTotalFunc: func(ctx context.Context, uid uuid.UUID) (decimal.Decimal, error) {
+			return decimal.NewFromInt(600), nil
+		},
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantOrders []string
+		wantTotal  string
+	}{
+		{name: "closed range", query: "?from=2024-03-05T00:00:00Z&to=2024-03-15T00:00:00Z", wantStatus: http.StatusOK, wantOrders: []string{"12345678903"}},
+		{name: "only from", query: "?from=2024-03-05T00:00:00Z", wantStatus: http.StatusOK, wantOrders: []string{"2377225624", "12345678903"}},
+		{name: "only to with offset", query: "?to=2024-03-10T15:00:00%2B03:00", wantStatus: http.StatusOK, wantOrders: []string{"12345678903", "79927398713"}},
+		{name: "bounds are inclusive", query: "?from=2024-03-10T12:00:00Z&to=2024-03-10T12:00:00Z", wantStatus: http.StatusOK, wantOrders: []string{"12345678903"}},
+		{name: "summary totals the range", query: "?from=2024-03-05T00:00:00Z&summary=true", wantStatus: http.StatusOK, wantTotal: `"total":500`},
+		{name: "empty range", query: "?from=2024-04-01T00:00:00Z", wantStatus: http.StatusNoContent},
+		{name: "from after to", query: "?from=2024-03-15T00:00:00Z&to=2024-03-05T00:00:00Z", wantStatus: http.StatusBadRequest},
+		{name: "invalid from", query: "?from=2024-03-05", wantStatus: http.StatusBadRequest},
+		{name: "invalid to", query: "?to=yesterday", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodGet, "/api/user/withdrawals"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.Set("user_id", uuid.New())
+
+			if err := NewBalanceHandler(service).GetWithdrawals(c); err != nil {
+				e.HTTPErrorHandler(err, c)
+			}
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantTotal != "" && !strings.Contains(rec.Body.String(), tt.wantTotal) {
+				t.Errorf("body = %s, want %s", rec.Body.String(), tt.wantTotal)
+			}
+			if tt.wantOrders == nil {
+				return
+			}
+
+			var got []models.WithdrawalResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			var orders []string
+			for _, w := range got {
+				orders = append(orders, w.Order)
+			}
+			if strings.Join(orders, ",") != strings.Join(tt.wantOrders, ",") {
+				t.Errorf("orders = %v, want %v", orders, tt.wantOrders)
+			}
+		})
+	}
+}
+
+func TestBalanceHandler_GetWithdrawalsCSV(t *testing.T) {
+	userID := uuid.New()
+	withdrawals := []*models.Withdrawal{
+		{OrderNumber: "2377225624", Sum: decimal.RequireFromString("751.5"), ProcessedAt: time.Date(2020, 12, 9, 19, 9, 57, 0, time.FixedZone("MSK", 3*60*60))},
+		{OrderNumber: "79927398713", Sum: decimal.NewFromInt(100), ProcessedAt: time.Date(2020, 12, 1, 10, 0, 0, 0, time.UTC)},
+	}
+
+	tests := []struct {
+		name           string
+		query          string
+		withdrawals    []*models.Withdrawal
+		exportErr      error
+		wantFrom       time.Time
+		expectedStatus int
+		wantBody       string
+	}{
+		{
+			name:           "withdrawals",
+			query:          "?format=csv",
+			withdrawals:    withdrawals,
+			expectedStatus: http.StatusOK,
+			wantBody: "order,sum,processed_at\n" +
+				"2377225624,751.50,2020-12-09T16:09:57Z\n" +
+				"79927398713,100.00,2020-12-01T10:00:00Z\n",
+		},
+		{
+			name:           "range is passed through",
+			query:          "?format=csv&from=2020-12-05T00:00:00Z",
+			withdrawals:    withdrawals[:1],
+			wantFrom:       time.Date(2020, 12, 5, 0, 0, 0, 0, time.UTC),
+			expectedStatus: http.StatusOK,
+			wantBody:       "order,sum,processed_at\n2377225624,751.50,2020-12-09T16:09:57Z\n",
+		},
+		{name: "no withdrawals", query: "?format=csv", expectedStatus: http.StatusOK, wantBody: "order,sum,processed_at\n"},
+		{name: "storage error", query: "?format=csv", exportErr: errors.New("db error"), expectedStatus: http.StatusInternalServerError},
+		{name: "unknown format", query: "?format=xlsx", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := &mockBalanceService{
+				ExportFunc: func(ctx context.Context, uid uuid.UUID, from, to time.Time, fn func(w *models.Withdrawal) error) error {
+					if uid != userID || !from.Equal(tt.wantFrom) || !to.IsZero() {
+						t.Errorf("ExportWithdrawals(%s, %s, %s), want (%s, %s, zero)", uid, from, to, userID, tt.wantFrom)
+					}
+					if tt.exportErr != nil {
+						return tt.exportErr
+					}
+					for _, w := range tt.withdrawals {
+						if err := fn(w); err != nil {
+							return err
+						}
+					}
+					return nil
+				},
+			}
+
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodGet, "/api/user/withdrawals"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.Set("user_id", userID)
+
+			if err := NewBalanceHandler(mock).GetWithdrawals(c); err != nil {
+				e.HTTPErrorHandler(err, c)
+			}
+
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.expectedStatus)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			if ct := rec.Header().Get(echo.HeaderContentType); ct != "text/csv; charset=utf-8" {
+				t.Errorf("Content-Type = %q, want text/csv", ct)
+			}
+			if cd := rec.Header().Get(echo.HeaderContentDisposition); cd != `attachment; filename="withdrawals.csv"` {
+				t.Errorf("Content-Disposition = %q", cd)
+			}
+			if rec.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestBalanceHandler_GetWithdrawalsSummaryError(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/api/user/withdrawals?summary=true", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.Set("user_id", uuid.New())
+
+	handler := NewBalanceHandler(&mockBalanceService{
+		GetWithdrawalsFunc: func(ctx context.Context, uid uuid.UUID) ([]*models.Withdrawal, error) {
+			return []*models.Withdrawal{{OrderNumber: "2377225624", Sum: decimal.NewFromInt(1)}}, nil
+		},
+		GetWithdrawalsTotalFunc: func(ctx context.Context, uid uuid.UUID) (decimal.Decimal, error) {
+			return decimal.Zero, errors.New("db down")
+		},
+	})
+	if err := handler.GetWithdrawals(c); err != nil {
+		e.HTTPErrorHandler(err, c)
+	}
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestBalanceHandler_ReverseWithdrawal(t *testing.T) {
+	userID := uuid.New()
+	processedAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name           string
+		userParam      string
+		reverseErr     error
+		expectedStatus int
+		wantBody       string
+	}{
+		{
+			name:           "reversed",
+			userParam:      userID.String(),
+			expectedStatus: http.StatusOK,
+			wantBody:       `{"order":"2377225624","sum":751.5,"processed_at":"2024-03-01T12:00:00Z"}`,
+		},
+		{name: "invalid user id", userParam: "not-a-uuid", expectedStatus: http.StatusBadRequest},
+		{name: "not found or already reversed", userParam: userID.String(), reverseErr: storage.ErrWithdrawalNotFound, expectedStatus: http.StatusNotFound},
+		{name: "user not found", userParam: userID.String(), reverseErr: storage.ErrUserNotFound, expectedStatus: http.StatusNotFound},
+		{name: "internal error", userParam: userID.String(), reverseErr: errors.New("db error"), expectedStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var called bool
+			mock := &mockBalanceService{
+				ReverseFunc: func(ctx context.Context, uid uuid.UUID, number string) (*models.Withdrawal, error) {
+					called = true
+					if uid != userID || number != "2377225624" {
+						t.Errorf("ReverseWithdrawal(%s, %q), want (%s, %q)", uid, number, userID, "2377225624")
+					}
+					if tt.reverseErr != nil {
+						return nil, tt.reverseErr
+					}
+					return &models.Withdrawal{
+						UserID:      uid,
+						OrderNumber: number,
+						Sum:         decimal.RequireFromString("751.5"),
+						ProcessedAt: processedAt,
+					}, nil
+				},
+			}
+
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/api/admin/users/"+tt.userParam+"/withdrawals/2377225624/reverse", nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("id", "number")
+			c.SetParamValues(tt.userParam, "2377225624")
+
+			if err := NewBalanceHandler(mock).ReverseWithdrawal(c); err != nil {
+				e.HTTPErrorHandler(err, c)
+			}
+
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.expectedStatus)
+			}
+			if called != (tt.expectedStatus != http.StatusBadRequest) {
+				t.Errorf("service called = %v", called)
+			}
+			if tt.wantBody != "" && strings.TrimSpace(rec.Body.String()) != tt.wantBody {
+				t.Errorf("body = %s, want %s", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestBalanceHandler_AdjustBalance(t *testing.T) {
+	adminID := uuid.New()
+	userID := uuid.New()
+	createdAt := time.Date(2024, 3, 1, 15, 0, 0, 0, time.FixedZone("MSK", 3*60*60))
+
+	tests := []struct {
+		name           string
+		userParam      string
+		body           string
+		adjustErr      error
+		expectedStatus int
+		wantBody       []string
+	}{
+		{
+			name:           "credit",
+			userParam:      userID.String(),
+			body:           `{"delta":25.5,"reason":"goodwill"}`,
+			expectedStatus: http.StatusOK,
+			wantBody:       []string{`"delta":25.5`, `"balance_before":100`, `"balance_after":125.5`, `"reason":"goodwill"`, `"created_at":"2024-03-01T12:00:00Z"`, `"admin_id":"` + adminID.String() + `"`},
+		},
+		{
+			name:           "debit",
+			userParam:      userID.String(),
+			body:           `{"delta":-40,"reason":"chargeback"}`,
+			expectedStatus: http.StatusOK,
+			wantBody:       []string{`"delta":-40`, `"balance_after":60`},
+		},
+		{
+			name:           "below zero",
+			userParam:      userID.String(),
+			body:           `{"delta":-150,"reason":"chargeback"}`,
+			adjustErr:      &storage.InsufficientBalanceError{Requested: decimal.NewFromInt(150), Available: decimal.NewFromInt(100)},
+			expectedStatus: http.StatusUnprocessableEntity,
+			wantBody:       []string{`"message":"balance cannot become negative"`, `"current":100`, `"available":100`},
+		},
+		{name: "invalid user id", userParam: "not-a-uuid", body: `{"delta":1,"reason":"x"}`, expectedStatus: http.StatusBadRequest},
+		{name: "missing reason", userParam: userID.String(), body: `{"delta":1}`, expectedStatus: http.StatusBadRequest},
+		{name: "zero delta", userParam: userID.String(), body: `{"delta":0,"reason":"x"}`, adjustErr: services.ErrInvalidAdjustment, expectedStatus: http.StatusUnprocessableEntity},
+		{name: "blank reason", userParam: userID.String(), body: `{"delta":1,"reason":" "}`, adjustErr: services.ErrAdjustmentReason, expectedStatus: http.StatusUnprocessableEntity},
+		{name: "user not found", userParam: userID.String(), body: `{"delta":1,"reason":"x"}`, adjustErr: storage.ErrUserNotFound, expectedStatus: http.StatusNotFound},
+		{name: "internal error", userParam: userID.String(), body: `{"delta":1,"reason":"x"}`, adjustErr: services.ErrAuditNotConfigured, expectedStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := &mockBalanceService{
+				AdjustFunc: func(ctx context.Context, aid, uid uuid.UUID, delta decimal.Decimal, reason string) (*models.AuditEntry, error) {
+					if aid != adminID || uid != userID {
+						t.Errorf("AdjustBalance(%s, %s), want (%s, %s)", aid, uid, adminID, userID)
+					}
+					if tt.adjustErr != nil {
+						return nil, tt.adjustErr
+					}
+					entry := &models.AuditEntry{
+						UserID:        uid,
+						AdminID:       &aid,
+						Reason:        reason,
+						Kind:          models.AuditKindAdjustmentCredit,
+						Amount:        delta.Abs(),
+						BalanceBefore: decimal.NewFromInt(100),
+						BalanceAfter:  decimal.NewFromInt(100).Add(delta),
+						CreatedAt:     createdAt,
+					}
+					if delta.IsNegative() {
+						entry.Kind = models.AuditKindAdjustmentDebit
+					}
+					return entry, nil
+				},
+			}
+
+			e := echo.New()
+			e.Validator = NewRequestValidator()
+			req := httptest.NewRequest(http.MethodPost, "/api/admin/users/"+tt.userParam+"/balance/adjust", strings.NewReader(tt.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.Set("user_id", adminID)
+			c.SetParamNames("id")
+			c.SetParamValues(tt.userParam)
+
+			if err := NewBalanceHandler(mock).AdjustBalance(c); err != nil {
+				e.HTTPErrorHandler(err, c)
+			}
+
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("status = %d, want %d; body = %s", rec.Code, tt.expectedStatus, rec.Body.String())
+			}
+			for _, want := range tt.wantBody {
+				if !strings.Contains(rec.Body.String(), want) {
+					t.Errorf("body %s doesn't contain %s", rec.Body.String(), want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+)
+
+// ConnTracker учитывает долгоживущие ответы (например, потоки Server-Sent Events).
+// http.Server.Shutdown ждёт, пока соединения освободятся, а такие ответы сами не завершаются,
+// поэтому при остановке приложения их нужно прервать через Close.
+type ConnTracker struct {
+	mu      sync.Mutex
+	closed  bool
+	nextID  uint64
+	cancels map[uint64]context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// NewConnTracker создаёт пустой трекер соединений.
+func NewConnTracker() *ConnTracker {
+	return &ConnTracker{cancels: make(map[uint64]context.CancelFunc)}
+}
+
+// Track регистрирует долгий ответ. Возвращённый контекст отменяется при отмене ctx
+// или при Close; handler должен завершиться по его Done и вызвать done.
+// После Close контекст возвращается уже отменённым.
+func (t *ConnTracker) Track(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		cancel()
+		return ctx, func() {}
+	}
+
+	id := t.nextID
+	t.nextID++
+	t.cancels[id] = cancel
+	t.wg.Add(1)
+
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			t.mu.Lock()
+			delete(t.cancels, id)
+			t.mu.Unlock()
+			cancel()
+			t.wg.Done()
+		})
+	}
+}
+
+// Close прерывает все отслеживаемые ответы и ждёт их завершения, но не дольше ctx.
+// Новые ответы после Close сразу получают отменённый контекст.
+func (t *ConnTracker) Close(ctx context.Context) error {
+	t.mu.Lock()
+	t.closed = true
+	for _, cancel := range t.cancels {
+		cancel()
+	}
+	t.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
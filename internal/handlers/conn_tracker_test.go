@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestConnTracker_CloseCancelsTracked(t *testing.T) {
+	tracker := NewConnTracker()
+
+	ctx, done := tracker.Track(context.Background())
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		defer done()
+		<-ctx.Done()
+	}()
+
+	closeCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := tracker.Close(closeCtx); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	select {
+	case <-finished:
+	default:
+		t.Fatal("Close returned before the tracked handler finished")
+	}
+
+	// После Close новые ответы сразу получают отменённый контекст
+	late, lateDone := tracker.Track(context.Background())
+	defer lateDone()
+	if late.Err() == nil {
+		t.Error("context tracked after Close is not cancelled")
+	}
+}
+
+func TestConnTracker_CloseIsBounded(t *testing.T) {
+	tracker := NewConnTracker()
+
+	// Handler не реагирует на отмену: Close не должен ждать его дольше своего контекста
+	_, done := tracker.Track(context.Background())
+	defer done()
+
+	closeCtx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := tracker.Close(closeCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Close() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestConnTracker_DoneUntracks(t *testing.T) {
+	tracker := NewConnTracker()
+
+	_, done := tracker.Track(context.Background())
+	done()
+	done()
+
+	closeCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := tracker.Close(closeCtx); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+}
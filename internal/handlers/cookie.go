@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const defaultCookieMaxAge = 24 * time.Hour
+
+// CookieConfig описывает атрибуты cookie с токеном авторизации.
+type CookieConfig struct {
+	Secure   bool
+	SameSite http.SameSite
+	// MaxAge совпадает со временем жизни JWT токена.
+	MaxAge time.Duration
+	// Path - путь cookie; при работе за префиксом совпадает с базовым путём.
+	Path string
+}
+
+// DefaultCookieConfig возвращает настройки cookie по умолчанию.
+func DefaultCookieConfig() CookieConfig {
+	return CookieConfig{
+		Secure:   false,
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   defaultCookieMaxAge,
+		Path:     "/",
+	}
+}
+
+// NewCookieConfig создаёт настройки cookie и валидирует значение SameSite.
+// SameSite=None допускается только вместе с Secure: иначе браузеры отбрасывают cookie.
+func NewCookieConfig(secure bool, sameSite string, maxAge time.Duration) (CookieConfig, error) {
+	mode, err := ParseSameSite(sameSite)
+	if err != nil {
+		return CookieConfig{}, err
+	}
+	if mode == http.SameSiteNoneMode && !secure {
+		return CookieConfig{}, fmt.Errorf("SameSite=None requires a Secure cookie")
+	}
+	if maxAge <= 0 {
+		maxAge = defaultCookieMaxAge
+	}
+	return CookieConfig{
+		Secure:   secure,
+		SameSite: mode,
+		MaxAge:   maxAge,
+		Path:     "/",
+	}, nil
+}
+
+// WithPath возвращает копию настроек с путём cookie, соответствующим базовому пути basePath.
+func (cfg CookieConfig) WithPath(basePath string) CookieConfig {
+	cfg.Path = basePath
+	if cfg.Path == "" {
+		cfg.Path = "/"
+	}
+	return cfg
+}
+
+// ParseSameSite преобразует строковое значение SameSite в http.SameSite.
+func ParseSameSite(value string) (http.SameSite, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "strict":
+		return http.SameSiteStrictMode, nil
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	case "default":
+		return http.SameSiteDefaultMode, nil
+	default:
+		return 0, fmt.Errorf("invalid SameSite value: %q", value)
+	}
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/agamariel/gofermart/internal/money"
+	"github.com/labstack/echo/v4"
+	"github.com/shopspring/decimal"
+)
+
+// streamCSV отдаёт файл filename в формате CSV с заголовком header. produce передаёт строки
+// в write по мере их чтения из хранилища, поэтому весь список не буферизуется. Ответ
+// начинается с первой строки: ошибка до неё возвращается обычным 500, а после - лишь
+// обрывает выгрузку. При пустом списке файл состоит из одного заголовка.
+//
+// Большая выгрузка может идти дольше таймаутов чтения и записи сервера, поэтому они снимаются
+// с соединения, как и для потока событий: иначе файл оборвался бы посреди ответа 200.
+func streamCSV(c echo.Context, filename string, header []string, produce func(write func(record []string) error) error) error {
+	res := c.Response()
+	rc := http.NewResponseController(res)
+	if err := rc.SetReadDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		c.Logger().Warnf("failed to clear read deadline for %s: %v", filename, err)
+	}
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		c.Logger().Warnf("failed to clear write deadline for %s: %v", filename, err)
+	}
+
+	w := csv.NewWriter(res)
+	start := func() error {
+		if res.Committed {
+			return nil
+		}
+		res.Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+		res.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+		res.WriteHeader(http.StatusOK)
+		return w.Write(header)
+	}
+
+	err := produce(func(record []string) error {
+		if err := start(); err != nil {
+			return err
+		}
+		return w.Write(record)
+	})
+	if err != nil {
+		if !res.Committed {
+			return internalServerError(err)
+		}
+		return fmt.Errorf("export %s: %w", filename, err)
+	}
+
+	if err := start(); err != nil {
+		return err
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// csvAmount форматирует денежную сумму для CSV с двумя знаками после точки.
+func csvAmount(amount decimal.Decimal) string {
+	return money.Format(amount, money.Places)
+}
+
+// csvTime форматирует время для CSV в RFC3339 по UTC.
+func csvTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
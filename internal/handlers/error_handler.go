@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/agamariel/gofermart/internal/storage"
+	"github.com/labstack/echo/v4"
+)
+
+// StatusClientClosedRequest - нестандартный код 499 (как в nginx): клиент закрыл соединение,
+// не дождавшись ответа.
+const StatusClientClosedRequest = 499
+
+// RouteErrorResponse - тело ответа на запрос к несуществующему маршруту или с неподдерживаемым методом.
+type RouteErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// NewHTTPErrorHandler возвращает обработчик ошибок, отвечающий JSON на неизвестный маршрут (404)
+// и неподдерживаемый метод (405). Отменённый клиентом запрос (storage.ErrRequestCancelled) получает
+// 499 без тела. Непредвиденные ошибки хранилища (storage.ErrStorageInternal) передаются fallback
+// как 500 без текста исходной ошибки. Остальные ошибки передаются fallback.
+func NewHTTPErrorHandler(fallback echo.HTTPErrorHandler) echo.HTTPErrorHandler {
+	return func(err error, c echo.Context) {
+		var (
+			code    int
+			message string
+		)
+		switch {
+		case errors.Is(err, storage.ErrRequestCancelled):
+			// Ответ клиенту уже не нужен: код 499 попадает только в журнал запросов
+			if !c.Response().Committed {
+				c.Response().WriteHeader(StatusClientClosedRequest)
+			}
+			return
+		case errors.Is(err, storage.ErrStorageInternal):
+			fallback(echo.NewHTTPError(http.StatusInternalServerError, "internal server error").SetInternal(err), c)
+			return
+		case errors.Is(err, echo.ErrNotFound):
+			code, message = http.StatusNotFound, "not found"
+		case errors.Is(err, echo.ErrMethodNotAllowed):
+			code, message = http.StatusMethodNotAllowed, "method not allowed"
+		default:
+			fallback(err, c)
+			return
+		}
+
+		if c.Response().Committed {
+			return
+		}
+
+		var writeErr error
+		if c.Request().Method == http.MethodHead {
+			writeErr = c.NoContent(code)
+		} else {
+			writeErr = c.JSON(code, RouteErrorResponse{Error: message})
+		}
+		if writeErr != nil {
+			c.Logger().Error(writeErr)
+		}
+	}
+}
+
+// internalServerError возвращает 500 "internal server error", сохраняя err как внутреннюю ошибку,
+// чтобы NewHTTPErrorHandler отличил отмену запроса клиентом от сбоя.
+func internalServerError(err error) *echo.HTTPError {
+	return echo.NewHTTPError(http.StatusInternalServerError, "internal server error").SetInternal(err)
+}
+
+// logUnexpected пишет непредвиденную ошибку обработчика в лог; отмену запроса клиентом не логирует.
+func logUnexpected(c echo.Context, format string, err error) {
+	if errors.Is(err, storage.ErrRequestCancelled) {
+		return
+	}
+	c.Logger().Errorf(format, err)
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/agamariel/gofermart/internal/storage"
+	"github.com/labstack/echo/v4"
+)
+
+func TestHTTPErrorHandler(t *testing.T) {
+	e := echo.New()
+	e.HTTPErrorHandler = NewHTTPErrorHandler(e.DefaultHTTPErrorHandler)
+	e.GET("/api/user/orders", func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+	e.GET("/api/user/missing", func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusNotFound, "order not found")
+	})
+	e.GET("/api/user/broken", func(c echo.Context) error {
+		return fmt.Errorf("get user orders: %w", storage.ErrStorageInternal)
+	})
+	e.GET("/api/user/cancelled", func(c echo.Context) error {
+		return internalServerError(fmt.Errorf("get user orders: %w", storage.ErrRequestCancelled))
+	})
+	e.GET("/api/user/failed", func(c echo.Context) error {
+		return internalServerError(fmt.Errorf("get user orders: %w", storage.ErrStorageInternal))
+	})
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+		wantBody   string
+		wantAllow  string
+	}{
+		{name: "unknown path", method: http.MethodGet, path: "/api/unknown", wantStatus: http.StatusNotFound, wantBody: `{"error":"not found"}`},
+		{name: "wrong method", method: http.MethodDelete, path: "/api/user/orders", wantStatus: http.StatusMethodNotAllowed, wantBody: `{"error":"method not allowed"}`, wantAllow: http.MethodGet},
+		{name: "unknown path with HEAD", method: http.MethodHead, path: "/api/unknown", wantStatus: http.StatusNotFound},
+		{name: "handler errors keep their message", method: http.MethodGet, path: "/api/user/missing", wantStatus: http.StatusNotFound, wantBody: `{"message":"order not found"}`},
+		{name: "storage errors are hidden", method: http.MethodGet, path: "/api/user/broken", wantStatus: http.StatusInternalServerError, wantBody: `{"message":"internal server error"}`},
+		{name: "handler 500 keeps storage cause", method: http.MethodGet, path: "/api/user/failed", wantStatus: http.StatusInternalServerError, wantBody: `{"message":"internal server error"}`},
+		{name: "cancelled request", method: http.MethodGet, path: "/api/user/cancelled", wantStatus: StatusClientClosedRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if body := strings.TrimSpace(rec.Body.String()); body != tt.wantBody {
+				t.Errorf("body = %s, want %s", body, tt.wantBody)
+			}
+			if tt.wantBody != "" && !strings.HasPrefix(rec.Header().Get(echo.HeaderContentType), echo.MIMEApplicationJSON) {
+				t.Errorf("Content-Type = %q, want JSON", rec.Header().Get(echo.HeaderContentType))
+			}
+			if tt.wantAllow != "" && !strings.Contains(rec.Header().Get(echo.HeaderAllow), tt.wantAllow) {
+				t.Errorf("Allow = %q, want it to contain %s", rec.Header().Get(echo.HeaderAllow), tt.wantAllow)
+			}
+		})
+	}
+}
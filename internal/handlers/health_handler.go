@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/agamariel/gofermart/internal/services"
+	"github.com/labstack/echo/v4"
+)
+
+// readinessTimeout ограничивает проверку хранилища в /readyz.
+const readinessTimeout = 2 * time.Second
+
+// HealthHandler обрабатывает проверки состояния сервиса.
+type HealthHandler struct {
+	pinger services.Pinger
+}
+
+// NewHealthHandler создаёт handler; pinger проверяет хранилище, с которым работает приложение.
+func NewHealthHandler(pinger services.Pinger) *HealthHandler {
+	return &HealthHandler{pinger: pinger}
+}
+
+// Ready обрабатывает GET /readyz: 200, если хранилище доступно, иначе 503.
+func (h *HealthHandler) Ready(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(c.Request().Context(), readinessTimeout)
+	defer cancel()
+
+	if err := h.pinger.Ping(ctx); err != nil {
+		log.Printf("readiness check failed: %v", err)
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "storage unavailable")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+}
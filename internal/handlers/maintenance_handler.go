@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/labstack/echo/v4"
+)
+
+// MaintenanceStatus - тело запроса и ответа администраторского переключателя режима обслуживания.
+type MaintenanceStatus struct {
+	Enabled *bool `json:"enabled" validate:"required"`
+}
+
+// MaintenanceHandler управляет режимом обслуживания: пока он включён, изменяющие запросы
+// получают 503, а чтение продолжает работать. Режим переключается без перезапуска.
+type MaintenanceHandler struct {
+	enabled atomic.Bool
+}
+
+// NewMaintenanceHandler создаёт handler с начальным состоянием режима обслуживания.
+func NewMaintenanceHandler(enabled bool) *MaintenanceHandler {
+	h := &MaintenanceHandler{}
+	h.enabled.Store(enabled)
+	return h
+}
+
+// Enabled сообщает, включён ли режим обслуживания.
+func (h *MaintenanceHandler) Enabled() bool {
+	return h.enabled.Load()
+}
+
+// Middleware отклоняет запросы с 503, пока включён режим обслуживания.
+// Подключается к маршрутам, изменяющим данные.
+func (h *MaintenanceHandler) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if h.enabled.Load() {
+				return echo.NewHTTPError(http.StatusServiceUnavailable, "service is in maintenance mode")
+			}
+			return next(c)
+		}
+	}
+}
+
+// GetStatus обрабатывает GET /api/admin/maintenance.
+func (h *MaintenanceHandler) GetStatus(c echo.Context) error {
+	enabled := h.enabled.Load()
+	return c.JSON(http.StatusOK, MaintenanceStatus{Enabled: &enabled})
+}
+
+// SetStatus обрабатывает PUT /api/admin/maintenance: включает или выключает режим обслуживания.
+func (h *MaintenanceHandler) SetStatus(c echo.Context) error {
+	var req MaintenanceStatus
+	if err := bindAndValidate(c, &req); err != nil {
+		return err
+	}
+
+	if prev := h.enabled.Swap(*req.Enabled); prev != *req.Enabled {
+		log.Printf("maintenance mode set to %v", *req.Enabled)
+	}
+	return c.JSON(http.StatusOK, req)
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestMaintenanceHandler_Middleware(t *testing.T) {
+	h := NewMaintenanceHandler(true)
+	called := false
+	next := h.Middleware()(func(c echo.Context) error {
+		called = true
+		return c.NoContent(http.StatusOK)
+	})
+
+	e := echo.New()
+	c := e.NewContext(httptest.NewRequest(http.MethodPost, "/api/user/orders", nil), httptest.NewRecorder())
+	err := next(c)
+	he, ok := err.(*echo.HTTPError)
+	if !ok || he.Code != http.StatusServiceUnavailable {
+		t.Fatalf("error = %v, want 503", err)
+	}
+	if called {
+		t.Error("handler was called during maintenance")
+	}
+
+	h.enabled.Store(false)
+	c = e.NewContext(httptest.NewRequest(http.MethodPost, "/api/user/orders", nil), httptest.NewRecorder())
+	if err := next(c); err != nil || !called {
+		t.Errorf("error = %v, called = %v; want the handler to run", err, called)
+	}
+}
+
+func TestMaintenanceHandler_SetStatus(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantStatus  int
+		wantEnabled bool
+	}{
+		{name: "enable", body: `{"enabled":true}`, wantStatus: http.StatusOK, wantEnabled: true},
+		{name: "disable", body: `{"enabled":false}`, wantStatus: http.StatusOK, wantEnabled: false},
+		{name: "missing field keeps state", body: `{}`, wantStatus: http.StatusBadRequest, wantEnabled: true},
+		{name: "wrong type keeps state", body: `{"enabled":"yes"}`, wantStatus: http.StatusBadRequest, wantEnabled: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewMaintenanceHandler(true)
+			e := echo.New()
+			e.Validator = NewRequestValidator()
+			req := httptest.NewRequest(http.MethodPut, "/api/admin/maintenance", strings.NewReader(tt.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+
+			if err := h.SetStatus(c); err != nil {
+				e.HTTPErrorHandler(err, c)
+			}
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if h.Enabled() != tt.wantEnabled {
+				t.Errorf("Enabled() = %v, want %v", h.Enabled(), tt.wantEnabled)
+			}
+		})
+	}
+}
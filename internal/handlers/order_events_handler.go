@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/agamariel/gofermart/internal/auth"
+	"github.com/agamariel/gofermart/internal/services"
+	"github.com/labstack/echo/v4"
+)
+
+// orderEventsKeepAlive - период комментариев-пингов, не дающих прокси закрыть простаивающее соединение.
+const orderEventsKeepAlive = 15 * time.Second
+
+// OrderEventsHandler отдаёт изменения статусов заказов через Server-Sent Events.
+type OrderEventsHandler struct {
+	events    services.OrderEventSubscriber
+	keepAlive time.Duration
+	// conns прерывает открытые потоки при остановке приложения; nil - потоки не отслеживаются.
+	conns *ConnTracker
+}
+
+// NewOrderEventsHandler создаёт handler потока событий заказов.
+// Потоки регистрируются в conns, чтобы остановка приложения могла их закрыть.
+func NewOrderEventsHandler(events services.OrderEventSubscriber, conns *ConnTracker) *OrderEventsHandler {
+	return &OrderEventsHandler{events: events, keepAlive: orderEventsKeepAlive, conns: conns}
+}
+
+// Stream обрабатывает GET /api/user/orders/events: держит соединение открытым и
+// отправляет событие "order" при каждом изменении статуса заказа пользователя.
+// Поток завершается, когда клиент отключается или приложение останавливается.
+func (h *OrderEventsHandler) Stream(c echo.Context) error {
+	userID, err := auth.GetUserIDFromContext(c)
+	if err != nil {
+		return err
+	}
+
+	events, unsubscribe := h.events.Subscribe(userID)
+	defer unsubscribe()
+
+	res := c.Response()
+
+	// Поток живёт дольше таймаутов чтения и записи сервера: снимаем их с соединения.
+	// Иначе по ReadTimeout отменится контекст запроса, а по WriteTimeout оборвётся запись.
+	rc := http.NewResponseController(res)
+	if err := rc.SetReadDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		c.Logger().Warnf("failed to clear read deadline for order events: %v", err)
+	}
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		c.Logger().Warnf("failed to clear write deadline for order events: %v", err)
+	}
+
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set(echo.HeaderConnection, "keep-alive")
+	res.WriteHeader(http.StatusOK)
+
+	// Первый комментарий сообщает клиенту, что подписка оформлена
+	if _, err := fmt.Fprint(res, ": connected\n\n"); err != nil {
+		return nil
+	}
+	res.Flush()
+
+	ticker := time.NewTicker(h.keepAlive)
+	defer ticker.Stop()
+
+	ctx := c.Request().Context()
+	if h.conns != nil {
+		var done func()
+		ctx, done = h.conns.Track(ctx)
+		defer done()
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if _, err := fmt.Fprint(res, ": keep-alive\n\n"); err != nil {
+				return nil
+			}
+			res.Flush()
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				c.Logger().Errorf("failed to encode order event: %v", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(res, "event: order\ndata: %s\n\n", data); err != nil {
+				return nil
+			}
+			res.Flush()
+		}
+	}
+}
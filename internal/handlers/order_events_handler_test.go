@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agamariel/gofermart/internal/models"
+	"github.com/agamariel/gofermart/internal/services"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+func TestOrderEventsHandler_Stream(t *testing.T) {
+	userID := uuid.New()
+	broker := services.NewOrderEventBroker()
+
+	e := echo.New()
+	e.GET("/api/user/orders/events", NewOrderEventsHandler(broker, nil).Stream, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set("user_id", userID)
+			return next(c)
+		}
+	})
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/api/user/orders/events", nil)
+	if err != nil {
+		t.Fatalf("build request: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get(echo.HeaderContentType); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", ct)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	readLine := func() string {
+		t.Helper()
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read stream: %v", err)
+		}
+		return strings.TrimRight(line, "\n")
+	}
+
+	// Ждём подтверждения подписки, чтобы событие не ушло раньше неё
+	if line := readLine(); line != ": connected" {
+		t.Fatalf("first line = %q, want %q", line, ": connected")
+	}
+	readLine()
+
+	accrual := 500.0
+	// Событие другого пользователя не должно попасть в поток
+	broker.Publish(models.OrderEvent{UserID: uuid.New(), Number: "79927398713", Status: "INVALID"})
+	broker.Publish(models.OrderEvent{UserID: userID, Number: "12345678903", Status: "PROCESSED", Accrual: &accrual})
+
+	if line := readLine(); line != "event: order" {
+		t.Fatalf("event line = %q, want %q", line, "event: order")
+	}
+	want := `data: {"number":"12345678903","status":"PROCESSED","accrual":500}`
+	if line := readLine(); line != want {
+		t.Fatalf("data line = %q, want %q", line, want)
+	}
+}
+
+func TestOrderEventsHandler_StopsOnDisconnect(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/api/user/orders/events", nil)
+	ctx, cancel := context.WithCancel(req.Context())
+	req = req.WithContext(ctx)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.Set("user_id", uuid.New())
+
+	done := make(chan error, 1)
+	go func() {
+		done <- NewOrderEventsHandler(services.NewOrderEventBroker(), nil).Stream(c)
+	}()
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Stream() error = %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Stream() did not return after client disconnect")
+	}
+}
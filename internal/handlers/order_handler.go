@@ -1,18 +1,43 @@
 package handlers
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"mime"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/agamariel/gofermart/internal/auth"
 	"github.com/agamariel/gofermart/internal/models"
+	"github.com/agamariel/gofermart/internal/money"
 	"github.com/agamariel/gofermart/internal/services"
+	"github.com/agamariel/gofermart/internal/storage"
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 )
 
+// maxBulkOrders - максимальное число номеров в одном пакетном запросе.
+const maxBulkOrders = 1000
+
+// Постраничная выдача заказов (параметры cursor и limit).
+const (
+	defaultOrdersPageSize = 50
+	maxOrdersPageSize     = 100
+
+	// HeaderNextCursor содержит курсор следующей страницы; отсутствует на последней странице.
+	HeaderNextCursor = "X-Next-Cursor"
+)
+
+// Заголовки условных запросов списка заказов.
+const (
+	HeaderETag        = "ETag"
+	HeaderIfNoneMatch = "If-None-Match"
+)
+
 // OrderHandler обрабатывает запросы, связанные с заказами.
 type OrderHandler struct {
 	orderService services.OrderService
@@ -29,16 +54,112 @@ func (h *OrderHandler) SubmitOrder(c echo.Context) error {
 		return err
 	}
 
-	body, err := io.ReadAll(c.Request().Body)
+	orderNumber, err := readOrderNumber(c)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "unable to read body")
+		return err
 	}
-	orderNumber := strings.TrimSpace(string(body))
+
+	err = h.orderService.SubmitOrder(c.Request().Context(), userID, orderNumber)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidOrderNumber):
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, "invalid order number")
+		case errors.Is(err, services.ErrOrderAlreadyUploaded):
+			return c.NoContent(http.StatusOK)
+		case errors.Is(err, services.ErrOrderOwnedByAnotherUser):
+			return echo.NewHTTPError(http.StatusConflict, "order uploaded by another user")
+		case errors.Is(err, services.ErrOrderLimitExceeded):
+			return echo.NewHTTPError(http.StatusForbidden, "order limit exceeded")
+		case errors.Is(err, services.ErrOrderOwnerUnavailable):
+			// Заказ уже создан параллельным запросом; повтор вернёт 200 или 409
+			c.Response().Header().Set("Retry-After", "1")
+			return echo.NewHTTPError(http.StatusServiceUnavailable, "order status temporarily unavailable")
+		default:
+			return internalServerError(err)
+		}
+	}
+
+	return c.NoContent(http.StatusAccepted)
+}
+
+// readOrderNumber читает номер заказа из тела запроса: text/plain - номер целиком,
+// application/json - объект {"number": "..."}. Без Content-Type тело считается текстом.
+func readOrderNumber(c echo.Context) (string, error) {
+	mediaType := echo.MIMETextPlain
+	if contentType := c.Request().Header.Get(echo.HeaderContentType); contentType != "" {
+		parsed, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return "", echo.NewHTTPError(http.StatusBadRequest, "invalid content type")
+		}
+		mediaType = parsed
+	}
+
+	var orderNumber string
+	switch mediaType {
+	case echo.MIMETextPlain:
+		body, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return "", echo.NewHTTPError(http.StatusBadRequest, "unable to read body")
+		}
+		orderNumber = string(body)
+	case echo.MIMEApplicationJSON:
+		var req models.SubmitOrderRequest
+		if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+			return "", echo.NewHTTPError(http.StatusBadRequest, "invalid request format")
+		}
+		orderNumber = req.Number
+	default:
+		return "", echo.NewHTTPError(http.StatusBadRequest, "unsupported content type")
+	}
+
+	orderNumber = strings.TrimSpace(orderNumber)
 	if orderNumber == "" {
-		return echo.NewHTTPError(http.StatusBadRequest, "empty order number")
+		return "", echo.NewHTTPError(http.StatusBadRequest, "empty order number")
 	}
+	return orderNumber, nil
+}
 
-	err = h.orderService.SubmitOrder(c.Request().Context(), userID, orderNumber)
+// SubmitOrders обрабатывает POST /api/user/orders/bulk.
+// Принимает JSON-массив номеров и возвращает результат по каждому номеру.
+func (h *OrderHandler) SubmitOrders(c echo.Context) error {
+	userID, err := auth.GetUserIDFromContext(c)
+	if err != nil {
+		return err
+	}
+
+	var numbers []string
+	if err := c.Bind(&numbers); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request format")
+	}
+	if len(numbers) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "empty order list")
+	}
+	if len(numbers) > maxBulkOrders {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "too many orders")
+	}
+
+	results, err := h.orderService.SubmitOrders(c.Request().Context(), userID, numbers)
+	if err != nil {
+		return internalServerError(err)
+	}
+
+	return c.JSON(http.StatusOK, results)
+}
+
+// ValidateOrder обрабатывает POST /api/user/orders/validate.
+// Выполняет те же проверки, что и SubmitOrder, но не создаёт заказ.
+func (h *OrderHandler) ValidateOrder(c echo.Context) error {
+	userID, err := auth.GetUserIDFromContext(c)
+	if err != nil {
+		return err
+	}
+
+	orderNumber, err := readOrderNumber(c)
+	if err != nil {
+		return err
+	}
+
+	err = h.orderService.ValidateOrder(c.Request().Context(), userID, orderNumber)
 	if err != nil {
 		switch {
 		case errors.Is(err, services.ErrInvalidOrderNumber):
@@ -48,7 +169,28 @@ func (h *OrderHandler) SubmitOrder(c echo.Context) error {
 		case errors.Is(err, services.ErrOrderOwnedByAnotherUser):
 			return echo.NewHTTPError(http.StatusConflict, "order uploaded by another user")
 		default:
-			return echo.NewHTTPError(http.StatusInternalServerError, "internal server error")
+			return internalServerError(err)
+		}
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+// ReprocessOrder обрабатывает POST /api/admin/orders/:number/reprocess.
+func (h *OrderHandler) ReprocessOrder(c echo.Context) error {
+	number := strings.TrimSpace(c.Param("number"))
+	if number == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "empty order number")
+	}
+
+	if err := h.orderService.ReprocessOrder(c.Request().Context(), number); err != nil {
+		switch {
+		case errors.Is(err, storage.ErrOrderNotFound):
+			return echo.NewHTTPError(http.StatusNotFound, "order not found")
+		case errors.Is(err, services.ErrOrderAlreadyProcessed):
+			return echo.NewHTTPError(http.StatusConflict, "order already processed")
+		default:
+			return internalServerError(err)
 		}
 	}
 
@@ -56,33 +198,218 @@ func (h *OrderHandler) SubmitOrder(c echo.Context) error {
 }
 
 // GetOrders обрабатывает GET /api/user/orders.
+// Параметр sort=asc|desc задаёт порядок по времени загрузки (по умолчанию desc).
+// С параметрами limit и/или cursor заказы отдаются постранично от новых к старым,
+// а курсор следующей страницы возвращается в заголовке X-Next-Cursor.
+// С параметром format=csv все заказы выгружаются файлом CSV.
+// С параметром include=totals вместо массива возвращается объект со списком заказов
+// и суммой начислений total_accrued; постраничный вывод его не поддерживает.
 func (h *OrderHandler) GetOrders(c echo.Context) error {
 	userID, err := auth.GetUserIDFromContext(c)
 	if err != nil {
 		return err
 	}
 
-	orders, err := h.orderService.GetUserOrders(c.Request().Context(), userID)
+	switch c.QueryParam("format") {
+	case "", "json":
+	case "csv":
+		return h.exportOrdersCSV(c, userID)
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid format value")
+	}
+
+	var totals bool
+	switch c.QueryParam("include") {
+	case "":
+	case "totals":
+		totals = true
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid include value")
+	}
+
+	if c.QueryParams().Has("cursor") || c.QueryParams().Has("limit") {
+		if totals {
+			return echo.NewHTTPError(http.StatusBadRequest, "include=totals is not supported with pagination")
+		}
+		return h.getOrdersPage(c, userID)
+	}
+
+	var asc bool
+	switch c.QueryParam("sort") {
+	case "", "desc":
+		asc = false
+	case "asc":
+		asc = true
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid sort value")
+	}
+
+	orders, err := h.orderService.GetUserOrdersSorted(c.Request().Context(), userID, asc)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "internal server error")
+		return internalServerError(err)
 	}
 
 	if len(orders) == 0 {
 		return c.NoContent(http.StatusNoContent)
 	}
 
+	// Опрашивающие клиенты получают 304 без тела, пока список не изменился
+	etag := ordersETag(orders, asc, totals)
+	c.Response().Header().Set(echo.HeaderCacheControl, "private, no-cache")
+	c.Response().Header().Set(HeaderETag, etag)
+	if etagMatches(c.Request().Header.Get(HeaderIfNoneMatch), etag) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
 	// Маппинг domain моделей в DTO
 	response := h.mapOrdersToResponse(orders)
+
+	if totals {
+		total, err := h.orderService.GetAccruedTotal(c.Request().Context(), userID)
+		if err != nil {
+			return internalServerError(err)
+		}
+		return c.JSON(http.StatusOK, &models.OrdersWithTotalsResponse{
+			Orders:       response,
+			TotalAccrued: money.ToFloat(total),
+		})
+	}
+
 	return c.JSON(http.StatusOK, response)
 }
 
+// ordersCSVHeader - заголовок выгрузки заказов в CSV.
+var ordersCSVHeader = []string{"number", "status", "accrual", "uploaded_at"}
+
+// exportOrdersCSV отдаёт заказы пользователя файлом CSV от новых к старым.
+func (h *OrderHandler) exportOrdersCSV(c echo.Context, userID uuid.UUID) error {
+	return streamCSV(c, "orders.csv", ordersCSVHeader, func(write func(record []string) error) error {
+		return h.orderService.ExportUserOrders(c.Request().Context(), userID, func(order *models.Order) error {
+			accrual := ""
+			if order.Accrual != nil {
+				accrual = csvAmount(*order.Accrual)
+			}
+			return write([]string{order.Number, string(order.Status), accrual, csvTime(order.UploadedAt)})
+		})
+	})
+}
+
+// ordersETag возвращает слабый ETag списка заказов: число заказов, время последнего
+// изменения (uploaded_at или updated_at), порядок сортировки и форму ответа (с итогами или без).
+// Новый заказ меняет число, смена статуса или начисления - updated_at.
+func ordersETag(orders []*models.Order, asc, totals bool) string {
+	var latest time.Time
+	for _, o := range orders {
+		if o.UpdatedAt.After(latest) {
+			latest = o.UpdatedAt
+		}
+		if o.UploadedAt.After(latest) {
+			latest = o.UploadedAt
+		}
+	}
+	order := "desc"
+	if asc {
+		order = "asc"
+	}
+	if totals {
+		order += "-totals"
+	}
+	return fmt.Sprintf(`W/"%d-%d-%s"`, len(orders), latest.UnixNano(), order)
+}
+
+// etagMatches сообщает, что If-None-Match содержит etag или "*". Сравнение слабое:
+// префикс W/ не учитывается.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	want := strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == want {
+			return true
+		}
+	}
+	return false
+}
+
+// SearchOrders обрабатывает GET /api/user/orders/search.
+// Параметр q - начало номера заказа (только цифры), limit - максимум заказов в ответе.
+func (h *OrderHandler) SearchOrders(c echo.Context) error {
+	userID, err := auth.GetUserIDFromContext(c)
+	if err != nil {
+		return err
+	}
+
+	limit, err := parseOrdersLimit(c)
+	if err != nil {
+		return err
+	}
+
+	orders, err := h.orderService.SearchUserOrders(c.Request().Context(), userID, c.QueryParam("q"), limit)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidSearchPrefix) {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid search query")
+		}
+		return internalServerError(err)
+	}
+
+	if len(orders) == 0 {
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	return c.JSON(http.StatusOK, h.mapOrdersToResponse(orders))
+}
+
+// getOrdersPage отдаёт страницу заказов по курсору.
+func (h *OrderHandler) getOrdersPage(c echo.Context, userID uuid.UUID) error {
+	if sort := c.QueryParam("sort"); sort != "" && sort != "desc" {
+		return echo.NewHTTPError(http.StatusBadRequest, "pagination supports only desc sort")
+	}
+
+	limit, err := parseOrdersLimit(c)
+	if err != nil {
+		return err
+	}
+
+	orders, next, err := h.orderService.GetUserOrdersPage(c.Request().Context(), userID, c.QueryParam("cursor"), limit)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidCursor) {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid cursor")
+		}
+		return internalServerError(err)
+	}
+
+	if len(orders) == 0 {
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	if next != "" {
+		c.Response().Header().Set(HeaderNextCursor, next)
+	}
+	return c.JSON(http.StatusOK, h.mapOrdersToResponse(orders))
+}
+
+// parseOrdersLimit читает параметр limit; без него используется размер страницы по умолчанию.
+func parseOrdersLimit(c echo.Context) (int, error) {
+	raw := c.QueryParam("limit")
+	if raw == "" {
+		return defaultOrdersPageSize, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n <= 0 || n > maxOrdersPageSize {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "invalid limit value")
+	}
+	return n, nil
+}
+
 // mapOrdersToResponse преобразует domain модели заказов в DTO для HTTP-ответа.
 func (h *OrderHandler) mapOrdersToResponse(orders []*models.Order) []*models.OrderResponse {
 	var response []*models.OrderResponse
 	for _, order := range orders {
 		var accrualPtr *float64
 		if order.Accrual != nil {
-			val, _ := order.Accrual.Float64()
+			val := money.ToFloat(*order.Accrual)
 			accrualPtr = &val
 		}
 
@@ -90,7 +417,7 @@ func (h *OrderHandler) mapOrdersToResponse(orders []*models.Order) []*models.Ord
 			Number:     order.Number,
 			Status:     string(order.Status),
 			Accrual:    accrualPtr,
-			UploadedAt: order.UploadedAt.Format(time.RFC3339),
+			UploadedAt: order.UploadedAt.UTC().Format(time.RFC3339),
 		})
 	}
 	return response
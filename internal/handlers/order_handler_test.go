@@ -4,8 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -13,14 +15,46 @@ import (
 	"github.com/agamariel/gofermart/internal/auth"
 	"github.com/agamariel/gofermart/internal/models"
 	"github.com/agamariel/gofermart/internal/services"
+	"github.com/agamariel/gofermart/internal/storage"
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	"github.com/shopspring/decimal"
 )
 
 type mockOrderService struct {
-	SubmitFunc func(ctx context.Context, userID uuid.UUID, orderNumber string) error
-	ListFunc   func(ctx context.Context, userID uuid.UUID) ([]*models.Order, error)
+	SubmitFunc     func(ctx context.Context, userID uuid.UUID, orderNumber string) error
+	SubmitBulkFunc func(ctx context.Context, userID uuid.UUID, numbers []string) ([]models.BulkOrderResult, error)
+	ValidateFunc   func(ctx context.Context, userID uuid.UUID, orderNumber string) error
+	ListFunc       func(ctx context.Context, userID uuid.UUID) ([]*models.Order, error)
+	ListSortedFunc func(ctx context.Context, userID uuid.UUID, asc bool) ([]*models.Order, error)
+	ListPageFunc   func(ctx context.Context, userID uuid.UUID, cursor string, limit int) ([]*models.Order, string, error)
+	ReprocessFunc  func(ctx context.Context, orderNumber string) error
+	SearchFunc     func(ctx context.Context, userID uuid.UUID, prefix string, limit int) ([]*models.Order, error)
+	ExportFunc     func(ctx context.Context, userID uuid.UUID, fn func(order *models.Order) error) error
+	AccruedFunc    func(ctx context.Context, userID uuid.UUID) (decimal.Decimal, error)
+}
+
+func (m *mockOrderService) GetAccruedTotal(ctx context.Context, userID uuid.UUID) (decimal.Decimal, error) {
+	if m.AccruedFunc != nil {
+		return m.AccruedFunc(ctx, userID)
+	}
+	return decimal.Zero, nil
+}
+
+func (m *mockOrderService) ExportUserOrders(ctx context.Context, userID uuid.UUID, fn func(order *models.Order) error) error {
+	if m.ExportFunc != nil {
+		return m.ExportFunc(ctx, userID, fn)
+	}
+	orders, err := m.GetUserOrders(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, order := range orders {
+		if err := fn(order); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (m *mockOrderService) SubmitOrder(ctx context.Context, userID uuid.UUID, orderNumber string) error {
@@ -30,6 +64,31 @@ func (m *mockOrderService) SubmitOrder(ctx context.Context, userID uuid.UUID, or
 	return nil
 }
 
+func (m *mockOrderService) SubmitOrders(ctx context.Context, userID uuid.UUID, numbers []string) ([]models.BulkOrderResult, error) {
+	if m.SubmitBulkFunc != nil {
+		return m.SubmitBulkFunc(ctx, userID, numbers)
+	}
+	results := make([]models.BulkOrderResult, len(numbers))
+	for i, number := range numbers {
+		results[i] = models.BulkOrderResult{Number: number, Status: models.BulkOrderAccepted}
+	}
+	return results, nil
+}
+
+func (m *mockOrderService) ValidateOrder(ctx context.Context, userID uuid.UUID, orderNumber string) error {
+	if m.ValidateFunc != nil {
+		return m.ValidateFunc(ctx, userID, orderNumber)
+	}
+	return nil
+}
+
+func (m *mockOrderService) ReprocessOrder(ctx context.Context, orderNumber string) error {
+	if m.ReprocessFunc != nil {
+		return m.ReprocessFunc(ctx, orderNumber)
+	}
+	return nil
+}
+
 func (m *mockOrderService) GetUserOrders(ctx context.Context, userID uuid.UUID) ([]*models.Order, error) {
 	if m.ListFunc != nil {
 		return m.ListFunc(ctx, userID)
@@ -37,6 +96,28 @@ func (m *mockOrderService) GetUserOrders(ctx context.Context, userID uuid.UUID)
 	return []*models.Order{}, nil
 }
 
+func (m *mockOrderService) GetUserOrdersPage(ctx context.Context, userID uuid.UUID, cursor string, limit int) ([]*models.Order, string, error) {
+	if m.ListPageFunc != nil {
+		return m.ListPageFunc(ctx, userID, cursor, limit)
+	}
+	return []*models.Order{}, "", nil
+}
+
+func (m *mockOrderService) SearchUserOrders(ctx context.Context, userID uuid.UUID, prefix string, limit int) ([]*models.Order, error) {
+	if m.SearchFunc != nil {
+		return m.SearchFunc(ctx, userID, prefix, limit)
+	}
+	return []*models.Order{}, nil
+}
+
+func (m *mockOrderService) GetUserOrdersSorted(ctx context.Context, userID uuid.UUID, asc bool) ([]*models.Order, error) {
+	if m.ListSortedFunc != nil {
+		return m.ListSortedFunc(ctx, userID, asc)
+	}
+	// Порядок по умолчанию совпадает с GetUserOrders
+	return m.GetUserOrders(ctx, userID)
+}
+
 func TestOrderHandler_SubmitOrder(t *testing.T) {
 	userID := uuid.New()
 
@@ -56,6 +137,17 @@ func TestOrderHandler_SubmitOrder(t *testing.T) {
 			},
 			expectedStatus: http.StatusAccepted,
 		},
+		{
+			// Сервис сбрасывает собственный INVALID-заказ в NEW и не возвращает ошибку
+			name: "resubmitted invalid order",
+			body: "79927398713",
+			mockService: &mockOrderService{
+				SubmitFunc: func(ctx context.Context, uid uuid.UUID, number string) error {
+					return nil
+				},
+			},
+			expectedStatus: http.StatusAccepted,
+		},
 		{
 			name: "already uploaded by same user",
 			body: "79927398713",
@@ -66,6 +158,16 @@ func TestOrderHandler_SubmitOrder(t *testing.T) {
 			},
 			expectedStatus: http.StatusOK,
 		},
+		{
+			name: "order limit exceeded",
+			body: "79927398713",
+			mockService: &mockOrderService{
+				SubmitFunc: func(ctx context.Context, uid uuid.UUID, number string) error {
+					return services.ErrOrderLimitExceeded
+				},
+			},
+			expectedStatus: http.StatusForbidden,
+		},
 		{
 			name: "order owned by another user",
 			body: "79927398713",
@@ -76,6 +178,16 @@ func TestOrderHandler_SubmitOrder(t *testing.T) {
 			},
 			expectedStatus: http.StatusConflict,
 		},
+		{
+			name: "order owner unavailable after race",
+			body: "79927398713",
+			mockService: &mockOrderService{
+				SubmitFunc: func(ctx context.Context, uid uuid.UUID, number string) error {
+					return fmt.Errorf("%w: connection reset", services.ErrOrderOwnerUnavailable)
+				},
+			},
+			expectedStatus: http.StatusServiceUnavailable,
+		},
 		{
 			name: "invalid number",
 			body: "12345",
@@ -173,6 +285,10 @@ func TestOrderHandler_GetOrders(t *testing.T) {
 				if !strings.Contains(body, "79927398713") {
 					t.Errorf("response body does not contain order number: %s", body)
 				}
+				// Без начисления поле accrual не выводится
+				if strings.Contains(body, `"accrual"`) {
+					t.Errorf("accrual must be omitted when nil: %s", body)
+				}
 			},
 		},
 		{
@@ -203,9 +319,17 @@ func TestOrderHandler_GetOrders(t *testing.T) {
 				if len(resp) != 1 {
 					t.Fatalf("unexpected response length: %d", len(resp))
 				}
-				if _, ok := resp[0]["accrual"]; !ok {
+				accrual, ok := resp[0]["accrual"]
+				if !ok {
 					t.Fatalf("accrual field is missing in response: %s", body)
 				}
+				// Начисление - JSON-число, а не строка, как при сериализации decimal.Decimal
+				if number, isNumber := accrual.(float64); !isNumber || number != 729.98 {
+					t.Errorf("accrual = %#v, want JSON number 729.98", accrual)
+				}
+				if uploadedAt := resp[0]["uploaded_at"]; uploadedAt != "2025-12-09T15:04:05Z" {
+					t.Errorf("uploaded_at = %v, want RFC3339 2025-12-09T15:04:05Z", uploadedAt)
+				}
 			},
 		},
 		{
@@ -281,3 +405,778 @@ func TestOrderHandler_GetOrders(t *testing.T) {
 		})
 	}
 }
+
+func TestOrderHandler_GetOrdersSort(t *testing.T) {
+	userID := uuid.New()
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		wantAsc        bool
+	}{
+		{name: "default is desc", query: "", expectedStatus: http.StatusOK, wantAsc: false},
+		{name: "explicit desc", query: "?sort=desc", expectedStatus: http.StatusOK, wantAsc: false},
+		{name: "asc", query: "?sort=asc", expectedStatus: http.StatusOK, wantAsc: true},
+		{name: "unknown value", query: "?sort=random", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotAsc *bool
+			mock := &mockOrderService{
+				ListSortedFunc: func(ctx context.Context, uid uuid.UUID, asc bool) ([]*models.Order, error) {
+					gotAsc = &asc
+					return []*models.Order{{Number: "79927398713", Status: models.OrderStatusNew}}, nil
+				},
+			}
+
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodGet, "/api/user/orders"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.Set(string(auth.UserIDKey), userID)
+
+			handler := NewOrderHandler(mock)
+			err := handler.GetOrders(c)
+
+			if tt.expectedStatus >= 400 {
+				he, ok := err.(*echo.HTTPError)
+				if !ok || he.Code != tt.expectedStatus {
+					t.Fatalf("expected HTTP error %d, got %v", tt.expectedStatus, err)
+				}
+				if gotAsc != nil {
+					t.Fatal("service must not be called for invalid sort")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if gotAsc == nil || *gotAsc != tt.wantAsc {
+				t.Fatalf("asc = %v, want %v", gotAsc, tt.wantAsc)
+			}
+		})
+	}
+}
+
+func TestOrderHandler_GetOrdersTotals(t *testing.T) {
+	userID := uuid.New()
+	uploadedAt := time.Date(2025, 12, 9, 15, 4, 5, 0, time.UTC)
+	accrual := decimal.RequireFromString("729.98")
+	orders := []*models.Order{
+		{Number: "2357281120545", Status: models.OrderStatusProcessed, Accrual: &accrual, UploadedAt: uploadedAt},
+		{Number: "79927398713", Status: models.OrderStatusNew, UploadedAt: uploadedAt},
+	}
+
+	tests := []struct {
+		name           string
+		query          string
+		orders         []*models.Order
+		accruedErr     error
+		expectedStatus int
+		wantTotals     bool
+	}{
+		{name: "plain array by default", query: "", orders: orders, expectedStatus: http.StatusOK},
+		{name: "with totals", query: "?include=totals", orders: orders, expectedStatus: http.StatusOK, wantTotals: true},
+		{name: "with totals and sort", query: "?include=totals&sort=asc", orders: orders, expectedStatus: http.StatusOK, wantTotals: true},
+		{name: "no content with totals", query: "?include=totals", orders: nil, expectedStatus: http.StatusNoContent},
+		{name: "unknown include", query: "?include=balance", orders: orders, expectedStatus: http.StatusBadRequest},
+		{name: "totals with pagination", query: "?include=totals&limit=10", orders: orders, expectedStatus: http.StatusBadRequest},
+		{name: "totals storage error", query: "?include=totals", orders: orders, accruedErr: errors.New("db error"), expectedStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var accruedCalls int
+			mock := &mockOrderService{
+				ListFunc: func(ctx context.Context, uid uuid.UUID) ([]*models.Order, error) {
+					return tt.orders, nil
+				},
+				AccruedFunc: func(ctx context.Context, uid uuid.UUID) (decimal.Decimal, error) {
+					accruedCalls++
+					if uid != userID {
+						t.Errorf("userID = %s, want %s", uid, userID)
+					}
+					return accrual, tt.accruedErr
+				},
+			}
+
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodGet, "/api/user/orders"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.Set(string(auth.UserIDKey), userID)
+
+			err := NewOrderHandler(mock).GetOrders(c)
+
+			if tt.expectedStatus >= 400 {
+				he, ok := err.(*echo.HTTPError)
+				if !ok || he.Code != tt.expectedStatus {
+					t.Fatalf("expected HTTP error %d, got %v", tt.expectedStatus, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.expectedStatus)
+			}
+			if tt.expectedStatus == http.StatusNoContent {
+				if rec.Body.Len() != 0 {
+					t.Errorf("body = %q, want empty", rec.Body.String())
+				}
+				if accruedCalls != 0 {
+					t.Errorf("GetAccruedTotal called %d times for an empty list", accruedCalls)
+				}
+				return
+			}
+
+			if !tt.wantTotals {
+				var resp []models.OrderResponse
+				if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+					t.Fatalf("response is not an array: %v; body = %s", err, rec.Body)
+				}
+				if len(resp) != len(orders) {
+					t.Errorf("orders = %d, want %d", len(resp), len(orders))
+				}
+				if accruedCalls != 0 {
+					t.Errorf("GetAccruedTotal called %d times without include=totals", accruedCalls)
+				}
+				return
+			}
+
+			var resp map[string]json.RawMessage
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("response is not an object: %v; body = %s", err, rec.Body)
+			}
+			var list []models.OrderResponse
+			if err := json.Unmarshal(resp["orders"], &list); err != nil || len(list) != len(orders) {
+				t.Errorf("orders = %s, want %d orders", resp["orders"], len(orders))
+			}
+			if got := string(resp["total_accrued"]); got != "729.98" {
+				t.Errorf("total_accrued = %s, want JSON number 729.98", got)
+			}
+			if accruedCalls != 1 {
+				t.Errorf("GetAccruedTotal called %d times, want 1", accruedCalls)
+			}
+			if etag := rec.Header().Get(HeaderETag); !strings.Contains(etag, "totals") {
+				t.Errorf("ETag = %q must differ from the plain list", etag)
+			}
+		})
+	}
+}
+
+func TestOrderHandler_GetOrdersCSV(t *testing.T) {
+	userID := uuid.New()
+	accrual := decimal.RequireFromString("729.98")
+	orders := []*models.Order{
+		{Number: "9278923470", Status: models.OrderStatusProcessed, Accrual: &accrual, UploadedAt: time.Date(2020, 12, 10, 18, 12, 1, 0, time.FixedZone("MSK", 3*60*60))},
+		{Number: "12345678903", Status: models.OrderStatusNew, UploadedAt: time.Date(2020, 12, 9, 16, 9, 57, 0, time.UTC)},
+	}
+	errDB := errors.New("db error")
+
+	tests := []struct {
+		name           string
+		query          string
+		exportErr      error
+		orders         []*models.Order
+		expectedStatus int
+		wantBody       string
+	}{
+		{
+			name:           "orders",
+			query:          "?format=csv",
+			orders:         orders,
+			expectedStatus: http.StatusOK,
+			wantBody: "number,status,accrual,uploaded_at\n" +
+				"9278923470,PROCESSED,729.98,2020-12-10T15:12:01Z\n" +
+				"12345678903,NEW,,2020-12-09T16:09:57Z\n",
+		},
+		{
+			name:           "no orders",
+			query:          "?format=csv",
+			expectedStatus: http.StatusOK,
+			wantBody:       "number,status,accrual,uploaded_at\n",
+		},
+		{name: "storage error before the first row", query: "?format=csv", exportErr: errDB, expectedStatus: http.StatusInternalServerError},
+		{name: "unknown format", query: "?format=xml", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := &mockOrderService{
+				ExportFunc: func(ctx context.Context, uid uuid.UUID, fn func(order *models.Order) error) error {
+					if uid != userID {
+						t.Errorf("ExportUserOrders() user = %s, want %s", uid, userID)
+					}
+					if tt.exportErr != nil {
+						return tt.exportErr
+					}
+					for _, order := range tt.orders {
+						if err := fn(order); err != nil {
+							return err
+						}
+					}
+					return nil
+				},
+			}
+
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodGet, "/api/user/orders"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.Set(string(auth.UserIDKey), userID)
+
+			if err := NewOrderHandler(mock).GetOrders(c); err != nil {
+				e.HTTPErrorHandler(err, c)
+			}
+
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.expectedStatus)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			if ct := rec.Header().Get(echo.HeaderContentType); ct != "text/csv; charset=utf-8" {
+				t.Errorf("Content-Type = %q, want text/csv", ct)
+			}
+			if cd := rec.Header().Get(echo.HeaderContentDisposition); cd != `attachment; filename="orders.csv"` {
+				t.Errorf("Content-Disposition = %q", cd)
+			}
+			if rec.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+
+	t.Run("error after the first row aborts the export", func(t *testing.T) {
+		mock := &mockOrderService{
+			ExportFunc: func(ctx context.Context, uid uuid.UUID, fn func(order *models.Order) error) error {
+				if err := fn(orders[0]); err != nil {
+					return err
+				}
+				return errDB
+			},
+		}
+
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodGet, "/api/user/orders?format=csv", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.Set(string(auth.UserIDKey), userID)
+
+		if err := NewOrderHandler(mock).GetOrders(c); !errors.Is(err, errDB) {
+			t.Fatalf("GetOrders() error = %v, want %v", err, errDB)
+		}
+		if rec.Code != http.StatusOK {
+			t.Errorf("status = %d, want the already started 200", rec.Code)
+		}
+	})
+}
+
+func TestOrderHandler_GetOrdersETag(t *testing.T) {
+	userID := uuid.New()
+	uploadedAt := time.Date(2025, 12, 9, 15, 4, 5, 0, time.UTC)
+	order := &models.Order{Number: "79927398713", Status: models.OrderStatusNew, UploadedAt: uploadedAt, UpdatedAt: uploadedAt}
+	handler := NewOrderHandler(&mockOrderService{
+		ListSortedFunc: func(ctx context.Context, uid uuid.UUID, asc bool) ([]*models.Order, error) {
+			o := *order
+			return []*models.Order{&o}, nil
+		},
+	})
+
+	get := func(query, ifNoneMatch string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/user/orders"+query, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set(HeaderIfNoneMatch, ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.Set(string(auth.UserIDKey), userID)
+		if err := handler.GetOrders(c); err != nil {
+			t.Fatalf("GetOrders() error = %v", err)
+		}
+		return rec
+	}
+
+	first := get("", "")
+	etag := first.Header().Get(HeaderETag)
+	if first.Code != http.StatusOK || !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("first request: status = %d, ETag = %q; want 200 with a weak ETag", first.Code, etag)
+	}
+	if cc := first.Header().Get(echo.HeaderCacheControl); cc != "private, no-cache" {
+		t.Errorf("Cache-Control = %q, want private, no-cache", cc)
+	}
+
+	t.Run("matching If-None-Match returns 304", func(t *testing.T) {
+		for _, header := range []string{etag, `"other", ` + etag, strings.TrimPrefix(etag, "W/"), "*"} {
+			rec := get("", header)
+			if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+				t.Errorf("If-None-Match %q: status = %d, body = %q; want 304 without body", header, rec.Code, rec.Body)
+			}
+			if got := rec.Header().Get(HeaderETag); got != etag {
+				t.Errorf("If-None-Match %q: ETag = %q, want %q", header, got, etag)
+			}
+		}
+	})
+
+	t.Run("sort order has its own ETag", func(t *testing.T) {
+		rec := get("?sort=asc", etag)
+		if rec.Code != http.StatusOK || rec.Header().Get(HeaderETag) == etag {
+			t.Errorf("status = %d, ETag = %q; want 200 with a different ETag", rec.Code, rec.Header().Get(HeaderETag))
+		}
+	})
+
+	t.Run("status change invalidates ETag", func(t *testing.T) {
+		accrual := decimal.NewFromInt(500)
+		order.Status = models.OrderStatusProcessed
+		order.Accrual = &accrual
+		order.UpdatedAt = uploadedAt.Add(time.Minute)
+
+		rec := get("", etag)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200 after the order changed", rec.Code)
+		}
+		newETag := rec.Header().Get(HeaderETag)
+		if newETag == etag {
+			t.Fatal("ETag did not change after the status change")
+		}
+		if !strings.Contains(rec.Body.String(), string(models.OrderStatusProcessed)) {
+			t.Errorf("body = %s, want the new status", rec.Body)
+		}
+		if rec := get("", newETag); rec.Code != http.StatusNotModified {
+			t.Errorf("status = %d, want 304 for the new ETag", rec.Code)
+		}
+	})
+}
+
+func TestOrderHandler_ValidateOrder(t *testing.T) {
+	userID := uuid.New()
+
+	tests := []struct {
+		name           string
+		body           string
+		validateErr    error
+		expectedStatus int
+	}{
+		{name: "valid order", body: "79927398713", expectedStatus: http.StatusOK},
+		{name: "already uploaded by same user", body: "79927398713", validateErr: services.ErrOrderAlreadyUploaded, expectedStatus: http.StatusOK},
+		{name: "owned by another user", body: "79927398713", validateErr: services.ErrOrderOwnedByAnotherUser, expectedStatus: http.StatusConflict},
+		{name: "invalid number", body: "12345", validateErr: services.ErrInvalidOrderNumber, expectedStatus: http.StatusUnprocessableEntity},
+		{name: "empty body", body: "", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := &mockOrderService{
+				ValidateFunc: func(ctx context.Context, uid uuid.UUID, number string) error {
+					return tt.validateErr
+				},
+				SubmitFunc: func(ctx context.Context, uid uuid.UUID, number string) error {
+					t.Fatal("SubmitOrder must not be called on validation")
+					return nil
+				},
+			}
+
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/api/user/orders/validate", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.Set(string(auth.UserIDKey), userID)
+
+			handler := NewOrderHandler(mock)
+			if err := handler.ValidateOrder(c); err != nil {
+				e.HTTPErrorHandler(err, c)
+			}
+
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.expectedStatus)
+			}
+		})
+	}
+}
+
+func TestOrderHandler_ReprocessOrder(t *testing.T) {
+	tests := []struct {
+		name           string
+		reprocessErr   error
+		expectedStatus int
+	}{
+		{name: "reset", expectedStatus: http.StatusAccepted},
+		{name: "not found", reprocessErr: storage.ErrOrderNotFound, expectedStatus: http.StatusNotFound},
+		{name: "already processed", reprocessErr: services.ErrOrderAlreadyProcessed, expectedStatus: http.StatusConflict},
+		{name: "internal error", reprocessErr: errors.New("db error"), expectedStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotNumber string
+			mock := &mockOrderService{
+				ReprocessFunc: func(ctx context.Context, number string) error {
+					gotNumber = number
+					return tt.reprocessErr
+				},
+			}
+
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/api/admin/orders/79927398713/reprocess", nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("number")
+			c.SetParamValues("79927398713")
+
+			handler := NewOrderHandler(mock)
+			if err := handler.ReprocessOrder(c); err != nil {
+				e.HTTPErrorHandler(err, c)
+			}
+
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.expectedStatus)
+			}
+			if gotNumber != "79927398713" {
+				t.Errorf("number = %q, want 79927398713", gotNumber)
+			}
+		})
+	}
+}
+
+func TestOrderHandler_SubmitOrders(t *testing.T) {
+	userID := uuid.New()
+
+	tests := []struct {
+		name           string
+		body           string
+		mockService    *mockOrderService
+		expectedStatus int
+		wantBody       string
+	}{
+		{
+			name: "mixed results",
+			body: `["79927398713","12345","2377225624"]`,
+			mockService: &mockOrderService{
+				SubmitBulkFunc: func(ctx context.Context, uid uuid.UUID, numbers []string) ([]models.BulkOrderResult, error) {
+					return []models.BulkOrderResult{
+						{Number: numbers[0], Status: models.BulkOrderAccepted},
+						{Number: numbers[1], Status: models.BulkOrderInvalid},
+						{Number: numbers[2], Status: models.BulkOrderConflict},
+					}, nil
+				},
+			},
+			expectedStatus: http.StatusOK,
+			wantBody:       `[{"number":"79927398713","status":"accepted"},{"number":"12345","status":"invalid"},{"number":"2377225624","status":"conflict"}]`,
+		},
+		{
+			name:           "not an array",
+			body:           `"79927398713"`,
+			mockService:    &mockOrderService{},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "empty array",
+			body:           `[]`,
+			mockService:    &mockOrderService{},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "service error",
+			body: `["79927398713"]`,
+			mockService: &mockOrderService{
+				SubmitBulkFunc: func(ctx context.Context, uid uuid.UUID, numbers []string) ([]models.BulkOrderResult, error) {
+					return nil, errors.New("db down")
+				},
+			},
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/api/user/orders/bulk", strings.NewReader(tt.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.Set(string(auth.UserIDKey), userID)
+
+			handler := NewOrderHandler(tt.mockService)
+			if err := handler.SubmitOrders(c); err != nil {
+				e.HTTPErrorHandler(err, c)
+			}
+
+			if rec.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, rec.Code)
+			}
+			if tt.wantBody != "" {
+				if got := strings.TrimSpace(rec.Body.String()); got != tt.wantBody {
+					t.Errorf("body = %s, want %s", got, tt.wantBody)
+				}
+			}
+		})
+	}
+}
+
+func TestOrderHandler_GetOrdersPaginated(t *testing.T) {
+	userID := uuid.New()
+	uploaded := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	var all []*models.Order
+	for i := 0; i < 5; i++ {
+		all = append(all, &models.Order{Number: strconv.Itoa(i), Status: models.OrderStatusNew, UploadedAt: uploaded})
+	}
+
+	// Курсор непрозрачен для handler, поэтому мок использует в нём индекс
+	service := &mockOrderService{
+		ListPageFunc: func(ctx context.Context, uid uuid.UUID, cursor string, limit int) ([]*models.Order, string, error) {
+			start := 0
+			if cursor != "" {
+				n, err := strconv.Atoi(cursor)
+				if err != nil {
+					return nil, "", services.ErrInvalidCursor
+				}
+				start = n
+			}
+			end := start + limit
+			if end >= len(all) {
+				return all[start:], "", nil
+			}
+			return all[start:end], strconv.Itoa(end), nil
+		},
+	}
+	handler := NewOrderHandler(service)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodGet, "/api/user/orders"+query, nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.Set(string(auth.UserIDKey), userID)
+		if err := handler.GetOrders(c); err != nil {
+			e.HTTPErrorHandler(err, c)
+		}
+		return rec
+	}
+
+	t.Run("iterates all pages", func(t *testing.T) {
+		var numbers []string
+		query := "?limit=2"
+		for pages := 0; ; pages++ {
+			if pages > len(all) {
+				t.Fatal("pagination does not terminate")
+			}
+			rec := get(query)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+			}
+
+			var page []models.OrderResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+				t.Fatalf("decode page: %v", err)
+			}
+			for _, o := range page {
+				numbers = append(numbers, o.Number)
+			}
+
+			next := rec.Header().Get(HeaderNextCursor)
+			if next == "" {
+				break
+			}
+			query = "?limit=2&cursor=" + next
+		}
+
+		if got := strings.Join(numbers, ","); got != "0,1,2,3,4" {
+			t.Errorf("orders = %s, want 0,1,2,3,4", got)
+		}
+	})
+
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{name: "corrupted cursor", query: "?cursor=garbage"},
+		{name: "zero limit", query: "?limit=0"},
+		{name: "limit too large", query: "?limit=1000"},
+		{name: "limit not a number", query: "?limit=ten"},
+		{name: "asc sort", query: "?limit=2&sort=asc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := get(tt.query); rec.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func TestOrderHandler_SubmitOrderContentType(t *testing.T) {
+	tests := []struct {
+		name           string
+		contentType    string
+		body           string
+		expectedStatus int
+		wantNumber     string
+	}{
+		{name: "text/plain", contentType: echo.MIMETextPlain, body: "79927398713\n", expectedStatus: http.StatusAccepted, wantNumber: "79927398713"},
+		{name: "text/plain with charset", contentType: "text/plain; charset=utf-8", body: "79927398713", expectedStatus: http.StatusAccepted, wantNumber: "79927398713"},
+		{name: "no content type", body: "79927398713", expectedStatus: http.StatusAccepted, wantNumber: "79927398713"},
+		{name: "application/json", contentType: echo.MIMEApplicationJSON, body: `{"number":"79927398713"}`, expectedStatus: http.StatusAccepted, wantNumber: "79927398713"},
+		{name: "application/json with charset", contentType: echo.MIMEApplicationJSONCharsetUTF8, body: `{"number":" 79927398713 "}`, expectedStatus: http.StatusAccepted, wantNumber: "79927398713"},
+		{name: "json without number", contentType: echo.MIMEApplicationJSON, body: `{}`, expectedStatus: http.StatusBadRequest},
+		{name: "malformed json", contentType: echo.MIMEApplicationJSON, body: `{"number":`, expectedStatus: http.StatusBadRequest},
+		{name: "unsupported type", contentType: echo.MIMEApplicationXML, body: `<number>79927398713</number>`, expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var submitted string
+			service := &mockOrderService{
+				SubmitFunc: func(ctx context.Context, uid uuid.UUID, number string) error {
+					submitted = number
+					return nil
+				},
+			}
+
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/api/user/orders", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set(echo.HeaderContentType, tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.Set(string(auth.UserIDKey), uuid.New())
+
+			if err := NewOrderHandler(service).SubmitOrder(c); err != nil {
+				e.HTTPErrorHandler(err, c)
+			}
+
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.expectedStatus)
+			}
+			if submitted != tt.wantNumber {
+				t.Errorf("submitted number = %q, want %q", submitted, tt.wantNumber)
+			}
+		})
+	}
+}
+
+func TestOrderHandler_SearchOrders(t *testing.T) {
+	userID := uuid.New()
+	uploaded := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	all := []*models.Order{
+		{Number: "79927398713", Status: models.OrderStatusProcessed, UploadedAt: uploaded},
+		{Number: "12345678903", Status: models.OrderStatusNew, UploadedAt: uploaded},
+	}
+
+	tests := []struct {
+		name           string
+		query          string
+		searchErr      error
+		expectedStatus int
+		wantNumbers    []string
+		wantLimit      int
+	}{
+		{name: "matching prefix", query: "?q=7992", expectedStatus: http.StatusOK, wantNumbers: []string{"79927398713"}, wantLimit: defaultOrdersPageSize},
+		{name: "custom limit", query: "?q=1&limit=5", expectedStatus: http.StatusOK, wantNumbers: []string{"12345678903"}, wantLimit: 5},
+		{name: "non-matching prefix", query: "?q=555", expectedStatus: http.StatusNoContent, wantLimit: defaultOrdersPageSize},
+		{name: "invalid prefix", query: "?q=abc", searchErr: services.ErrInvalidSearchPrefix, expectedStatus: http.StatusBadRequest, wantLimit: defaultOrdersPageSize},
+		{name: "invalid limit", query: "?q=7&limit=1000", expectedStatus: http.StatusBadRequest},
+		{name: "storage error", query: "?q=7", searchErr: errors.New("db down"), expectedStatus: http.StatusInternalServerError, wantLimit: defaultOrdersPageSize},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotLimit int
+			service := &mockOrderService{
+				SearchFunc: func(ctx context.Context, uid uuid.UUID, prefix string, limit int) ([]*models.Order, error) {
+					gotLimit = limit
+					if uid != userID {
+						t.Errorf("userID = %v, want %v", uid, userID)
+					}
+					if tt.searchErr != nil {
+						return nil, tt.searchErr
+					}
+					var found []*models.Order
+					for _, o := range all {
+						if strings.HasPrefix(o.Number, prefix) {
+							found = append(found, o)
+						}
+					}
+					return found, nil
+				},
+			}
+
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodGet, "/api/user/orders/search"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.Set(string(auth.UserIDKey), userID)
+
+			if err := NewOrderHandler(service).SearchOrders(c); err != nil {
+				e.HTTPErrorHandler(err, c)
+			}
+
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.expectedStatus)
+			}
+			if gotLimit != tt.wantLimit {
+				t.Errorf("limit = %d, want %d", gotLimit, tt.wantLimit)
+			}
+			if tt.wantNumbers == nil {
+				return
+			}
+
+			var got []models.OrderResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			var numbers []string
+			for _, o := range got {
+				numbers = append(numbers, o.Number)
+			}
+			if strings.Join(numbers, ",") != strings.Join(tt.wantNumbers, ",") {
+				t.Errorf("orders = %v, want %v", numbers, tt.wantNumbers)
+			}
+		})
+	}
+}
+
+func TestOrderHandler_GetOrdersUploadedAtFormat(t *testing.T) {
+	// Время из БД содержит доли секунды и часовой пояс - в ответе остаётся чистый RFC3339 в UTC
+	moscow := time.FixedZone("MSK", 3*60*60)
+	uploadedAt := time.Date(2025, 12, 9, 18, 4, 5, 123456789, moscow)
+
+	service := &mockOrderService{
+		ListFunc: func(ctx context.Context, uid uuid.UUID) ([]*models.Order, error) {
+			return []*models.Order{{Number: "79927398713", Status: models.OrderStatusNew, UploadedAt: uploadedAt}}, nil
+		},
+	}
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/api/user/orders", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.Set(string(auth.UserIDKey), uuid.New())
+
+	if err := NewOrderHandler(service).GetOrders(c); err != nil {
+		t.Fatalf("GetOrders() error = %v", err)
+	}
+
+	var resp []models.OrderResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(resp) != 1 {
+		t.Fatalf("got %d orders, want 1", len(resp))
+	}
+
+	const want = "2025-12-09T15:04:05Z"
+	if resp[0].UploadedAt != want {
+		t.Errorf("uploaded_at = %q, want %q", resp[0].UploadedAt, want)
+	}
+	if parsed, err := time.Parse(time.RFC3339, resp[0].UploadedAt); err != nil || parsed.Nanosecond() != 0 {
+		t.Errorf("uploaded_at %q is not RFC3339 without fractional seconds", resp[0].UploadedAt)
+	}
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/agamariel/gofermart/internal/services"
+	"github.com/labstack/echo/v4"
+)
+
+// TokenExpiration - тело запроса и ответа администраторской настройки времени жизни токенов.
+// Expiration задаётся в формате Go duration ("30m", "12h").
+type TokenExpiration struct {
+	Expiration string `json:"expiration" validate:"required"`
+}
+
+// TokenExpirationHandler позволяет администратору менять время жизни выпускаемых токенов
+// без перезапуска. Уже выданные токены сохраняют прежний срок действия.
+type TokenExpirationHandler struct {
+	service services.TokenExpirationService
+}
+
+// NewTokenExpirationHandler создаёт новый экземпляр TokenExpirationHandler.
+func NewTokenExpirationHandler(service services.TokenExpirationService) *TokenExpirationHandler {
+	return &TokenExpirationHandler{service: service}
+}
+
+// GetExpiration обрабатывает GET /api/admin/token-expiration.
+func (h *TokenExpirationHandler) GetExpiration(c echo.Context) error {
+	return c.JSON(http.StatusOK, TokenExpiration{Expiration: h.service.TokenExpiration().String()})
+}
+
+// SetExpiration обрабатывает PUT /api/admin/token-expiration.
+func (h *TokenExpirationHandler) SetExpiration(c echo.Context) error {
+	var req TokenExpiration
+	if err := bindAndValidate(c, &req); err != nil {
+		return err
+	}
+
+	exp, err := time.ParseDuration(req.Expiration)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "expiration must be a duration such as 30m or 12h")
+	}
+	if err := h.service.SetTokenExpiration(exp); err != nil {
+		if errors.Is(err, services.ErrInvalidTokenExpiration) {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		logUnexpected(c, "failed to set token expiration: %v", err)
+		return internalServerError(err)
+	}
+
+	log.Printf("token expiration set to %s", exp)
+	return c.JSON(http.StatusOK, TokenExpiration{Expiration: exp.String()})
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agamariel/gofermart/internal/services"
+	"github.com/labstack/echo/v4"
+)
+
+func TestTokenExpirationHandler_SetExpiration(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantTTL    time.Duration
+	}{
+		{name: "valid duration", body: `{"expiration":"2h"}`, wantStatus: http.StatusOK, wantTTL: 2 * time.Hour},
+		{name: "zero keeps ttl", body: `{"expiration":"0s"}`, wantStatus: http.StatusBadRequest, wantTTL: time.Hour},
+		{name: "negative keeps ttl", body: `{"expiration":"-5m"}`, wantStatus: http.StatusBadRequest, wantTTL: time.Hour},
+		{name: "not a duration", body: `{"expiration":"two hours"}`, wantStatus: http.StatusBadRequest, wantTTL: time.Hour},
+		{name: "missing field", body: `{}`, wantStatus: http.StatusBadRequest, wantTTL: time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := services.NewUserService(nil, "test-secret", time.Hour)
+			h := NewTokenExpirationHandler(svc)
+			e := echo.New()
+			e.Validator = NewRequestValidator()
+			req := httptest.NewRequest(http.MethodPut, "/api/admin/token-expiration", strings.NewReader(tt.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+
+			if err := h.SetExpiration(c); err != nil {
+				e.HTTPErrorHandler(err, c)
+			}
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d (body %s)", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if svc.TokenExpiration() != tt.wantTTL {
+				t.Errorf("TokenExpiration() = %v, want %v", svc.TokenExpiration(), tt.wantTTL)
+			}
+			if tt.wantStatus == http.StatusOK && !strings.Contains(rec.Body.String(), `"2h0m0s"`) {
+				t.Errorf("body = %s, want the new expiration", rec.Body)
+			}
+		})
+	}
+}
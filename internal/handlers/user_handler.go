@@ -2,10 +2,14 @@ package handlers
 
 import (
 	"errors"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/agamariel/gofermart/internal/auth"
 	"github.com/agamariel/gofermart/internal/models"
+	"github.com/agamariel/gofermart/internal/money"
 	"github.com/agamariel/gofermart/internal/services"
 	"github.com/agamariel/gofermart/internal/storage"
 	"github.com/labstack/echo/v4"
@@ -14,12 +18,14 @@ import (
 // UserHandler обрабатывает HTTP-запросы для работы с пользователями.
 type UserHandler struct {
 	userService services.UserService
+	cookieCfg   CookieConfig
 }
 
 // NewUserHandler создаёт новый экземпляр UserHandler.
-func NewUserHandler(userService services.UserService) *UserHandler {
+func NewUserHandler(userService services.UserService, cookieCfg CookieConfig) *UserHandler {
 	return &UserHandler{
 		userService: userService,
+		cookieCfg:   cookieCfg,
 	}
 }
 
@@ -27,9 +33,9 @@ func NewUserHandler(userService services.UserService) *UserHandler {
 func (h *UserHandler) Register(c echo.Context) error {
 	var req models.RegisterRequest
 
-	// Парсинг JSON body
-	if err := c.Bind(&req); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "invalid request format")
+	// Парсинг и валидация JSON body
+	if err := bindAndValidate(c, &req); err != nil {
+		return err
 	}
 
 	// Вызов сервиса регистрации
@@ -41,27 +47,24 @@ func (h *UserHandler) Register(c echo.Context) error {
 		if errors.Is(err, storage.ErrLoginExists) {
 			return echo.NewHTTPError(http.StatusConflict, "login already exists")
 		}
-		c.Logger().Errorf("failed to register user: %v", err)
-		return echo.NewHTTPError(http.StatusInternalServerError, "internal server error")
+		logUnexpected(c, "failed to register user: %v", err)
+		return internalServerError(err)
 	}
 
 	// Установка токена в cookie и заголовок
-	setAuthToken(c, token)
+	setAuthToken(c, token, h.cookieCfg)
 
 	// Возврат успешного ответа
-	return c.JSON(http.StatusOK, map[string]interface{}{
-		"user_id": user.ID,
-		"login":   user.Login,
-	})
+	return c.JSON(http.StatusOK, h.authResponse(user, token))
 }
 
 // Login обрабатывает POST /api/user/login.
 func (h *UserHandler) Login(c echo.Context) error {
 	var req models.LoginRequest
 
-	// Парсинг JSON body
-	if err := c.Bind(&req); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "invalid request format")
+	// Парсинг и валидация JSON body
+	if err := bindAndValidate(c, &req); err != nil {
+		return err
 	}
 
 	// Вызов сервиса аутентификации
@@ -73,18 +76,71 @@ func (h *UserHandler) Login(c echo.Context) error {
 		if errors.Is(err, services.ErrInvalidCredentials) {
 			return echo.NewHTTPError(http.StatusUnauthorized, "invalid login or password")
 		}
-		c.Logger().Errorf("failed to login user: %v", err)
-		return echo.NewHTTPError(http.StatusInternalServerError, "internal server error")
+		if errors.Is(err, services.ErrAccountDeactivated) {
+			return echo.NewHTTPError(http.StatusForbidden, "account is deactivated")
+		}
+		var lockedErr *services.AccountLockedError
+		if errors.As(err, &lockedErr) {
+			c.Response().Header().Set("Retry-After", retryAfterSeconds(time.Until(lockedErr.Until)))
+			return echo.NewHTTPError(http.StatusTooManyRequests, "too many failed login attempts")
+		}
+		logUnexpected(c, "failed to login user: %v", err)
+		return internalServerError(err)
 	}
 
 	// Установка токена в cookie и заголовок
-	setAuthToken(c, token)
+	setAuthToken(c, token, h.cookieCfg)
 
 	// Возврат успешного ответа
-	return c.JSON(http.StatusOK, map[string]interface{}{
-		"user_id": user.ID,
-		"login":   user.Login,
-	})
+	return c.JSON(http.StatusOK, h.authResponse(user, token))
+}
+
+// ChangePassword обрабатывает POST /api/user/password.
+func (h *UserHandler) ChangePassword(c echo.Context) error {
+	userID, err := auth.GetUserIDFromContext(c)
+	if err != nil {
+		return err
+	}
+
+	var req models.ChangePasswordRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return err
+	}
+
+	err = h.userService.ChangePassword(c.Request().Context(), userID, req.OldPassword, req.NewPassword)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidCredentials):
+			return echo.NewHTTPError(http.StatusUnauthorized, "invalid old password")
+		case errors.Is(err, auth.ErrWeakPassword):
+			return echo.NewHTTPError(http.StatusBadRequest, "new password is too weak")
+		case errors.Is(err, storage.ErrUserNotFound):
+			return echo.NewHTTPError(http.StatusUnauthorized, "user not found")
+		default:
+			logUnexpected(c, "failed to change password: %v", err)
+			return internalServerError(err)
+		}
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// Deactivate обрабатывает POST /api/user/deactivate.
+func (h *UserHandler) Deactivate(c echo.Context) error {
+	userID, err := auth.GetUserIDFromContext(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.userService.Deactivate(c.Request().Context(), userID); err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return echo.NewHTTPError(http.StatusUnauthorized, "user not found")
+		}
+		logUnexpected(c, "failed to deactivate user: %v", err)
+		return internalServerError(err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
 }
 
 // GetBalance обрабатывает GET /api/user/balance.
@@ -101,8 +157,8 @@ func (h *UserHandler) GetBalance(c echo.Context) error {
 		if errors.Is(err, storage.ErrUserNotFound) {
 			return echo.NewHTTPError(http.StatusUnauthorized, "user not found")
 		}
-		c.Logger().Errorf("failed to get balance: %v", err)
-		return echo.NewHTTPError(http.StatusInternalServerError, "internal server error")
+		logUnexpected(c, "failed to get balance: %v", err)
+		return internalServerError(err)
 	}
 
 	// Маппинг domain модели в DTO
@@ -110,16 +166,43 @@ func (h *UserHandler) GetBalance(c echo.Context) error {
 	return c.JSON(http.StatusOK, response)
 }
 
+// authResponse формирует тело ответа на регистрацию и вход.
+// expires_at берётся из токена, а при ошибке разбора вычисляется по времени жизни cookie.
+func (h *UserHandler) authResponse(user *models.User, token string) map[string]interface{} {
+	expiresAt, err := auth.TokenExpiresAt(token)
+	if err != nil {
+		expiresAt = time.Now().Add(h.cookieCfg.MaxAge)
+	}
+
+	return map[string]interface{}{
+		"user_id":    user.ID,
+		"login":      user.Login,
+		"expires_at": expiresAt.UTC().Format(time.RFC3339),
+	}
+}
+
 // setAuthToken устанавливает токен в cookie и заголовок ответа.
-func setAuthToken(c echo.Context, token string) {
+// Время жизни cookie берётся из срока действия токена, чтобы следовать за изменением
+// времени жизни токенов без перезапуска; если токен не разбирается, используется cfg.MaxAge.
+func setAuthToken(c echo.Context, token string, cfg CookieConfig) {
+	path := cfg.Path
+	if path == "" {
+		path = "/"
+	}
+	maxAge := cfg.MaxAge
+	if expiresAt, err := auth.TokenExpiresAt(token); err == nil {
+		maxAge = time.Until(expiresAt)
+	}
+
 	// Установка cookie
 	cookie := &http.Cookie{
 		Name:     "Authorization",
 		Value:    token,
-		Path:     "/",
+		Path:     path,
 		HttpOnly: true,
-		SameSite: http.SameSiteStrictMode,
-		MaxAge:   86400, // 24 часа
+		Secure:   cfg.Secure,
+		SameSite: cfg.SameSite,
+		MaxAge:   int(maxAge.Seconds()),
 	}
 	c.SetCookie(cookie)
 
@@ -129,11 +212,17 @@ func setAuthToken(c echo.Context, token string) {
 
 // mapUserToBalanceResponse преобразует domain модель пользователя в DTO баланса.
 func (h *UserHandler) mapUserToBalanceResponse(user *models.User) *models.BalanceResponse {
-	current, _ := user.Balance.Float64()
-	withdrawn, _ := user.Withdrawn.Float64()
-
 	return &models.BalanceResponse{
-		Current:   current,
-		Withdrawn: withdrawn,
+		Current:   money.ToFloat(user.Balance),
+		Withdrawn: money.ToFloat(user.Withdrawn),
+	}
+}
+
+// retryAfterSeconds форматирует паузу для заголовка Retry-After: целые секунды с округлением вверх, не меньше 1.
+func retryAfterSeconds(d time.Duration) string {
+	seconds := int64(math.Ceil(d.Seconds()))
+	if seconds < 1 {
+		seconds = 1
 	}
+	return strconv.FormatInt(seconds, 10)
 }
@@ -2,12 +2,15 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/agamariel/gofermart/internal/auth"
 	"github.com/agamariel/gofermart/internal/models"
 	"github.com/agamariel/gofermart/internal/services"
 	"github.com/agamariel/gofermart/internal/storage"
@@ -21,6 +24,9 @@ type MockUserService struct {
 	RegisterFunc   func(ctx context.Context, login, password string) (*models.User, string, error)
 	LoginFunc      func(ctx context.Context, login, password string) (*models.User, string, error)
 	GetBalanceFunc func(ctx context.Context, userID uuid.UUID) (*models.User, error)
+
+	ChangePasswordFunc func(ctx context.Context, userID uuid.UUID, oldPassword, newPassword string) error
+	DeactivateFunc     func(ctx context.Context, userID uuid.UUID) error
 }
 
 func (m *MockUserService) Register(ctx context.Context, login, password string) (*models.User, string, error) {
@@ -30,6 +36,20 @@ func (m *MockUserService) Register(ctx context.Context, login, password string)
 	return nil, "", nil
 }
 
+func (m *MockUserService) ChangePassword(ctx context.Context, userID uuid.UUID, oldPassword, newPassword string) error {
+	if m.ChangePasswordFunc != nil {
+		return m.ChangePasswordFunc(ctx, userID, oldPassword, newPassword)
+	}
+	return nil
+}
+
+func (m *MockUserService) Deactivate(ctx context.Context, userID uuid.UUID) error {
+	if m.DeactivateFunc != nil {
+		return m.DeactivateFunc(ctx, userID)
+	}
+	return nil
+}
+
 func (m *MockUserService) Login(ctx context.Context, login, password string) (*models.User, string, error) {
 	if m.LoginFunc != nil {
 		return m.LoginFunc(ctx, login, password)
@@ -111,12 +131,13 @@ func TestUserHandler_Register(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			e := echo.New()
+			e.Validator = NewRequestValidator()
 			req := httptest.NewRequest(http.MethodPost, "/api/user/register", strings.NewReader(tt.requestBody))
 			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 			rec := httptest.NewRecorder()
 			c := e.NewContext(req, rec)
 
-			handler := NewUserHandler(tt.mockService)
+			handler := NewUserHandler(tt.mockService, DefaultCookieConfig())
 			err := handler.Register(c)
 
 			if tt.expectedStatus < 400 {
@@ -225,12 +246,13 @@ func TestUserHandler_Login(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			e := echo.New()
+			e.Validator = NewRequestValidator()
 			req := httptest.NewRequest(http.MethodPost, "/api/user/login", strings.NewReader(tt.requestBody))
 			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 			rec := httptest.NewRecorder()
 			c := e.NewContext(req, rec)
 
-			handler := NewUserHandler(tt.mockService)
+			handler := NewUserHandler(tt.mockService, DefaultCookieConfig())
 			err := handler.Login(c)
 
 			if tt.expectedStatus < 400 {
@@ -342,7 +364,7 @@ func TestUserHandler_GetBalance(t *testing.T) {
 
 			tt.setupContext(&c)
 
-			handler := NewUserHandler(tt.mockService)
+			handler := NewUserHandler(tt.mockService, DefaultCookieConfig())
 			err := handler.GetBalance(c)
 
 			if tt.expectedStatus < 400 {
@@ -378,7 +400,7 @@ func TestSetAuthToken(t *testing.T) {
 	c := e.NewContext(req, rec)
 
 	token := "test-token-value"
-	setAuthToken(c, token)
+	setAuthToken(c, token, DefaultCookieConfig())
 
 	// Проверяем cookie
 	res := rec.Result()
@@ -419,3 +441,429 @@ func TestSetAuthToken(t *testing.T) {
 		t.Errorf("Authorization header = %v, want %v", authHeader, expectedHeader)
 	}
 }
+
+func TestSetAuthToken_CookieConfig(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	cfg, err := NewCookieConfig(true, "None", 0)
+	if err != nil {
+		t.Fatalf("NewCookieConfig() error = %v", err)
+	}
+	setAuthToken(c, "test-token-value", cfg)
+
+	res := rec.Result()
+	defer res.Body.Close()
+	var authCookie *http.Cookie
+	for _, cookie := range res.Cookies() {
+		if cookie.Name == "Authorization" {
+			authCookie = cookie
+			break
+		}
+	}
+
+	if authCookie == nil {
+		t.Fatal("Authorization cookie not set")
+	}
+	if !authCookie.Secure {
+		t.Error("Cookie should be Secure")
+	}
+	if authCookie.SameSite != http.SameSiteNoneMode {
+		t.Errorf("Cookie SameSite = %v, want %v", authCookie.SameSite, http.SameSiteNoneMode)
+	}
+}
+
+func TestParseSameSite(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    http.SameSite
+		wantErr bool
+	}{
+		{value: "", want: http.SameSiteStrictMode},
+		{value: "Strict", want: http.SameSiteStrictMode},
+		{value: "lax", want: http.SameSiteLaxMode},
+		{value: "NONE", want: http.SameSiteNoneMode},
+		{value: "default", want: http.SameSiteDefaultMode},
+		{value: "bogus", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseSameSite(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSameSite() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("ParseSameSite() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewCookieConfig_SameSiteNoneRequiresSecure(t *testing.T) {
+	if _, err := NewCookieConfig(false, "None", 0); err == nil {
+		t.Error("NewCookieConfig(secure=false, SameSite=None) error = nil, want error")
+	}
+	cfg, err := NewCookieConfig(true, "None", 0)
+	if err != nil {
+		t.Fatalf("NewCookieConfig(secure=true, SameSite=None) error = %v", err)
+	}
+	if cfg.SameSite != http.SameSiteNoneMode || !cfg.Secure {
+		t.Errorf("NewCookieConfig() = %+v, want Secure with SameSite=None", cfg)
+	}
+}
+
+func TestSetAuthToken_MaxAgeFromExpiration(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	cfg, err := NewCookieConfig(false, "Strict", 48*time.Hour)
+	if err != nil {
+		t.Fatalf("NewCookieConfig() error = %v", err)
+	}
+	setAuthToken(c, "test-token-value", cfg)
+
+	res := rec.Result()
+	defer res.Body.Close()
+	var authCookie *http.Cookie
+	for _, cookie := range res.Cookies() {
+		if cookie.Name == "Authorization" {
+			authCookie = cookie
+			break
+		}
+	}
+
+	if authCookie == nil {
+		t.Fatal("Authorization cookie not set")
+	}
+	if want := int((48 * time.Hour).Seconds()); authCookie.MaxAge != want {
+		t.Errorf("Cookie MaxAge = %v, want %v", authCookie.MaxAge, want)
+	}
+}
+
+func TestUserHandler_ValidationErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantFields []string
+	}{
+		{
+			name:       "missing login and password",
+			body:       `{}`,
+			wantFields: []string{`"field":"login"`, `"field":"password"`},
+		},
+		{
+			name:       "missing password",
+			body:       `{"login":"test@example.com"}`,
+			wantFields: []string{`"field":"password"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			e.Validator = NewRequestValidator()
+			req := httptest.NewRequest(http.MethodPost, "/api/user/register", strings.NewReader(tt.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+
+			handler := NewUserHandler(&MockUserService{}, DefaultCookieConfig())
+			err := handler.Register(c)
+			if err == nil {
+				t.Fatal("Expected error, got nil")
+			}
+			e.HTTPErrorHandler(err, c)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("Expected status %d, got %d", http.StatusBadRequest, rec.Code)
+			}
+			body := rec.Body.String()
+			for _, field := range tt.wantFields {
+				if !strings.Contains(body, field) {
+					t.Errorf("Response %s doesn't contain %s", body, field)
+				}
+			}
+		})
+	}
+}
+
+func TestUserHandler_DecodeErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantMessage string
+	}{
+		{name: "syntax error", body: `{"login":"user" "password":"secret"}`, wantMessage: "malformed JSON at offset 17"},
+		{name: "truncated body", body: `{"login":"user"`, wantMessage: "malformed JSON: unexpected end of input"},
+		{name: "field of wrong type", body: `{"login":42,"password":"secret"}`, wantMessage: "field login must be a string"},
+		{name: "body of wrong type", body: `["user","secret"]`, wantMessage: "request body must be an object"},
+	}
+
+	handler := NewUserHandler(&MockUserService{}, DefaultCookieConfig())
+	endpoints := []struct {
+		path   string
+		handle echo.HandlerFunc
+	}{
+		{path: "/api/user/register", handle: handler.Register},
+		{path: "/api/user/login", handle: handler.Login},
+	}
+
+	for _, ep := range endpoints {
+		for _, tt := range tests {
+			t.Run(ep.path+" "+tt.name, func(t *testing.T) {
+				e := echo.New()
+				e.Validator = NewRequestValidator()
+				req := httptest.NewRequest(http.MethodPost, ep.path, strings.NewReader(tt.body))
+				req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+				rec := httptest.NewRecorder()
+				c := e.NewContext(req, rec)
+
+				err := ep.handle(c)
+				var he *echo.HTTPError
+				if !errors.As(err, &he) {
+					t.Fatalf("expected *echo.HTTPError, got %v", err)
+				}
+				if he.Code != http.StatusBadRequest || he.Message != tt.wantMessage {
+					t.Errorf("error = %d %v, want 400 %q", he.Code, he.Message, tt.wantMessage)
+				}
+			})
+		}
+	}
+}
+
+func TestUserHandler_ExpiresAt(t *testing.T) {
+	ttl := 2 * time.Hour
+
+	issue := func(ctx context.Context, login, password string) (*models.User, string, error) {
+		user := &models.User{ID: uuid.New(), Login: login}
+		token, err := auth.GenerateToken(user, "test-secret", ttl)
+		return user, token, err
+	}
+	service := &MockUserService{RegisterFunc: issue, LoginFunc: issue}
+
+	endpoints := []struct {
+		name   string
+		handle func(h *UserHandler, c echo.Context) error
+	}{
+		{name: "register", handle: (*UserHandler).Register},
+		{name: "login", handle: (*UserHandler).Login},
+	}
+
+	for _, ep := range endpoints {
+		t.Run(ep.name, func(t *testing.T) {
+			e := echo.New()
+			e.Validator = NewRequestValidator()
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"login":"test@example.com","password":"password123"}`))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+
+			handler := NewUserHandler(service, DefaultCookieConfig())
+			if err := ep.handle(handler, c); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			var body map[string]interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			for _, key := range []string{"user_id", "login", "expires_at"} {
+				if _, ok := body[key]; !ok {
+					t.Errorf("response has no %q field: %s", key, rec.Body.String())
+				}
+			}
+
+			raw, _ := body["expires_at"].(string)
+			expiresAt, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				t.Fatalf("expires_at %q is not RFC3339: %v", raw, err)
+			}
+			if diff := time.Until(expiresAt) - ttl; diff > 5*time.Second || diff < -5*time.Second {
+				t.Errorf("expires_at = %v, want about now+%v", expiresAt, ttl)
+			}
+
+			// Cookie живёт столько же, сколько токен, а не DefaultCookieConfig().MaxAge
+			for _, cookie := range rec.Result().Cookies() {
+				if cookie.Name != "Authorization" {
+					continue
+				}
+				if diff := time.Duration(cookie.MaxAge)*time.Second - ttl; diff > 5*time.Second || diff < -5*time.Second {
+					t.Errorf("cookie MaxAge = %ds, want about %v", cookie.MaxAge, ttl)
+				}
+			}
+		})
+	}
+}
+
+func TestUserHandler_ChangePassword(t *testing.T) {
+	userID := uuid.New()
+
+	tests := []struct {
+		name           string
+		body           string
+		serviceErr     error
+		expectedStatus int
+	}{
+		{name: "success", body: `{"old_password":"old-password","new_password":"new-password"}`, expectedStatus: http.StatusNoContent},
+		{name: "wrong old password", body: `{"old_password":"wrong","new_password":"new-password"}`, serviceErr: services.ErrInvalidCredentials, expectedStatus: http.StatusUnauthorized},
+		{name: "weak new password", body: `{"old_password":"old-password","new_password":"123"}`, serviceErr: auth.ErrWeakPassword, expectedStatus: http.StatusBadRequest},
+		{name: "missing fields", body: `{"old_password":"old-password"}`, expectedStatus: http.StatusBadRequest},
+		{name: "internal error", body: `{"old_password":"old-password","new_password":"new-password"}`, serviceErr: errors.New("db down"), expectedStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			e.Validator = NewRequestValidator()
+			req := httptest.NewRequest(http.MethodPost, "/api/user/password", strings.NewReader(tt.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.Set(string(auth.UserIDKey), userID)
+
+			service := &MockUserService{
+				ChangePasswordFunc: func(ctx context.Context, uid uuid.UUID, oldPassword, newPassword string) error {
+					if uid != userID {
+						t.Errorf("userID = %v, want %v", uid, userID)
+					}
+					return tt.serviceErr
+				},
+			}
+
+			handler := NewUserHandler(service, DefaultCookieConfig())
+			if err := handler.ChangePassword(c); err != nil {
+				e.HTTPErrorHandler(err, c)
+			}
+
+			if rec.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, rec.Code)
+			}
+		})
+	}
+}
+
+func TestUserHandler_Deactivate(t *testing.T) {
+	userID := uuid.New()
+
+	tests := []struct {
+		name           string
+		serviceErr     error
+		expectedStatus int
+	}{
+		{name: "success", expectedStatus: http.StatusNoContent},
+		{name: "user not found", serviceErr: storage.ErrUserNotFound, expectedStatus: http.StatusUnauthorized},
+		{name: "internal error", serviceErr: errors.New("db down"), expectedStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/api/user/deactivate", nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.Set(string(auth.UserIDKey), userID)
+
+			handler := NewUserHandler(&MockUserService{
+				DeactivateFunc: func(ctx context.Context, uid uuid.UUID) error {
+					return tt.serviceErr
+				},
+			}, DefaultCookieConfig())
+			if err := handler.Deactivate(c); err != nil {
+				e.HTTPErrorHandler(err, c)
+			}
+
+			if rec.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, rec.Code)
+			}
+		})
+	}
+}
+
+func TestUserHandler_LoginDeactivated(t *testing.T) {
+	e := echo.New()
+	e.Validator = NewRequestValidator()
+	req := httptest.NewRequest(http.MethodPost, "/api/user/login", strings.NewReader(`{"login":"test@example.com","password":"password123"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	handler := NewUserHandler(&MockUserService{
+		LoginFunc: func(ctx context.Context, login, password string) (*models.User, string, error) {
+			return nil, "", services.ErrAccountDeactivated
+		},
+	}, DefaultCookieConfig())
+	if err := handler.Login(c); err != nil {
+		e.HTTPErrorHandler(err, c)
+	}
+
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, rec.Code)
+	}
+	if len(rec.Result().Cookies()) != 0 {
+		t.Error("deactivated user must not receive auth cookie")
+	}
+}
+
+func TestUserHandler_RegisterConflictReturnsNoToken(t *testing.T) {
+	e := echo.New()
+	e.Validator = NewRequestValidator()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/user/register",
+		strings.NewReader(`{"login":"race@example.com","password":"password123"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	handler := NewUserHandler(&MockUserService{
+		RegisterFunc: func(ctx context.Context, login, password string) (*models.User, string, error) {
+			return nil, "", storage.ErrLoginExists
+		},
+	}, DefaultCookieConfig())
+
+	if err := handler.Register(c); err != nil {
+		e.HTTPErrorHandler(err, c)
+	}
+
+	if rec.Code != http.StatusConflict {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+	if got := rec.Header().Get("Authorization"); got != "" {
+		t.Errorf("Authorization header = %q, want empty", got)
+	}
+	if cookies := rec.Result().Cookies(); len(cookies) != 0 {
+		t.Errorf("unexpected cookies on conflict: %v", cookies)
+	}
+}
+
+func TestUserHandler_LoginLocked(t *testing.T) {
+	e := echo.New()
+	e.Validator = NewRequestValidator()
+	req := httptest.NewRequest(http.MethodPost, "/api/user/login", strings.NewReader(`{"login":"test@example.com","password":"password123"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	handler := NewUserHandler(&MockUserService{
+		LoginFunc: func(ctx context.Context, login, password string) (*models.User, string, error) {
+			return nil, "", &services.AccountLockedError{Until: time.Now().Add(90 * time.Second)}
+		},
+	}, DefaultCookieConfig())
+	if err := handler.Login(c); err != nil {
+		e.HTTPErrorHandler(err, c)
+	}
+
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status %d, got %d", http.StatusTooManyRequests, rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "90" {
+		t.Errorf("Retry-After = %q, want %q", got, "90")
+	}
+	if len(rec.Result().Cookies()) != 0 {
+		t.Error("locked user must not receive auth cookie")
+	}
+}
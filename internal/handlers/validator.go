@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/agamariel/gofermart/internal/money"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/shopspring/decimal"
+)
+
+// FieldError описывает ошибку валидации отдельного поля запроса.
+type FieldError struct {
+	Field string `json:"field"`
+	Rule  string `json:"rule"`
+}
+
+// ValidationErrorResponse - тело ответа при ошибке валидации запроса.
+type ValidationErrorResponse struct {
+	Message string       `json:"message"`
+	Errors  []FieldError `json:"errors"`
+}
+
+// RequestValidator реализует echo.Validator на базе go-playground/validator.
+type RequestValidator struct {
+	validate *validator.Validate
+}
+
+// NewRequestValidator создаёт валидатор, использующий имена полей из json-тегов.
+func NewRequestValidator() *RequestValidator {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+
+	// decimal.Decimal валидируется через строковое представление,
+	// так как validator не применяет теги к полям-структурам.
+	v.RegisterCustomTypeFunc(func(field reflect.Value) interface{} {
+		if d, ok := field.Interface().(decimal.Decimal); ok {
+			return d.String()
+		}
+		return nil
+	}, decimal.Decimal{})
+	_ = v.RegisterValidation("positive", validatePositiveDecimal)
+	_ = v.RegisterValidation("max_places", validateMaxPlaces)
+
+	return &RequestValidator{validate: v}
+}
+
+// validatePositiveDecimal проверяет, что десятичное число строго больше нуля.
+func validatePositiveDecimal(fl validator.FieldLevel) bool {
+	d, err := decimal.NewFromString(fl.Field().String())
+	if err != nil {
+		return false
+	}
+	return d.IsPositive()
+}
+
+// validateMaxPlaces проверяет, что у суммы не больше money.Places знаков после запятой.
+func validateMaxPlaces(fl validator.FieldLevel) bool {
+	d, err := decimal.NewFromString(fl.Field().String())
+	if err != nil {
+		return false
+	}
+	return money.HasValidPlaces(d)
+}
+
+// Validate проверяет структуру по validate-тегам.
+func (v *RequestValidator) Validate(i interface{}) error {
+	return v.validate.Struct(i)
+}
+
+// bindError превращает ошибку разбора тела запроса в HTTP-ошибку 400 с причиной:
+// синтаксическая ошибка JSON с позицией или поле неверного типа. Остальные ошибки
+// получают общее сообщение "invalid request format".
+func bindError(err error) *echo.HTTPError {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("malformed JSON at offset %d", syntaxErr.Offset))
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return echo.NewHTTPError(http.StatusBadRequest, "malformed JSON: unexpected end of input")
+	case errors.As(err, &typeErr):
+		if typeErr.Field == "" {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("request body must be %s", jsonTypeName(typeErr.Type)))
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("field %s must be %s", typeErr.Field, jsonTypeName(typeErr.Type)))
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request format")
+	}
+}
+
+// jsonTypeName называет JSON-тип, в который декодируется значение типа t.
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	case reflect.Pointer:
+		return jsonTypeName(t.Elem())
+	default:
+		return "a " + t.Kind().String()
+	}
+}
+
+// bindAndValidate парсит тело запроса и валидирует его.
+// Возвращает готовую HTTP-ошибку 400 со списком невалидных полей.
+func bindAndValidate(c echo.Context, req interface{}) error {
+	if err := c.Bind(req); err != nil {
+		return bindError(err)
+	}
+
+	if err := c.Validate(req); err != nil {
+		var validationErrs validator.ValidationErrors
+		if !errors.As(err, &validationErrs) {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid request format")
+		}
+
+		response := &ValidationErrorResponse{Message: "validation failed"}
+		for _, fe := range validationErrs {
+			response.Errors = append(response.Errors, FieldError{
+				Field: fe.Field(),
+				Rule:  fe.Tag(),
+			})
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, response)
+	}
+
+	return nil
+}
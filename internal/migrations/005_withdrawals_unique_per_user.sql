@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE withdrawals DROP CONSTRAINT IF EXISTS withdrawals_order_number_key;
+ALTER TABLE withdrawals
+    ADD CONSTRAINT withdrawals_user_id_order_number_key UNIQUE (user_id, order_number);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE withdrawals DROP CONSTRAINT IF EXISTS withdrawals_user_id_order_number_key;
+ALTER TABLE withdrawals
+    ADD CONSTRAINT withdrawals_order_number_key UNIQUE (order_number);
+-- +goose StatementEnd
@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Ручные корректировки баланса: кто их выполнил и почему. Для них order_number пустой.
+ALTER TABLE audit_log
+    ADD COLUMN IF NOT EXISTS admin_id UUID REFERENCES users(id),
+    ADD COLUMN IF NOT EXISTS reason TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE audit_log DROP CONSTRAINT IF EXISTS audit_log_kind_check;
+ALTER TABLE audit_log ADD CONSTRAINT audit_log_kind_check
+    CHECK (kind IN ('WITHDRAWAL', 'ACCRUAL', 'ADJUSTMENT_CREDIT', 'ADJUSTMENT_DEBIT'));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+-- Записи корректировок не проходят прежнее ограничение kind: удаляем их в обход триггера
+ALTER TABLE audit_log DISABLE TRIGGER audit_log_append_only;
+DELETE FROM audit_log WHERE kind IN ('ADJUSTMENT_CREDIT', 'ADJUSTMENT_DEBIT');
+ALTER TABLE audit_log ENABLE TRIGGER audit_log_append_only;
+
+ALTER TABLE audit_log DROP CONSTRAINT IF EXISTS audit_log_kind_check;
+ALTER TABLE audit_log ADD CONSTRAINT audit_log_kind_check CHECK (kind IN ('WITHDRAWAL', 'ACCRUAL'));
+
+ALTER TABLE audit_log
+    DROP COLUMN IF EXISTS reason,
+    DROP COLUMN IF EXISTS admin_id;
+-- +goose StatementEnd
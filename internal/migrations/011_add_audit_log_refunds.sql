@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Возвраты отменённых списаний на баланс
+ALTER TABLE audit_log DROP CONSTRAINT IF EXISTS audit_log_kind_check;
+ALTER TABLE audit_log ADD CONSTRAINT audit_log_kind_check
+    CHECK (kind IN ('WITHDRAWAL', 'ACCRUAL', 'ADJUSTMENT_CREDIT', 'ADJUSTMENT_DEBIT', 'REFUND'));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+-- Записи возвратов не проходят прежнее ограничение kind: удаляем их в обход триггера
+ALTER TABLE audit_log DISABLE TRIGGER audit_log_append_only;
+DELETE FROM audit_log WHERE kind = 'REFUND';
+ALTER TABLE audit_log ENABLE TRIGGER audit_log_append_only;
+
+ALTER TABLE audit_log DROP CONSTRAINT IF EXISTS audit_log_kind_check;
+ALTER TABLE audit_log ADD CONSTRAINT audit_log_kind_check
+    CHECK (kind IN ('WITHDRAWAL', 'ACCRUAL', 'ADJUSTMENT_CREDIT', 'ADJUSTMENT_DEBIT'));
+-- +goose StatementEnd
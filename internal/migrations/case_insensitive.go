@@ -0,0 +1,52 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrLoginCaseConflict - в базе есть логины, отличающиеся только регистром,
+// и включить регистронезависимые логины без ручного разбора нельзя.
+var ErrLoginCaseConflict = errors.New("logins differ only by case")
+
+// EnsureCaseInsensitiveLogins готовит базу к регистронезависимым логинам
+// (LOGIN_CASE_INSENSITIVE): приводит существующие логины к нижнему регистру
+// и создаёт уникальный индекс по lower(login).
+//
+// В отличие от обычных миграций применяется только при включённой опции.
+// Если у разных пользователей логины совпадают без учёта регистра, данные не меняются
+// и возвращается ErrLoginCaseConflict - такие аккаунты нужно объединить вручную.
+func EnsureCaseInsensitiveLogins(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var conflicts int
+	err = tx.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM (
+			SELECT LOWER(login) FROM users GROUP BY LOWER(login) HAVING COUNT(*) > 1
+		) AS duplicates
+	`).Scan(&conflicts)
+	if err != nil {
+		return fmt.Errorf("check login conflicts: %w", err)
+	}
+	if conflicts > 0 {
+		return fmt.Errorf("%w: %d logins", ErrLoginCaseConflict, conflicts)
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET login = LOWER(login), updated_at = NOW() WHERE login <> LOWER(login)`); err != nil {
+		return fmt.Errorf("normalize logins: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `CREATE UNIQUE INDEX IF NOT EXISTS users_login_lower_key ON users (LOWER(login))`); err != nil {
+		return fmt.Errorf("create lower(login) index: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
@@ -1,9 +1,14 @@
 package migrations
 
 import (
+	"context"
 	"database/sql"
 	"embed"
+	"errors"
 	"fmt"
+	"io/fs"
+	"log"
+	"path"
 
 	"github.com/pressly/goose/v3"
 )
@@ -11,8 +16,34 @@ import (
 //go:embed *.sql
 var embedMigrations embed.FS
 
+// ErrSchemaOutdated - версия схемы БД ниже последней встроенной миграции.
+var ErrSchemaOutdated = errors.New("database schema is older than the latest migration")
+
+// migrationLockID - ключ advisory lock, под которым выполняются миграции.
+const migrationLockID int64 = 7_311_202_401
+
 // Run применяет все миграции к базе данных.
+// Миграции выполняются под advisory lock: если несколько экземпляров приложения
+// стартуют одновременно, мигрирует один, остальные ждут и затем видят актуальную схему.
 func Run(db *sql.DB) error {
+	ctx := context.Background()
+
+	// Advisory lock принадлежит сессии, поэтому держим отдельное соединение до конца миграций
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection for migration lock: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockID); err != nil {
+			log.Printf("failed to release migration lock: %v", err)
+		}
+	}()
+
 	goose.SetBaseFS(embedMigrations)
 
 	if err := goose.SetDialect("postgres"); err != nil {
@@ -39,3 +70,48 @@ func Version(db *sql.DB) (int64, error) {
 
 	return version, nil
 }
+
+// Latest возвращает версию последней встроенной миграции.
+func Latest() (int64, error) {
+	entries, err := fs.ReadDir(embedMigrations, ".")
+	if err != nil {
+		return 0, fmt.Errorf("failed to read embedded migrations: %w", err)
+	}
+
+	var latest int64
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".sql" {
+			continue
+		}
+		version, err := goose.NumericComponent(entry.Name())
+		if err != nil {
+			return 0, fmt.Errorf("invalid migration name %s: %w", entry.Name(), err)
+		}
+		if version > latest {
+			latest = version
+		}
+	}
+	return latest, nil
+}
+
+// Verify проверяет, что схема БД не старше встроенных миграций. Используется вместо Run,
+// когда миграции применяет отдельная задача; при отставании возвращает ErrSchemaOutdated.
+func Verify(db *sql.DB) error {
+	latest, err := Latest()
+	if err != nil {
+		return err
+	}
+	version, err := Version(db)
+	if err != nil {
+		return err
+	}
+	return checkVersion(version, latest)
+}
+
+// checkVersion сравнивает версию схемы с последней миграцией.
+func checkVersion(version, latest int64) error {
+	if version < latest {
+		return fmt.Errorf("%w: version %d, want at least %d", ErrSchemaOutdated, version, latest)
+	}
+	return nil
+}
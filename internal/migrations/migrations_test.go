@@ -2,6 +2,8 @@ package migrations
 
 import (
 	"database/sql"
+	"errors"
+	"strings"
 	"testing"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
@@ -61,3 +63,59 @@ func TestVersionWithInvalidDB(t *testing.T) {
 		t.Error("Expected error for invalid DB connection, got nil")
 	}
 }
+
+func TestLatest(t *testing.T) {
+	entries, err := embedMigrations.ReadDir(".")
+	if err != nil {
+		t.Fatalf("Failed to read embedded migrations: %v", err)
+	}
+	var want int64
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".sql") {
+			want++
+		}
+	}
+
+	// Миграции пронумерованы подряд с 001, поэтому последняя версия равна их числу
+	latest, err := Latest()
+	if err != nil {
+		t.Fatalf("Latest() error = %v", err)
+	}
+	if latest != want {
+		t.Errorf("Latest() = %d, want %d", latest, want)
+	}
+}
+
+func TestCheckVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		version int64
+		wantErr bool
+	}{
+		{name: "up to date", version: 9},
+		{name: "newer schema", version: 10},
+		{name: "outdated", version: 8, wantErr: true},
+		{name: "empty database", version: 0, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkVersion(tt.version, 9)
+			if tt.wantErr != errors.Is(err, ErrSchemaOutdated) {
+				t.Errorf("checkVersion(%d, 9) error = %v, want ErrSchemaOutdated: %v", tt.version, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyWithInvalidDB(t *testing.T) {
+	db, err := sql.Open("pgx", "invalid://connection")
+	if err != nil {
+		t.Skipf("Cannot create test DB connection: %v", err)
+	}
+	defer db.Close()
+
+	if err := Verify(db); err == nil {
+		t.Error("Expected error for invalid DB connection, got nil")
+	}
+}
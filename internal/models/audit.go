@@ -0,0 +1,64 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// AuditKind - тип операции в журнале аудита.
+type AuditKind string
+
+const (
+	AuditKindWithdrawal AuditKind = "WITHDRAWAL"
+	AuditKindAccrual    AuditKind = "ACCRUAL"
+	// Возврат суммы отменённого списания на баланс.
+	AuditKindRefund AuditKind = "REFUND"
+	// Ручные корректировки баланса администратором.
+	AuditKindAdjustmentCredit AuditKind = "ADJUSTMENT_CREDIT"
+	AuditKindAdjustmentDebit  AuditKind = "ADJUSTMENT_DEBIT"
+)
+
+// AuditEntry - запись журнала аудита об изменении баланса пользователя по заказу
+// или ручной корректировки. Amount всегда неотрицательна: направление изменения задаёт Kind.
+// У корректировок OrderNumber пустой, а AdminID и Reason указывают, кто и почему её выполнил.
+type AuditEntry struct {
+	ID            uuid.UUID       `db:"id"`
+	UserID        uuid.UUID       `db:"user_id"`
+	OrderNumber   string          `db:"order_number"`
+	AdminID       *uuid.UUID      `db:"admin_id"`
+	Reason        string          `db:"reason"`
+	Kind          AuditKind       `db:"kind"`
+	Amount        decimal.Decimal `db:"amount"`
+	BalanceBefore decimal.Decimal `db:"balance_before"`
+	BalanceAfter  decimal.Decimal `db:"balance_after"`
+	CreatedAt     time.Time       `db:"created_at"`
+}
+
+// BalanceDelta возвращает изменение баланса по записи: начисление увеличивает баланс,
+// списание и отрицательная корректировка уменьшают, возврат списания увеличивает.
+func (e *AuditEntry) BalanceDelta() decimal.Decimal {
+	if e.Kind == AuditKindWithdrawal || e.Kind == AuditKindAdjustmentDebit {
+		return e.Amount.Neg()
+	}
+	return e.Amount
+}
+
+// BalanceAdjustmentRequest DTO для ручной корректировки баланса администратором.
+// Отрицательная Delta уменьшает баланс.
+type BalanceAdjustmentRequest struct {
+	Delta  decimal.Decimal `json:"delta" validate:"max_places"`
+	Reason string          `json:"reason" validate:"required"`
+}
+
+// BalanceAdjustmentResponse DTO для ответа о выполненной корректировке баланса.
+type BalanceAdjustmentResponse struct {
+	UserID        uuid.UUID `json:"user_id"`
+	AdminID       uuid.UUID `json:"admin_id"`
+	Delta         float64   `json:"delta"`
+	BalanceBefore float64   `json:"balance_before"`
+	BalanceAfter  float64   `json:"balance_after"`
+	Reason        string    `json:"reason"`
+	CreatedAt     string    `json:"created_at"`
+}
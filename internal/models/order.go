@@ -28,6 +28,11 @@ type Order struct {
 	UpdatedAt  time.Time        `db:"updated_at"`
 }
 
+// SubmitOrderRequest - JSON-тело загрузки заказа (Content-Type: application/json).
+type SubmitOrderRequest struct {
+	Number string `json:"number"`
+}
+
 // OrderResponse ответ для списка заказов.
 type OrderResponse struct {
 	Number     string   `json:"number"`
@@ -35,3 +40,36 @@ type OrderResponse struct {
 	Accrual    *float64 `json:"accrual,omitempty"`
 	UploadedAt string   `json:"uploaded_at"`
 }
+
+// OrdersWithTotalsResponse - список заказов вместе с суммой начислений по обработанным заказам
+// (GET /api/user/orders?include=totals).
+type OrdersWithTotalsResponse struct {
+	Orders       []*OrderResponse `json:"orders"`
+	TotalAccrued float64          `json:"total_accrued"`
+}
+
+// BulkOrderStatus - результат загрузки отдельного номера в пакетном запросе.
+type BulkOrderStatus string
+
+const (
+	BulkOrderAccepted  BulkOrderStatus = "accepted"
+	BulkOrderDuplicate BulkOrderStatus = "duplicate"
+	BulkOrderConflict  BulkOrderStatus = "conflict"
+	BulkOrderInvalid   BulkOrderStatus = "invalid"
+	// BulkOrderLimitExceeded - номер не принят: достигнут лимит заказов пользователя.
+	BulkOrderLimitExceeded BulkOrderStatus = "limit_exceeded"
+)
+
+// BulkOrderResult - результат по одному номеру из пакетной загрузки.
+type BulkOrderResult struct {
+	Number string          `json:"number"`
+	Status BulkOrderStatus `json:"status"`
+}
+
+// OrderEvent - изменение статуса заказа, отправляемое владельцу через поток событий.
+type OrderEvent struct {
+	UserID  uuid.UUID `json:"-"`
+	Number  string    `json:"number"`
+	Status  string    `json:"status"`
+	Accrual *float64  `json:"accrual,omitempty"`
+}
@@ -7,6 +7,13 @@ import (
 	"github.com/shopspring/decimal"
 )
 
+const (
+	// RoleUser - роль обычного пользователя.
+	RoleUser = "user"
+	// RoleAdmin - роль администратора.
+	RoleAdmin = "admin"
+)
+
 // User представляет пользователя системы.
 type User struct {
 	ID           uuid.UUID       `db:"id"`
@@ -14,20 +21,43 @@ type User struct {
 	PasswordHash string          `db:"password_hash"`
 	Balance      decimal.Decimal `db:"balance"`
 	Withdrawn    decimal.Decimal `db:"withdrawn"`
+	Role         string          `db:"role"`
 	CreatedAt    time.Time       `db:"created_at"`
 	UpdatedAt    time.Time       `db:"updated_at"`
+	// DeactivatedAt - момент деактивации аккаунта; nil для активных пользователей.
+	DeactivatedAt *time.Time `db:"deactivated_at"`
+	// FailedLoginAttempts - число неудачных попыток входа подряд с последней блокировки или успешного входа.
+	FailedLoginAttempts int `db:"failed_login_attempts"`
+	// LockedUntil - момент окончания блокировки входа; nil, если вход не блокировался.
+	LockedUntil *time.Time `db:"locked_until"`
+}
+
+// IsDeactivated сообщает, что аккаунт деактивирован.
+func (u *User) IsDeactivated() bool {
+	return u.DeactivatedAt != nil
+}
+
+// IsLocked сообщает, что вход в аккаунт заблокирован на момент now.
+func (u *User) IsLocked(now time.Time) bool {
+	return u.LockedUntil != nil && now.Before(*u.LockedUntil)
 }
 
 // RegisterRequest - запрос на регистрацию пользователя.
 type RegisterRequest struct {
-	Login    string `json:"login"`
-	Password string `json:"password"`
+	Login    string `json:"login" validate:"required"`
+	Password string `json:"password" validate:"required"`
 }
 
 // LoginRequest - запрос на аутентификацию пользователя.
 type LoginRequest struct {
-	Login    string `json:"login"`
-	Password string `json:"password"`
+	Login    string `json:"login" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+// ChangePasswordRequest - запрос на смену пароля.
+type ChangePasswordRequest struct {
+	OldPassword string `json:"old_password" validate:"required"`
+	NewPassword string `json:"new_password" validate:"required"`
 }
 
 // BalanceResponse - ответ с балансом пользователя.
@@ -35,3 +65,17 @@ type BalanceResponse struct {
 	Current   float64 `json:"current"`
 	Withdrawn float64 `json:"withdrawn"`
 }
+
+// BalanceSummary - сводка по балансу пользователя, включая все начисления.
+type BalanceSummary struct {
+	Current   decimal.Decimal
+	Withdrawn decimal.Decimal
+	Accrued   decimal.Decimal
+}
+
+// BalanceSummaryResponse - расширенный ответ с балансом пользователя.
+type BalanceSummaryResponse struct {
+	Current   float64 `json:"current"`
+	Withdrawn float64 `json:"withdrawn"`
+	Accrued   float64 `json:"accrued"`
+}
@@ -17,9 +17,10 @@ type Withdrawal struct {
 }
 
 // WithdrawRequest DTO для запроса списания.
+// Sum декодируется сразу в decimal, чтобы избежать погрешностей float64.
 type WithdrawRequest struct {
-	Order string  `json:"order"`
-	Sum   float64 `json:"sum"`
+	Order string          `json:"order" validate:"required"`
+	Sum   decimal.Decimal `json:"sum" validate:"positive,max_places"`
 }
 
 // WithdrawalResponse DTO для ответа по списаниям.
@@ -28,3 +29,9 @@ type WithdrawalResponse struct {
 	Sum         float64 `json:"sum"`
 	ProcessedAt string  `json:"processed_at"`
 }
+
+// WithdrawalsSummaryResponse DTO для истории списаний вместе с их общей суммой.
+type WithdrawalsSummaryResponse struct {
+	Withdrawals []*WithdrawalResponse `json:"withdrawals"`
+	Total       float64               `json:"total"`
+}
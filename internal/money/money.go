@@ -0,0 +1,42 @@
+// Package money содержит преобразования денежных сумм между decimal.Decimal,
+// float64 и строками. Суммы в системе хранятся с точностью до копеек.
+package money
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// Places — число знаков после точки у денежных сумм.
+const Places = 2
+
+// ErrInvalidAmount возвращается Parse для пустой, нечисловой строки
+// или суммы с дробной частью точнее копейки.
+var ErrInvalidAmount = errors.New("invalid amount")
+
+// ToFloat преобразует сумму в float64 для JSON-ответов, округляя до копеек.
+func ToFloat(d decimal.Decimal) float64 {
+	return d.Round(Places).InexactFloat64()
+}
+
+// Parse разбирает строковую сумму. Пробелы по краям игнорируются, знак допускается;
+// больше Places знаков после точки — ошибка, а не округление.
+func Parse(s string) (decimal.Decimal, error) {
+	d, err := decimal.NewFromString(strings.TrimSpace(s))
+	if err != nil || !HasValidPlaces(d) {
+		return decimal.Zero, ErrInvalidAmount
+	}
+	return d, nil
+}
+
+// HasValidPlaces сообщает, что у суммы не больше Places знаков после точки.
+func HasValidPlaces(d decimal.Decimal) bool {
+	return d.Equal(d.Round(Places))
+}
+
+// Format форматирует сумму с places знаками после точки, округляя лишние.
+func Format(d decimal.Decimal, places int32) string {
+	return d.StringFixed(places)
+}
@@ -0,0 +1,94 @@
+package money
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestToFloat(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want float64
+	}{
+		{"zero", "0", 0},
+		{"cents", "500.50", 500.5},
+		{"negative", "-0.01", -0.01},
+		{"many decimals", "3.14159", 3.14},
+		{"large", "9999999999.99", 9999999999.99},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ToFloat(decimal.RequireFromString(tt.in)); got != tt.want {
+				t.Errorf("ToFloat(%s) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"zero", "0", "0", false},
+		{"zero with cents", "0.00", "0", false},
+		{"cents", "751.25", "751.25", false},
+		{"one place", "1.5", "1.5", false},
+		{"negative", "-20.10", "-20.1", false},
+		{"surrounding spaces", " 42 ", "42", false},
+		{"trailing zeros beyond places", "1.500", "1.5", false},
+		{"many decimals", "1.005", "", true},
+		{"negative many decimals", "-0.001", "", true},
+		{"empty", "", "", true},
+		{"blank", "   ", "", true},
+		{"not a number", "abc", "", true},
+		{"comma separator", "1,50", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.in)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidAmount) {
+					t.Fatalf("Parse(%q) error = %v, want ErrInvalidAmount", tt.in, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse(%q) unexpected error: %v", tt.in, err)
+			}
+			if !got.Equal(decimal.RequireFromString(tt.want)) {
+				t.Errorf("Parse(%q) = %s, want %s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		in     string
+		places int32
+		want   string
+	}{
+		{"zero", "0", Places, "0.00"},
+		{"pads cents", "12.5", Places, "12.50"},
+		{"negative", "-7", Places, "-7.00"},
+		{"many decimals rounded", "2.675", Places, "2.68"},
+		{"whole places", "19.99", 0, "20"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Format(decimal.RequireFromString(tt.in), tt.places); got != tt.want {
+				t.Errorf("Format(%s, %d) = %q, want %q", tt.in, tt.places, got, tt.want)
+			}
+		})
+	}
+}
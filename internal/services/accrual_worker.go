@@ -2,149 +2,614 @@ package services
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"log/slog"
+	"math/rand"
+	"sync"
 	"time"
 
 	"github.com/agamariel/gofermart/internal/accrual"
 	"github.com/agamariel/gofermart/internal/models"
+	"github.com/agamariel/gofermart/internal/money"
+	"github.com/agamariel/gofermart/internal/storage"
 	"github.com/google/uuid"
-	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/shopspring/decimal"
 )
 
+const (
+	defaultReapInterval = time.Minute
+	defaultBackoffBase  = 5 * time.Second
+	defaultBackoffMax   = time.Minute
+
+	defaultServerErrorPause = 2 * time.Second
+
+	// DefaultTickJitter - доля интервала опроса, на которую случайно сдвигается каждый тик.
+	DefaultTickJitter = 0.2
+)
+
+// Итоги обработки заказа для поля result в журнале.
+const (
+	orderResultProcessed     = "processed"
+	orderResultRegistered    = "registered"
+	orderResultProcessing    = "processing"
+	orderResultInvalid       = "invalid"
+	orderResultNotFound      = "not_found"
+	orderResultRateLimited   = "rate_limited"
+	orderResultTimeout       = "timeout"
+	orderResultCircuitOpen   = "circuit_open"
+	orderResultUnknownStatus = "unknown_status"
+	orderResultSuspicious    = "suspicious_accrual"
+	orderResultError         = "error"
+)
+
 // AccrualWorker периодически обновляет статусы заказов и начисляет баллы.
 type AccrualWorker struct {
-	pool         *pgxpool.Pool
+	pool         TxBeginner
 	orderStorage OrderStorage
 	userStorage  UserStorage
 	client       accrual.AccrualClient
 	interval     time.Duration
 	logger       *log.Logger
+	// orderLogger получает итог обработки каждого заказа структурированными полями.
+	orderLogger *slog.Logger
+
+	// Число попыток обработки заказа воркером; сбрасывается, когда статус заказа становится окончательным.
+	attemptsMu sync.Mutex
+	attempts   map[string]int
+
+	// Заказы в PROCESSING дольше stuckThreshold перезапрашиваются раз в reapInterval.
+	stuckThreshold time.Duration
+	reapInterval   time.Duration
+
+	// Экспоненциальная пауза при 429 без Retry-After: min(backoffBase * 2^n, backoffMax),
+	// где n - число подряд полученных 429. Сбрасывается после успешного запроса.
+	backoffBase   time.Duration
+	backoffMax    time.Duration
+	rateLimitMu   sync.Mutex
+	rateLimitHits int
+
+	// Пауза перед следующей попыткой после 5xx от сервиса начислений.
+	serverErrorPause time.Duration
+
+	// Предельное время запроса начисления по одному заказу; ноль - без ограничения.
+	fetchTimeout time.Duration
+
+	// events получает изменения статусов заказов; nil - события не публикуются.
+	events OrderEventPublisher
+
+	// Начисления больше maxAccrual не применяются; ноль - без ограничения.
+	maxAccrual decimal.Decimal
+
+	// audit получает запись о каждом применённом начислении; nil - журнал не ведётся.
+	audit AuditStorage
+
+	// Интервал до следующего тика случайно отклоняется от interval не больше чем на
+	// tickJitter * interval, чтобы реплики не опрашивали сервис начислений одновременно.
+	// Ноль отключает разброс. randFloat возвращает число из [0, 1).
+	tickJitter float64
+	randFloat  func() float64
+}
+
+// ErrAccrualTooLarge - начисление по заказу превышает допустимый максимум.
+var ErrAccrualTooLarge = errors.New("accrual exceeds the allowed maximum")
+
+// WorkerOption настраивает AccrualWorker.
+type WorkerOption func(*AccrualWorker)
+
+// WithStuckReaper включает периодическую перепроверку заказов,
+// застрявших в статусе PROCESSING дольше threshold.
+func WithStuckReaper(threshold, interval time.Duration) WorkerOption {
+	return func(w *AccrualWorker) {
+		if threshold <= 0 {
+			return
+		}
+		if interval <= 0 {
+			interval = defaultReapInterval
+		}
+		w.stuckThreshold = threshold
+		w.reapInterval = interval
+	}
+}
+
+// WithRateLimitBackoff задаёт базовую и максимальную паузу при 429 без Retry-After.
+func WithRateLimitBackoff(base, maxPause time.Duration) WorkerOption {
+	return func(w *AccrualWorker) {
+		if base > 0 {
+			w.backoffBase = base
+		}
+		if maxPause > 0 {
+			w.backoffMax = maxPause
+		}
+	}
+}
+
+// WithFetchTimeout ограничивает время запроса начисления по одному заказу.
+// Зависший запрос прерывается, а заказ обрабатывается заново на следующем тике.
+func WithFetchTimeout(timeout time.Duration) WorkerOption {
+	return func(w *AccrualWorker) {
+		if timeout > 0 {
+			w.fetchTimeout = timeout
+		}
+	}
+}
+
+// WithOrderEvents включает публикацию изменений статусов заказов.
+func WithOrderEvents(events OrderEventPublisher) WorkerOption {
+	return func(w *AccrualWorker) {
+		w.events = events
+	}
+}
+
+// WithMaxAccrual ограничивает начисление по одному заказу. Большие значения считаются
+// признаком сбоя или компрометации сервиса начислений: они не применяются и пишутся в журнал.
+func WithMaxAccrual(maxAccrual decimal.Decimal) WorkerOption {
+	return func(w *AccrualWorker) {
+		if maxAccrual.IsPositive() {
+			w.maxAccrual = maxAccrual
+		}
+	}
+}
+
+// WithAccrualAudit записывает каждое применённое начисление в журнал аудита в той же транзакции.
+func WithAccrualAudit(audit AuditStorage) WorkerOption {
+	return func(w *AccrualWorker) {
+		w.audit = audit
+	}
 }
 
-func NewAccrualWorker(pool *pgxpool.Pool, orderStorage OrderStorage, userStorage UserStorage, client accrual.AccrualClient, interval time.Duration, logger *log.Logger) *AccrualWorker {
+// WithOrderLogger задаёт структурированный логгер для итогов обработки заказов.
+func WithOrderLogger(logger *slog.Logger) WorkerOption {
+	return func(w *AccrualWorker) {
+		if logger != nil {
+			w.orderLogger = logger
+		}
+	}
+}
+
+// WithTickJitter задаёт разброс интервала опроса как долю от него (0.2 - ±20%).
+// Ноль отключает разброс; значения вне [0, 1) игнорируются.
+func WithTickJitter(fraction float64) WorkerOption {
+	return func(w *AccrualWorker) {
+		if fraction >= 0 && fraction < 1 {
+			w.tickJitter = fraction
+		}
+	}
+}
+
+// WithServerErrorBackoff задаёт паузу после 5xx от сервиса начислений.
+func WithServerErrorBackoff(pause time.Duration) WorkerOption {
+	return func(w *AccrualWorker) {
+		if pause > 0 {
+			w.serverErrorPause = pause
+		}
+	}
+}
+
+func NewAccrualWorker(pool TxBeginner, orderStorage OrderStorage, userStorage UserStorage, client accrual.AccrualClient, interval time.Duration, logger *log.Logger, opts ...WorkerOption) *AccrualWorker {
 	if interval <= 0 {
 		interval = 5 * time.Second
 	}
 	if logger == nil {
 		logger = log.Default()
 	}
-	return &AccrualWorker{
-		pool:         pool,
+	w := &AccrualWorker{
 		orderStorage: orderStorage,
 		userStorage:  userStorage,
 		client:       client,
 		interval:     interval,
+		pool:         pool,
 		logger:       logger,
+		orderLogger:  slog.Default(),
+		attempts:     make(map[string]int),
+		backoffBase:  defaultBackoffBase,
+		backoffMax:   defaultBackoffMax,
+		tickJitter:   DefaultTickJitter,
+		randFloat:    rand.Float64,
+
+		serverErrorPause: defaultServerErrorPause,
 	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w
 }
 
 // Start запускает воркер в отдельной горутине и останавливается по ctx.Done().
+// Пачки ожидающих заказов и перепроверка застрявших выполняются в одном цикле по очереди:
+// иначе один заказ опрашивался бы параллельно, и запоздавший ответ одного опроса
+// перезаписывал бы результат другого.
 func (w *AccrualWorker) Start(ctx context.Context) {
-	ticker := time.NewTicker(w.interval)
+	// Таймер вместо тикера: интервал до каждого следующего тика выбирается заново
+	timer := time.NewTimer(w.nextInterval())
+
+	// Без WithStuckReaper канал остаётся nil и перепроверка не срабатывает
+	var reap <-chan time.Time
+	var reapTicker *time.Ticker
+	if w.stuckThreshold > 0 {
+		reapTicker = time.NewTicker(w.reapInterval)
+		reap = reapTicker.C
+	}
+
 	go func() {
-		defer ticker.Stop()
-		if err := w.processBatch(ctx); err != nil {
+		defer timer.Stop()
+		if reapTicker != nil {
+			defer reapTicker.Stop()
+		}
+		if _, err := w.processBatch(ctx); err != nil {
 			w.logger.Printf("accrual worker error on initial batch: %v", err)
 		}
 		for {
 			select {
 			case <-ctx.Done():
 				return
-			case <-ticker.C:
-				if err := w.processBatch(ctx); err != nil {
+			case <-timer.C:
+				if _, err := w.processBatch(ctx); err != nil {
 					w.logger.Printf("accrual worker error: %v", err)
 				}
+				timer.Reset(w.nextInterval())
+			case <-reap:
+				if err := w.reapStuck(ctx); err != nil {
+					w.logger.Printf("accrual reaper error: %v", err)
+				}
 			}
 		}
 	}()
 }
 
-func (w *AccrualWorker) processBatch(ctx context.Context) error {
-	orders, err := w.orderStorage.GetPendingOrders(ctx)
+// nextInterval возвращает интервал до следующего тика: interval со случайным
+// отклонением в пределах ±tickJitter.
+func (w *AccrualWorker) nextInterval() time.Duration {
+	if w.tickJitter == 0 {
+		return w.interval
+	}
+	offset := (2*w.randFloat() - 1) * w.tickJitter
+	return w.interval + time.Duration(offset*float64(w.interval))
+}
+
+// reapStuck повторно запрашивает начисления для заказов, застрявших в PROCESSING.
+func (w *AccrualWorker) reapStuck(ctx context.Context) error {
+	orders, err := w.orderStorage.GetStuckProcessing(ctx, w.stuckThreshold)
 	if err != nil {
-		w.logger.Printf("failed to get pending orders: %v", err)
 		return err
 	}
 
 	if len(orders) > 0 {
-		w.logger.Printf("processing %d pending orders", len(orders))
+		w.logger.Printf("re-queueing %d orders stuck in PROCESSING longer than %s", len(orders), w.stuckThreshold)
 	}
 
 	for _, o := range orders {
-		if err := w.processOrder(ctx, o); err != nil {
-			w.logger.Printf("process order %s error: %v", o.Number, err)
+		if _, err := w.processOrder(ctx, o); err != nil {
+			if errors.Is(err, accrual.ErrCircuitOpen) {
+				return nil
+			}
+			w.logger.Printf("reprocess stuck order %s error: %v", o.Number, err)
 		}
 	}
 	return nil
 }
 
-func (w *AccrualWorker) processOrder(ctx context.Context, order *models.Order) error {
+// batchSummary - итог обработки одной пачки ожидающих заказов.
+type batchSummary struct {
+	total     int // заказов в пачке
+	processed int // статус заказа получен, в том числе промежуточный
+	pending   int // статус не применён без ошибки (лимит запросов, таймаут, заказ не найден, подозрительное начисление), повтор позже
+	failed    int // обработка завершилась ошибкой
+	skipped   int // не обработаны: сервис начислений недоступен, повтор на следующем тике
+}
+
+// processBatch обрабатывает ожидающие заказы и пишет итог пачки в журнал. Ошибки отдельных
+// заказов не прерывают пачку и учитываются в итоге; ошибка возвращается, только если
+// не удалось получить сами заказы.
+func (w *AccrualWorker) processBatch(ctx context.Context) (batchSummary, error) {
+	orders, err := w.orderStorage.GetPendingOrders(ctx)
+	if err != nil {
+		w.logger.Printf("failed to get pending orders: %v", err)
+		return batchSummary{}, err
+	}
+
+	if len(orders) > 0 {
+		w.logger.Printf("processing %d pending orders", len(orders))
+	}
+
+	summary := w.processOrders(ctx, orders)
+	w.logBatch(summary)
+	return summary, nil
+}
+
+// processOrders обрабатывает заказы пачки по очереди и подсчитывает итог.
+func (w *AccrualWorker) processOrders(ctx context.Context, orders []*models.Order) batchSummary {
+	summary := batchSummary{total: len(orders)}
+	for i, o := range orders {
+		result, err := w.processOrder(ctx, o)
+		if err == nil {
+			if isStatusResult(result) {
+				summary.processed++
+			} else {
+				summary.pending++
+			}
+			continue
+		}
+		if errors.Is(err, accrual.ErrCircuitOpen) {
+			// Сервис недоступен: пропускаем оставшиеся заказы до следующего тика
+			summary.skipped = len(orders) - i
+			return summary
+		}
+		summary.failed++
+		w.logger.Printf("process order %s error: %v", o.Number, err)
+		if isRetryableAccrualError(err) {
+			// Сервис начислений недоступен: не перебираем остальные заказы,
+			// а ждём и повторяем на следующем тике
+			w.logger.Printf("accrual service unavailable, pausing for %s", w.serverErrorPause)
+			w.sleep(ctx, w.serverErrorPause)
+			summary.skipped = len(orders) - i - 1
+			return summary
+		}
+	}
+	return summary
+}
+
+// logBatch пишет итог непустой пачки; пачка с ошибками пишется с уровнем Warn.
+func (w *AccrualWorker) logBatch(summary batchSummary) {
+	if summary.total == 0 {
+		return
+	}
+	attrs := []any{
+		slog.Int("total", summary.total),
+		slog.Int("processed", summary.processed),
+		slog.Int("pending", summary.pending),
+		slog.Int("failed", summary.failed),
+		slog.Int("skipped", summary.skipped),
+	}
+	if summary.failed > 0 {
+		w.orderLogger.Warn("accrual batch processed", attrs...)
+		return
+	}
+	w.orderLogger.Info("accrual batch processed", attrs...)
+}
+
+// isStatusResult сообщает, что итог обработки заказа - полученный от сервиса начислений статус.
+func isStatusResult(result string) bool {
+	switch result {
+	case orderResultProcessed, orderResultInvalid, orderResultProcessing, orderResultRegistered:
+		return true
+	}
+	return false
+}
+
+// isRetryableAccrualError сообщает, что ошибку вызвал временный сбой сервиса начислений.
+func isRetryableAccrualError(err error) bool {
+	return errors.Is(err, accrual.ErrAccrualServerError)
+}
+
+// sleep ждёт d или отмены ctx.
+func (w *AccrualWorker) sleep(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
+// processOrder обрабатывает заказ и пишет в журнал итог, число попыток и общее время
+// обработки, включая запрос к сервису начислений и запись в базу. Возвращает итог обработки.
+func (w *AccrualWorker) processOrder(ctx context.Context, order *models.Order) (string, error) {
+	start := time.Now()
+	attempt := w.nextAttempt(order.Number)
+
+	result, err := w.handleOrder(ctx, order)
+	if err != nil && result != orderResultCircuitOpen {
+		result = orderResultError
+	}
+
+	attrs := []any{
+		slog.String("order", order.Number),
+		slog.Int64("duration_ms", time.Since(start).Milliseconds()),
+		slog.String("result", result),
+		slog.Int("attempt", attempt),
+	}
+	if err != nil {
+		w.orderLogger.Warn("accrual order processed", append(attrs, slog.String("error", err.Error()))...)
+		return result, err
+	}
+	if result == orderResultProcessed || result == orderResultInvalid {
+		w.resetAttempts(order.Number)
+	}
+	w.orderLogger.Info("accrual order processed", attrs...)
+	return result, nil
+}
+
+// nextAttempt увеличивает и возвращает номер попытки обработки заказа.
+func (w *AccrualWorker) nextAttempt(number string) int {
+	w.attemptsMu.Lock()
+	defer w.attemptsMu.Unlock()
+	w.attempts[number]++
+	return w.attempts[number]
+}
+
+// resetAttempts забывает счётчик попыток заказа с окончательным статусом.
+func (w *AccrualWorker) resetAttempts(number string) {
+	w.attemptsMu.Lock()
+	delete(w.attempts, number)
+	w.attemptsMu.Unlock()
+}
+
+// handleOrder запрашивает начисление и обновляет заказ; возвращает итог для журнала.
+func (w *AccrualWorker) handleOrder(ctx context.Context, order *models.Order) (string, error) {
 	w.logger.Printf("fetching accrual for order %s", order.Number)
-	resp, err := w.client.GetOrderAccrual(ctx, order.Number)
+	resp, err := w.fetchAccrual(ctx, order.Number)
 	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+			w.logger.Printf("accrual fetch for order %s timed out after %s, will retry on next tick", order.Number, w.fetchTimeout)
+			return orderResultTimeout, nil
+		}
 		if rl, ok := err.(accrual.RateLimitError); ok {
-			w.logger.Printf("rate limited for order %s, retrying after %s", order.Number, rl.RetryAfter)
-			time.Sleep(rl.RetryAfter)
-			return nil
+			pause := w.rateLimitPause(rl)
+			w.logger.Printf("rate limited for order %s, retrying after %s", order.Number, pause)
+			w.sleep(ctx, pause)
+			return orderResultRateLimited, nil
 		}
 		if err == accrual.ErrNotFound {
 			w.logger.Printf("order %s not found in accrual system, skipping", order.Number)
-			return nil
+			return orderResultNotFound, nil
+		}
+		if errors.Is(err, accrual.ErrCircuitOpen) {
+			return orderResultCircuitOpen, err
 		}
 		w.logger.Printf("error fetching accrual for order %s: %v", order.Number, err)
-		return err
+		return orderResultError, err
 	}
 
+	w.resetRateLimit()
+
 	w.logger.Printf("order %s status: %s, accrual: %v", order.Number, resp.Status, resp.Accrual)
 	switch resp.Status {
 	case "REGISTERED":
-		return w.orderStorage.UpdateStatus(ctx, order.Number, models.OrderStatusProcessing, nil)
+		// Расчёт начисления ещё не начат: по API лояльности это NEW, а не PROCESSING.
+		// Заказ остаётся в опросе и перейдёт в PROCESSING, когда расчёт начнётся.
+		return orderResultRegistered, w.updateStatus(ctx, order, models.OrderStatusNew)
 	case "PROCESSING":
-		return w.orderStorage.UpdateStatus(ctx, order.Number, models.OrderStatusProcessing, nil)
+		return orderResultProcessing, w.updateStatus(ctx, order, models.OrderStatusProcessing)
 	case "INVALID":
-		return w.orderStorage.UpdateStatus(ctx, order.Number, models.OrderStatusInvalid, nil)
+		return orderResultInvalid, w.updateStatus(ctx, order, models.OrderStatusInvalid)
 	case "PROCESSED":
 		w.logger.Printf("applying processed accrual for order %s: %s", order.Number, resp.Accrual.String())
-		return w.applyProcessed(ctx, order.UserID, order.Number, resp.Accrual)
+		if err := w.applyProcessed(ctx, order.UserID, order.Number, resp.Accrual); err != nil {
+			if errors.Is(err, ErrAccrualTooLarge) {
+				// Заказ остаётся в текущем статусе до ручной проверки
+				w.logger.Printf("suspicious accrual %s for order %s exceeds %s, skipping", resp.Accrual, order.Number, w.maxAccrual)
+				return orderResultSuspicious, nil
+			}
+			return orderResultProcessed, err
+		}
+		w.publishStatus(order, models.OrderStatusProcessed, &resp.Accrual)
+		return orderResultProcessed, nil
 	default:
 		w.logger.Printf("unknown status %s for order %s", resp.Status, order.Number)
-		return nil
+		return orderResultUnknownStatus, nil
 	}
 }
 
+// updateStatus сохраняет новый статус заказа без начисления и публикует изменение.
+func (w *AccrualWorker) updateStatus(ctx context.Context, order *models.Order, status models.OrderStatus) error {
+	if err := w.orderStorage.UpdateStatus(ctx, order.Number, status, nil); err != nil {
+		return err
+	}
+	w.publishStatus(order, status, nil)
+	return nil
+}
+
+// publishStatus публикует событие, если статус заказа действительно изменился.
+func (w *AccrualWorker) publishStatus(order *models.Order, status models.OrderStatus, amount *decimal.Decimal) {
+	if w.events == nil || order.Status == status {
+		return
+	}
+
+	event := models.OrderEvent{
+		UserID: order.UserID,
+		Number: order.Number,
+		Status: string(status),
+	}
+	if amount != nil {
+		f := money.ToFloat(*amount)
+		event.Accrual = &f
+	}
+	w.events.Publish(event)
+}
+
+// fetchAccrual запрашивает начисление, ограничивая запрос fetchTimeout.
+func (w *AccrualWorker) fetchAccrual(ctx context.Context, number string) (*accrual.AccrualResponse, error) {
+	if w.fetchTimeout <= 0 {
+		return w.client.GetOrderAccrual(ctx, number)
+	}
+	fetchCtx, cancel := context.WithTimeout(ctx, w.fetchTimeout)
+	defer cancel()
+	return w.client.GetOrderAccrual(fetchCtx, number)
+}
+
+// rateLimitPause возвращает паузу после 429: Retry-After, если сервис его указал,
+// иначе экспоненциально растущую паузу по числу подряд полученных 429.
+func (w *AccrualWorker) rateLimitPause(rl accrual.RateLimitError) time.Duration {
+	w.rateLimitMu.Lock()
+	defer w.rateLimitMu.Unlock()
+
+	hits := w.rateLimitHits
+	w.rateLimitHits++
+
+	if rl.RetryAfter > 0 {
+		return rl.RetryAfter
+	}
+
+	pause := w.backoffBase
+	for i := 0; i < hits && pause < w.backoffMax; i++ {
+		pause *= 2
+	}
+	if pause > w.backoffMax {
+		pause = w.backoffMax
+	}
+	return pause
+}
+
+// resetRateLimit сбрасывает счётчик 429 после успешного запроса.
+func (w *AccrualWorker) resetRateLimit() {
+	w.rateLimitMu.Lock()
+	w.rateLimitHits = 0
+	w.rateLimitMu.Unlock()
+}
+
+// applyProcessed начисляет баллы и отмечает заказ обработанным в одной транзакции.
+// Строка users блокируется первой (UserStorage.CreditTx) - в том же порядке, что и при списании (UserStorage.WithdrawTx),
+// иначе встречные транзакции могут взаимно заблокироваться.
+// Повторное применение идемпотентно: если заказ уже обработан (например, другой репликой),
+// транзакция откатывается и баланс не меняется.
 func (w *AccrualWorker) applyProcessed(ctx context.Context, userID uuid.UUID, orderNumber string, accrual decimal.Decimal) error {
+	if w.maxAccrual.IsPositive() && accrual.GreaterThan(w.maxAccrual) {
+		return fmt.Errorf("%w: %s > %s", ErrAccrualTooLarge, accrual, w.maxAccrual)
+	}
+
 	tx, err := w.pool.Begin(ctx)
 	if err != nil {
-		return err
+		return storage.WrapInternal(err)
 	}
 
-	// Обновляем заказ
-	_, err = tx.Exec(ctx, `
-		UPDATE orders
-		SET status = $1, accrual = $2, updated_at = NOW()
-		WHERE number = $3
-	`, models.OrderStatusProcessed, accrual, orderNumber)
-	if err != nil {
+	// Начисляем баланс
+	if err := w.userStorage.CreditTx(ctx, tx, userID, accrual); err != nil {
 		tx.Rollback(ctx)
 		return err
 	}
 
-	// Начисляем баланс
-	_, err = tx.Exec(ctx, `
-		UPDATE users
-		SET balance = balance + $1, updated_at = NOW()
-		WHERE id = $2
-	`, accrual, userID)
+	// Обновляем заказ
+	applied, err := w.orderStorage.ApplyAccrualTx(ctx, tx, orderNumber, accrual)
 	if err != nil {
 		tx.Rollback(ctx)
 		return err
 	}
+	if !applied {
+		tx.Rollback(ctx)
+		w.logger.Printf("order %s is already processed, accrual is not applied again", orderNumber)
+		return nil
+	}
+
+	// Пишем журнал аудита
+	if w.audit != nil {
+		entry := &models.AuditEntry{
+			UserID:      userID,
+			OrderNumber: orderNumber,
+			Kind:        models.AuditKindAccrual,
+			Amount:      accrual,
+		}
+		if err := w.audit.Record(ctx, tx, entry); err != nil {
+			tx.Rollback(ctx)
+			return fmt.Errorf("record audit: %w", err)
+		}
+	}
 
 	// Коммитим транзакцию
 	if err := tx.Commit(ctx); err != nil {
 		w.logger.Printf("failed to commit accrual transaction for order %s: %v", orderNumber, err)
-		return err
+		return storage.WrapInternal(err)
 	}
 	w.logger.Printf("successfully committed accrual for order %s: %s", orderNumber, accrual.String())
 	return nil
@@ -0,0 +1,819 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agamariel/gofermart/internal/accrual"
+	"github.com/agamariel/gofermart/internal/models"
+	"github.com/agamariel/gofermart/internal/storage"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+)
+
+type mockAccrualClient struct {
+	GetOrderAccrualFunc func(ctx context.Context, orderNumber string) (*accrual.AccrualResponse, error)
+}
+
+func (m *mockAccrualClient) GetOrderAccrual(ctx context.Context, orderNumber string) (*accrual.AccrualResponse, error) {
+	if m.GetOrderAccrualFunc != nil {
+		return m.GetOrderAccrualFunc(ctx, orderNumber)
+	}
+	return nil, accrual.ErrNotFound
+}
+
+func newTestWorker(orderStorage OrderStorage, client accrual.AccrualClient, opts ...WorkerOption) *AccrualWorker {
+	opts = append([]WorkerOption{WithOrderLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))}, opts...)
+	return NewAccrualWorker(nil, orderStorage, &storage.MockUserStorage{}, client, time.Second, log.New(io.Discard, "", 0), opts...)
+}
+
+func TestAccrualWorker_ReapStuck(t *testing.T) {
+	ctx := context.Background()
+	threshold := 10 * time.Minute
+	now := time.Now()
+
+	// Один заказ чуть старше порога, другой - чуть моложе
+	orders := []*models.Order{
+		{ID: uuid.New(), Number: "stale", Status: models.OrderStatusProcessing, UpdatedAt: now.Add(-threshold - time.Second)},
+		{ID: uuid.New(), Number: "fresh", Status: models.OrderStatusProcessing, UpdatedAt: now.Add(-threshold + time.Second)},
+	}
+
+	var gotThreshold time.Duration
+	var updated []string
+	orderStorage := &mockOrderStorage{
+		GetStuckFunc: func(ctx context.Context, olderThan time.Duration) ([]*models.Order, error) {
+			gotThreshold = olderThan
+			cutoff := now.Add(-olderThan)
+			var stuck []*models.Order
+			for _, o := range orders {
+				if o.UpdatedAt.Before(cutoff) {
+					stuck = append(stuck, o)
+				}
+			}
+			return stuck, nil
+		},
+		UpdateStatusFunc: func(ctx context.Context, number string, status models.OrderStatus, accrual *decimal.Decimal) error {
+			updated = append(updated, number)
+			if status != models.OrderStatusInvalid {
+				t.Errorf("status = %v, want %v", status, models.OrderStatusInvalid)
+			}
+			return nil
+		},
+	}
+	client := &mockAccrualClient{
+		GetOrderAccrualFunc: func(ctx context.Context, orderNumber string) (*accrual.AccrualResponse, error) {
+			return &accrual.AccrualResponse{Order: orderNumber, Status: "INVALID"}, nil
+		},
+	}
+
+	w := newTestWorker(orderStorage, client, WithStuckReaper(threshold, time.Minute))
+	if err := w.reapStuck(ctx); err != nil {
+		t.Fatalf("reapStuck() error = %v", err)
+	}
+
+	if gotThreshold != threshold {
+		t.Errorf("threshold = %v, want %v", gotThreshold, threshold)
+	}
+	if len(updated) != 1 || updated[0] != "stale" {
+		t.Errorf("updated orders = %v, want [stale]", updated)
+	}
+}
+
+func TestWithStuckReaper_Disabled(t *testing.T) {
+	w := newTestWorker(&mockOrderStorage{}, &mockAccrualClient{}, WithStuckReaper(0, time.Minute))
+	if w.stuckThreshold != 0 {
+		t.Errorf("stuckThreshold = %v, want 0", w.stuckThreshold)
+	}
+
+	w = newTestWorker(&mockOrderStorage{}, &mockAccrualClient{}, WithStuckReaper(time.Minute, 0))
+	if w.reapInterval != defaultReapInterval {
+		t.Errorf("reapInterval = %v, want %v", w.reapInterval, defaultReapInterval)
+	}
+}
+
+func TestAccrualWorker_NextInterval(t *testing.T) {
+	const interval = 5 * time.Second
+
+	t.Run("stays within jitter bounds", func(t *testing.T) {
+		w := NewAccrualWorker(nil, &mockOrderStorage{}, nil, &mockAccrualClient{}, interval, nil)
+		low := time.Duration(float64(interval) * (1 - DefaultTickJitter))
+		high := time.Duration(float64(interval) * (1 + DefaultTickJitter))
+
+		for i := 0; i < 1000; i++ {
+			if got := w.nextInterval(); got < low || got > high {
+				t.Fatalf("nextInterval() = %v, want within [%v, %v]", got, low, high)
+			}
+		}
+	})
+
+	t.Run("extremes of the random source", func(t *testing.T) {
+		w := NewAccrualWorker(nil, &mockOrderStorage{}, nil, &mockAccrualClient{}, interval, nil, WithTickJitter(0.2))
+		for _, tt := range []struct {
+			random float64
+			want   time.Duration
+		}{
+			{random: 0, want: 4 * time.Second},
+			{random: 0.5, want: interval},
+			{random: 0.75, want: 5500 * time.Millisecond},
+		} {
+			w.randFloat = func() float64 { return tt.random }
+			if got := w.nextInterval(); got != tt.want {
+				t.Errorf("random %v: nextInterval() = %v, want %v", tt.random, got, tt.want)
+			}
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		w := NewAccrualWorker(nil, &mockOrderStorage{}, nil, &mockAccrualClient{}, interval, nil, WithTickJitter(0))
+		w.randFloat = func() float64 {
+			t.Error("random source must not be used without jitter")
+			return 0
+		}
+		if got := w.nextInterval(); got != interval {
+			t.Errorf("nextInterval() = %v, want %v", got, interval)
+		}
+	})
+
+	t.Run("out of range fraction is ignored", func(t *testing.T) {
+		w := NewAccrualWorker(nil, &mockOrderStorage{}, nil, &mockAccrualClient{}, interval, nil, WithTickJitter(1.5))
+		if w.tickJitter != DefaultTickJitter {
+			t.Errorf("tickJitter = %v, want %v", w.tickJitter, DefaultTickJitter)
+		}
+	})
+}
+
+func TestAccrualWorker_RateLimitBackoff(t *testing.T) {
+	w := newTestWorker(&mockOrderStorage{}, &mockAccrualClient{}, WithRateLimitBackoff(time.Second, 10*time.Second))
+
+	want := []time.Duration{
+		time.Second,
+		2 * time.Second,
+		4 * time.Second,
+		8 * time.Second,
+		10 * time.Second, // ограничено максимумом
+		10 * time.Second,
+	}
+	for i, expected := range want {
+		if got := w.rateLimitPause(accrual.RateLimitError{}); got != expected {
+			t.Errorf("pause #%d = %v, want %v", i, got, expected)
+		}
+	}
+
+	// Явный Retry-After имеет приоритет
+	if got := w.rateLimitPause(accrual.RateLimitError{RetryAfter: 3 * time.Second}); got != 3*time.Second {
+		t.Errorf("pause with Retry-After = %v, want 3s", got)
+	}
+}
+
+func TestAccrualWorker_RateLimitBackoffReset(t *testing.T) {
+	ctx := context.Background()
+	client := &mockAccrualClient{
+		GetOrderAccrualFunc: func(ctx context.Context, orderNumber string) (*accrual.AccrualResponse, error) {
+			return &accrual.AccrualResponse{Order: orderNumber, Status: "PROCESSING"}, nil
+		},
+	}
+	w := newTestWorker(&mockOrderStorage{}, client, WithRateLimitBackoff(time.Second, time.Minute))
+
+	for i := 0; i < 3; i++ {
+		w.rateLimitPause(accrual.RateLimitError{})
+	}
+
+	if _, err := w.processOrder(ctx, &models.Order{Number: "79927398713"}); err != nil {
+		t.Fatalf("processOrder() error = %v", err)
+	}
+
+	if got := w.rateLimitPause(accrual.RateLimitError{}); got != time.Second {
+		t.Errorf("pause after reset = %v, want 1s", got)
+	}
+}
+
+func TestAccrualWorker_RateLimitPauseStopsOnCancel(t *testing.T) {
+	client := &mockAccrualClient{
+		GetOrderAccrualFunc: func(ctx context.Context, orderNumber string) (*accrual.AccrualResponse, error) {
+			return nil, accrual.RateLimitError{RetryAfter: time.Minute}
+		},
+	}
+	w := newTestWorker(&mockOrderStorage{}, client)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	result, err := w.handleOrder(ctx, &models.Order{Number: "79927398713"})
+	if err != nil {
+		t.Fatalf("handleOrder() error = %v", err)
+	}
+	if result != orderResultRateLimited {
+		t.Errorf("result = %q, want %q", result, orderResultRateLimited)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("handleOrder() returned after %v, want the pause to stop on cancel", elapsed)
+	}
+}
+
+func TestAccrualWorker_ServerErrorStopsBatch(t *testing.T) {
+	ctx := context.Background()
+	orders := []*models.Order{
+		{ID: uuid.New(), Number: "first", Status: models.OrderStatusNew},
+		{ID: uuid.New(), Number: "second", Status: models.OrderStatusNew},
+	}
+
+	var calls []string
+	client := &mockAccrualClient{
+		GetOrderAccrualFunc: func(ctx context.Context, orderNumber string) (*accrual.AccrualResponse, error) {
+			calls = append(calls, orderNumber)
+			return nil, fmt.Errorf("%w: status %d", accrual.ErrAccrualServerError, 503)
+		},
+	}
+	orderStorage := &mockOrderStorage{
+		GetPendingFunc: func(ctx context.Context) ([]*models.Order, error) {
+			return orders, nil
+		},
+	}
+
+	w := newTestWorker(orderStorage, client, WithServerErrorBackoff(time.Millisecond))
+	summary, err := w.processBatch(ctx)
+	if err != nil {
+		t.Fatalf("processBatch() error = %v", err)
+	}
+
+	if len(calls) != 1 {
+		t.Errorf("accrual calls = %v, want only the first order", calls)
+	}
+	if want := (batchSummary{total: 2, failed: 1, skipped: 1}); summary != want {
+		t.Errorf("summary = %+v, want %+v", summary, want)
+	}
+}
+
+func TestIsRetryableAccrualError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "server error", err: fmt.Errorf("%w: status %d", accrual.ErrAccrualServerError, 502), want: true},
+		{name: "not found", err: accrual.ErrNotFound, want: false},
+		{name: "rate limit", err: accrual.RateLimitError{}, want: false},
+		{name: "other", err: fmt.Errorf("unexpected accrual status: 400"), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryableAccrualError(tt.err); got != tt.want {
+				t.Errorf("isRetryableAccrualError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAccrualWorker_CircuitOpenSkipsBatch(t *testing.T) {
+	ctx := context.Background()
+	orders := []*models.Order{
+		{ID: uuid.New(), Number: "first", Status: models.OrderStatusNew},
+		{ID: uuid.New(), Number: "second", Status: models.OrderStatusNew},
+	}
+
+	calls := 0
+	client := &mockAccrualClient{
+		GetOrderAccrualFunc: func(ctx context.Context, orderNumber string) (*accrual.AccrualResponse, error) {
+			calls++
+			return nil, accrual.ErrCircuitOpen
+		},
+	}
+	orderStorage := &mockOrderStorage{
+		GetPendingFunc: func(ctx context.Context) ([]*models.Order, error) {
+			return orders, nil
+		},
+	}
+
+	w := newTestWorker(orderStorage, client)
+	summary, err := w.processBatch(ctx)
+	if err != nil {
+		t.Fatalf("processBatch() error = %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("accrual calls = %d, want 1", calls)
+	}
+	if want := (batchSummary{total: 2, skipped: 2}); summary != want {
+		t.Errorf("summary = %+v, want %+v", summary, want)
+	}
+}
+
+func TestAccrualWorker_BatchSummary(t *testing.T) {
+	ctx := context.Background()
+	errStorage := errors.New("db error")
+	orders := []*models.Order{
+		{ID: uuid.New(), UserID: uuid.New(), Number: "processed", Status: models.OrderStatusProcessing},
+		{ID: uuid.New(), Number: "processing", Status: models.OrderStatusNew},
+		{ID: uuid.New(), Number: "bad-request", Status: models.OrderStatusNew},
+		{ID: uuid.New(), Number: "not-found", Status: models.OrderStatusNew},
+		{ID: uuid.New(), Number: "rate-limited", Status: models.OrderStatusNew},
+		{ID: uuid.New(), Number: "timed-out", Status: models.OrderStatusNew},
+		{ID: uuid.New(), Number: "storage-error", Status: models.OrderStatusNew},
+	}
+
+	client := &mockAccrualClient{
+		GetOrderAccrualFunc: func(ctx context.Context, orderNumber string) (*accrual.AccrualResponse, error) {
+			switch orderNumber {
+			case "processed":
+				return &accrual.AccrualResponse{Order: orderNumber, Status: "PROCESSED", Accrual: decimal.NewFromInt(500)}, nil
+			case "bad-request":
+				return nil, fmt.Errorf("unexpected accrual status: 400")
+			case "not-found":
+				return nil, accrual.ErrNotFound
+			case "rate-limited":
+				return nil, accrual.RateLimitError{RetryAfter: time.Millisecond}
+			case "timed-out":
+				return nil, context.DeadlineExceeded
+			default:
+				return &accrual.AccrualResponse{Order: orderNumber, Status: "PROCESSING"}, nil
+			}
+		},
+	}
+	orderStorage := &mockOrderStorage{
+		GetPendingFunc: func(ctx context.Context) ([]*models.Order, error) {
+			return orders, nil
+		},
+		UpdateStatusFunc: func(ctx context.Context, number string, status models.OrderStatus, accrual *decimal.Decimal) error {
+			if number == "storage-error" {
+				return errStorage
+			}
+			return nil
+		},
+	}
+
+	var buf bytes.Buffer
+	w := newTestWorker(orderStorage, client, WithOrderLogger(slog.New(slog.NewJSONHandler(&buf, nil))))
+	w.pool = &fakeBeginner{tx: &fakeTx{}}
+
+	summary, err := w.processBatch(ctx)
+	if err != nil {
+		t.Fatalf("processBatch() error = %v", err)
+	}
+	// Лимит запросов, таймаут и неизвестный заказ не дают статуса и не считаются обработанными
+	if want := (batchSummary{total: 7, processed: 2, pending: 3, failed: 2}); summary != want {
+		t.Errorf("summary = %+v, want %+v", summary, want)
+	}
+
+	var logged map[string]any
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &logged); err != nil {
+		t.Fatalf("decode batch log: %v", err)
+	}
+	if logged["msg"] != "accrual batch processed" || logged["level"] != "WARN" ||
+		logged["total"] != float64(7) || logged["processed"] != float64(2) || logged["pending"] != float64(3) ||
+		logged["failed"] != float64(2) || logged["skipped"] != float64(0) {
+		t.Errorf("batch log = %v", logged)
+	}
+
+	t.Run("pending orders failure", func(t *testing.T) {
+		orderStorage.GetPendingFunc = func(ctx context.Context) ([]*models.Order, error) {
+			return nil, errStorage
+		}
+		summary, err := w.processBatch(ctx)
+		if !errors.Is(err, errStorage) || summary != (batchSummary{}) {
+			t.Errorf("processBatch() = %+v, %v; want empty summary and %v", summary, err, errStorage)
+		}
+	})
+}
+
+func TestAccrualWorker_ProcessOrderLogsFields(t *testing.T) {
+	var buf bytes.Buffer
+	tx := &fakeTx{}
+	client := &mockAccrualClient{
+		GetOrderAccrualFunc: func(ctx context.Context, orderNumber string) (*accrual.AccrualResponse, error) {
+			return &accrual.AccrualResponse{Order: orderNumber, Status: "PROCESSED", Accrual: decimal.NewFromInt(500)}, nil
+		},
+	}
+
+	var credited, updated bool
+	orderStorage := &mockOrderStorage{
+		ApplyAccrualTxFunc: func(ctx context.Context, got pgx.Tx, number string, accrual decimal.Decimal) (bool, error) {
+			updated = got == tx && accrual.Equal(decimal.NewFromInt(500))
+			return true, nil
+		},
+	}
+	w := newTestWorker(orderStorage, client, WithOrderLogger(slog.New(slog.NewJSONHandler(&buf, nil))))
+	w.pool = &fakeBeginner{tx: tx}
+	w.userStorage = &storage.MockUserStorage{
+		CreditTxFunc: func(ctx context.Context, got pgx.Tx, id uuid.UUID, amount decimal.Decimal) error {
+			credited = got == tx && amount.Equal(decimal.NewFromInt(500))
+			return nil
+		},
+	}
+
+	order := &models.Order{ID: uuid.New(), UserID: uuid.New(), Number: "12345678903"}
+	if _, err := w.processOrder(context.Background(), order); err != nil {
+		t.Fatalf("processOrder() error = %v", err)
+	}
+
+	if !credited || !updated || !tx.committed {
+		t.Errorf("credited = %v, updated = %v, committed = %v; want all true", credited, updated, tx.committed)
+	}
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("expected single JSON log entry, got %q: %v", buf.String(), err)
+	}
+	if entry["order"] != order.Number {
+		t.Errorf("order = %v, want %q", entry["order"], order.Number)
+	}
+	if entry["result"] != "processed" {
+		t.Errorf("result = %v, want %q", entry["result"], "processed")
+	}
+	if entry["attempt"] != float64(1) {
+		t.Errorf("attempt = %v, want 1", entry["attempt"])
+	}
+	if d, ok := entry["duration_ms"].(float64); !ok || d < 0 {
+		t.Errorf("duration_ms = %v, want non-negative number", entry["duration_ms"])
+	}
+}
+
+func TestAccrualWorker_ProcessOrderCountsAttempts(t *testing.T) {
+	var buf bytes.Buffer
+	client := &mockAccrualClient{
+		GetOrderAccrualFunc: func(ctx context.Context, orderNumber string) (*accrual.AccrualResponse, error) {
+			return &accrual.AccrualResponse{Order: orderNumber, Status: "PROCESSING"}, nil
+		},
+	}
+	w := newTestWorker(&mockOrderStorage{}, client, WithOrderLogger(slog.New(slog.NewJSONHandler(&buf, nil))))
+
+	order := &models.Order{ID: uuid.New(), Number: "12345678903"}
+	for i := 0; i < 2; i++ {
+		if _, err := w.processOrder(context.Background(), order); err != nil {
+			t.Fatalf("processOrder() error = %v", err)
+		}
+	}
+
+	dec := json.NewDecoder(&buf)
+	var entry map[string]any
+	for i := 1; i <= 2; i++ {
+		if err := dec.Decode(&entry); err != nil {
+			t.Fatalf("decode log entry #%d: %v", i, err)
+		}
+		if entry["attempt"] != float64(i) || entry["result"] != "processing" {
+			t.Errorf("entry #%d: attempt = %v, result = %v", i, entry["attempt"], entry["result"])
+		}
+	}
+}
+
+func TestAccrualWorker_FetchTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Сервис "зависает", пока запрос не отменят
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	var logs bytes.Buffer
+	var updated bool
+	orderStorage := &mockOrderStorage{
+		UpdateStatusFunc: func(ctx context.Context, number string, status models.OrderStatus, accrual *decimal.Decimal) error {
+			updated = true
+			return nil
+		},
+	}
+	client := accrual.NewHTTPAccrualClient(srv.URL, 10*time.Second)
+	w := newTestWorker(orderStorage, client,
+		WithFetchTimeout(50*time.Millisecond),
+		WithOrderLogger(slog.New(slog.NewJSONHandler(&logs, nil))))
+
+	start := time.Now()
+	_, err := w.processOrder(context.Background(), &models.Order{ID: uuid.New(), Number: "12345678903"})
+	if err != nil {
+		t.Fatalf("processOrder() error = %v, want nil (order retried on next tick)", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("processOrder() took %v, deadline did not fire", elapsed)
+	}
+	if updated {
+		t.Error("order status must not change after a timed out fetch")
+	}
+
+	var entry map[string]any
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatalf("expected JSON log entry, got %q: %v", logs.String(), err)
+	}
+	if entry["result"] != "timeout" {
+		t.Errorf("result = %v, want %q", entry["result"], "timeout")
+	}
+}
+
+func TestAccrualWorker_PublishesStatusChanges(t *testing.T) {
+	broker := NewOrderEventBroker()
+	userID := uuid.New()
+	events, unsubscribe := broker.Subscribe(userID)
+	defer unsubscribe()
+
+	status := "PROCESSING"
+	client := &mockAccrualClient{
+		GetOrderAccrualFunc: func(ctx context.Context, orderNumber string) (*accrual.AccrualResponse, error) {
+			return &accrual.AccrualResponse{Order: orderNumber, Status: status, Accrual: decimal.NewFromInt(500)}, nil
+		},
+	}
+	w := newTestWorker(&mockOrderStorage{}, client, WithOrderEvents(broker))
+	w.pool = &fakeBeginner{tx: &fakeTx{}}
+
+	order := &models.Order{ID: uuid.New(), UserID: userID, Number: "12345678903", Status: models.OrderStatusNew}
+	if _, err := w.processOrder(context.Background(), order); err != nil {
+		t.Fatalf("processOrder() error = %v", err)
+	}
+	assertEvent := func(wantStatus string, wantAccrual *float64) {
+		t.Helper()
+		select {
+		case event := <-events:
+			if event.Number != order.Number || event.Status != wantStatus {
+				t.Errorf("event = %+v, want status %s", event, wantStatus)
+			}
+			if (event.Accrual == nil) != (wantAccrual == nil) || (wantAccrual != nil && *event.Accrual != *wantAccrual) {
+				t.Errorf("event accrual = %v, want %v", event.Accrual, wantAccrual)
+			}
+		default:
+			t.Fatalf("expected %s event", wantStatus)
+		}
+	}
+	assertEvent("PROCESSING", nil)
+
+	// Повторный PROCESSING статус не меняет и события не порождает
+	order.Status = models.OrderStatusProcessing
+	if _, err := w.processOrder(context.Background(), order); err != nil {
+		t.Fatalf("processOrder() error = %v", err)
+	}
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected event for unchanged status: %+v", event)
+	default:
+	}
+
+	status = "PROCESSED"
+	if _, err := w.processOrder(context.Background(), order); err != nil {
+		t.Fatalf("processOrder() error = %v", err)
+	}
+	want := 500.0
+	assertEvent("PROCESSED", &want)
+}
+
+func TestAccrualWorker_MaxAccrual(t *testing.T) {
+	maxAccrual := decimal.NewFromInt(10_000)
+
+	tests := []struct {
+		name        string
+		accrual     decimal.Decimal
+		wantApplied bool
+		wantResult  string
+	}{
+		{name: "below cap", accrual: decimal.RequireFromString("9999.99"), wantApplied: true, wantResult: orderResultProcessed},
+		{name: "equal to cap", accrual: maxAccrual, wantApplied: true, wantResult: orderResultProcessed},
+		{name: "above cap", accrual: decimal.RequireFromString("10000.01"), wantResult: orderResultSuspicious},
+		{name: "absurd value", accrual: decimal.RequireFromString("1e20"), wantResult: orderResultSuspicious},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &mockAccrualClient{
+				GetOrderAccrualFunc: func(ctx context.Context, orderNumber string) (*accrual.AccrualResponse, error) {
+					return &accrual.AccrualResponse{Order: orderNumber, Status: "PROCESSED", Accrual: tt.accrual}, nil
+				},
+			}
+			tx := &fakeTx{}
+			credited := false
+			w := newTestWorker(&mockOrderStorage{}, client, WithMaxAccrual(maxAccrual))
+			w.pool = &fakeBeginner{tx: tx}
+			w.userStorage = &storage.MockUserStorage{
+				CreditTxFunc: func(ctx context.Context, tx pgx.Tx, id uuid.UUID, amount decimal.Decimal) error {
+					credited = true
+					return nil
+				},
+			}
+
+			order := &models.Order{ID: uuid.New(), UserID: uuid.New(), Number: "12345678903", Status: models.OrderStatusProcessing}
+			result, err := w.handleOrder(context.Background(), order)
+			if err != nil {
+				t.Fatalf("handleOrder() error = %v", err)
+			}
+			if result != tt.wantResult {
+				t.Errorf("result = %q, want %q", result, tt.wantResult)
+			}
+			if tx.committed != tt.wantApplied {
+				t.Errorf("accrual applied = %v, want %v", tx.committed, tt.wantApplied)
+			}
+			if credited != tt.wantApplied {
+				t.Errorf("balance credited = %v, want %v", credited, tt.wantApplied)
+			}
+		})
+	}
+}
+
+func TestAccrualWorker_Audit(t *testing.T) {
+	amount := decimal.RequireFromString("120.5")
+	client := &mockAccrualClient{
+		GetOrderAccrualFunc: func(ctx context.Context, orderNumber string) (*accrual.AccrualResponse, error) {
+			return &accrual.AccrualResponse{Order: orderNumber, Status: "PROCESSED", Accrual: amount}, nil
+		},
+	}
+
+	tests := []struct {
+		name       string
+		recordErr  error
+		wantCommit bool
+	}{
+		{name: "entry is recorded in the accrual transaction", wantCommit: true},
+		{name: "record failure rolls back the accrual", recordErr: errors.New("audit unavailable")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := &fakeTx{}
+			var recorded *models.AuditEntry
+			audit := &storage.MockAuditStorage{
+				RecordFunc: func(ctx context.Context, recordTx pgx.Tx, entry *models.AuditEntry) error {
+					if recordTx != tx {
+						t.Error("audit entry must be recorded in the accrual transaction")
+					}
+					recorded = entry
+					return tt.recordErr
+				},
+			}
+			w := newTestWorker(&mockOrderStorage{}, client, WithAccrualAudit(audit))
+			w.pool = &fakeBeginner{tx: tx}
+			w.userStorage = &storage.MockUserStorage{}
+
+			order := &models.Order{ID: uuid.New(), UserID: uuid.New(), Number: "12345678903", Status: models.OrderStatusProcessing}
+			_, err := w.handleOrder(context.Background(), order)
+			if !errors.Is(err, tt.recordErr) || (tt.recordErr == nil && err != nil) {
+				t.Fatalf("handleOrder() error = %v, want %v", err, tt.recordErr)
+			}
+			if tx.committed != tt.wantCommit || tx.rolledBack == tt.wantCommit {
+				t.Errorf("committed = %v, rolled back = %v; want commit %v", tx.committed, tx.rolledBack, tt.wantCommit)
+			}
+			if recorded == nil || recorded.UserID != order.UserID || recorded.OrderNumber != order.Number ||
+				recorded.Kind != models.AuditKindAccrual || !recorded.Amount.Equal(amount) {
+				t.Errorf("entry = %+v, want accrual of %s for order %s", recorded, amount, order.Number)
+			}
+		})
+	}
+}
+
+func TestAccrualWorker_AlreadyProcessedIsNotCreditedTwice(t *testing.T) {
+	client := &mockAccrualClient{
+		GetOrderAccrualFunc: func(ctx context.Context, orderNumber string) (*accrual.AccrualResponse, error) {
+			return &accrual.AccrualResponse{Order: orderNumber, Status: "PROCESSED", Accrual: decimal.NewFromInt(500)}, nil
+		},
+	}
+	// Заказ уже обработала другая реплика: ApplyAccrualTx ничего не меняет
+	orderStorage := &mockOrderStorage{
+		ApplyAccrualTxFunc: func(ctx context.Context, tx pgx.Tx, number string, accrual decimal.Decimal) (bool, error) {
+			return false, nil
+		},
+	}
+	recorded := false
+	audit := &storage.MockAuditStorage{
+		RecordFunc: func(ctx context.Context, tx pgx.Tx, entry *models.AuditEntry) error {
+			recorded = true
+			return nil
+		},
+	}
+	tx := &fakeTx{}
+	w := newTestWorker(orderStorage, client, WithAccrualAudit(audit))
+	w.pool = &fakeBeginner{tx: tx}
+	w.userStorage = &storage.MockUserStorage{}
+
+	order := &models.Order{ID: uuid.New(), UserID: uuid.New(), Number: "12345678903", Status: models.OrderStatusProcessing}
+	if _, err := w.handleOrder(context.Background(), order); err != nil {
+		t.Fatalf("handleOrder() error = %v", err)
+	}
+	if tx.committed || !tx.rolledBack {
+		t.Errorf("committed = %v, rolled back = %v; want the credit rolled back", tx.committed, tx.rolledBack)
+	}
+	if recorded {
+		t.Error("audit entry recorded for an accrual that was not applied")
+	}
+}
+
+func TestAccrualWorker_StoredStatusForEachAccrualStatus(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		accrualStatus string
+		initial       models.OrderStatus
+		want          models.OrderStatus
+		wantResult    string
+	}{
+		{accrualStatus: "REGISTERED", initial: models.OrderStatusNew, want: models.OrderStatusNew, wantResult: orderResultRegistered},
+		{accrualStatus: "PROCESSING", initial: models.OrderStatusNew, want: models.OrderStatusProcessing, wantResult: orderResultProcessing},
+		{accrualStatus: "INVALID", initial: models.OrderStatusProcessing, want: models.OrderStatusInvalid, wantResult: orderResultInvalid},
+		{accrualStatus: "PROCESSED", initial: models.OrderStatusProcessing, want: models.OrderStatusProcessed, wantResult: orderResultProcessed},
+		{accrualStatus: "UNKNOWN", initial: models.OrderStatusProcessing, want: models.OrderStatusProcessing, wantResult: orderResultUnknownStatus},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.accrualStatus, func(t *testing.T) {
+			users := storage.NewInMemoryUserStorage()
+			orders := storage.NewInMemoryOrderStorage()
+			user := &models.User{ID: uuid.New(), Login: "alice", PasswordHash: "hash"}
+			if err := users.Create(ctx, user); err != nil {
+				t.Fatalf("Create user error = %v", err)
+			}
+			order := &models.Order{ID: uuid.New(), UserID: user.ID, Number: "12345678903", Status: models.OrderStatusNew}
+			if err := orders.Create(ctx, order); err != nil {
+				t.Fatalf("Create order error = %v", err)
+			}
+			if tt.initial != models.OrderStatusNew {
+				if err := orders.UpdateStatus(ctx, order.Number, tt.initial, nil); err != nil {
+					t.Fatalf("UpdateStatus() error = %v", err)
+				}
+				order.Status = tt.initial
+			}
+
+			client := &mockAccrualClient{
+				GetOrderAccrualFunc: func(ctx context.Context, orderNumber string) (*accrual.AccrualResponse, error) {
+					return &accrual.AccrualResponse{Order: orderNumber, Status: tt.accrualStatus, Accrual: decimal.NewFromInt(500)}, nil
+				},
+			}
+			w := newTestWorker(orders, client)
+			w.pool = storage.NewMemoryTxBeginner()
+			w.userStorage = users
+
+			result, err := w.handleOrder(ctx, order)
+			if err != nil {
+				t.Fatalf("handleOrder() error = %v", err)
+			}
+			if result != tt.wantResult {
+				t.Errorf("result = %q, want %q", result, tt.wantResult)
+			}
+			stored, err := orders.GetByNumber(ctx, order.Number)
+			if err != nil {
+				t.Fatalf("GetByNumber() error = %v", err)
+			}
+			if stored.Status != tt.want {
+				t.Errorf("stored status = %s, want %s", stored.Status, tt.want)
+			}
+		})
+	}
+}
+
+func TestAccrualWorker_StaleUpdateAfterProcessedCreditsOnce(t *testing.T) {
+	ctx := context.Background()
+	users := storage.NewInMemoryUserStorage()
+	orders := storage.NewInMemoryOrderStorage()
+	user := &models.User{ID: uuid.New(), Login: "alice", PasswordHash: "hash"}
+	if err := users.Create(ctx, user); err != nil {
+		t.Fatalf("Create user error = %v", err)
+	}
+	order := &models.Order{ID: uuid.New(), UserID: user.ID, Number: "12345678903", Status: models.OrderStatusProcessing}
+	if err := orders.Create(ctx, order); err != nil {
+		t.Fatalf("Create order error = %v", err)
+	}
+
+	// Ответы сервиса начислений в порядке их записи: PROCESSED, запоздавший PROCESSING
+	// от параллельного опроса и снова PROCESSED
+	responses := []string{"PROCESSED", "PROCESSING", "PROCESSED"}
+	client := &mockAccrualClient{
+		GetOrderAccrualFunc: func(ctx context.Context, orderNumber string) (*accrual.AccrualResponse, error) {
+			status := responses[0]
+			responses = responses[1:]
+			return &accrual.AccrualResponse{Order: orderNumber, Status: status, Accrual: decimal.NewFromInt(500)}, nil
+		},
+	}
+	w := newTestWorker(orders, client)
+	w.pool = storage.NewMemoryTxBeginner()
+	w.userStorage = users
+
+	for i := 0; i < 3; i++ {
+		// Каждый опрос работает со снимком заказа, прочитанным до обработки
+		if _, err := w.processOrder(ctx, &models.Order{UserID: user.ID, Number: order.Number, Status: models.OrderStatusProcessing}); err != nil {
+			t.Fatalf("processOrder() error = %v", err)
+		}
+	}
+
+	stored, err := orders.GetByNumber(ctx, order.Number)
+	if err != nil {
+		t.Fatalf("GetByNumber() error = %v", err)
+	}
+	if stored.Status != models.OrderStatusProcessed {
+		t.Errorf("stored status = %s, want PROCESSED", stored.Status)
+	}
+	got, err := users.GetByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetByID() error = %v", err)
+	}
+	if !got.Balance.Equal(decimal.NewFromInt(500)) {
+		t.Errorf("balance = %s, want 500 credited once", got.Balance)
+	}
+}
@@ -8,54 +8,157 @@ import (
 	"time"
 
 	"github.com/agamariel/gofermart/internal/models"
-	"github.com/agamariel/gofermart/internal/utils"
+	"github.com/agamariel/gofermart/internal/money"
+	"github.com/agamariel/gofermart/internal/storage"
 	"github.com/google/uuid"
-	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/shopspring/decimal"
 )
 
+// Списание или его отмена, прерванные взаимной блокировкой (deadlock), повторяются до withdrawAttempts раз.
+const (
+	withdrawAttempts   = 3
+	withdrawRetryPause = 10 * time.Millisecond
+)
+
 var (
 	ErrInvalidWithdrawalNumber = errors.New("invalid order number")
 	ErrInvalidWithdrawalSum    = errors.New("invalid withdrawal sum")
+	ErrWithdrawalTooLarge      = errors.New("withdrawal sum exceeds the allowed maximum")
+	ErrInvalidAdjustment       = errors.New("invalid balance adjustment")
+	ErrAdjustmentReason        = errors.New("balance adjustment reason is required")
+	ErrAuditNotConfigured      = errors.New("audit log is not configured")
 )
 
 // BalanceService описывает операции по списаниям и истории.
 type BalanceService interface {
 	Withdraw(ctx context.Context, userID uuid.UUID, orderNumber string, sum decimal.Decimal) error
+	ReverseWithdrawal(ctx context.Context, userID uuid.UUID, orderNumber string) (*models.Withdrawal, error)
 	GetWithdrawals(ctx context.Context, userID uuid.UUID) ([]*models.Withdrawal, error)
+	GetWithdrawalsBetween(ctx context.Context, userID uuid.UUID, from, to time.Time) ([]*models.Withdrawal, error)
+	ExportWithdrawals(ctx context.Context, userID uuid.UUID, from, to time.Time, fn func(withdrawal *models.Withdrawal) error) error
+	GetWithdrawalsTotal(ctx context.Context, userID uuid.UUID) (decimal.Decimal, error)
+	GetBalance(ctx context.Context, userID uuid.UUID) (*models.BalanceSummary, error)
+	AdjustBalance(ctx context.Context, adminID, userID uuid.UUID, delta decimal.Decimal, reason string) (*models.AuditEntry, error)
 }
 
 type BalanceServiceImpl struct {
-	pool              *pgxpool.Pool
+	pool              TxBeginner
 	userStorage       UserStorage
 	withdrawalStorage WithdrawalStorage
+	orderStorage      OrderStorage
+
+	// Списания больше maxWithdrawal отклоняются; ноль - без ограничения.
+	maxWithdrawal decimal.Decimal
+	// strictOrder - списывать только по заказам, загруженным самим пользователем.
+	strictOrder bool
+	// skipLuhn - не проверять номера по алгоритму Луна (только для песочницы).
+	skipLuhn bool
+	// audit получает запись о каждом списании; nil - журнал не ведётся.
+	audit AuditStorage
+}
+
+// BalanceServiceOption настраивает BalanceServiceImpl.
+type BalanceServiceOption func(*BalanceServiceImpl)
+
+// WithMaxWithdrawal ограничивает сумму одного списания.
+func WithMaxWithdrawal(maxWithdrawal decimal.Decimal) BalanceServiceOption {
+	return func(s *BalanceServiceImpl) {
+		if maxWithdrawal.IsPositive() {
+			s.maxWithdrawal = maxWithdrawal
+		}
+	}
+}
+
+// WithStrictWithdrawalOrder разрешает списание только по номеру заказа, который уже загружен
+// этим пользователем; иначе Withdraw возвращает ErrInvalidWithdrawalNumber.
+func WithStrictWithdrawalOrder() BalanceServiceOption {
+	return func(s *BalanceServiceImpl) {
+		s.strictOrder = true
+	}
+}
+
+// WithoutWithdrawalLuhnCheck отключает проверку номера заказа при списании по алгоритму Луна:
+// номер должен лишь быть непустым и состоять из цифр. Только для песочницы.
+func WithoutWithdrawalLuhnCheck() BalanceServiceOption {
+	return func(s *BalanceServiceImpl) {
+		s.skipLuhn = true
+	}
+}
+
+// WithWithdrawalAudit записывает каждое списание и его отмену в журнал аудита в той же транзакции.
+func WithWithdrawalAudit(audit AuditStorage) BalanceServiceOption {
+	return func(s *BalanceServiceImpl) {
+		s.audit = audit
+	}
 }
 
 // NewBalanceService создаёт сервис баланса.
-func NewBalanceService(pool *pgxpool.Pool, userStorage UserStorage, withdrawalStorage WithdrawalStorage) *BalanceServiceImpl {
-	return &BalanceServiceImpl{
+func NewBalanceService(pool TxBeginner, userStorage UserStorage, withdrawalStorage WithdrawalStorage, orderStorage OrderStorage, opts ...BalanceServiceOption) *BalanceServiceImpl {
+	s := &BalanceServiceImpl{
 		pool:              pool,
 		userStorage:       userStorage,
 		withdrawalStorage: withdrawalStorage,
+		orderStorage:      orderStorage,
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	return s
 }
 
 // Withdraw выполняет списание средств.
 func (s *BalanceServiceImpl) Withdraw(ctx context.Context, userID uuid.UUID, orderNumber string, sum decimal.Decimal) error {
 	orderNumber = strings.TrimSpace(orderNumber)
-	if orderNumber == "" || !utils.ValidateLuhn(orderNumber) {
+	if !validOrderNumber(orderNumber, !s.skipLuhn) {
 		return ErrInvalidWithdrawalNumber
 	}
 	if sum.LessThanOrEqual(decimal.Zero) {
 		return ErrInvalidWithdrawalSum
 	}
+	if s.maxWithdrawal.IsPositive() && sum.GreaterThan(s.maxWithdrawal) {
+		return ErrWithdrawalTooLarge
+	}
+	if s.strictOrder {
+		if err := s.checkOwnOrder(ctx, userID, orderNumber); err != nil {
+			return err
+		}
+	}
+
+	return retryOnDeadlock(ctx, func() error {
+		return s.withdraw(ctx, userID, orderNumber, sum)
+	})
+}
+
+// checkOwnOrder проверяет, что пользователь уже загрузил заказ с этим номером.
+func (s *BalanceServiceImpl) checkOwnOrder(ctx context.Context, userID uuid.UUID, orderNumber string) error {
+	if _, err := s.orderStorage.GetByNumberForUser(ctx, orderNumber, userID); err != nil {
+		if errors.Is(err, storage.ErrOrderNotFound) {
+			return ErrInvalidWithdrawalNumber
+		}
+		return err
+	}
+	return nil
+}
 
+// withdraw выполняет списание в одной транзакции.
+func (s *BalanceServiceImpl) withdraw(ctx context.Context, userID uuid.UUID, orderNumber string, sum decimal.Decimal) error {
 	tx, err := s.pool.Begin(ctx)
 	if err != nil {
-		return fmt.Errorf("begin tx: %w", err)
+		return fmt.Errorf("begin tx: %w", storage.WrapInternal(err))
 	}
 	defer tx.Rollback(ctx)
 
+	// Повторное списание по заказу отклоняем до изменения баланса. Одновременные запросы
+	// могут пройти проверку оба - второй отклонит ограничение уникальности при вставке.
+	exists, err := s.withdrawalStorage.ExistsByOrder(ctx, tx, userID, orderNumber)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return storage.ErrWithdrawalExists
+	}
+
 	// списание с баланса
 	if err := s.userStorage.WithdrawTx(ctx, tx, userID, sum); err != nil {
 		return err
@@ -72,13 +175,177 @@ func (s *BalanceServiceImpl) Withdraw(ctx context.Context, userID uuid.UUID, ord
 		return err
 	}
 
+	// запись в журнал аудита
+	if s.audit != nil {
+		entry := &models.AuditEntry{
+			UserID:      userID,
+			OrderNumber: orderNumber,
+			Kind:        models.AuditKindWithdrawal,
+			Amount:      sum,
+		}
+		if err := s.audit.Record(ctx, tx, entry); err != nil {
+			return fmt.Errorf("record audit: %w", err)
+		}
+	}
+
 	if err := tx.Commit(ctx); err != nil {
-		return fmt.Errorf("commit tx: %w", err)
+		return fmt.Errorf("commit tx: %w", storage.WrapInternal(err))
 	}
 
 	return nil
 }
 
+// ReverseWithdrawal отменяет ошибочное списание: удаляет его, возвращает сумму на баланс
+// и записывает возврат в журнал аудита в одной транзакции. Возвращает отменённое списание; повторная отмена того же списания
+// завершается ErrWithdrawalNotFound.
+func (s *BalanceServiceImpl) ReverseWithdrawal(ctx context.Context, userID uuid.UUID, orderNumber string) (*models.Withdrawal, error) {
+	orderNumber = strings.TrimSpace(orderNumber)
+	if orderNumber == "" {
+		return nil, ErrInvalidWithdrawalNumber
+	}
+
+	var reversed *models.Withdrawal
+	err := retryOnDeadlock(ctx, func() error {
+		var err error
+		reversed, err = s.reverseWithdrawal(ctx, userID, orderNumber)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reversed, nil
+}
+
+// reverseWithdrawal выполняет отмену списания в одной транзакции.
+// Строка пользователя блокируется первой, как при списании, иначе встречные списание
+// и отмена блокировали бы строки в разном порядке и попадали в deadlock. Параллельная
+// отмена дождётся коммита и не найдёт списание, поэтому сумма не вернётся на баланс дважды.
+func (s *BalanceServiceImpl) reverseWithdrawal(ctx context.Context, userID uuid.UUID, orderNumber string) (*models.Withdrawal, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", storage.WrapInternal(err))
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := s.userStorage.GetByIDForUpdateTx(ctx, tx, userID); err != nil {
+		return nil, err
+	}
+
+	withdrawal, err := s.withdrawalStorage.DeleteByOrder(ctx, tx, userID, orderNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.userStorage.RefundTx(ctx, tx, userID, withdrawal.Sum); err != nil {
+		return nil, err
+	}
+
+	// запись возврата в журнал аудита
+	if s.audit != nil {
+		entry := &models.AuditEntry{
+			UserID:      userID,
+			OrderNumber: withdrawal.OrderNumber,
+			Kind:        models.AuditKindRefund,
+			Amount:      withdrawal.Sum,
+		}
+		if err := s.audit.Record(ctx, tx, entry); err != nil {
+			return nil, fmt.Errorf("record audit: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("commit tx: %w", storage.WrapInternal(err))
+	}
+
+	return withdrawal, nil
+}
+
+// AdjustBalance вручную изменяет баланс пользователя на delta по решению администратора adminID.
+// Отрицательная delta уменьшает баланс, но не ниже нуля (storage.InsufficientBalanceError).
+// Изменение и запись журнала аудита с причиной reason выполняются в одной транзакции,
+// поэтому без журнала (WithWithdrawalAudit) корректировка невозможна. Возвращает запись журнала.
+func (s *BalanceServiceImpl) AdjustBalance(ctx context.Context, adminID, userID uuid.UUID, delta decimal.Decimal, reason string) (*models.AuditEntry, error) {
+	// Баланс хранится с точностью до копеек
+	if delta.IsZero() || !money.HasValidPlaces(delta) {
+		return nil, ErrInvalidAdjustment
+	}
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, ErrAdjustmentReason
+	}
+	if s.audit == nil {
+		return nil, ErrAuditNotConfigured
+	}
+
+	var entry *models.AuditEntry
+	err := retryOnDeadlock(ctx, func() error {
+		var err error
+		entry, err = s.adjustBalance(ctx, adminID, userID, delta, reason)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// adjustBalance выполняет корректировку баланса в одной транзакции.
+func (s *BalanceServiceImpl) adjustBalance(ctx context.Context, adminID, userID uuid.UUID, delta decimal.Decimal, reason string) (*models.AuditEntry, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", storage.WrapInternal(err))
+	}
+	defer tx.Rollback(ctx)
+
+	if err := s.userStorage.AdjustBalanceTx(ctx, tx, userID, delta); err != nil {
+		return nil, err
+	}
+
+	entry := &models.AuditEntry{
+		UserID:  userID,
+		AdminID: &adminID,
+		Reason:  reason,
+		Kind:    models.AuditKindAdjustmentCredit,
+		Amount:  delta.Abs(),
+	}
+	if delta.IsNegative() {
+		entry.Kind = models.AuditKindAdjustmentDebit
+	}
+	if err := s.audit.Record(ctx, tx, entry); err != nil {
+		return nil, fmt.Errorf("record audit: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("commit tx: %w", storage.WrapInternal(err))
+	}
+
+	return entry, nil
+}
+
+// retryOnDeadlock выполняет транзакцию fn и повторяет её, если она прервана взаимной блокировкой.
+func retryOnDeadlock(ctx context.Context, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= withdrawAttempts || !isDeadlock(err) {
+			return err
+		}
+
+		timer := time.NewTimer(withdrawRetryPause * time.Duration(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// isDeadlock сообщает, что транзакция прервана из-за взаимной блокировки и её можно повторить.
+func isDeadlock(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "40P01" // deadlock_detected
+}
+
 // GetWithdrawals возвращает историю списаний пользователя.
 func (s *BalanceServiceImpl) GetWithdrawals(ctx context.Context, userID uuid.UUID) ([]*models.Withdrawal, error) {
 	list, err := s.withdrawalStorage.GetByUserID(ctx, userID)
@@ -88,3 +355,42 @@ func (s *BalanceServiceImpl) GetWithdrawals(ctx context.Context, userID uuid.UUI
 
 	return list, nil
 }
+
+// GetWithdrawalsBetween возвращает списания пользователя за период [from, to];
+// нулевая граница не ограничивает период.
+func (s *BalanceServiceImpl) GetWithdrawalsBetween(ctx context.Context, userID uuid.UUID, from, to time.Time) ([]*models.Withdrawal, error) {
+	return s.withdrawalStorage.GetByUserIDBetween(ctx, userID, from, to)
+}
+
+// ExportWithdrawals передаёт в fn списания пользователя за период [from, to] новые первыми
+// по одному, не загружая весь список в память; используется для выгрузки в CSV.
+func (s *BalanceServiceImpl) ExportWithdrawals(ctx context.Context, userID uuid.UUID, from, to time.Time, fn func(withdrawal *models.Withdrawal) error) error {
+	if err := s.withdrawalStorage.EachByUserIDBetween(ctx, userID, from, to, fn); err != nil {
+		return fmt.Errorf("export withdrawals: %w", err)
+	}
+	return nil
+}
+
+// GetWithdrawalsTotal возвращает сумму всех списаний пользователя.
+func (s *BalanceServiceImpl) GetWithdrawalsTotal(ctx context.Context, userID uuid.UUID) (decimal.Decimal, error) {
+	return s.withdrawalStorage.GetTotalByUser(ctx, userID)
+}
+
+// GetBalance возвращает текущий баланс, сумму списаний и всех начислений пользователя.
+func (s *BalanceServiceImpl) GetBalance(ctx context.Context, userID uuid.UUID) (*models.BalanceSummary, error) {
+	user, err := s.userStorage.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	accrued, err := s.orderStorage.GetAccruedTotal(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("get accrued total: %w", err)
+	}
+
+	return &models.BalanceSummary{
+		Current:   user.Balance,
+		Withdrawn: user.Withdrawn,
+		Accrued:   accrued,
+	}, nil
+}
@@ -0,0 +1,870 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/agamariel/gofermart/internal/models"
+	"github.com/agamariel/gofermart/internal/storage"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/shopspring/decimal"
+)
+
+func TestBalanceService_GetBalance(t *testing.T) {
+	ctx := context.Background()
+	userID := uuid.New()
+
+	t.Run("includes accrued total", func(t *testing.T) {
+		// Несколько обработанных заказов: 100 + 250.50 + 49.50
+		processed := []decimal.Decimal{
+			decimal.NewFromInt(100),
+			decimal.NewFromFloat(250.50),
+			decimal.NewFromFloat(49.50),
+		}
+
+		userStorage := &storage.MockUserStorage{
+			GetByIDFunc: func(ctx context.Context, id uuid.UUID) (*models.User, error) {
+				return &models.User{
+					ID:        id,
+					Balance:   decimal.NewFromInt(300),
+					Withdrawn: decimal.NewFromInt(100),
+				}, nil
+			},
+		}
+		orderStorage := &mockOrderStorage{
+			GetAccruedFunc: func(ctx context.Context, uid uuid.UUID) (decimal.Decimal, error) {
+				total := decimal.Zero
+				for _, a := range processed {
+					total = total.Add(a)
+				}
+				return total, nil
+			},
+		}
+
+		svc := NewBalanceService(nil, userStorage, &storage.MockWithdrawalStorage{}, orderStorage)
+		summary, err := svc.GetBalance(ctx, userID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if !summary.Accrued.Equal(decimal.NewFromInt(400)) {
+			t.Errorf("Accrued = %v, want 400", summary.Accrued)
+		}
+		if !summary.Current.Equal(decimal.NewFromInt(300)) {
+			t.Errorf("Current = %v, want 300", summary.Current)
+		}
+		if !summary.Withdrawn.Equal(decimal.NewFromInt(100)) {
+			t.Errorf("Withdrawn = %v, want 100", summary.Withdrawn)
+		}
+	})
+
+	t.Run("user not found", func(t *testing.T) {
+		svc := NewBalanceService(nil, &storage.MockUserStorage{}, &storage.MockWithdrawalStorage{}, &mockOrderStorage{})
+		if _, err := svc.GetBalance(ctx, userID); !errors.Is(err, storage.ErrUserNotFound) {
+			t.Fatalf("expected ErrUserNotFound, got %v", err)
+		}
+	})
+}
+
+func TestBalanceService_Withdraw(t *testing.T) {
+	ctx := context.Background()
+	userID := uuid.New()
+	errDB := errors.New("db error")
+
+	tests := []struct {
+		name           string
+		beginErr       error
+		exists         bool
+		existsErr      error
+		withdrawErr    error
+		createErr      error
+		commitErr      error
+		wantErr        error
+		wantCommitted  bool
+		wantRolledBack bool
+		wantCreate     bool
+		wantNoDebit    bool
+	}{
+		{
+			name:          "success commits",
+			wantCommitted: true,
+			wantCreate:    true,
+		},
+		{
+			name:           "insufficient funds rolls back",
+			withdrawErr:    storage.ErrInsufficientBalance,
+			wantErr:        storage.ErrInsufficientBalance,
+			wantRolledBack: true,
+		},
+		{
+			name:           "existing withdrawal rejected before debit",
+			exists:         true,
+			wantErr:        storage.ErrWithdrawalExists,
+			wantRolledBack: true,
+			wantNoDebit:    true,
+		},
+		{
+			name:           "existence check error",
+			existsErr:      errDB,
+			wantErr:        errDB,
+			wantRolledBack: true,
+			wantNoDebit:    true,
+		},
+		{
+			name:           "duplicate withdrawal rolls back",
+			createErr:      storage.ErrWithdrawalExists,
+			wantErr:        storage.ErrWithdrawalExists,
+			wantRolledBack: true,
+			wantCreate:     true,
+		},
+		{
+			name:           "commit error",
+			commitErr:      errDB,
+			wantErr:        errDB,
+			wantRolledBack: true,
+			wantCreate:     true,
+		},
+		{
+			name:     "begin error",
+			beginErr: errDB,
+			wantErr:  errDB,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := &fakeTx{commitErr: tt.commitErr}
+			var created, debited bool
+
+			userStorage := &storage.MockUserStorage{
+				WithdrawTxFunc: func(ctx context.Context, gotTx pgx.Tx, id uuid.UUID, amount decimal.Decimal) error {
+					debited = true
+					if gotTx != tx {
+						t.Error("WithdrawTx called outside of the service transaction")
+					}
+					return tt.withdrawErr
+				},
+			}
+			withdrawalStorage := &storage.MockWithdrawalStorage{
+				ExistsFunc: func(ctx context.Context, gotTx pgx.Tx, id uuid.UUID, orderNumber string) (bool, error) {
+					if gotTx != tx {
+						t.Error("ExistsByOrder called outside of the service transaction")
+					}
+					if id != userID || orderNumber != "2377225624" {
+						t.Errorf("ExistsByOrder(%s, %s), want user %s and order 2377225624", id, orderNumber, userID)
+					}
+					return tt.exists, tt.existsErr
+				},
+				CreateWithTxFunc: func(ctx context.Context, gotTx pgx.Tx, w *models.Withdrawal) error {
+					created = true
+					if gotTx != tx {
+						t.Error("CreateWithTx called outside of the service transaction")
+					}
+					if w.OrderNumber != "2377225624" || !w.Sum.Equal(decimal.NewFromInt(100)) {
+						t.Errorf("unexpected withdrawal: %+v", w)
+					}
+					return tt.createErr
+				},
+			}
+
+			svc := NewBalanceService(&fakeBeginner{tx: tx, err: tt.beginErr}, userStorage, withdrawalStorage, &mockOrderStorage{})
+			err := svc.Withdraw(ctx, userID, "2377225624", decimal.NewFromInt(100))
+
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Fatalf("Withdraw() error = %v, want %v", err, tt.wantErr)
+			}
+			if tx.committed != tt.wantCommitted {
+				t.Errorf("committed = %v, want %v", tx.committed, tt.wantCommitted)
+			}
+			if tx.rolledBack != tt.wantRolledBack {
+				t.Errorf("rolledBack = %v, want %v", tx.rolledBack, tt.wantRolledBack)
+			}
+			if created != tt.wantCreate {
+				t.Errorf("CreateWithTx called = %v, want %v", created, tt.wantCreate)
+			}
+			if tt.wantNoDebit && debited {
+				t.Error("WithdrawTx called although the withdrawal must be rejected first")
+			}
+		})
+	}
+}
+
+func TestBalanceService_BeginErrorIsClassified(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("db failure is internal", func(t *testing.T) {
+		svc := NewBalanceService(&fakeBeginner{err: errors.New("connection refused")}, &storage.MockUserStorage{}, &storage.MockWithdrawalStorage{}, &mockOrderStorage{})
+		err := svc.Withdraw(ctx, uuid.New(), "2377225624", decimal.NewFromInt(100))
+		if !errors.Is(err, storage.ErrStorageInternal) {
+			t.Errorf("Withdraw() error = %v, want ErrStorageInternal", err)
+		}
+	})
+
+	t.Run("cancelled request", func(t *testing.T) {
+		svc := NewBalanceService(&fakeBeginner{err: context.Canceled}, &storage.MockUserStorage{}, &storage.MockWithdrawalStorage{}, &mockOrderStorage{})
+		_, err := svc.ReverseWithdrawal(ctx, uuid.New(), "2377225624")
+		if !errors.Is(err, storage.ErrRequestCancelled) {
+			t.Errorf("ReverseWithdrawal() error = %v, want ErrRequestCancelled", err)
+		}
+	})
+}
+
+func TestBalanceService_WithdrawDuplicateKeepsBalance(t *testing.T) {
+	ctx := context.Background()
+	users := storage.NewInMemoryUserStorage()
+	withdrawals := storage.NewInMemoryWithdrawalStorage()
+	user := &models.User{ID: uuid.New(), Login: "alice", PasswordHash: "hash"}
+	if err := users.Create(ctx, user); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := users.UpdateBalance(ctx, user.ID, decimal.NewFromInt(500)); err != nil {
+		t.Fatalf("UpdateBalance() error = %v", err)
+	}
+	svc := NewBalanceService(storage.NewMemoryTxBeginner(), users, withdrawals, &mockOrderStorage{})
+
+	if err := svc.Withdraw(ctx, user.ID, "2377225624", decimal.NewFromInt(100)); err != nil {
+		t.Fatalf("first Withdraw() error = %v", err)
+	}
+	if err := svc.Withdraw(ctx, user.ID, "2377225624", decimal.NewFromInt(50)); !errors.Is(err, storage.ErrWithdrawalExists) {
+		t.Fatalf("second Withdraw() error = %v, want ErrWithdrawalExists", err)
+	}
+
+	got, err := users.GetByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetByID() error = %v", err)
+	}
+	if !got.Balance.Equal(decimal.NewFromInt(400)) || !got.Withdrawn.Equal(decimal.NewFromInt(100)) {
+		t.Errorf("balance = %s, withdrawn = %s; want 400 and 100", got.Balance, got.Withdrawn)
+	}
+	list, err := withdrawals.GetByUserID(ctx, user.ID)
+	if err != nil || len(list) != 1 {
+		t.Errorf("withdrawals = %d (%v), want 1", len(list), err)
+	}
+}
+
+func TestBalanceService_WithdrawMax(t *testing.T) {
+	maxWithdrawal := decimal.NewFromInt(1000)
+
+	tests := []struct {
+		name    string
+		sum     decimal.Decimal
+		wantErr error
+	}{
+		{name: "below cap", sum: decimal.RequireFromString("999.99")},
+		{name: "equal to cap", sum: maxWithdrawal},
+		{name: "above cap", sum: decimal.RequireFromString("1000.01"), wantErr: ErrWithdrawalTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			beginner := &txPerBegin{}
+			svc := NewBalanceService(beginner, &storage.MockUserStorage{}, &storage.MockWithdrawalStorage{}, &mockOrderStorage{},
+				WithMaxWithdrawal(maxWithdrawal))
+
+			err := svc.Withdraw(context.Background(), uuid.New(), "2377225624", tt.sum)
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Fatalf("Withdraw() error = %v, want %v", err, tt.wantErr)
+			}
+			// Слишком крупное списание отклоняется до открытия транзакции
+			wantBegins := 1
+			if tt.wantErr != nil {
+				wantBegins = 0
+			}
+			if len(beginner.txs) != wantBegins {
+				t.Errorf("transactions = %d, want %d", len(beginner.txs), wantBegins)
+			}
+		})
+	}
+}
+
+func TestBalanceService_WithdrawStrictOrder(t *testing.T) {
+	userID := uuid.New()
+	errDB := errors.New("db is down")
+
+	tests := []struct {
+		name      string
+		strict    bool
+		lookupErr error
+		wantErr   error
+		wantTx    bool
+	}{
+		{name: "not strict accepts unknown order", strict: false, lookupErr: storage.ErrOrderNotFound, wantTx: true},
+		{name: "strict accepts own order", strict: true, wantTx: true},
+		{name: "strict rejects unknown or foreign order", strict: true, lookupErr: storage.ErrOrderNotFound, wantErr: ErrInvalidWithdrawalNumber},
+		{name: "strict lookup error", strict: true, lookupErr: errDB, wantErr: errDB},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lookups := 0
+			orderStorage := &mockOrderStorage{
+				GetForUserFunc: func(ctx context.Context, number string, gotUserID uuid.UUID) (*models.Order, error) {
+					lookups++
+					if number != "2377225624" || gotUserID != userID {
+						t.Errorf("GetByNumberForUser(%q, %v), want 2377225624 of %v", number, gotUserID, userID)
+					}
+					if tt.lookupErr != nil {
+						return nil, tt.lookupErr
+					}
+					return &models.Order{UserID: userID, Number: number}, nil
+				},
+			}
+			var opts []BalanceServiceOption
+			if tt.strict {
+				opts = append(opts, WithStrictWithdrawalOrder())
+			}
+			beginner := &txPerBegin{}
+			svc := NewBalanceService(beginner, &storage.MockUserStorage{}, &storage.MockWithdrawalStorage{}, orderStorage, opts...)
+
+			err := svc.Withdraw(context.Background(), userID, "2377225624", decimal.NewFromInt(100))
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Fatalf("Withdraw() error = %v, want %v", err, tt.wantErr)
+			}
+			if !tt.strict && lookups != 0 {
+				t.Errorf("order lookups = %d without strict mode, want 0", lookups)
+			}
+			if got := len(beginner.txs) > 0; got != tt.wantTx {
+				t.Errorf("transaction opened = %v, want %v", got, tt.wantTx)
+			}
+		})
+	}
+}
+
+func TestBalanceService_WithdrawAudit(t *testing.T) {
+	userID := uuid.New()
+	sum := decimal.NewFromInt(30)
+
+	t.Run("entry is recorded in the withdrawal transaction", func(t *testing.T) {
+		beginner := &txPerBegin{}
+		var recorded *models.AuditEntry
+		var recordTx pgx.Tx
+		audit := &storage.MockAuditStorage{
+			RecordFunc: func(ctx context.Context, tx pgx.Tx, entry *models.AuditEntry) error {
+				recorded, recordTx = entry, tx
+				return nil
+			},
+		}
+		svc := NewBalanceService(beginner, &storage.MockUserStorage{}, &storage.MockWithdrawalStorage{}, &mockOrderStorage{},
+			WithWithdrawalAudit(audit))
+
+		if err := svc.Withdraw(context.Background(), userID, "2377225624", sum); err != nil {
+			t.Fatalf("Withdraw() error = %v", err)
+		}
+		if recorded == nil {
+			t.Fatal("audit entry was not recorded")
+		}
+		if recordTx != beginner.txs[0] || !beginner.txs[0].committed {
+			t.Error("audit entry must be recorded in the committed withdrawal transaction")
+		}
+		if recorded.UserID != userID || recorded.OrderNumber != "2377225624" ||
+			recorded.Kind != models.AuditKindWithdrawal || !recorded.Amount.Equal(sum) {
+			t.Errorf("entry = %+v, want withdrawal of %s by %s", recorded, sum, userID)
+		}
+	})
+
+	t.Run("record failure rolls back the withdrawal", func(t *testing.T) {
+		beginner := &txPerBegin{}
+		recordErr := errors.New("audit unavailable")
+		audit := &storage.MockAuditStorage{
+			RecordFunc: func(ctx context.Context, tx pgx.Tx, entry *models.AuditEntry) error {
+				return recordErr
+			},
+		}
+		svc := NewBalanceService(beginner, &storage.MockUserStorage{}, &storage.MockWithdrawalStorage{}, &mockOrderStorage{},
+			WithWithdrawalAudit(audit))
+
+		if err := svc.Withdraw(context.Background(), userID, "2377225624", sum); !errors.Is(err, recordErr) {
+			t.Fatalf("Withdraw() error = %v, want %v", err, recordErr)
+		}
+		if tx := beginner.txs[0]; tx.committed || !tx.rolledBack {
+			t.Errorf("committed = %v, rolled back = %v; want rollback only", tx.committed, tx.rolledBack)
+		}
+	})
+}
+
+func TestBalanceService_WithdrawLuhnCheck(t *testing.T) {
+	ctx := context.Background()
+	sum := decimal.NewFromInt(10)
+
+	tests := []struct {
+		name       string
+		number     string
+		wantStrict error
+		wantBypass error
+	}{
+		{name: "luhn-valid number", number: "2377225624"},
+		{name: "non-luhn number", number: "12345", wantStrict: ErrInvalidWithdrawalNumber},
+		{name: "empty number", number: "", wantStrict: ErrInvalidWithdrawalNumber, wantBypass: ErrInvalidWithdrawalNumber},
+		{name: "non-digit number", number: "order-1", wantStrict: ErrInvalidWithdrawalNumber, wantBypass: ErrInvalidWithdrawalNumber},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strict := NewBalanceService(&txPerBegin{}, &storage.MockUserStorage{}, &storage.MockWithdrawalStorage{}, &mockOrderStorage{})
+			if err := strict.Withdraw(ctx, uuid.New(), tt.number, sum); !errors.Is(err, tt.wantStrict) {
+				t.Errorf("Withdraw() with Luhn check error = %v, want %v", err, tt.wantStrict)
+			}
+
+			bypass := NewBalanceService(&txPerBegin{}, &storage.MockUserStorage{}, &storage.MockWithdrawalStorage{}, &mockOrderStorage{},
+				WithoutWithdrawalLuhnCheck())
+			if err := bypass.Withdraw(ctx, uuid.New(), tt.number, sum); !errors.Is(err, tt.wantBypass) {
+				t.Errorf("Withdraw() without Luhn check error = %v, want %v", err, tt.wantBypass)
+			}
+		})
+	}
+}
+
+func TestBalanceService_AdjustBalance(t *testing.T) {
+	ctx := context.Background()
+	adminID := uuid.New()
+
+	// newService создаёт сервис на хранилищах в памяти и пользователя с балансом 100.
+	newService := func(t *testing.T, opts ...BalanceServiceOption) (*BalanceServiceImpl, *storage.InMemoryUserStorage, *storage.InMemoryAuditStorage, uuid.UUID) {
+		t.Helper()
+		users := storage.NewInMemoryUserStorage()
+		audit := storage.NewInMemoryAuditStorage(users)
+		user := &models.User{ID: uuid.New(), Login: "alice", PasswordHash: "hash"}
+		if err := users.Create(ctx, user); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+		if err := users.UpdateBalance(ctx, user.ID, decimal.NewFromInt(100)); err != nil {
+			t.Fatalf("UpdateBalance() error = %v", err)
+		}
+		opts = append([]BalanceServiceOption{WithWithdrawalAudit(audit)}, opts...)
+		svc := NewBalanceService(storage.NewMemoryTxBeginner(), users, storage.NewInMemoryWithdrawalStorage(), &mockOrderStorage{}, opts...)
+		return svc, users, audit, user.ID
+	}
+
+	tests := []struct {
+		name        string
+		delta       string
+		wantKind    models.AuditKind
+		wantBalance string
+	}{
+		{name: "positive credits the balance", delta: "25.50", wantKind: models.AuditKindAdjustmentCredit, wantBalance: "125.50"},
+		{name: "negative debits the balance", delta: "-40", wantKind: models.AuditKindAdjustmentDebit, wantBalance: "60"},
+		{name: "negative down to zero", delta: "-100", wantKind: models.AuditKindAdjustmentDebit, wantBalance: "0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, users, audit, userID := newService(t)
+			delta := decimal.RequireFromString(tt.delta)
+
+			entry, err := svc.AdjustBalance(ctx, adminID, userID, delta, "  manual correction ")
+			if err != nil {
+				t.Fatalf("AdjustBalance() error = %v", err)
+			}
+			if entry.Kind != tt.wantKind || !entry.BalanceDelta().Equal(delta) || entry.Reason != "manual correction" ||
+				entry.AdminID == nil || *entry.AdminID != adminID {
+				t.Errorf("entry = %+v", entry)
+			}
+			wantBalance := decimal.RequireFromString(tt.wantBalance)
+			if !entry.BalanceBefore.Equal(decimal.NewFromInt(100)) || !entry.BalanceAfter.Equal(wantBalance) {
+				t.Errorf("entry balances = %s -> %s, want 100 -> %s", entry.BalanceBefore, entry.BalanceAfter, wantBalance)
+			}
+
+			user, _ := users.GetByID(ctx, userID)
+			if !user.Balance.Equal(wantBalance) || !user.Withdrawn.IsZero() {
+				t.Errorf("balance = %s, withdrawn = %s; want %s and 0", user.Balance, user.Withdrawn, wantBalance)
+			}
+			if entries, _ := audit.GetByUserID(ctx, userID); len(entries) != 1 {
+				t.Errorf("audit entries = %d, want 1", len(entries))
+			}
+		})
+	}
+
+	t.Run("below zero is rejected without changes", func(t *testing.T) {
+		svc, users, audit, userID := newService(t)
+
+		_, err := svc.AdjustBalance(ctx, adminID, userID, decimal.RequireFromString("-100.01"), "chargeback")
+		var balanceErr *storage.InsufficientBalanceError
+		if !errors.As(err, &balanceErr) || !balanceErr.Available.Equal(decimal.NewFromInt(100)) {
+			t.Fatalf("AdjustBalance() error = %v, want InsufficientBalanceError with 100 available", err)
+		}
+
+		user, _ := users.GetByID(ctx, userID)
+		if !user.Balance.Equal(decimal.NewFromInt(100)) {
+			t.Errorf("balance = %s, want 100", user.Balance)
+		}
+		if entries, _ := audit.GetByUserID(ctx, userID); len(entries) != 0 {
+			t.Errorf("audit entries = %d, want 0", len(entries))
+		}
+	})
+
+	t.Run("audit failure rolls back the adjustment", func(t *testing.T) {
+		recordErr := errors.New("audit unavailable")
+		svc, users, _, userID := newService(t, WithWithdrawalAudit(&storage.MockAuditStorage{
+			RecordFunc: func(ctx context.Context, tx pgx.Tx, entry *models.AuditEntry) error {
+				return recordErr
+			},
+		}))
+
+		if _, err := svc.AdjustBalance(ctx, adminID, userID, decimal.NewFromInt(10), "bonus"); !errors.Is(err, recordErr) {
+			t.Fatalf("AdjustBalance() error = %v, want %v", err, recordErr)
+		}
+		got, _ := users.GetByID(ctx, userID)
+		if !got.Balance.Equal(decimal.NewFromInt(100)) {
+			t.Errorf("balance = %s, want 100", got.Balance)
+		}
+	})
+
+	invalid := []struct {
+		name    string
+		delta   string
+		reason  string
+		noAudit bool
+		wantErr error
+	}{
+		{name: "zero delta", delta: "0", reason: "noop", wantErr: ErrInvalidAdjustment},
+		{name: "fraction of a cent", delta: "0.001", reason: "rounding", wantErr: ErrInvalidAdjustment},
+		{name: "blank reason", delta: "10", reason: "   ", wantErr: ErrAdjustmentReason},
+		{name: "audit not configured", delta: "10", reason: "bonus", noAudit: true, wantErr: ErrAuditNotConfigured},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, users, _, userID := newService(t)
+			if tt.noAudit {
+				svc.audit = nil
+			}
+
+			if _, err := svc.AdjustBalance(ctx, adminID, userID, decimal.RequireFromString(tt.delta), tt.reason); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("AdjustBalance() error = %v, want %v", err, tt.wantErr)
+			}
+			user, _ := users.GetByID(ctx, userID)
+			if !user.Balance.Equal(decimal.NewFromInt(100)) {
+				t.Errorf("balance = %s, want 100", user.Balance)
+			}
+		})
+	}
+}
+
+// txPerBegin выдаёт новую фейковую транзакцию на каждый Begin, как пул соединений.
+type txPerBegin struct {
+	mu  sync.Mutex
+	txs []*fakeTx
+}
+
+func (b *txPerBegin) Begin(ctx context.Context) (pgx.Tx, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	tx := &fakeTx{}
+	b.txs = append(b.txs, tx)
+	return tx, nil
+}
+
+func deadlockError() error {
+	return fmt.Errorf("failed to check balance: %w", &pgconn.PgError{Code: "40P01", Message: "deadlock detected"})
+}
+
+func TestBalanceService_WithdrawRetriesOnDeadlock(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("concurrent withdrawals recover from deadlocks", func(t *testing.T) {
+		const racers = 8
+
+		// Первая попытка каждого списания попадает в deadlock, как при встречных блокировках
+		var calls atomic.Int32
+		userStorage := &storage.MockUserStorage{
+			WithdrawTxFunc: func(ctx context.Context, tx pgx.Tx, id uuid.UUID, amount decimal.Decimal) error {
+				if calls.Add(1) <= racers {
+					return deadlockError()
+				}
+				return nil
+			},
+		}
+		beginner := &txPerBegin{}
+		svc := NewBalanceService(beginner, userStorage, &storage.MockWithdrawalStorage{}, &mockOrderStorage{})
+
+		var wg sync.WaitGroup
+		errs := make(chan error, racers)
+		for i := 0; i < racers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- svc.Withdraw(ctx, uuid.New(), "2377225624", decimal.NewFromInt(10))
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			if err != nil {
+				t.Errorf("Withdraw() error = %v", err)
+			}
+		}
+
+		var committed, rolledBack int
+		for _, tx := range beginner.txs {
+			if tx.committed {
+				committed++
+			}
+			if tx.rolledBack {
+				rolledBack++
+			}
+		}
+		if committed != racers || rolledBack != racers {
+			t.Errorf("committed = %d, rolled back = %d; want %d each", committed, rolledBack, racers)
+		}
+	})
+
+	t.Run("gives up after bounded attempts", func(t *testing.T) {
+		var calls int
+		userStorage := &storage.MockUserStorage{
+			WithdrawTxFunc: func(ctx context.Context, tx pgx.Tx, id uuid.UUID, amount decimal.Decimal) error {
+				calls++
+				return deadlockError()
+			},
+		}
+		svc := NewBalanceService(&txPerBegin{}, userStorage, &storage.MockWithdrawalStorage{}, &mockOrderStorage{})
+
+		err := svc.Withdraw(ctx, uuid.New(), "2377225624", decimal.NewFromInt(10))
+		if !isDeadlock(err) {
+			t.Fatalf("Withdraw() error = %v, want deadlock", err)
+		}
+		if calls != withdrawAttempts {
+			t.Errorf("attempts = %d, want %d", calls, withdrawAttempts)
+		}
+	})
+
+	t.Run("other errors are not retried", func(t *testing.T) {
+		var calls int
+		userStorage := &storage.MockUserStorage{
+			WithdrawTxFunc: func(ctx context.Context, tx pgx.Tx, id uuid.UUID, amount decimal.Decimal) error {
+				calls++
+				return storage.ErrInsufficientBalance
+			},
+		}
+		svc := NewBalanceService(&txPerBegin{}, userStorage, &storage.MockWithdrawalStorage{}, &mockOrderStorage{})
+
+		if err := svc.Withdraw(ctx, uuid.New(), "2377225624", decimal.NewFromInt(10)); !errors.Is(err, storage.ErrInsufficientBalance) {
+			t.Fatalf("Withdraw() error = %v, want %v", err, storage.ErrInsufficientBalance)
+		}
+		if calls != 1 {
+			t.Errorf("attempts = %d, want 1", calls)
+		}
+	})
+}
+
+func TestBalanceService_ReverseWithdrawal(t *testing.T) {
+	ctx := context.Background()
+	userID := uuid.New()
+	errDB := errors.New("db error")
+	sum := decimal.RequireFromString("150.25")
+	lockUser := func(ctx context.Context, tx pgx.Tx, id uuid.UUID) (*models.User, error) {
+		return &models.User{ID: id}, nil
+	}
+
+	tests := []struct {
+		name           string
+		lockErr        error
+		deleteErr      error
+		refundErr      error
+		commitErr      error
+		wantErr        error
+		wantRefund     bool
+		wantCommitted  bool
+		wantRolledBack bool
+	}{
+		{
+			name:          "deletes withdrawal and refunds balance",
+			wantRefund:    true,
+			wantCommitted: true,
+		},
+		{
+			name:           "unknown user",
+			lockErr:        storage.ErrUserNotFound,
+			wantErr:        storage.ErrUserNotFound,
+			wantRolledBack: true,
+		},
+		{
+			name:           "already reversed",
+			deleteErr:      storage.ErrWithdrawalNotFound,
+			wantErr:        storage.ErrWithdrawalNotFound,
+			wantRolledBack: true,
+		},
+		{
+			name:           "refund error rolls back deletion",
+			refundErr:      storage.ErrUserNotFound,
+			wantErr:        storage.ErrUserNotFound,
+			wantRefund:     true,
+			wantRolledBack: true,
+		},
+		{
+			name:           "commit error",
+			commitErr:      errDB,
+			wantErr:        errDB,
+			wantRefund:     true,
+			wantRolledBack: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := &fakeTx{commitErr: tt.commitErr}
+			var refunded, locked bool
+
+			withdrawalStorage := &storage.MockWithdrawalStorage{
+				DeleteFunc: func(ctx context.Context, gotTx pgx.Tx, uid uuid.UUID, number string) (*models.Withdrawal, error) {
+					// Строка пользователя блокируется раньше строки списания, как при списании
+					if !locked {
+						t.Error("DeleteByOrder called before the user row was locked")
+					}
+					if gotTx != tx {
+						t.Error("DeleteByOrder called outside of the service transaction")
+					}
+					if uid != userID || number != "2377225624" {
+						t.Errorf("DeleteByOrder(%s, %q), want (%s, %q)", uid, number, userID, "2377225624")
+					}
+					if tt.deleteErr != nil {
+						return nil, tt.deleteErr
+					}
+					return &models.Withdrawal{UserID: uid, OrderNumber: number, Sum: sum}, nil
+				},
+			}
+			userStorage := &storage.MockUserStorage{
+				GetByIDForUpdateTxFunc: func(ctx context.Context, gotTx pgx.Tx, id uuid.UUID) (*models.User, error) {
+					if gotTx != tx {
+						t.Error("GetByIDForUpdateTx called outside of the service transaction")
+					}
+					if tt.lockErr != nil {
+						return nil, tt.lockErr
+					}
+					locked = true
+					return &models.User{ID: id}, nil
+				},
+				RefundTxFunc: func(ctx context.Context, gotTx pgx.Tx, id uuid.UUID, amount decimal.Decimal) error {
+					refunded = true
+					if gotTx != tx {
+						t.Error("RefundTx called outside of the service transaction")
+					}
+					if id != userID || !amount.Equal(sum) {
+						t.Errorf("RefundTx(%s, %s), want (%s, %s)", id, amount, userID, sum)
+					}
+					return tt.refundErr
+				},
+			}
+
+			svc := NewBalanceService(&fakeBeginner{tx: tx}, userStorage, withdrawalStorage, &mockOrderStorage{})
+			reversed, err := svc.ReverseWithdrawal(ctx, userID, " 2377225624 ")
+
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Fatalf("ReverseWithdrawal() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && (reversed == nil || !reversed.Sum.Equal(sum)) {
+				t.Errorf("reversed = %+v, want sum %s", reversed, sum)
+			}
+			if refunded != tt.wantRefund {
+				t.Errorf("RefundTx called = %v, want %v", refunded, tt.wantRefund)
+			}
+			if tx.committed != tt.wantCommitted {
+				t.Errorf("committed = %v, want %v", tx.committed, tt.wantCommitted)
+			}
+			if tx.rolledBack != tt.wantRolledBack {
+				t.Errorf("rolledBack = %v, want %v", tx.rolledBack, tt.wantRolledBack)
+			}
+		})
+	}
+
+	t.Run("empty order number", func(t *testing.T) {
+		beginner := &txPerBegin{}
+		svc := NewBalanceService(beginner, &storage.MockUserStorage{}, &storage.MockWithdrawalStorage{}, &mockOrderStorage{})
+		if _, err := svc.ReverseWithdrawal(ctx, userID, "  "); !errors.Is(err, ErrInvalidWithdrawalNumber) {
+			t.Fatalf("ReverseWithdrawal() error = %v, want ErrInvalidWithdrawalNumber", err)
+		}
+		if len(beginner.txs) != 0 {
+			t.Errorf("transactions = %d, want 0", len(beginner.txs))
+		}
+	})
+
+	t.Run("refund is recorded in audit", func(t *testing.T) {
+		beginner := &txPerBegin{}
+		var recorded *models.AuditEntry
+		var recordTx pgx.Tx
+		audit := &storage.MockAuditStorage{
+			RecordFunc: func(ctx context.Context, tx pgx.Tx, entry *models.AuditEntry) error {
+				recorded, recordTx = entry, tx
+				return nil
+			},
+		}
+		withdrawalStorage := &storage.MockWithdrawalStorage{
+			DeleteFunc: func(ctx context.Context, tx pgx.Tx, uid uuid.UUID, number string) (*models.Withdrawal, error) {
+				return &models.Withdrawal{UserID: uid, OrderNumber: number, Sum: sum}, nil
+			},
+		}
+		svc := NewBalanceService(beginner, &storage.MockUserStorage{GetByIDForUpdateTxFunc: lockUser}, withdrawalStorage, &mockOrderStorage{},
+			WithWithdrawalAudit(audit))
+
+		if _, err := svc.ReverseWithdrawal(ctx, userID, "2377225624"); err != nil {
+			t.Fatalf("ReverseWithdrawal() error = %v", err)
+		}
+		if recorded == nil {
+			t.Fatal("audit entry was not recorded")
+		}
+		if recordTx != beginner.txs[0] || !beginner.txs[0].committed {
+			t.Error("audit entry must be recorded in the committed reversal transaction")
+		}
+		if recorded.UserID != userID || recorded.OrderNumber != "2377225624" ||
+			recorded.Kind != models.AuditKindRefund || !recorded.Amount.Equal(sum) {
+			t.Errorf("entry = %+v, want refund of %s to %s", recorded, sum, userID)
+		}
+	})
+
+	t.Run("audit failure rolls back the reversal", func(t *testing.T) {
+		beginner := &txPerBegin{}
+		recordErr := errors.New("audit unavailable")
+		audit := &storage.MockAuditStorage{
+			RecordFunc: func(ctx context.Context, tx pgx.Tx, entry *models.AuditEntry) error {
+				return recordErr
+			},
+		}
+		withdrawalStorage := &storage.MockWithdrawalStorage{
+			DeleteFunc: func(ctx context.Context, tx pgx.Tx, uid uuid.UUID, number string) (*models.Withdrawal, error) {
+				return &models.Withdrawal{UserID: uid, OrderNumber: number, Sum: sum}, nil
+			},
+		}
+		svc := NewBalanceService(beginner, &storage.MockUserStorage{GetByIDForUpdateTxFunc: lockUser}, withdrawalStorage, &mockOrderStorage{},
+			WithWithdrawalAudit(audit))
+
+		if _, err := svc.ReverseWithdrawal(ctx, userID, "2377225624"); !errors.Is(err, recordErr) {
+			t.Fatalf("ReverseWithdrawal() error = %v, want %v", err, recordErr)
+		}
+		if tx := beginner.txs[0]; tx.committed || !tx.rolledBack {
+			t.Errorf("committed = %v, rolled back = %v; want rollback only", tx.committed, tx.rolledBack)
+		}
+	})
+
+	t.Run("retries on deadlock", func(t *testing.T) {
+		var calls int
+		userStorage := &storage.MockUserStorage{
+			GetByIDForUpdateTxFunc: lockUser,
+			RefundTxFunc: func(ctx context.Context, tx pgx.Tx, id uuid.UUID, amount decimal.Decimal) error {
+				calls++
+				if calls == 1 {
+					return deadlockError()
+				}
+				return nil
+			},
+		}
+		withdrawalStorage := &storage.MockWithdrawalStorage{
+			DeleteFunc: func(ctx context.Context, tx pgx.Tx, uid uuid.UUID, number string) (*models.Withdrawal, error) {
+				return &models.Withdrawal{UserID: uid, OrderNumber: number, Sum: sum}, nil
+			},
+		}
+		beginner := &txPerBegin{}
+		svc := NewBalanceService(beginner, userStorage, withdrawalStorage, &mockOrderStorage{})
+
+		if _, err := svc.ReverseWithdrawal(ctx, userID, "2377225624"); err != nil {
+			t.Fatalf("ReverseWithdrawal() error = %v", err)
+		}
+		if len(beginner.txs) != 2 || !beginner.txs[0].rolledBack || !beginner.txs[1].committed {
+			t.Errorf("expected a rolled back attempt followed by a committed one, got %d transactions", len(beginner.txs))
+		}
+	})
+}
@@ -0,0 +1,52 @@
+package services
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// fakeTx фиксирует завершение транзакции; методы pgx.Tx, не переопределённые здесь, не используются.
+type fakeTx struct {
+	pgx.Tx
+	execs      int
+	commitErr  error
+	committed  bool
+	rolledBack bool
+}
+
+func (tx *fakeTx) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	tx.execs++
+	return pgconn.NewCommandTag("UPDATE 1"), nil
+}
+
+func (tx *fakeTx) Commit(ctx context.Context) error {
+	if tx.commitErr != nil {
+		return tx.commitErr
+	}
+	tx.committed = true
+	return nil
+}
+
+// Rollback после успешного Commit ничего не откатывает, как и в pgx.
+func (tx *fakeTx) Rollback(ctx context.Context) error {
+	if tx.committed {
+		return pgx.ErrTxClosed
+	}
+	tx.rolledBack = true
+	return nil
+}
+
+// fakeBeginner выдаёт заранее подготовленную транзакцию или ошибку.
+type fakeBeginner struct {
+	tx  *fakeTx
+	err error
+}
+
+func (b *fakeBeginner) Begin(ctx context.Context) (pgx.Tx, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	return b.tx, nil
+}
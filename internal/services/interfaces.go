@@ -2,6 +2,7 @@ package services
 
 import (
 	"context"
+	"time"
 
 	"github.com/agamariel/gofermart/internal/models"
 	"github.com/google/uuid"
@@ -9,13 +10,37 @@ import (
 	"github.com/shopspring/decimal"
 )
 
+// TxBeginner открывает транзакцию БД; реализуется *pgxpool.Pool.
+// Сервисы зависят от интерфейса, чтобы в тестах можно было подставить фейковую транзакцию.
+type TxBeginner interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// Pinger проверяет доступность хранилища; используется проверкой готовности.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
 // OrderStorage определяет интерфейс для работы с заказами.
 type OrderStorage interface {
 	Create(ctx context.Context, order *models.Order) error
+	CreateBatch(ctx context.Context, orders []*models.Order) ([]bool, error)
+	OrderOwner(ctx context.Context, number string) (uuid.UUID, bool, error)
 	GetByNumber(ctx context.Context, number string) (*models.Order, error)
+	GetByNumberForUser(ctx context.Context, number string, userID uuid.UUID) (*models.Order, error)
 	GetByUserID(ctx context.Context, userID uuid.UUID) ([]*models.Order, error)
+	GetByUserIDSorted(ctx context.Context, userID uuid.UUID, asc bool) ([]*models.Order, error)
+	GetByUserIDAfter(ctx context.Context, userID uuid.UUID, afterUploadedAt time.Time, afterID uuid.UUID, limit int) ([]*models.Order, error)
+	EachByUserID(ctx context.Context, userID uuid.UUID, fn func(order *models.Order) error) error
+	SearchByNumberPrefix(ctx context.Context, userID uuid.UUID, prefix string, limit int) ([]*models.Order, error)
 	UpdateStatus(ctx context.Context, number string, status models.OrderStatus, accrual *decimal.Decimal) error
+	ApplyAccrualTx(ctx context.Context, tx pgx.Tx, number string, accrual decimal.Decimal) (bool, error)
 	GetPendingOrders(ctx context.Context) ([]*models.Order, error)
+	GetAccruedTotal(ctx context.Context, userID uuid.UUID) (decimal.Decimal, error)
+	CountByUser(ctx context.Context, userID uuid.UUID) (int, error)
+	GetStuckProcessing(ctx context.Context, olderThan time.Duration) ([]*models.Order, error)
+	ResetToNew(ctx context.Context, number string) error
+	ResetToNewIfInvalid(ctx context.Context, number string, userID uuid.UUID) (bool, error)
 }
 
 // UserStorage определяет интерфейс для работы с пользователями.
@@ -23,14 +48,42 @@ type UserStorage interface {
 	Create(ctx context.Context, user *models.User) error
 	GetByLogin(ctx context.Context, login string) (*models.User, error)
 	GetByID(ctx context.Context, id uuid.UUID) (*models.User, error)
+	GetByIDs(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]*models.User, error)
+	ListIDs(ctx context.Context) ([]uuid.UUID, error)
+	GetByIDForUpdateTx(ctx context.Context, tx pgx.Tx, id uuid.UUID) (*models.User, error)
 	UpdateBalance(ctx context.Context, id uuid.UUID, amount decimal.Decimal) error
 	Withdraw(ctx context.Context, id uuid.UUID, amount decimal.Decimal) error
 	WithdrawTx(ctx context.Context, tx pgx.Tx, id uuid.UUID, amount decimal.Decimal) error
+	RefundTx(ctx context.Context, tx pgx.Tx, id uuid.UUID, amount decimal.Decimal) error
+	CreditTx(ctx context.Context, tx pgx.Tx, id uuid.UUID, amount decimal.Decimal) error
+	AdjustBalanceTx(ctx context.Context, tx pgx.Tx, id uuid.UUID, delta decimal.Decimal) error
+	UpdatePasswordHash(ctx context.Context, id uuid.UUID, hash string) error
+	Deactivate(ctx context.Context, id uuid.UUID) error
+	RegisterLoginFailure(ctx context.Context, id uuid.UUID, maxAttempts int, lockFor time.Duration) (*time.Time, error)
+	ResetLoginFailures(ctx context.Context, id uuid.UUID) error
 }
 
 // WithdrawalStorage определяет интерфейс для работы со списаниями.
 type WithdrawalStorage interface {
 	Create(ctx context.Context, withdrawal *models.Withdrawal) error
 	CreateWithTx(ctx context.Context, tx pgx.Tx, withdrawal *models.Withdrawal) error
+	ExistsByOrder(ctx context.Context, tx pgx.Tx, userID uuid.UUID, orderNumber string) (bool, error)
 	GetByUserID(ctx context.Context, userID uuid.UUID) ([]*models.Withdrawal, error)
+	GetByUserIDBetween(ctx context.Context, userID uuid.UUID, from, to time.Time) ([]*models.Withdrawal, error)
+	EachByUserIDBetween(ctx context.Context, userID uuid.UUID, from, to time.Time, fn func(withdrawal *models.Withdrawal) error) error
+	DeleteByOrder(ctx context.Context, tx pgx.Tx, userID uuid.UUID, orderNumber string) (*models.Withdrawal, error)
+	GetTotalByUser(ctx context.Context, userID uuid.UUID) (decimal.Decimal, error)
+}
+
+// AuditStorage ведёт журнал изменений баланса. Record вызывается в той же транзакции,
+// что и изменение баланса, после него.
+type AuditStorage interface {
+	Record(ctx context.Context, tx pgx.Tx, entry *models.AuditEntry) error
+}
+
+// AuditLedger - журнал аудита, из которого можно получить сумму ручных корректировок
+// баланса; нужен сверке балансов.
+type AuditLedger interface {
+	AuditStorage
+	GetManualAdjustmentTotal(ctx context.Context, userID uuid.UUID) (decimal.Decimal, error)
 }
@@ -0,0 +1,42 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrInvalidCursor - курсор страницы повреждён или получен не от сервиса.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// orderCursor - позиция последнего заказа на странице.
+type orderCursor struct {
+	UploadedAt time.Time `json:"t"`
+	ID         uuid.UUID `json:"id"`
+}
+
+// encodeOrderCursor упаковывает позицию в непрозрачную для клиента строку.
+func encodeOrderCursor(c orderCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeOrderCursor разбирает курсор, полученный от клиента.
+func decodeOrderCursor(s string) (orderCursor, error) {
+	var c orderCursor
+
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+	if c.UploadedAt.IsZero() || c.ID == uuid.Nil {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
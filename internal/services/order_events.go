@@ -0,0 +1,73 @@
+package services
+
+import (
+	"sync"
+
+	"github.com/agamariel/gofermart/internal/models"
+	"github.com/google/uuid"
+)
+
+// orderEventBuffer - размер буфера канала подписчика. Если клиент не успевает
+// читать события, новые события для него отбрасываются, а не блокируют воркер.
+const orderEventBuffer = 16
+
+// OrderEventPublisher публикует изменения статусов заказов.
+type OrderEventPublisher interface {
+	Publish(event models.OrderEvent)
+}
+
+// OrderEventSubscriber выдаёт поток изменений статусов заказов пользователя.
+// Возвращаемую функцию отписки нужно вызвать, когда события больше не нужны.
+type OrderEventSubscriber interface {
+	Subscribe(userID uuid.UUID) (<-chan models.OrderEvent, func())
+}
+
+// OrderEventBroker - in-memory pub/sub изменений статусов заказов с фильтрацией по пользователю.
+type OrderEventBroker struct {
+	mu   sync.RWMutex
+	subs map[uuid.UUID]map[chan models.OrderEvent]struct{}
+}
+
+// NewOrderEventBroker создаёт брокер событий заказов.
+func NewOrderEventBroker() *OrderEventBroker {
+	return &OrderEventBroker{subs: make(map[uuid.UUID]map[chan models.OrderEvent]struct{})}
+}
+
+// Subscribe подписывает на события заказов пользователя userID.
+func (b *OrderEventBroker) Subscribe(userID uuid.UUID) (<-chan models.OrderEvent, func()) {
+	ch := make(chan models.OrderEvent, orderEventBuffer)
+
+	b.mu.Lock()
+	if b.subs[userID] == nil {
+		b.subs[userID] = make(map[chan models.OrderEvent]struct{})
+	}
+	b.subs[userID][ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			delete(b.subs[userID], ch)
+			if len(b.subs[userID]) == 0 {
+				delete(b.subs, userID)
+			}
+			close(ch)
+		})
+	}
+	return ch, unsubscribe
+}
+
+// Publish рассылает событие подписчикам владельца заказа, не дожидаясь медленных читателей.
+func (b *OrderEventBroker) Publish(event models.OrderEvent) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for ch := range b.subs[event.UserID] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/agamariel/gofermart/internal/models"
+	"github.com/google/uuid"
+)
+
+func TestOrderEventBroker_FiltersByUser(t *testing.T) {
+	broker := NewOrderEventBroker()
+	alice, bob := uuid.New(), uuid.New()
+
+	aliceEvents, unsubscribe := broker.Subscribe(alice)
+	defer unsubscribe()
+
+	broker.Publish(models.OrderEvent{UserID: bob, Number: "1"})
+	broker.Publish(models.OrderEvent{UserID: alice, Number: "2"})
+
+	select {
+	case event := <-aliceEvents:
+		if event.Number != "2" {
+			t.Errorf("received order %s, want 2", event.Number)
+		}
+	default:
+		t.Fatal("expected an event for the subscriber")
+	}
+
+	select {
+	case event := <-aliceEvents:
+		t.Errorf("unexpected event: %+v", event)
+	default:
+	}
+}
+
+func TestOrderEventBroker_Unsubscribe(t *testing.T) {
+	broker := NewOrderEventBroker()
+	userID := uuid.New()
+
+	events, unsubscribe := broker.Subscribe(userID)
+	unsubscribe()
+	unsubscribe() // повторная отписка безопасна
+
+	if _, ok := <-events; ok {
+		t.Error("channel must be closed after unsubscribe")
+	}
+	// Публикация без подписчиков не блокируется и не паникует
+	broker.Publish(models.OrderEvent{UserID: userID})
+}
+
+func TestOrderEventBroker_SlowSubscriberDoesNotBlock(t *testing.T) {
+	broker := NewOrderEventBroker()
+	userID := uuid.New()
+
+	events, unsubscribe := broker.Subscribe(userID)
+	defer unsubscribe()
+
+	for i := 0; i < orderEventBuffer*2; i++ {
+		broker.Publish(models.OrderEvent{UserID: userID})
+	}
+	if len(events) != orderEventBuffer {
+		t.Errorf("buffered events = %d, want %d", len(events), orderEventBuffer)
+	}
+}
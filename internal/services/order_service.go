@@ -5,56 +5,113 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/agamariel/gofermart/internal/models"
 	"github.com/agamariel/gofermart/internal/storage"
 	"github.com/agamariel/gofermart/internal/utils"
 	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
 )
 
 var (
 	ErrInvalidOrderNumber      = errors.New("invalid order number")
 	ErrOrderOwnedByAnotherUser = errors.New("order already uploaded by another user")
 	ErrOrderAlreadyUploaded    = errors.New("order already uploaded by the same user")
+	ErrOrderAlreadyProcessed   = storage.ErrOrderAlreadyProcessed
+	ErrInvalidSearchPrefix     = errors.New("invalid order number prefix")
+	ErrOrderLimitExceeded      = errors.New("order limit per user exceeded")
+	ErrOrderOwnerUnavailable   = errors.New("order owner is temporarily unavailable")
 )
 
+// После конфликта вставки владелец заказа запрашивается до ownerLookupAttempts раз.
+const (
+	ownerLookupAttempts   = 3
+	ownerLookupRetryPause = 10 * time.Millisecond
+)
+
+// MaxSearchPrefixLength ограничивает длину префикса при поиске заказов.
+const MaxSearchPrefixLength = 32
+
 // OrderService определяет интерфейс работы с заказами.
 type OrderService interface {
 	SubmitOrder(ctx context.Context, userID uuid.UUID, orderNumber string) error
+	SubmitOrders(ctx context.Context, userID uuid.UUID, numbers []string) ([]models.BulkOrderResult, error)
+	ValidateOrder(ctx context.Context, userID uuid.UUID, orderNumber string) error
 	GetUserOrders(ctx context.Context, userID uuid.UUID) ([]*models.Order, error)
+	GetUserOrdersSorted(ctx context.Context, userID uuid.UUID, asc bool) ([]*models.Order, error)
+	ExportUserOrders(ctx context.Context, userID uuid.UUID, fn func(order *models.Order) error) error
+	GetAccruedTotal(ctx context.Context, userID uuid.UUID) (decimal.Decimal, error)
+	GetUserOrdersPage(ctx context.Context, userID uuid.UUID, cursor string, limit int) ([]*models.Order, string, error)
+	SearchUserOrders(ctx context.Context, userID uuid.UUID, prefix string, limit int) ([]*models.Order, error)
+	ReprocessOrder(ctx context.Context, orderNumber string) error
 }
 
 // OrderServiceImpl реализует OrderService.
 type OrderServiceImpl struct {
 	orderStorage OrderStorage
+	// Пользователь не может загрузить больше maxOrdersPerUser заказов; ноль - без ограничения.
+	maxOrdersPerUser int
+	// skipLuhn - не проверять номера по алгоритму Луна (только для песочницы).
+	skipLuhn bool
+}
+
+// OrderServiceOption настраивает OrderServiceImpl.
+type OrderServiceOption func(*OrderServiceImpl)
+
+// WithMaxOrdersPerUser ограничивает общее число заказов одного пользователя.
+// Лимит проверяется перед вставкой, поэтому одновременные загрузки могут превысить его
+// на число параллельных запросов.
+func WithMaxOrdersPerUser(limit int) OrderServiceOption {
+	return func(s *OrderServiceImpl) {
+		if limit > 0 {
+			s.maxOrdersPerUser = limit
+		}
+	}
+}
+
+// WithoutOrderLuhnCheck отключает проверку номеров заказов по алгоритму Луна: номер
+// должен лишь быть непустым и состоять из цифр. Только для песочницы с симулятором начислений.
+func WithoutOrderLuhnCheck() OrderServiceOption {
+	return func(s *OrderServiceImpl) {
+		s.skipLuhn = true
+	}
 }
 
 // NewOrderService создаёт новый сервис заказов.
-func NewOrderService(orderStorage OrderStorage) *OrderServiceImpl {
-	return &OrderServiceImpl{orderStorage: orderStorage}
+func NewOrderService(orderStorage OrderStorage, opts ...OrderServiceOption) *OrderServiceImpl {
+	s := &OrderServiceImpl{orderStorage: orderStorage}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 // SubmitOrder обрабатывает загрузку номера заказа.
+// Повторная загрузка собственного заказа в статусе INVALID возвращает его в NEW,
+// чтобы начисление было запрошено заново; заказы в остальных статусах не меняются.
 func (s *OrderServiceImpl) SubmitOrder(ctx context.Context, userID uuid.UUID, orderNumber string) error {
 	orderNumber = normalizeOrderNumber(orderNumber)
-	if orderNumber == "" {
-		return ErrInvalidOrderNumber
+	err := s.checkOrder(ctx, userID, orderNumber)
+	if errors.Is(err, ErrOrderAlreadyUploaded) {
+		reset, rErr := s.orderStorage.ResetToNewIfInvalid(ctx, orderNumber, userID)
+		if rErr != nil {
+			return fmt.Errorf("reset invalid order: %w", rErr)
+		}
+		if reset {
+			return nil
+		}
 	}
-
-	if !utils.ValidateLuhn(orderNumber) {
-		return ErrInvalidOrderNumber
+	if err != nil {
+		return err
 	}
 
-	// Проверяем существование заказа
-	existing, err := s.orderStorage.GetByNumber(ctx, orderNumber)
-	if err == nil && existing != nil {
-		if existing.UserID == userID {
-			return ErrOrderAlreadyUploaded
-		}
-		return ErrOrderOwnedByAnotherUser
+	remaining, err := s.remainingOrders(ctx, userID)
+	if err != nil {
+		return err
 	}
-	if err != nil && !errors.Is(err, storage.ErrOrderNotFound) {
-		return fmt.Errorf("check existing order: %w", err)
+	if remaining == 0 {
+		return ErrOrderLimitExceeded
 	}
 
 	// Создаём новый заказ
@@ -66,14 +123,8 @@ func (s *OrderServiceImpl) SubmitOrder(ctx context.Context, userID uuid.UUID, or
 
 	if err := s.orderStorage.Create(ctx, order); err != nil {
 		if errors.Is(err, storage.ErrOrderAlreadyExists) {
-			// На случай гонки: проверяем владельца ещё раз
-			existing, gErr := s.orderStorage.GetByNumber(ctx, orderNumber)
-			if gErr == nil && existing != nil {
-				if existing.UserID == userID {
-					return ErrOrderAlreadyUploaded
-				}
-				return ErrOrderOwnedByAnotherUser
-			}
+			// На случай гонки: заказ успел создать другой запрос, проверяем владельца ещё раз
+			return s.racedOrderOwner(ctx, userID, orderNumber)
 		}
 		return fmt.Errorf("create order: %w", err)
 	}
@@ -81,6 +132,172 @@ func (s *OrderServiceImpl) SubmitOrder(ctx context.Context, userID uuid.UUID, or
 	return nil
 }
 
+// racedOrderOwner определяет владельца заказа, вставка которого завершилась конфликтом.
+// Временные сбои чтения повторяются; если владельца так и не удалось узнать,
+// возвращается ErrOrderOwnerUnavailable - заказ в системе есть, и клиент может повторить запрос.
+func (s *OrderServiceImpl) racedOrderOwner(ctx context.Context, userID uuid.UUID, orderNumber string) error {
+	var lastErr error
+	for attempt := 1; ; attempt++ {
+		ownerID, found, err := s.orderStorage.OrderOwner(ctx, orderNumber)
+		switch {
+		case err != nil:
+			lastErr = err
+		case !found:
+			lastErr = storage.ErrOrderNotFound
+		case ownerID == userID:
+			return ErrOrderAlreadyUploaded
+		default:
+			return ErrOrderOwnedByAnotherUser
+		}
+
+		if attempt >= ownerLookupAttempts {
+			break
+		}
+		timer := time.NewTimer(ownerLookupRetryPause * time.Duration(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w: %w", ErrOrderOwnerUnavailable, ctx.Err())
+		case <-timer.C:
+		}
+	}
+
+	return fmt.Errorf("%w: %w", ErrOrderOwnerUnavailable, lastErr)
+}
+
+// SubmitOrders загружает несколько номеров заказов за один вызов.
+// Новые заказы создаются в одной транзакции; результат возвращается по каждому номеру
+// в исходном порядке. Повтор номера внутри запроса считается дубликатом.
+// Свой заказ в статусе INVALID, как и при загрузке по одному, сбрасывается
+// в NEW и считается принятым.
+func (s *OrderServiceImpl) SubmitOrders(ctx context.Context, userID uuid.UUID, numbers []string) ([]models.BulkOrderResult, error) {
+	results := make([]models.BulkOrderResult, len(numbers))
+	seen := make(map[string]struct{}, len(numbers))
+
+	var orders []*models.Order
+	var positions []int
+	for i, number := range numbers {
+		number = normalizeOrderNumber(number)
+		results[i].Number = number
+
+		if !validOrderNumber(number, !s.skipLuhn) {
+			results[i].Status = models.BulkOrderInvalid
+			continue
+		}
+		if _, ok := seen[number]; ok {
+			results[i].Status = models.BulkOrderDuplicate
+			continue
+		}
+		seen[number] = struct{}{}
+
+		orders = append(orders, &models.Order{
+			UserID: userID,
+			Number: number,
+			Status: models.OrderStatusNew,
+		})
+		positions = append(positions, i)
+	}
+
+	if len(orders) == 0 {
+		return results, nil
+	}
+
+	// Номера сверх остатка лимита отклоняются до вставки
+	remaining, err := s.remainingOrders(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if remaining >= 0 && len(orders) > remaining {
+		for _, i := range positions[remaining:] {
+			results[i].Status = models.BulkOrderLimitExceeded
+		}
+		orders, positions = orders[:remaining], positions[:remaining]
+		if len(orders) == 0 {
+			return results, nil
+		}
+	}
+
+	created, err := s.orderStorage.CreateBatch(ctx, orders)
+	if err != nil {
+		return nil, fmt.Errorf("create orders: %w", err)
+	}
+
+	for j, order := range orders {
+		i := positions[j]
+		if created[j] {
+			results[i].Status = models.BulkOrderAccepted
+			continue
+		}
+
+		// Номер уже был в системе: определяем владельца
+		ownerID, found, err := s.orderStorage.OrderOwner(ctx, order.Number)
+		if err != nil {
+			return nil, fmt.Errorf("check existing order: %w", err)
+		}
+		if !found {
+			return nil, fmt.Errorf("check existing order %s: %w", order.Number, storage.ErrOrderNotFound)
+		}
+		if ownerID == userID {
+			reset, err := s.orderStorage.ResetToNewIfInvalid(ctx, order.Number, userID)
+			if err != nil {
+				return nil, fmt.Errorf("reset invalid order: %w", err)
+			}
+			if reset {
+				results[i].Status = models.BulkOrderAccepted
+			} else {
+				results[i].Status = models.BulkOrderDuplicate
+			}
+		} else {
+			results[i].Status = models.BulkOrderConflict
+		}
+	}
+
+	return results, nil
+}
+
+// remainingOrders возвращает, сколько ещё заказов может загрузить пользователь,
+// или -1, если лимит не задан.
+func (s *OrderServiceImpl) remainingOrders(ctx context.Context, userID uuid.UUID) (int, error) {
+	if s.maxOrdersPerUser == 0 {
+		return -1, nil
+	}
+
+	count, err := s.orderStorage.CountByUser(ctx, userID)
+	if err != nil {
+		return 0, fmt.Errorf("count user orders: %w", err)
+	}
+	if count >= s.maxOrdersPerUser {
+		return 0, nil
+	}
+	return s.maxOrdersPerUser - count, nil
+}
+
+// ValidateOrder выполняет проверки номера заказа (Луна и владелец) без его создания.
+func (s *OrderServiceImpl) ValidateOrder(ctx context.Context, userID uuid.UUID, orderNumber string) error {
+	return s.checkOrder(ctx, userID, normalizeOrderNumber(orderNumber))
+}
+
+// checkOrder проверяет номер по алгоритму Луна и наличие заказа в системе.
+func (s *OrderServiceImpl) checkOrder(ctx context.Context, userID uuid.UUID, orderNumber string) error {
+	if !validOrderNumber(orderNumber, !s.skipLuhn) {
+		return ErrInvalidOrderNumber
+	}
+
+	// Проверяем существование заказа: достаточно владельца, полная строка не нужна
+	ownerID, found, err := s.orderStorage.OrderOwner(ctx, orderNumber)
+	if err != nil {
+		return fmt.Errorf("check existing order: %w", err)
+	}
+	if found {
+		if ownerID == userID {
+			return ErrOrderAlreadyUploaded
+		}
+		return ErrOrderOwnedByAnotherUser
+	}
+
+	return nil
+}
+
 // GetUserOrders возвращает список заказов пользователя.
 func (s *OrderServiceImpl) GetUserOrders(ctx context.Context, userID uuid.UUID) ([]*models.Order, error) {
 	orders, err := s.orderStorage.GetByUserID(ctx, userID)
@@ -91,7 +308,114 @@ func (s *OrderServiceImpl) GetUserOrders(ctx context.Context, userID uuid.UUID)
 	return orders, nil
 }
 
+// GetUserOrdersSorted возвращает список заказов пользователя в заданном порядке по времени загрузки.
+func (s *OrderServiceImpl) GetUserOrdersSorted(ctx context.Context, userID uuid.UUID, asc bool) ([]*models.Order, error) {
+	orders, err := s.orderStorage.GetByUserIDSorted(ctx, userID, asc)
+	if err != nil {
+		return nil, fmt.Errorf("get user orders: %w", err)
+	}
+
+	return orders, nil
+}
+
+// GetAccruedTotal возвращает сумму начислений по обработанным заказам пользователя.
+func (s *OrderServiceImpl) GetAccruedTotal(ctx context.Context, userID uuid.UUID) (decimal.Decimal, error) {
+	total, err := s.orderStorage.GetAccruedTotal(ctx, userID)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("get accrued total: %w", err)
+	}
+
+	return total, nil
+}
+
+// ExportUserOrders передаёт в fn заказы пользователя от новых к старым по одному,
+// не загружая весь список в память; используется для выгрузки в CSV.
+func (s *OrderServiceImpl) ExportUserOrders(ctx context.Context, userID uuid.UUID, fn func(order *models.Order) error) error {
+	if err := s.orderStorage.EachByUserID(ctx, userID, fn); err != nil {
+		return fmt.Errorf("export user orders: %w", err)
+	}
+	return nil
+}
+
+// GetUserOrdersPage возвращает страницу заказов пользователя от новых к старым.
+// Пустой cursor означает первую страницу; возвращаемый курсор следующей страницы
+// пуст, если страниц больше нет.
+func (s *OrderServiceImpl) GetUserOrdersPage(ctx context.Context, userID uuid.UUID, cursor string, limit int) ([]*models.Order, string, error) {
+	var after orderCursor
+	if cursor != "" {
+		var err error
+		if after, err = decodeOrderCursor(cursor); err != nil {
+			return nil, "", err
+		}
+	}
+
+	// Запрашиваем на один заказ больше, чтобы узнать, есть ли следующая страница
+	orders, err := s.orderStorage.GetByUserIDAfter(ctx, userID, after.UploadedAt, after.ID, limit+1)
+	if err != nil {
+		return nil, "", fmt.Errorf("get user orders page: %w", err)
+	}
+
+	if len(orders) <= limit {
+		return orders, "", nil
+	}
+
+	orders = orders[:limit]
+	last := orders[len(orders)-1]
+	return orders, encodeOrderCursor(orderCursor{UploadedAt: last.UploadedAt, ID: last.ID}), nil
+}
+
+// SearchUserOrders ищет заказы пользователя по началу номера.
+// Префикс должен состоять только из цифр и быть не длиннее MaxSearchPrefixLength.
+func (s *OrderServiceImpl) SearchUserOrders(ctx context.Context, userID uuid.UUID, prefix string, limit int) ([]*models.Order, error) {
+	prefix = normalizeOrderNumber(prefix)
+	if !isDigits(prefix) || len(prefix) > MaxSearchPrefixLength {
+		return nil, ErrInvalidSearchPrefix
+	}
+
+	orders, err := s.orderStorage.SearchByNumberPrefix(ctx, userID, prefix, limit)
+	if err != nil {
+		return nil, fmt.Errorf("search user orders: %w", err)
+	}
+
+	return orders, nil
+}
+
+// ReprocessOrder сбрасывает заказ в NEW, чтобы воркер заново запросил начисление.
+// Обработанные заказы не сбрасываются (ErrOrderAlreadyProcessed), чтобы избежать
+// повторного начисления; статус проверяет хранилище при сбросе.
+func (s *OrderServiceImpl) ReprocessOrder(ctx context.Context, orderNumber string) error {
+	orderNumber = normalizeOrderNumber(orderNumber)
+
+	if err := s.orderStorage.ResetToNew(ctx, orderNumber); err != nil {
+		return fmt.Errorf("reset order: %w", err)
+	}
+
+	return nil
+}
+
 // normalizeOrderNumber убирает пробелы и переносы.
 func normalizeOrderNumber(number string) string {
 	return strings.TrimSpace(number)
 }
+
+// validOrderNumber сообщает, что номер заказа непуст, состоит из цифр и,
+// если luhn, проходит проверку по алгоритму Луна.
+func validOrderNumber(number string, luhn bool) bool {
+	if !isDigits(number) {
+		return false
+	}
+	return !luhn || utils.ValidateLuhn(number)
+}
+
+// isDigits сообщает, что строка непуста и состоит только из ASCII-цифр.
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
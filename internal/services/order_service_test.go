@@ -1,23 +1,42 @@
 package services
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/agamariel/gofermart/internal/models"
 	"github.com/agamariel/gofermart/internal/storage"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/shopspring/decimal"
 )
 
 type mockOrderStorage struct {
-	CreateFunc       func(ctx context.Context, order *models.Order) error
-	GetByNumberFunc  func(ctx context.Context, number string) (*models.Order, error)
-	GetByUserIDFunc  func(ctx context.Context, userID uuid.UUID) ([]*models.Order, error)
-	UpdateStatusFunc func(ctx context.Context, number string, status models.OrderStatus, accrual *decimal.Decimal) error
-	GetPendingFunc   func(ctx context.Context) ([]*models.Order, error)
+	CreateFunc         func(ctx context.Context, order *models.Order) error
+	CreateBatchFunc    func(ctx context.Context, orders []*models.Order) ([]bool, error)
+	GetByNumberFunc    func(ctx context.Context, number string) (*models.Order, error)
+	OrderOwnerFunc     func(ctx context.Context, number string) (uuid.UUID, bool, error)
+	GetByUserIDFunc    func(ctx context.Context, userID uuid.UUID) ([]*models.Order, error)
+	GetSortedFunc      func(ctx context.Context, userID uuid.UUID, asc bool) ([]*models.Order, error)
+	GetAfterFunc       func(ctx context.Context, userID uuid.UUID, afterUploadedAt time.Time, afterID uuid.UUID, limit int) ([]*models.Order, error)
+	SearchFunc         func(ctx context.Context, userID uuid.UUID, prefix string, limit int) ([]*models.Order, error)
+	GetForUserFunc     func(ctx context.Context, number string, userID uuid.UUID) (*models.Order, error)
+	UpdateStatusFunc   func(ctx context.Context, number string, status models.OrderStatus, accrual *decimal.Decimal) error
+	ApplyAccrualTxFunc func(ctx context.Context, tx pgx.Tx, number string, accrual decimal.Decimal) (bool, error)
+	GetPendingFunc     func(ctx context.Context) ([]*models.Order, error)
+	EachByUserIDFunc   func(ctx context.Context, userID uuid.UUID, fn func(order *models.Order) error) error
+	GetAccruedFunc     func(ctx context.Context, userID uuid.UUID) (decimal.Decimal, error)
+	CountFunc          func(ctx context.Context, userID uuid.UUID) (int, error)
+	GetStuckFunc       func(ctx context.Context, olderThan time.Duration) ([]*models.Order, error)
+	ResetToNewFunc     func(ctx context.Context, number string) error
+
+	ResetIfInvalidFunc func(ctx context.Context, number string, userID uuid.UUID) (bool, error)
 }
 
 func (m *mockOrderStorage) Create(ctx context.Context, order *models.Order) error {
@@ -27,6 +46,17 @@ func (m *mockOrderStorage) Create(ctx context.Context, order *models.Order) erro
 	return nil
 }
 
+func (m *mockOrderStorage) CreateBatch(ctx context.Context, orders []*models.Order) ([]bool, error) {
+	if m.CreateBatchFunc != nil {
+		return m.CreateBatchFunc(ctx, orders)
+	}
+	created := make([]bool, len(orders))
+	for i := range created {
+		created[i] = true
+	}
+	return created, nil
+}
+
 func (m *mockOrderStorage) GetByNumber(ctx context.Context, number string) (*models.Order, error) {
 	if m.GetByNumberFunc != nil {
 		return m.GetByNumberFunc(ctx, number)
@@ -34,6 +64,28 @@ func (m *mockOrderStorage) GetByNumber(ctx context.Context, number string) (*mod
 	return nil, storage.ErrOrderNotFound
 }
 
+func (m *mockOrderStorage) GetByNumberForUser(ctx context.Context, number string, userID uuid.UUID) (*models.Order, error) {
+	if m.GetForUserFunc != nil {
+		return m.GetForUserFunc(ctx, number, userID)
+	}
+	return nil, storage.ErrOrderNotFound
+}
+
+func (m *mockOrderStorage) OrderOwner(ctx context.Context, number string) (uuid.UUID, bool, error) {
+	if m.OrderOwnerFunc != nil {
+		return m.OrderOwnerFunc(ctx, number)
+	}
+	// По умолчанию владелец берётся из GetByNumber, чтобы тесты задавали заказы в одном месте
+	order, err := m.GetByNumber(ctx, number)
+	if errors.Is(err, storage.ErrOrderNotFound) {
+		return uuid.Nil, false, nil
+	}
+	if err != nil {
+		return uuid.Nil, false, err
+	}
+	return order.UserID, true, nil
+}
+
 func (m *mockOrderStorage) GetByUserID(ctx context.Context, userID uuid.UUID) ([]*models.Order, error) {
 	if m.GetByUserIDFunc != nil {
 		return m.GetByUserIDFunc(ctx, userID)
@@ -41,6 +93,34 @@ func (m *mockOrderStorage) GetByUserID(ctx context.Context, userID uuid.UUID) ([
 	return []*models.Order{}, nil
 }
 
+func (m *mockOrderStorage) EachByUserID(ctx context.Context, userID uuid.UUID, fn func(order *models.Order) error) error {
+	if m.EachByUserIDFunc != nil {
+		return m.EachByUserIDFunc(ctx, userID, fn)
+	}
+	return nil
+}
+
+func (m *mockOrderStorage) GetByUserIDSorted(ctx context.Context, userID uuid.UUID, asc bool) ([]*models.Order, error) {
+	if m.GetSortedFunc != nil {
+		return m.GetSortedFunc(ctx, userID, asc)
+	}
+	return []*models.Order{}, nil
+}
+
+func (m *mockOrderStorage) GetByUserIDAfter(ctx context.Context, userID uuid.UUID, afterUploadedAt time.Time, afterID uuid.UUID, limit int) ([]*models.Order, error) {
+	if m.GetAfterFunc != nil {
+		return m.GetAfterFunc(ctx, userID, afterUploadedAt, afterID, limit)
+	}
+	return []*models.Order{}, nil
+}
+
+func (m *mockOrderStorage) SearchByNumberPrefix(ctx context.Context, userID uuid.UUID, prefix string, limit int) ([]*models.Order, error) {
+	if m.SearchFunc != nil {
+		return m.SearchFunc(ctx, userID, prefix, limit)
+	}
+	return []*models.Order{}, nil
+}
+
 func (m *mockOrderStorage) UpdateStatus(ctx context.Context, number string, status models.OrderStatus, accrual *decimal.Decimal) error {
 	if m.UpdateStatusFunc != nil {
 		return m.UpdateStatusFunc(ctx, number, status, accrual)
@@ -48,6 +128,13 @@ func (m *mockOrderStorage) UpdateStatus(ctx context.Context, number string, stat
 	return nil
 }
 
+func (m *mockOrderStorage) ApplyAccrualTx(ctx context.Context, tx pgx.Tx, number string, accrual decimal.Decimal) (bool, error) {
+	if m.ApplyAccrualTxFunc != nil {
+		return m.ApplyAccrualTxFunc(ctx, tx, number, accrual)
+	}
+	return true, nil
+}
+
 func (m *mockOrderStorage) GetPendingOrders(ctx context.Context) ([]*models.Order, error) {
 	if m.GetPendingFunc != nil {
 		return m.GetPendingFunc(ctx)
@@ -55,6 +142,82 @@ func (m *mockOrderStorage) GetPendingOrders(ctx context.Context) ([]*models.Orde
 	return []*models.Order{}, nil
 }
 
+func (m *mockOrderStorage) GetAccruedTotal(ctx context.Context, userID uuid.UUID) (decimal.Decimal, error) {
+	if m.GetAccruedFunc != nil {
+		return m.GetAccruedFunc(ctx, userID)
+	}
+	return decimal.Zero, nil
+}
+
+func (m *mockOrderStorage) CountByUser(ctx context.Context, userID uuid.UUID) (int, error) {
+	if m.CountFunc != nil {
+		return m.CountFunc(ctx, userID)
+	}
+	return 0, nil
+}
+
+func (m *mockOrderStorage) GetStuckProcessing(ctx context.Context, olderThan time.Duration) ([]*models.Order, error) {
+	if m.GetStuckFunc != nil {
+		return m.GetStuckFunc(ctx, olderThan)
+	}
+	return []*models.Order{}, nil
+}
+
+func (m *mockOrderStorage) ResetToNew(ctx context.Context, number string) error {
+	if m.ResetToNewFunc != nil {
+		return m.ResetToNewFunc(ctx, number)
+	}
+	return nil
+}
+
+func (m *mockOrderStorage) ResetToNewIfInvalid(ctx context.Context, number string, userID uuid.UUID) (bool, error) {
+	if m.ResetIfInvalidFunc != nil {
+		return m.ResetIfInvalidFunc(ctx, number, userID)
+	}
+	return false, nil
+}
+
+func TestOrderService_SubmitOrderLuhnCheck(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name       string
+		number     string
+		wantStrict error
+		wantBypass error
+	}{
+		{name: "luhn-valid number", number: "79927398713"},
+		{name: "non-luhn number", number: "12345", wantStrict: ErrInvalidOrderNumber},
+		{name: "empty number", number: "  ", wantStrict: ErrInvalidOrderNumber, wantBypass: ErrInvalidOrderNumber},
+		{name: "non-digit number", number: "12a45", wantStrict: ErrInvalidOrderNumber, wantBypass: ErrInvalidOrderNumber},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strict := NewOrderService(storage.NewInMemoryOrderStorage())
+			if err := strict.SubmitOrder(ctx, uuid.New(), tt.number); !errors.Is(err, tt.wantStrict) {
+				t.Errorf("SubmitOrder() with Luhn check error = %v, want %v", err, tt.wantStrict)
+			}
+
+			bypass := NewOrderService(storage.NewInMemoryOrderStorage(), WithoutOrderLuhnCheck())
+			if err := bypass.SubmitOrder(ctx, uuid.New(), tt.number); !errors.Is(err, tt.wantBypass) {
+				t.Errorf("SubmitOrder() without Luhn check error = %v, want %v", err, tt.wantBypass)
+			}
+		})
+	}
+
+	t.Run("bulk upload", func(t *testing.T) {
+		svc := NewOrderService(storage.NewInMemoryOrderStorage(), WithoutOrderLuhnCheck())
+		results, err := svc.SubmitOrders(ctx, uuid.New(), []string{"12345", "12a45"})
+		if err != nil {
+			t.Fatalf("SubmitOrders() error = %v", err)
+		}
+		if results[0].Status != models.BulkOrderAccepted || results[1].Status != models.BulkOrderInvalid {
+			t.Errorf("results = %+v, want the non-luhn number accepted and the non-digit one invalid", results)
+		}
+	})
+}
+
 func TestOrderService_SubmitOrder(t *testing.T) {
 	ctx := context.Background()
 	userID := uuid.New()
@@ -125,6 +288,291 @@ func TestOrderService_SubmitOrder(t *testing.T) {
 	})
 }
 
+func TestOrderService_SubmitOrderResubmitsInvalid(t *testing.T) {
+	ctx := context.Background()
+	userID := uuid.New()
+	otherUserID := uuid.New()
+	validNumber := "79927398713"
+
+	// Хранилище сбрасывает заказ, только если он принадлежит пользователю и имеет статус INVALID
+	newStorage := func(owner uuid.UUID, status models.OrderStatus, resets *int) *mockOrderStorage {
+		return &mockOrderStorage{
+			GetByNumberFunc: func(ctx context.Context, number string) (*models.Order, error) {
+				return &models.Order{UserID: owner, Number: number, Status: status}, nil
+			},
+			ResetIfInvalidFunc: func(ctx context.Context, number string, uid uuid.UUID) (bool, error) {
+				if number != validNumber {
+					t.Errorf("reset number = %s, want %s", number, validNumber)
+				}
+				if uid != owner || status != models.OrderStatusInvalid {
+					return false, nil
+				}
+				*resets++
+				return true, nil
+			},
+			CreateFunc: func(ctx context.Context, order *models.Order) error {
+				t.Error("existing order must not be created again")
+				return nil
+			},
+		}
+	}
+
+	tests := []struct {
+		name       string
+		owner      uuid.UUID
+		status     models.OrderStatus
+		wantErr    error
+		wantResets int
+	}{
+		{name: "own invalid order is reset", owner: userID, status: models.OrderStatusInvalid, wantResets: 1},
+		{name: "own processed order is unchanged", owner: userID, status: models.OrderStatusProcessed, wantErr: ErrOrderAlreadyUploaded},
+		{name: "own processing order is unchanged", owner: userID, status: models.OrderStatusProcessing, wantErr: ErrOrderAlreadyUploaded},
+		{name: "invalid order of another user", owner: otherUserID, status: models.OrderStatusInvalid, wantErr: ErrOrderOwnedByAnotherUser},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resets int
+			svc := NewOrderService(newStorage(tt.owner, tt.status, &resets))
+
+			err := svc.SubmitOrder(ctx, userID, " "+validNumber+" ")
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Fatalf("SubmitOrder() error = %v, want %v", err, tt.wantErr)
+			}
+			if resets != tt.wantResets {
+				t.Errorf("resets = %d, want %d", resets, tt.wantResets)
+			}
+		})
+	}
+
+	t.Run("reset error", func(t *testing.T) {
+		svc := NewOrderService(&mockOrderStorage{
+			GetByNumberFunc: func(ctx context.Context, number string) (*models.Order, error) {
+				return &models.Order{UserID: userID, Number: number, Status: models.OrderStatusInvalid}, nil
+			},
+			ResetIfInvalidFunc: func(ctx context.Context, number string, uid uuid.UUID) (bool, error) {
+				return false, errors.New("db error")
+			},
+		})
+		err := svc.SubmitOrder(ctx, userID, validNumber)
+		if err == nil || errors.Is(err, ErrOrderAlreadyUploaded) {
+			t.Fatalf("SubmitOrder() error = %v, want storage error", err)
+		}
+	})
+}
+
+func TestOrderService_SubmitOrderRaceOwnerLookup(t *testing.T) {
+	ctx := context.Background()
+	userID := uuid.New()
+	otherUserID := uuid.New()
+	validNumber := "79927398713"
+
+	// Проверка перед вставкой не находит заказ, но параллельный запрос успевает его создать.
+	// lookup отвечает на чтения владельца после конфликта вставки.
+	newStorage := func(lookups *int, lookup func(n int) (uuid.UUID, bool, error)) *mockOrderStorage {
+		checked := false
+		return &mockOrderStorage{
+			CreateFunc: func(ctx context.Context, order *models.Order) error {
+				return storage.ErrOrderAlreadyExists
+			},
+			OrderOwnerFunc: func(ctx context.Context, number string) (uuid.UUID, bool, error) {
+				if !checked {
+					checked = true
+					return uuid.Nil, false, nil
+				}
+				*lookups++
+				return lookup(*lookups)
+			},
+		}
+	}
+	failFirst := func(owner uuid.UUID, failures int) func(n int) (uuid.UUID, bool, error) {
+		return func(n int) (uuid.UUID, bool, error) {
+			if n <= failures {
+				return uuid.Nil, false, errors.New("connection reset")
+			}
+			return owner, true, nil
+		}
+	}
+
+	tests := []struct {
+		name        string
+		lookup      func(n int) (uuid.UUID, bool, error)
+		wantErr     error
+		wantLookups int
+	}{
+		{name: "same user", lookup: failFirst(userID, 0), wantErr: ErrOrderAlreadyUploaded, wantLookups: 1},
+		{name: "another user", lookup: failFirst(otherUserID, 0), wantErr: ErrOrderOwnedByAnotherUser, wantLookups: 1},
+		{name: "transient lookup error, same user", lookup: failFirst(userID, 1), wantErr: ErrOrderAlreadyUploaded, wantLookups: 2},
+		{name: "transient lookup errors, another user", lookup: failFirst(otherUserID, ownerLookupAttempts-1), wantErr: ErrOrderOwnedByAnotherUser, wantLookups: ownerLookupAttempts},
+		{name: "persistent lookup error", lookup: failFirst(userID, ownerLookupAttempts), wantErr: ErrOrderOwnerUnavailable, wantLookups: ownerLookupAttempts},
+		{
+			name: "order vanished after conflict",
+			lookup: func(int) (uuid.UUID, bool, error) {
+				return uuid.Nil, false, nil
+			},
+			wantErr:     ErrOrderOwnerUnavailable,
+			wantLookups: ownerLookupAttempts,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lookups int
+			svc := NewOrderService(newStorage(&lookups, tt.lookup))
+
+			err := svc.SubmitOrder(ctx, userID, validNumber)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("SubmitOrder() error = %v, want %v", err, tt.wantErr)
+			}
+			if lookups != tt.wantLookups {
+				t.Errorf("owner lookups = %d, want %d", lookups, tt.wantLookups)
+			}
+		})
+	}
+
+	t.Run("cancelled context stops retries", func(t *testing.T) {
+		cancelCtx, cancel := context.WithCancel(ctx)
+		var lookups int
+		svc := NewOrderService(newStorage(&lookups, func(int) (uuid.UUID, bool, error) {
+			cancel()
+			return uuid.Nil, false, errors.New("connection reset")
+		}))
+
+		err := svc.SubmitOrder(cancelCtx, userID, validNumber)
+		if !errors.Is(err, ErrOrderOwnerUnavailable) || !errors.Is(err, context.Canceled) {
+			t.Fatalf("SubmitOrder() error = %v, want ErrOrderOwnerUnavailable and context.Canceled", err)
+		}
+		if lookups != 1 {
+			t.Errorf("owner lookups = %d, want 1", lookups)
+		}
+	})
+}
+
+// countingOrderStorage считает обращения SubmitOrder к хранилищу заказов.
+// beforeCreate, если задан, вызывается перед вставкой - так тест моделирует параллельный запрос.
+type countingOrderStorage struct {
+	OrderStorage
+	calls        map[string]int
+	beforeCreate func()
+}
+
+func newCountingOrderStorage() *countingOrderStorage {
+	return &countingOrderStorage{OrderStorage: storage.NewInMemoryOrderStorage(), calls: make(map[string]int)}
+}
+
+func (s *countingOrderStorage) queries() int {
+	total := 0
+	for _, n := range s.calls {
+		total += n
+	}
+	return total
+}
+
+func (s *countingOrderStorage) Create(ctx context.Context, order *models.Order) error {
+	s.calls["Create"]++
+	if s.beforeCreate != nil {
+		s.beforeCreate()
+	}
+	return s.OrderStorage.Create(ctx, order)
+}
+
+func (s *countingOrderStorage) GetByNumber(ctx context.Context, number string) (*models.Order, error) {
+	s.calls["GetByNumber"]++
+	return s.OrderStorage.GetByNumber(ctx, number)
+}
+
+func (s *countingOrderStorage) OrderOwner(ctx context.Context, number string) (uuid.UUID, bool, error) {
+	s.calls["OrderOwner"]++
+	return s.OrderStorage.OrderOwner(ctx, number)
+}
+
+func (s *countingOrderStorage) CountByUser(ctx context.Context, userID uuid.UUID) (int, error) {
+	s.calls["CountByUser"]++
+	return s.OrderStorage.CountByUser(ctx, userID)
+}
+
+func (s *countingOrderStorage) ResetToNewIfInvalid(ctx context.Context, number string, userID uuid.UUID) (bool, error) {
+	s.calls["ResetToNewIfInvalid"]++
+	return s.OrderStorage.ResetToNewIfInvalid(ctx, number, userID)
+}
+
+// submitScenario готовит хранилище к одному из путей SubmitOrder.
+type submitScenario struct {
+	name    string
+	prepare func(s *countingOrderStorage, number string)
+	wantErr error
+	// wantCalls - ожидаемые обращения к хранилищу; GetByNumber не должен вызываться ни на одном пути.
+	wantCalls map[string]int
+}
+
+var submitScenarios = []submitScenario{
+	{
+		name:      "new order",
+		prepare:   func(s *countingOrderStorage, number string) {},
+		wantCalls: map[string]int{"OrderOwner": 1, "Create": 1},
+	},
+	{
+		name: "already uploaded by another user",
+		prepare: func(s *countingOrderStorage, number string) {
+			_ = s.OrderStorage.Create(context.Background(), &models.Order{UserID: uuid.New(), Number: number, Status: models.OrderStatusNew})
+		},
+		wantErr:   ErrOrderOwnedByAnotherUser,
+		wantCalls: map[string]int{"OrderOwner": 1},
+	},
+	{
+		name: "concurrent insert",
+		prepare: func(s *countingOrderStorage, number string) {
+			s.beforeCreate = func() {
+				_ = s.OrderStorage.Create(context.Background(), &models.Order{UserID: uuid.New(), Number: number, Status: models.OrderStatusNew})
+			}
+		},
+		wantErr:   ErrOrderOwnedByAnotherUser,
+		wantCalls: map[string]int{"OrderOwner": 2, "Create": 1},
+	},
+}
+
+// TestOrderService_SubmitOrderQueryCount фиксирует число запросов SubmitOrder: владелец заказа
+// определяется одним запросом OrderOwner, полная строка заказа (GetByNumber) не читается.
+func TestOrderService_SubmitOrderQueryCount(t *testing.T) {
+	for _, sc := range submitScenarios {
+		t.Run(sc.name, func(t *testing.T) {
+			s := newCountingOrderStorage()
+			sc.prepare(s, "79927398713")
+			s.calls = make(map[string]int)
+
+			err := NewOrderService(s).SubmitOrder(context.Background(), uuid.New(), "79927398713")
+			if !errors.Is(err, sc.wantErr) || (sc.wantErr == nil && err != nil) {
+				t.Fatalf("SubmitOrder() error = %v, want %v", err, sc.wantErr)
+			}
+			if fmt.Sprint(s.calls) != fmt.Sprint(sc.wantCalls) {
+				t.Errorf("storage calls = %v, want %v", s.calls, sc.wantCalls)
+			}
+		})
+	}
+}
+
+// BenchmarkOrderService_SubmitOrder сообщает число запросов к хранилищу на вызов (queries/op)
+// для каждого пути SubmitOrder.
+func BenchmarkOrderService_SubmitOrder(b *testing.B) {
+	for _, sc := range submitScenarios {
+		b.Run(sc.name, func(b *testing.B) {
+			queries := 0
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				s := newCountingOrderStorage()
+				sc.prepare(s, "79927398713")
+				s.calls = make(map[string]int)
+				svc := NewOrderService(s)
+				b.StartTimer()
+
+				_ = svc.SubmitOrder(context.Background(), uuid.New(), "79927398713")
+				queries += s.queries()
+			}
+			b.ReportMetric(float64(queries)/float64(b.N), "queries/op")
+		})
+	}
+}
+
 func TestOrderService_GetUserOrders(t *testing.T) {
 	ctx := context.Background()
 	userID := uuid.New()
@@ -183,3 +631,461 @@ func TestOrderService_GetUserOrdersEmpty(t *testing.T) {
 		t.Fatalf("expected empty slice, got %d", len(resp))
 	}
 }
+
+func TestOrderService_GetUserOrdersSorted(t *testing.T) {
+	ctx := context.Background()
+	userID := uuid.New()
+
+	for _, asc := range []bool{true, false} {
+		var gotAsc bool
+		svc := NewOrderService(&mockOrderStorage{
+			GetSortedFunc: func(ctx context.Context, uid uuid.UUID, a bool) ([]*models.Order, error) {
+				gotAsc = a
+				return []*models.Order{{UserID: uid, Number: "79927398713"}}, nil
+			},
+		})
+
+		resp, err := svc.GetUserOrdersSorted(ctx, userID, asc)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(resp) != 1 {
+			t.Fatalf("expected 1 order, got %d", len(resp))
+		}
+		if gotAsc != asc {
+			t.Errorf("asc passed to storage = %v, want %v", gotAsc, asc)
+		}
+	}
+}
+
+func TestOrderService_ValidateOrder(t *testing.T) {
+	ctx := context.Background()
+	userID := uuid.New()
+	otherUserID := uuid.New()
+	validNumber := "79927398713"
+
+	failOnCreate := func(ctx context.Context, order *models.Order) error {
+		t.Fatal("ValidateOrder must not create orders")
+		return nil
+	}
+
+	t.Run("valid new order", func(t *testing.T) {
+		svc := NewOrderService(&mockOrderStorage{CreateFunc: failOnCreate})
+		if err := svc.ValidateOrder(ctx, userID, validNumber); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("owned by another user", func(t *testing.T) {
+		svc := NewOrderService(&mockOrderStorage{
+			CreateFunc: failOnCreate,
+			GetByNumberFunc: func(ctx context.Context, number string) (*models.Order, error) {
+				return &models.Order{UserID: otherUserID, Number: validNumber}, nil
+			},
+		})
+		if err := svc.ValidateOrder(ctx, userID, validNumber); !errors.Is(err, ErrOrderOwnedByAnotherUser) {
+			t.Fatalf("expected ErrOrderOwnedByAnotherUser, got %v", err)
+		}
+	})
+
+	t.Run("invalid number", func(t *testing.T) {
+		svc := NewOrderService(&mockOrderStorage{CreateFunc: failOnCreate})
+		if err := svc.ValidateOrder(ctx, userID, "12345"); !errors.Is(err, ErrInvalidOrderNumber) {
+			t.Fatalf("expected ErrInvalidOrderNumber, got %v", err)
+		}
+	})
+}
+
+func TestOrderService_ReprocessOrder(t *testing.T) {
+	ctx := context.Background()
+	number := "79927398713"
+
+	t.Run("resets stuck order", func(t *testing.T) {
+		var reset string
+		svc := NewOrderService(&mockOrderStorage{
+			ResetToNewFunc: func(ctx context.Context, n string) error {
+				reset = n
+				return nil
+			},
+		})
+		if err := svc.ReprocessOrder(ctx, " "+number+"\n"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if reset != number {
+			t.Errorf("reset order = %q, want %q", reset, number)
+		}
+	})
+
+	t.Run("processed order is not reset", func(t *testing.T) {
+		svc := NewOrderService(&mockOrderStorage{
+			ResetToNewFunc: func(ctx context.Context, n string) error {
+				return storage.ErrOrderAlreadyProcessed
+			},
+		})
+		if err := svc.ReprocessOrder(ctx, number); !errors.Is(err, ErrOrderAlreadyProcessed) {
+			t.Fatalf("expected ErrOrderAlreadyProcessed, got %v", err)
+		}
+	})
+
+	t.Run("order not found", func(t *testing.T) {
+		svc := NewOrderService(&mockOrderStorage{
+			ResetToNewFunc: func(ctx context.Context, n string) error {
+				return storage.ErrOrderNotFound
+			},
+		})
+		if err := svc.ReprocessOrder(ctx, number); !errors.Is(err, storage.ErrOrderNotFound) {
+			t.Fatalf("expected ErrOrderNotFound, got %v", err)
+		}
+	})
+}
+
+func TestOrderService_SubmitOrders(t *testing.T) {
+	ctx := context.Background()
+	userID := uuid.New()
+	otherUserID := uuid.New()
+
+	// Уже загруженные заказы: свои (один из них INVALID) и чужой
+	existing := map[string]uuid.UUID{
+		"4561261212345467": userID,
+		"49927398716":      userID,
+		"2377225624":       otherUserID,
+	}
+	invalid := map[string]bool{"49927398716": true}
+
+	var batched []string
+	orderStorage := &mockOrderStorage{
+		CreateBatchFunc: func(ctx context.Context, orders []*models.Order) ([]bool, error) {
+			created := make([]bool, len(orders))
+			for i, o := range orders {
+				batched = append(batched, o.Number)
+				_, exists := existing[o.Number]
+				created[i] = !exists
+			}
+			return created, nil
+		},
+		GetByNumberFunc: func(ctx context.Context, number string) (*models.Order, error) {
+			if owner, ok := existing[number]; ok {
+				return &models.Order{Number: number, UserID: owner}, nil
+			}
+			return nil, storage.ErrOrderNotFound
+		},
+		ResetIfInvalidFunc: func(ctx context.Context, number string, uid uuid.UUID) (bool, error) {
+			if existing[number] != uid || !invalid[number] {
+				return false, nil
+			}
+			invalid[number] = false
+			return true, nil
+		},
+	}
+
+	svc := NewOrderService(orderStorage)
+	results, err := svc.SubmitOrders(ctx, userID, []string{
+		"79927398713",      // новый
+		"12345",            // не проходит Луна
+		" 79927398713 ",    // повтор в запросе
+		"4561261212345467", // уже загружен этим пользователем
+		"49927398716",      // свой заказ в статусе INVALID
+		"2377225624",       // загружен другим пользователем
+		"",                 // пустой
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []models.BulkOrderResult{
+		{Number: "79927398713", Status: models.BulkOrderAccepted},
+		{Number: "12345", Status: models.BulkOrderInvalid},
+		{Number: "79927398713", Status: models.BulkOrderDuplicate},
+		{Number: "4561261212345467", Status: models.BulkOrderDuplicate},
+		{Number: "49927398716", Status: models.BulkOrderAccepted},
+		{Number: "2377225624", Status: models.BulkOrderConflict},
+		{Number: "", Status: models.BulkOrderInvalid},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(results), len(want))
+	}
+	for i := range want {
+		if results[i] != want[i] {
+			t.Errorf("result #%d = %+v, want %+v", i, results[i], want[i])
+		}
+	}
+
+	if len(batched) != 4 {
+		t.Errorf("batched orders = %v, want 4 unique valid numbers", batched)
+	}
+	if invalid["49927398716"] {
+		t.Error("own INVALID order was not reset")
+	}
+}
+
+func TestOrderService_MaxOrdersPerUser(t *testing.T) {
+	ctx := context.Background()
+	userID := uuid.New()
+	const limit = 3
+
+	newStorage := func(count int, created *[]string) *mockOrderStorage {
+		return &mockOrderStorage{
+			CountFunc: func(ctx context.Context, uid uuid.UUID) (int, error) {
+				return count, nil
+			},
+			CreateFunc: func(ctx context.Context, order *models.Order) error {
+				*created = append(*created, order.Number)
+				return nil
+			},
+			CreateBatchFunc: func(ctx context.Context, orders []*models.Order) ([]bool, error) {
+				result := make([]bool, len(orders))
+				for i, o := range orders {
+					*created = append(*created, o.Number)
+					result[i] = true
+				}
+				return result, nil
+			},
+		}
+	}
+
+	t.Run("under the limit", func(t *testing.T) {
+		var created []string
+		svc := NewOrderService(newStorage(limit-1, &created), WithMaxOrdersPerUser(limit))
+		if err := svc.SubmitOrder(ctx, userID, "79927398713"); err != nil {
+			t.Fatalf("SubmitOrder() error = %v", err)
+		}
+		if len(created) != 1 {
+			t.Errorf("created %d orders, want 1", len(created))
+		}
+	})
+
+	t.Run("at the limit", func(t *testing.T) {
+		var created []string
+		svc := NewOrderService(newStorage(limit, &created), WithMaxOrdersPerUser(limit))
+		if err := svc.SubmitOrder(ctx, userID, "79927398713"); !errors.Is(err, ErrOrderLimitExceeded) {
+			t.Fatalf("SubmitOrder() error = %v, want ErrOrderLimitExceeded", err)
+		}
+		if len(created) != 0 {
+			t.Errorf("created %d orders, want 0", len(created))
+		}
+	})
+
+	t.Run("invalid number is reported before the limit", func(t *testing.T) {
+		var created []string
+		svc := NewOrderService(newStorage(limit, &created), WithMaxOrdersPerUser(limit))
+		if err := svc.SubmitOrder(ctx, userID, "12345"); !errors.Is(err, ErrInvalidOrderNumber) {
+			t.Fatalf("SubmitOrder() error = %v, want ErrInvalidOrderNumber", err)
+		}
+	})
+
+	t.Run("unlimited by default", func(t *testing.T) {
+		var created []string
+		orderStorage := newStorage(1000, &created)
+		orderStorage.CountFunc = func(ctx context.Context, uid uuid.UUID) (int, error) {
+			t.Error("CountByUser must not be called without a limit")
+			return 0, nil
+		}
+		if err := NewOrderService(orderStorage).SubmitOrder(ctx, userID, "79927398713"); err != nil {
+			t.Fatalf("SubmitOrder() error = %v", err)
+		}
+	})
+
+	t.Run("bulk upload is cut at the limit", func(t *testing.T) {
+		var created []string
+		svc := NewOrderService(newStorage(limit-2, &created), WithMaxOrdersPerUser(limit))
+		results, err := svc.SubmitOrders(ctx, userID, []string{"79927398713", "12345", "12345678903", "2377225624"})
+		if err != nil {
+			t.Fatalf("SubmitOrders() error = %v", err)
+		}
+
+		want := []models.BulkOrderStatus{
+			models.BulkOrderAccepted,
+			models.BulkOrderInvalid,
+			models.BulkOrderAccepted,
+			models.BulkOrderLimitExceeded,
+		}
+		for i, status := range want {
+			if results[i].Status != status {
+				t.Errorf("results[%d].Status = %s, want %s", i, results[i].Status, status)
+			}
+		}
+		if len(created) != 2 {
+			t.Errorf("created %d orders, want 2", len(created))
+		}
+	})
+
+	t.Run("count error", func(t *testing.T) {
+		var created []string
+		orderStorage := newStorage(0, &created)
+		orderStorage.CountFunc = func(ctx context.Context, uid uuid.UUID) (int, error) {
+			return 0, errors.New("db down")
+		}
+		svc := NewOrderService(orderStorage, WithMaxOrdersPerUser(limit))
+		if err := svc.SubmitOrder(ctx, userID, "79927398713"); err == nil || errors.Is(err, ErrOrderLimitExceeded) {
+			t.Fatalf("SubmitOrder() error = %v, want storage error", err)
+		}
+	})
+}
+
+func TestOrderService_SubmitOrders_StorageError(t *testing.T) {
+	orderStorage := &mockOrderStorage{
+		CreateBatchFunc: func(ctx context.Context, orders []*models.Order) ([]bool, error) {
+			return nil, errors.New("db down")
+		},
+	}
+
+	svc := NewOrderService(orderStorage)
+	if _, err := svc.SubmitOrders(context.Background(), uuid.New(), []string{"79927398713"}); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
+
+func TestOrderService_GetUserOrdersPage(t *testing.T) {
+	ctx := context.Background()
+	userID := uuid.New()
+
+	// 7 заказов, у двух одинаковое время загрузки - порядок между ними задаёт id
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	var all []*models.Order
+	for i := 0; i < 7; i++ {
+		uploaded := base.Add(time.Duration(i) * time.Minute)
+		if i == 4 {
+			uploaded = all[3].UploadedAt
+		}
+		all = append(all, &models.Order{ID: uuid.New(), UserID: userID, Number: fmt.Sprintf("order-%d", i), UploadedAt: uploaded})
+	}
+
+	// Хранилище реализует keyset-выборку так же, как SQL-запрос: (uploaded_at, id) DESC
+	orderStorage := &mockOrderStorage{
+		GetAfterFunc: func(ctx context.Context, uid uuid.UUID, afterUploadedAt time.Time, afterID uuid.UUID, limit int) ([]*models.Order, error) {
+			sorted := append([]*models.Order(nil), all...)
+			sort.Slice(sorted, func(i, j int) bool { return keysetLess(sorted[j], sorted[i]) })
+
+			var page []*models.Order
+			for _, o := range sorted {
+				if !afterUploadedAt.IsZero() && !keysetLess(o, &models.Order{UploadedAt: afterUploadedAt, ID: afterID}) {
+					continue
+				}
+				if len(page) == limit {
+					break
+				}
+				page = append(page, o)
+			}
+			return page, nil
+		},
+	}
+	svc := NewOrderService(orderStorage)
+
+	seen := make(map[string]bool)
+	var pages int
+	cursor := ""
+	for {
+		orders, next, err := svc.GetUserOrdersPage(ctx, userID, cursor, 3)
+		if err != nil {
+			t.Fatalf("GetUserOrdersPage() error = %v", err)
+		}
+		pages++
+		if len(orders) > 3 {
+			t.Fatalf("page size = %d, want <= 3", len(orders))
+		}
+		for _, o := range orders {
+			if seen[o.Number] {
+				t.Fatalf("order %s returned twice", o.Number)
+			}
+			seen[o.Number] = true
+		}
+		if next == "" {
+			break
+		}
+		if pages > len(all) {
+			t.Fatal("pagination does not terminate")
+		}
+		cursor = next
+	}
+
+	if len(seen) != len(all) {
+		t.Errorf("received %d orders, want %d", len(seen), len(all))
+	}
+	if pages != 3 {
+		t.Errorf("pages = %d, want 3", pages)
+	}
+}
+
+func TestOrderService_GetUserOrdersPageInvalidCursor(t *testing.T) {
+	svc := NewOrderService(&mockOrderStorage{})
+
+	for _, cursor := range []string{"not base64!", "bm90IGpzb24", encodeOrderCursor(orderCursor{})} {
+		if _, _, err := svc.GetUserOrdersPage(context.Background(), uuid.New(), cursor, 10); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("cursor %q: error = %v, want %v", cursor, err, ErrInvalidCursor)
+		}
+	}
+}
+
+func TestOrderService_SearchUserOrders(t *testing.T) {
+	userID := uuid.New()
+	all := []*models.Order{
+		{UserID: userID, Number: "79927398713"},
+		{UserID: userID, Number: "79927398721"},
+		{UserID: userID, Number: "12345678903"},
+	}
+
+	orderStorage := &mockOrderStorage{
+		SearchFunc: func(ctx context.Context, uid uuid.UUID, prefix string, limit int) ([]*models.Order, error) {
+			var found []*models.Order
+			for _, o := range all {
+				if o.UserID == uid && strings.HasPrefix(o.Number, prefix) && len(found) < limit {
+					found = append(found, o)
+				}
+			}
+			return found, nil
+		},
+	}
+	svc := NewOrderService(orderStorage)
+
+	tests := []struct {
+		name    string
+		prefix  string
+		limit   int
+		want    []string
+		wantErr error
+	}{
+		{name: "matching prefix", prefix: "7992739", limit: 10, want: []string{"79927398713", "79927398721"}},
+		{name: "full number", prefix: "12345678903", limit: 10, want: []string{"12345678903"}},
+		{name: "prefix is trimmed", prefix: " 123 ", limit: 10, want: []string{"12345678903"}},
+		{name: "limit applies", prefix: "7", limit: 1, want: []string{"79927398713"}},
+		{name: "non-matching prefix", prefix: "555", limit: 10, want: nil},
+		{name: "empty prefix", prefix: "", limit: 10, wantErr: ErrInvalidSearchPrefix},
+		{name: "non-digit prefix", prefix: "79%", limit: 10, wantErr: ErrInvalidSearchPrefix},
+		{name: "too long prefix", prefix: strings.Repeat("1", MaxSearchPrefixLength+1), limit: 10, wantErr: ErrInvalidSearchPrefix},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orders, err := svc.SearchUserOrders(context.Background(), userID, tt.prefix, tt.limit)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("SearchUserOrders() error = %v, want %v", err, tt.wantErr)
+			}
+
+			var got []string
+			for _, o := range orders {
+				got = append(got, o.Number)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("orders = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("other user's orders are not found", func(t *testing.T) {
+		orders, err := svc.SearchUserOrders(context.Background(), uuid.New(), "7992739", 10)
+		if err != nil {
+			t.Fatalf("SearchUserOrders() error = %v", err)
+		}
+		if len(orders) != 0 {
+			t.Errorf("got %d orders, want 0", len(orders))
+		}
+	})
+}
+
+// keysetLess сравнивает заказы по (uploaded_at, id).
+func keysetLess(a, b *models.Order) bool {
+	if !a.UploadedAt.Equal(b.UploadedAt) {
+		return a.UploadedAt.Before(b.UploadedAt)
+	}
+	return bytes.Compare(a.ID[:], b.ID[:]) < 0
+}
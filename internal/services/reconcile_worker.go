@@ -0,0 +1,275 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"time"
+
+	"github.com/agamariel/gofermart/internal/models"
+	"github.com/agamariel/gofermart/internal/storage"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// reconcileReason - причина автоматической корректировки в журнале аудита.
+const reconcileReason = "automatic balance reconciliation"
+
+// ReconcileWorker периодически сверяет балансы пользователей с операциями по ним:
+// ожидаемый баланс равен сумме начислений по обработанным заказам за вычетом списаний
+// с учётом ручных корректировок администраторами. Расхождения пишутся в журнал,
+// а с WithReconcileAutofix исправляются корректировкой с записью в журнал аудита.
+type ReconcileWorker struct {
+	pool              TxBeginner
+	userStorage       UserStorage
+	orderStorage      OrderStorage
+	withdrawalStorage WithdrawalStorage
+	audit             AuditLedger
+	interval          time.Duration
+	logger            *log.Logger
+	// discrepancyLogger получает найденные расхождения и итог сверки структурированными полями.
+	discrepancyLogger *slog.Logger
+
+	// autofix - исправлять расхождения баланса, а не только сообщать о них.
+	autofix bool
+}
+
+// ReconcileOption настраивает ReconcileWorker.
+type ReconcileOption func(*ReconcileWorker)
+
+// WithReconcileAutofix включает исправление найденных расхождений баланса.
+// Расхождение суммы списаний только пишется в журнал: её меняют лишь списания и возвраты.
+func WithReconcileAutofix() ReconcileOption {
+	return func(w *ReconcileWorker) {
+		w.autofix = true
+	}
+}
+
+// WithDiscrepancyLogger задаёт структурированный логгер для расхождений и итогов сверки.
+func WithDiscrepancyLogger(logger *slog.Logger) ReconcileOption {
+	return func(w *ReconcileWorker) {
+		if logger != nil {
+			w.discrepancyLogger = logger
+		}
+	}
+}
+
+// NewReconcileWorker создаёт воркер сверки; нулевой interval заменяется суточным.
+func NewReconcileWorker(pool TxBeginner, userStorage UserStorage, orderStorage OrderStorage, withdrawalStorage WithdrawalStorage, audit AuditLedger, interval time.Duration, logger *log.Logger, opts ...ReconcileOption) *ReconcileWorker {
+	if interval <= 0 {
+		interval = 24 * time.Hour
+	}
+	if logger == nil {
+		logger = log.Default()
+	}
+	w := &ReconcileWorker{
+		pool:              pool,
+		userStorage:       userStorage,
+		orderStorage:      orderStorage,
+		withdrawalStorage: withdrawalStorage,
+		audit:             audit,
+		interval:          interval,
+		logger:            logger,
+		discrepancyLogger: slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w
+}
+
+// Start запускает сверку раз в interval в отдельной горутине и останавливается по ctx.Done().
+// Первая сверка выполняется через interval после запуска, а не сразу: иначе каждый
+// перезапуск реплик создавал бы нагрузку на БД.
+func (w *ReconcileWorker) Start(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := w.reconcileAll(ctx); err != nil {
+					w.logger.Printf("balance reconciliation error: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// BalanceDiscrepancy - расхождение баланса или суммы списаний пользователя с его операциями.
+type BalanceDiscrepancy struct {
+	UserID            uuid.UUID
+	Balance           decimal.Decimal
+	ExpectedBalance   decimal.Decimal
+	Withdrawn         decimal.Decimal
+	ExpectedWithdrawn decimal.Decimal
+}
+
+// BalanceDelta возвращает корректировку, приводящую баланс к ожидаемому.
+func (d *BalanceDiscrepancy) BalanceDelta() decimal.Decimal {
+	return d.ExpectedBalance.Sub(d.Balance)
+}
+
+// balanceLedger - суммы операций пользователя, из которых складывается ожидаемый баланс.
+type balanceLedger struct {
+	accrued     decimal.Decimal // начисления по обработанным заказам
+	withdrawn   decimal.Decimal // списания
+	adjustments decimal.Decimal // ручные корректировки администраторами с учётом знака
+}
+
+// findDiscrepancy сравнивает баланс и сумму списаний пользователя с его операциями.
+// Возвращает nil, если расхождений нет.
+func findDiscrepancy(user *models.User, ledger balanceLedger) *BalanceDiscrepancy {
+	d := &BalanceDiscrepancy{
+		UserID:            user.ID,
+		Balance:           user.Balance,
+		ExpectedBalance:   ledger.accrued.Sub(ledger.withdrawn).Add(ledger.adjustments),
+		Withdrawn:         user.Withdrawn,
+		ExpectedWithdrawn: ledger.withdrawn,
+	}
+	if d.Balance.Equal(d.ExpectedBalance) && d.Withdrawn.Equal(d.ExpectedWithdrawn) {
+		return nil
+	}
+	return d
+}
+
+// reconcileSummary - итог одной сверки.
+type reconcileSummary struct {
+	checked    int // пользователей сверено
+	mismatched int // найдено расхождений
+	fixed      int // расхождений баланса исправлено
+	failed     int // сверка пользователя завершилась ошибкой
+}
+
+// reconcileAll сверяет всех пользователей по очереди и пишет итог в журнал. Ошибки отдельных
+// пользователей не прерывают сверку; ошибка возвращается, только если не удалось получить их список.
+func (w *ReconcileWorker) reconcileAll(ctx context.Context) (reconcileSummary, error) {
+	ids, err := w.userStorage.ListIDs(ctx)
+	if err != nil {
+		return reconcileSummary{}, fmt.Errorf("list users: %w", err)
+	}
+
+	var summary reconcileSummary
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			break
+		}
+		d, fixed, err := w.reconcileUser(ctx, id)
+		if err != nil {
+			summary.failed++
+			w.logger.Printf("reconcile balance of user %s error: %v", id, err)
+		}
+		summary.checked++
+		if d != nil {
+			summary.mismatched++
+		}
+		if fixed {
+			summary.fixed++
+		}
+	}
+
+	w.logSummary(summary)
+	return summary, nil
+}
+
+// reconcileUser сверяет баланс пользователя и при включённом autofix исправляет его.
+// Строка пользователя блокируется на время сверки, поэтому начисления и списания,
+// меняющие его баланс, дожидаются её окончания и не дают ложных расхождений.
+// Возвращает найденное расхождение (или nil) и признак того, что баланс исправлен.
+func (w *ReconcileWorker) reconcileUser(ctx context.Context, userID uuid.UUID) (*BalanceDiscrepancy, bool, error) {
+	tx, err := w.pool.Begin(ctx)
+	if err != nil {
+		return nil, false, storage.WrapInternal(err)
+	}
+	defer tx.Rollback(ctx)
+
+	user, err := w.userStorage.GetByIDForUpdateTx(ctx, tx, userID)
+	if err != nil {
+		return nil, false, fmt.Errorf("lock user: %w", err)
+	}
+	ledger, err := w.ledger(ctx, userID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	d := findDiscrepancy(user, ledger)
+	if d == nil {
+		return nil, false, nil
+	}
+	w.logDiscrepancy(d)
+
+	delta := d.BalanceDelta()
+	if !w.autofix || delta.IsZero() {
+		return d, false, nil
+	}
+
+	if err := w.userStorage.AdjustBalanceTx(ctx, tx, userID, delta); err != nil {
+		return d, false, fmt.Errorf("adjust balance: %w", err)
+	}
+	entry := &models.AuditEntry{
+		UserID: userID,
+		Reason: reconcileReason,
+		Kind:   models.AuditKindAdjustmentCredit,
+		Amount: delta.Abs(),
+	}
+	if delta.IsNegative() {
+		entry.Kind = models.AuditKindAdjustmentDebit
+	}
+	if err := w.audit.Record(ctx, tx, entry); err != nil {
+		return d, false, fmt.Errorf("record audit: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return d, false, storage.WrapInternal(err)
+	}
+	w.logger.Printf("balance of user %s corrected by %s: %s -> %s", userID, delta, d.Balance, d.ExpectedBalance)
+	return d, true, nil
+}
+
+// ledger читает суммы операций пользователя.
+func (w *ReconcileWorker) ledger(ctx context.Context, userID uuid.UUID) (balanceLedger, error) {
+	var (
+		ledger balanceLedger
+		err    error
+	)
+	if ledger.accrued, err = w.orderStorage.GetAccruedTotal(ctx, userID); err != nil {
+		return balanceLedger{}, fmt.Errorf("get accrued total: %w", err)
+	}
+	if ledger.withdrawn, err = w.withdrawalStorage.GetTotalByUser(ctx, userID); err != nil {
+		return balanceLedger{}, fmt.Errorf("get withdrawn total: %w", err)
+	}
+	if ledger.adjustments, err = w.audit.GetManualAdjustmentTotal(ctx, userID); err != nil {
+		return balanceLedger{}, fmt.Errorf("get adjustment total: %w", err)
+	}
+	return ledger, nil
+}
+
+// logDiscrepancy пишет найденное расхождение с уровнем Warn.
+func (w *ReconcileWorker) logDiscrepancy(d *BalanceDiscrepancy) {
+	w.discrepancyLogger.Warn("balance discrepancy",
+		slog.String("user_id", d.UserID.String()),
+		slog.String("balance", d.Balance.String()),
+		slog.String("expected_balance", d.ExpectedBalance.String()),
+		slog.String("withdrawn", d.Withdrawn.String()),
+		slog.String("expected_withdrawn", d.ExpectedWithdrawn.String()),
+		slog.Bool("autofix", w.autofix),
+	)
+}
+
+// logSummary пишет итог сверки; сверка с расхождениями или ошибками пишется с уровнем Warn.
+func (w *ReconcileWorker) logSummary(summary reconcileSummary) {
+	attrs := []any{
+		slog.Int("checked", summary.checked),
+		slog.Int("mismatched", summary.mismatched),
+		slog.Int("fixed", summary.fixed),
+		slog.Int("failed", summary.failed),
+	}
+	if summary.mismatched > 0 || summary.failed > 0 {
+		w.discrepancyLogger.Warn("balance reconciliation finished", attrs...)
+		return
+	}
+	w.discrepancyLogger.Info("balance reconciliation finished", attrs...)
+}
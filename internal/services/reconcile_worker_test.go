@@ -0,0 +1,340 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"log/slog"
+	"testing"
+
+	"github.com/agamariel/gofermart/internal/models"
+	"github.com/agamariel/gofermart/internal/storage"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+func TestFindDiscrepancy(t *testing.T) {
+	dec := decimal.RequireFromString
+
+	tests := []struct {
+		name          string
+		balance       string
+		withdrawn     string
+		ledger        balanceLedger
+		wantNil       bool
+		wantExpected  string
+		wantDelta     string
+		wantWithdrawn string
+	}{
+		{
+			name:    "consistent",
+			balance: "70", withdrawn: "30",
+			ledger:  balanceLedger{accrued: dec("100"), withdrawn: dec("30")},
+			wantNil: true,
+		},
+		{
+			name:    "manual adjustments are part of the expected balance",
+			balance: "95.50", withdrawn: "30",
+			ledger:  balanceLedger{accrued: dec("100"), withdrawn: dec("30"), adjustments: dec("25.50")},
+			wantNil: true,
+		},
+		{
+			name:    "new user without operations",
+			balance: "0", withdrawn: "0",
+			wantNil: true,
+		},
+		{
+			name:    "balance above operations",
+			balance: "120", withdrawn: "30",
+			ledger:       balanceLedger{accrued: dec("100"), withdrawn: dec("30")},
+			wantExpected: "70", wantDelta: "-50", wantWithdrawn: "30",
+		},
+		{
+			name:    "balance below operations",
+			balance: "60.01", withdrawn: "30",
+			ledger:       balanceLedger{accrued: dec("100"), withdrawn: dec("30"), adjustments: dec("-10")},
+			wantExpected: "60", wantDelta: "-0.01", wantWithdrawn: "30",
+		},
+		{
+			name:    "lost accrual",
+			balance: "0", withdrawn: "0",
+			ledger:       balanceLedger{accrued: dec("42.42")},
+			wantExpected: "42.42", wantDelta: "42.42", wantWithdrawn: "0",
+		},
+		{
+			name:    "withdrawn sum differs while balance matches",
+			balance: "70", withdrawn: "20",
+			ledger:       balanceLedger{accrued: dec("100"), withdrawn: dec("30")},
+			wantExpected: "70", wantDelta: "0", wantWithdrawn: "30",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &models.User{ID: uuid.New(), Balance: dec(tt.balance), Withdrawn: dec(tt.withdrawn)}
+
+			d := findDiscrepancy(user, tt.ledger)
+
+			if tt.wantNil {
+				if d != nil {
+					t.Fatalf("findDiscrepancy() = %+v, want nil", d)
+				}
+				return
+			}
+			if d == nil {
+				t.Fatal("findDiscrepancy() = nil, want discrepancy")
+			}
+			if d.UserID != user.ID {
+				t.Errorf("UserID = %s, want %s", d.UserID, user.ID)
+			}
+			if !d.ExpectedBalance.Equal(dec(tt.wantExpected)) {
+				t.Errorf("ExpectedBalance = %s, want %s", d.ExpectedBalance, tt.wantExpected)
+			}
+			if !d.BalanceDelta().Equal(dec(tt.wantDelta)) {
+				t.Errorf("BalanceDelta() = %s, want %s", d.BalanceDelta(), tt.wantDelta)
+			}
+			if !d.ExpectedWithdrawn.Equal(dec(tt.wantWithdrawn)) {
+				t.Errorf("ExpectedWithdrawn = %s, want %s", d.ExpectedWithdrawn, tt.wantWithdrawn)
+			}
+		})
+	}
+}
+
+// reconcileFixture - хранилища в памяти для сверки балансов.
+type reconcileFixture struct {
+	users       *storage.InMemoryUserStorage
+	orders      *storage.InMemoryOrderStorage
+	withdrawals *storage.InMemoryWithdrawalStorage
+	audit       *storage.InMemoryAuditStorage
+}
+
+func newReconcileFixture() *reconcileFixture {
+	users := storage.NewInMemoryUserStorage()
+	return &reconcileFixture{
+		users:       users,
+		orders:      storage.NewInMemoryOrderStorage(),
+		withdrawals: storage.NewInMemoryWithdrawalStorage(),
+		audit:       storage.NewInMemoryAuditStorage(users),
+	}
+}
+
+func (f *reconcileFixture) worker(opts ...ReconcileOption) *ReconcileWorker {
+	opts = append([]ReconcileOption{WithDiscrepancyLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))}, opts...)
+	return NewReconcileWorker(storage.NewMemoryTxBeginner(), f.users, f.orders, f.withdrawals, f.audit,
+		0, log.New(io.Discard, "", 0), opts...)
+}
+
+// addUser создаёт пользователя с заданными балансом и суммой списаний, обработанным заказом
+// на accrued и списанием на withdrawalSum.
+func (f *reconcileFixture) addUser(t *testing.T, login, balance, withdrawn, accrued, withdrawalSum string) uuid.UUID {
+	t.Helper()
+	ctx := context.Background()
+
+	user := &models.User{
+		Login:     login,
+		Balance:   decimal.RequireFromString(balance),
+		Withdrawn: decimal.RequireFromString(withdrawn),
+	}
+	if err := f.users.Create(ctx, user); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	number := "order-" + login
+	if err := f.orders.Create(ctx, &models.Order{Number: number, UserID: user.ID, Status: models.OrderStatusNew}); err != nil {
+		t.Fatalf("Create order error = %v", err)
+	}
+	amount := decimal.RequireFromString(accrued)
+	if err := f.orders.UpdateStatus(ctx, number, models.OrderStatusProcessed, &amount); err != nil {
+		t.Fatalf("UpdateStatus() error = %v", err)
+	}
+
+	sum := decimal.RequireFromString(withdrawalSum)
+	if sum.IsPositive() {
+		if err := f.withdrawals.Create(ctx, &models.Withdrawal{UserID: user.ID, OrderNumber: "w-" + login, Sum: sum}); err != nil {
+			t.Fatalf("Create withdrawal error = %v", err)
+		}
+	}
+	return user.ID
+}
+
+func TestReconcileWorker_ReconcileAll(t *testing.T) {
+	ctx := context.Background()
+
+	// setup создаёт трёх пользователей: с согласованным балансом (в том числе после ручной
+	// корректировки), с лишними 15 баллами и с расходящейся только суммой списаний.
+	setup := func(t *testing.T) (f *reconcileFixture, consistent, excess, withdrawnOnly uuid.UUID) {
+		t.Helper()
+		f = newReconcileFixture()
+		consistent = f.addUser(t, "consistent", "70", "30", "100", "30")
+		excess = f.addUser(t, "excess", "85", "30", "100", "30")
+		withdrawnOnly = f.addUser(t, "withdrawn", "70", "20", "100", "30")
+
+		adminID := uuid.New()
+		if err := f.users.AdjustBalanceTx(ctx, nil, consistent, decimal.NewFromInt(10)); err != nil {
+			t.Fatalf("AdjustBalanceTx() error = %v", err)
+		}
+		entry := &models.AuditEntry{UserID: consistent, AdminID: &adminID, Reason: "bonus", Kind: models.AuditKindAdjustmentCredit, Amount: decimal.NewFromInt(10)}
+		if err := f.audit.Record(ctx, nil, entry); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+		return f, consistent, excess, withdrawnOnly
+	}
+
+	balanceOf := func(t *testing.T, f *reconcileFixture, id uuid.UUID) string {
+		t.Helper()
+		user, err := f.users.GetByID(ctx, id)
+		if err != nil {
+			t.Fatalf("GetByID() error = %v", err)
+		}
+		return user.Balance.String()
+	}
+
+	t.Run("reports discrepancies without changing balances", func(t *testing.T) {
+		f, consistent, excess, withdrawnOnly := setup(t)
+
+		summary, err := f.worker().reconcileAll(ctx)
+		if err != nil {
+			t.Fatalf("reconcileAll() error = %v", err)
+		}
+
+		want := reconcileSummary{checked: 3, mismatched: 2}
+		if summary != want {
+			t.Errorf("summary = %+v, want %+v", summary, want)
+		}
+		for id, wantBalance := range map[uuid.UUID]string{consistent: "80", excess: "85", withdrawnOnly: "70"} {
+			if got := balanceOf(t, f, id); got != wantBalance {
+				t.Errorf("balance of %s = %s, want %s", id, got, wantBalance)
+			}
+		}
+		entries, _ := f.audit.GetByUserID(ctx, excess)
+		if len(entries) != 0 {
+			t.Errorf("audit entries = %d, want 0 without autofix", len(entries))
+		}
+	})
+
+	t.Run("autofix corrects the balance with an audit entry", func(t *testing.T) {
+		f, consistent, excess, withdrawnOnly := setup(t)
+		w := f.worker(WithReconcileAutofix())
+
+		summary, err := w.reconcileAll(ctx)
+		if err != nil {
+			t.Fatalf("reconcileAll() error = %v", err)
+		}
+
+		want := reconcileSummary{checked: 3, mismatched: 2, fixed: 1}
+		if summary != want {
+			t.Errorf("summary = %+v, want %+v", summary, want)
+		}
+		if got := balanceOf(t, f, excess); got != "70" {
+			t.Errorf("corrected balance = %s, want 70", got)
+		}
+		if got := balanceOf(t, f, consistent); got != "80" {
+			t.Errorf("consistent balance = %s, want 80", got)
+		}
+		if got := balanceOf(t, f, withdrawnOnly); got != "70" {
+			t.Errorf("balance with withdrawn mismatch = %s, want 70", got)
+		}
+
+		entries, err := f.audit.GetByUserID(ctx, excess)
+		if err != nil {
+			t.Fatalf("GetByUserID() error = %v", err)
+		}
+		if len(entries) != 1 {
+			t.Fatalf("audit entries = %d, want 1", len(entries))
+		}
+		e := entries[0]
+		if e.Kind != models.AuditKindAdjustmentDebit || !e.Amount.Equal(decimal.NewFromInt(15)) {
+			t.Errorf("audit entry = %s %s, want ADJUSTMENT_DEBIT 15", e.Kind, e.Amount)
+		}
+		if e.AdminID != nil || e.Reason != reconcileReason {
+			t.Errorf("audit entry admin = %v, reason = %q, want automatic reconciliation", e.AdminID, e.Reason)
+		}
+		if e.BalanceBefore.String() != "85" || e.BalanceAfter.String() != "70" {
+			t.Errorf("audit balances = %s -> %s, want 85 -> 70", e.BalanceBefore, e.BalanceAfter)
+		}
+
+		// Автоматическая корректировка не входит в ожидаемый баланс: повторная сверка
+		// не находит расхождения баланса и не исправляет его снова.
+		summary, err = w.reconcileAll(ctx)
+		if err != nil {
+			t.Fatalf("second reconcileAll() error = %v", err)
+		}
+		want = reconcileSummary{checked: 3, mismatched: 1}
+		if summary != want {
+			t.Errorf("second summary = %+v, want %+v", summary, want)
+		}
+	})
+
+	t.Run("autofix that would make the balance negative fails", func(t *testing.T) {
+		f := newReconcileFixture()
+		id := f.addUser(t, "overdrawn", "10", "130", "100", "130")
+
+		summary, err := f.worker(WithReconcileAutofix()).reconcileAll(ctx)
+		if err != nil {
+			t.Fatalf("reconcileAll() error = %v", err)
+		}
+
+		want := reconcileSummary{checked: 1, mismatched: 1, failed: 1}
+		if summary != want {
+			t.Errorf("summary = %+v, want %+v", summary, want)
+		}
+		if got := balanceOf(t, f, id); got != "10" {
+			t.Errorf("balance = %s, want 10", got)
+		}
+		if entries, _ := f.audit.GetByUserID(ctx, id); len(entries) != 0 {
+			t.Errorf("audit entries = %d, want 0", len(entries))
+		}
+	})
+}
+
+func TestReconcileWorker_Errors(t *testing.T) {
+	ctx := context.Background()
+	errDB := errors.New("db down")
+	quiet := WithDiscrepancyLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	t.Run("listing users fails", func(t *testing.T) {
+		users := &storage.MockUserStorage{
+			ListIDsFunc: func(ctx context.Context) ([]uuid.UUID, error) { return nil, errDB },
+		}
+		w := NewReconcileWorker(storage.NewMemoryTxBeginner(), users, &mockOrderStorage{}, storage.NewInMemoryWithdrawalStorage(),
+			&storage.MockAuditStorage{}, 0, log.New(io.Discard, "", 0), quiet)
+
+		if _, err := w.reconcileAll(ctx); !errors.Is(err, errDB) {
+			t.Errorf("reconcileAll() error = %v, want %v", err, errDB)
+		}
+	})
+
+	t.Run("failed user does not stop reconciliation", func(t *testing.T) {
+		broken, healthy := uuid.New(), uuid.New()
+		users := &storage.MockUserStorage{
+			ListIDsFunc: func(ctx context.Context) ([]uuid.UUID, error) { return []uuid.UUID{broken, healthy}, nil },
+			GetByIDFunc: func(ctx context.Context, id uuid.UUID) (*models.User, error) {
+				return &models.User{ID: id}, nil
+			},
+		}
+		audit := &storage.MockAuditStorage{
+			GetManualAdjustmentTotalFunc: func(ctx context.Context, userID uuid.UUID) (decimal.Decimal, error) {
+				if userID == broken {
+					return decimal.Zero, errDB
+				}
+				return decimal.Zero, nil
+			},
+		}
+		tx := &fakeTx{}
+		w := NewReconcileWorker(&fakeBeginner{tx: tx}, users, &mockOrderStorage{}, storage.NewInMemoryWithdrawalStorage(),
+			audit, 0, log.New(io.Discard, "", 0), quiet)
+
+		summary, err := w.reconcileAll(ctx)
+		if err != nil {
+			t.Fatalf("reconcileAll() error = %v", err)
+		}
+		want := reconcileSummary{checked: 2, failed: 1}
+		if summary != want {
+			t.Errorf("summary = %+v, want %+v", summary, want)
+		}
+		if !tx.rolledBack {
+			t.Error("transaction is not rolled back")
+		}
+	})
+}
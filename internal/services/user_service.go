@@ -4,6 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/agamariel/gofermart/internal/auth"
@@ -15,29 +18,150 @@ import (
 var (
 	ErrInvalidCredentials = errors.New("invalid credentials")
 	ErrEmptyCredentials   = errors.New("login and password are required")
+	ErrAccountDeactivated = errors.New("account is deactivated")
+	ErrAccountLocked      = errors.New("account is temporarily locked")
+	// ErrInvalidTokenExpiration - время жизни токена должно быть положительным.
+	ErrInvalidTokenExpiration = errors.New("token expiration must be positive")
 )
 
+// AccountLockedError - вход заблокирован после серии неудачных попыток до момента Until.
+// Удовлетворяет errors.Is(err, ErrAccountLocked).
+type AccountLockedError struct {
+	Until time.Time
+}
+
+func (e *AccountLockedError) Error() string {
+	return fmt.Sprintf("%s until %s", ErrAccountLocked, e.Until.Format(time.RFC3339))
+}
+
+func (e *AccountLockedError) Unwrap() error {
+	return ErrAccountLocked
+}
+
 // UserService определяет интерфейс для работы с пользователями.
 type UserService interface {
 	Register(ctx context.Context, login, password string) (*models.User, string, error)
 	Login(ctx context.Context, login, password string) (*models.User, string, error)
 	GetBalance(ctx context.Context, userID uuid.UUID) (*models.User, error)
+	ChangePassword(ctx context.Context, userID uuid.UUID, oldPassword, newPassword string) error
+	Deactivate(ctx context.Context, userID uuid.UUID) error
+}
+
+// TokenExpirationService позволяет менять время жизни выпускаемых токенов без перезапуска.
+type TokenExpirationService interface {
+	TokenExpiration() time.Duration
+	SetTokenExpiration(exp time.Duration) error
 }
 
 // UserServiceImpl реализует UserService.
 type UserServiceImpl struct {
-	userStorage     UserStorage
-	jwtSecret       string
-	tokenExpiration time.Duration
+	userStorage UserStorage
+	jwtSecret   string
+	// tokenExpiration хранит time.Duration и читается при выпуске каждого токена,
+	// поэтому SetTokenExpiration действует на следующие токены без перезапуска.
+	tokenExpiration atomic.Int64
+
+	// Логины приводятся к нижнему регистру перед обращением к хранилищу.
+	caseInsensitiveLogin bool
+	// tokenOpts задают issuer и audience выпускаемых токенов.
+	tokenOpts []auth.TokenOption
+	// После maxLoginAttempts неудачных попыток подряд вход блокируется на loginLockFor.
+	// Ноль отключает блокировку.
+	maxLoginAttempts int
+	loginLockFor     time.Duration
+	// bcryptCost - стоимость bcrypt для новых хешей паролей; ноль - auth.DefaultBcryptCost.
+	bcryptCost int
+}
+
+// UserServiceOption настраивает UserServiceImpl.
+type UserServiceOption func(*UserServiceImpl)
+
+// WithCaseInsensitiveLogin включает регистронезависимые логины: Test@Example.com и
+// test@example.com считаются одним логином при регистрации и входе.
+func WithCaseInsensitiveLogin() UserServiceOption {
+	return func(s *UserServiceImpl) {
+		s.caseInsensitiveLogin = true
+	}
+}
+
+// WithTokenOptions задаёт дополнительные claims выпускаемых токенов (issuer, audience).
+func WithTokenOptions(opts ...auth.TokenOption) UserServiceOption {
+	return func(s *UserServiceImpl) {
+		s.tokenOpts = append(s.tokenOpts, opts...)
+	}
+}
+
+// WithLoginLockout блокирует вход на lockFor после maxAttempts неудачных попыток подряд.
+// Счётчик хранится в БД, поэтому блокировка переживает перезапуск сервиса.
+func WithLoginLockout(maxAttempts int, lockFor time.Duration) UserServiceOption {
+	return func(s *UserServiceImpl) {
+		if maxAttempts > 0 && lockFor > 0 {
+			s.maxLoginAttempts = maxAttempts
+			s.loginLockFor = lockFor
+		}
+	}
+}
+
+// WithBcryptCost задаёт стоимость bcrypt для новых хешей паролей. Хеши с меньшей стоимостью
+// пересчитываются при следующем успешном входе пользователя.
+func WithBcryptCost(cost int) UserServiceOption {
+	return func(s *UserServiceImpl) {
+		if cost > 0 {
+			s.bcryptCost = cost
+		}
+	}
 }
 
 // NewUserService создаёт новый экземпляр UserService.
-func NewUserService(userStorage UserStorage, jwtSecret string, tokenExpiration time.Duration) *UserServiceImpl {
-	return &UserServiceImpl{
-		userStorage:     userStorage,
-		jwtSecret:       jwtSecret,
-		tokenExpiration: tokenExpiration,
+func NewUserService(userStorage UserStorage, jwtSecret string, tokenExpiration time.Duration, opts ...UserServiceOption) *UserServiceImpl {
+	s := &UserServiceImpl{
+		userStorage: userStorage,
+		jwtSecret:   jwtSecret,
+	}
+	s.tokenExpiration.Store(int64(tokenExpiration))
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// TokenExpiration возвращает время жизни выпускаемых токенов.
+func (s *UserServiceImpl) TokenExpiration() time.Duration {
+	if exp := time.Duration(s.tokenExpiration.Load()); exp > 0 {
+		return exp
+	}
+	return 24 * time.Hour
+}
+
+// SetTokenExpiration меняет время жизни токенов, выпускаемых после вызова.
+// Уже выданные токены сохраняют прежний срок действия.
+func (s *UserServiceImpl) SetTokenExpiration(exp time.Duration) error {
+	if exp <= 0 {
+		return ErrInvalidTokenExpiration
+	}
+	s.tokenExpiration.Store(int64(exp))
+	return nil
+}
+
+// hashPassword хеширует пароль с настроенной стоимостью bcrypt.
+func (s *UserServiceImpl) hashPassword(password string) (string, error) {
+	return auth.HashPasswordWithCost(password, s.passwordCost())
+}
+
+// passwordCost возвращает стоимость bcrypt для новых хешей.
+func (s *UserServiceImpl) passwordCost() int {
+	if s.bcryptCost > 0 {
+		return s.bcryptCost
 	}
+	return auth.DefaultBcryptCost
+}
+
+// normalizeLogin приводит логин к виду, в котором он хранится.
+func (s *UserServiceImpl) normalizeLogin(login string) string {
+	if s.caseInsensitiveLogin {
+		return strings.ToLower(login)
+	}
+	return login
 }
 
 // Register регистрирует нового пользователя.
@@ -45,8 +169,17 @@ func (s *UserServiceImpl) Register(ctx context.Context, login, password string)
 	if login == "" || password == "" {
 		return nil, "", ErrEmptyCredentials
 	}
+	login = s.normalizeLogin(login)
+
+	// Занятый логин отсекаем до дорогого bcrypt. Одновременные регистрации
+	// могут пройти эту проверку обе - проигравшую отклонит ограничение уникальности в БД.
+	if _, err := s.userStorage.GetByLogin(ctx, login); err == nil {
+		return nil, "", storage.ErrLoginExists
+	} else if !errors.Is(err, storage.ErrUserNotFound) {
+		return nil, "", fmt.Errorf("failed to check login: %w", err)
+	}
 
-	passwordHash, err := auth.HashPassword(password)
+	passwordHash, err := s.hashPassword(password)
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to hash password: %w", err)
 	}
@@ -78,6 +211,7 @@ func (s *UserServiceImpl) Login(ctx context.Context, login, password string) (*m
 	if login == "" || password == "" {
 		return nil, "", ErrEmptyCredentials
 	}
+	login = s.normalizeLogin(login)
 
 	user, err := s.userStorage.GetByLogin(ctx, login)
 	if err != nil {
@@ -87,10 +221,28 @@ func (s *UserServiceImpl) Login(ctx context.Context, login, password string) (*m
 		return nil, "", fmt.Errorf("failed to get user: %w", err)
 	}
 
+	// Заблокированный аккаунт отклоняем до проверки пароля, чтобы перебор не продолжался
+	if s.maxLoginAttempts > 0 && user.IsLocked(time.Now()) {
+		return nil, "", &AccountLockedError{Until: *user.LockedUntil}
+	}
+
 	if !auth.CheckPassword(password, user.PasswordHash) {
-		return nil, "", ErrInvalidCredentials
+		return nil, "", s.registerLoginFailure(ctx, user)
 	}
 
+	// Статус аккаунта раскрываем только после проверки пароля
+	if user.IsDeactivated() {
+		return nil, "", ErrAccountDeactivated
+	}
+
+	if s.maxLoginAttempts > 0 && (user.FailedLoginAttempts > 0 || user.LockedUntil != nil) {
+		if err := s.userStorage.ResetLoginFailures(ctx, user.ID); err != nil {
+			return nil, "", fmt.Errorf("failed to reset login failures: %w", err)
+		}
+	}
+
+	s.rehashPassword(ctx, user, password)
+
 	token, err := s.generateToken(user)
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to generate token: %w", err)
@@ -99,6 +251,42 @@ func (s *UserServiceImpl) Login(ctx context.Context, login, password string) (*m
 	return user, token, nil
 }
 
+// rehashPassword пересчитывает хеш пароля, созданный со стоимостью bcrypt ниже настроенной.
+// Вызывается после успешной проверки пароля, пока он известен в открытом виде. Ошибка
+// не прерывает вход: хеш будет пересчитан при следующем входе.
+func (s *UserServiceImpl) rehashPassword(ctx context.Context, user *models.User, password string) {
+	if !auth.NeedsRehash(user.PasswordHash, s.passwordCost()) {
+		return
+	}
+
+	passwordHash, err := s.hashPassword(password)
+	if err == nil {
+		err = s.userStorage.UpdatePasswordHash(ctx, user.ID, passwordHash)
+	}
+	if err != nil {
+		log.Printf("WARNING: failed to rehash password of user %s: %v", user.ID, err)
+		return
+	}
+	user.PasswordHash = passwordHash
+}
+
+// registerLoginFailure учитывает неудачную попытку входа и возвращает ошибку для клиента:
+// ErrInvalidCredentials или AccountLockedError, если попытка исчерпала лимит.
+func (s *UserServiceImpl) registerLoginFailure(ctx context.Context, user *models.User) error {
+	if s.maxLoginAttempts == 0 {
+		return ErrInvalidCredentials
+	}
+
+	lockedUntil, err := s.userStorage.RegisterLoginFailure(ctx, user.ID, s.maxLoginAttempts, s.loginLockFor)
+	if err != nil {
+		return fmt.Errorf("failed to register login failure: %w", err)
+	}
+	if lockedUntil != nil {
+		return &AccountLockedError{Until: *lockedUntil}
+	}
+	return ErrInvalidCredentials
+}
+
 // GetBalance возвращает баланс пользователя.
 func (s *UserServiceImpl) GetBalance(ctx context.Context, userID uuid.UUID) (*models.User, error) {
 	user, err := s.userStorage.GetByID(ctx, userID)
@@ -112,13 +300,50 @@ func (s *UserServiceImpl) GetBalance(ctx context.Context, userID uuid.UUID) (*mo
 	return user, nil
 }
 
+// ChangePassword меняет пароль пользователя после проверки текущего.
+func (s *UserServiceImpl) ChangePassword(ctx context.Context, userID uuid.UUID, oldPassword, newPassword string) error {
+	user, err := s.userStorage.GetByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return storage.ErrUserNotFound
+		}
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+
+	if !auth.CheckPassword(oldPassword, user.PasswordHash) {
+		return ErrInvalidCredentials
+	}
+
+	if err := auth.ValidatePasswordPolicy(newPassword); err != nil {
+		return err
+	}
+
+	passwordHash, err := s.hashPassword(newPassword)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	if err := s.userStorage.UpdatePasswordHash(ctx, userID, passwordHash); err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+
+	return nil
+}
+
+// Deactivate деактивирует аккаунт пользователя. Данные пользователя не удаляются.
+func (s *UserServiceImpl) Deactivate(ctx context.Context, userID uuid.UUID) error {
+	if err := s.userStorage.Deactivate(ctx, userID); err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return storage.ErrUserNotFound
+		}
+		return fmt.Errorf("failed to deactivate user: %w", err)
+	}
+	return nil
+}
+
 // generateToken генерирует JWT токен для пользователя.
 func (s *UserServiceImpl) generateToken(user *models.User) (string, error) {
-	exp := s.tokenExpiration
-	if exp <= 0 {
-		exp = 24 * time.Hour
-	}
-	token, err := auth.GenerateToken(user, s.jwtSecret, exp)
+	token, err := auth.GenerateToken(user, s.jwtSecret, s.TokenExpiration(), s.tokenOpts...)
 	if err != nil {
 		return "", err
 	}
@@ -3,6 +3,7 @@ package services
 import (
 	"context"
 	"errors"
+	"sync"
 	"testing"
 	"time"
 
@@ -11,6 +12,7 @@ import (
 	"github.com/agamariel/gofermart/internal/storage"
 	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestUserServiceImpl_Register(t *testing.T) {
@@ -357,3 +359,526 @@ func TestUserServiceImpl_RegisterHashesPassword(t *testing.T) {
 		t.Error("Register() stored empty password hash")
 	}
 }
+
+func TestUserServiceImpl_LoginRehashesPassword(t *testing.T) {
+	ctx := context.Background()
+	password := "testpassword123"
+
+	// Минимальные стоимости bcrypt, чтобы тест оставался быстрым
+	oldHash, err := auth.HashPasswordWithCost(password, bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("HashPasswordWithCost() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		cost       int
+		wantRehash bool
+		updateErr  error
+	}{
+		{name: "cost increased", cost: bcrypt.MinCost + 1, wantRehash: true},
+		{name: "cost equal", cost: bcrypt.MinCost, wantRehash: false},
+		{name: "cost decreased is kept", cost: bcrypt.MinCost - 1, wantRehash: false},
+		{name: "update failure does not fail login", cost: bcrypt.MinCost + 1, wantRehash: true, updateErr: errors.New("db error")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &models.User{ID: uuid.New(), Login: "user", PasswordHash: oldHash}
+			var updatedHash string
+			mockStorage := &storage.MockUserStorage{
+				GetByLoginFunc: func(ctx context.Context, login string) (*models.User, error) {
+					u := *user
+					return &u, nil
+				},
+				UpdatePasswordHashFunc: func(ctx context.Context, id uuid.UUID, hash string) error {
+					if id != user.ID {
+						t.Errorf("UpdatePasswordHash() id = %s, want %s", id, user.ID)
+					}
+					updatedHash = hash
+					return tt.updateErr
+				},
+			}
+
+			svc := NewUserService(mockStorage, "test-secret", time.Hour, WithBcryptCost(tt.cost))
+			loggedIn, token, err := svc.Login(ctx, user.Login, password)
+			if err != nil || token == "" {
+				t.Fatalf("Login() = %q, %v; want a token", token, err)
+			}
+			if loggedIn.ID != user.ID {
+				t.Errorf("Login() user = %s, want %s", loggedIn.ID, user.ID)
+			}
+
+			if !tt.wantRehash {
+				if updatedHash != "" {
+					t.Errorf("UpdatePasswordHash() called for cost %d", tt.cost)
+				}
+				return
+			}
+			if updatedHash == "" {
+				t.Fatal("UpdatePasswordHash() was not called")
+			}
+			if cost, err := bcrypt.Cost([]byte(updatedHash)); err != nil || cost != tt.cost {
+				t.Errorf("new hash cost = %d (%v), want %d", cost, err, tt.cost)
+			}
+			if !auth.CheckPassword(password, updatedHash) {
+				t.Error("new hash does not match the password")
+			}
+		})
+	}
+}
+
+func TestUserServiceImpl_ChangePassword(t *testing.T) {
+	ctx := context.Background()
+	oldPassword := "old-password"
+
+	hash, err := auth.HashPassword(oldPassword)
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	user := &models.User{ID: uuid.New(), Login: "test@example.com", PasswordHash: hash}
+
+	tests := []struct {
+		name        string
+		oldPassword string
+		newPassword string
+		wantErr     error
+		wantUpdate  bool
+	}{
+		{name: "success", oldPassword: oldPassword, newPassword: "new-password", wantUpdate: true},
+		{name: "wrong old password", oldPassword: "wrong-password", newPassword: "new-password", wantErr: ErrInvalidCredentials},
+		{name: "weak new password", oldPassword: oldPassword, newPassword: "short", wantErr: auth.ErrWeakPassword},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var storedHash string
+			mockStorage := &storage.MockUserStorage{
+				GetByIDFunc: func(ctx context.Context, id uuid.UUID) (*models.User, error) {
+					return user, nil
+				},
+				UpdatePasswordHashFunc: func(ctx context.Context, id uuid.UUID, hash string) error {
+					storedHash = hash
+					return nil
+				},
+			}
+
+			service := NewUserService(mockStorage, "test-secret", time.Hour)
+			err := service.ChangePassword(ctx, user.ID, tt.oldPassword, tt.newPassword)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ChangePassword() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if (storedHash != "") != tt.wantUpdate {
+				t.Fatalf("password updated = %v, want %v", storedHash != "", tt.wantUpdate)
+			}
+			if tt.wantUpdate && !auth.CheckPassword(tt.newPassword, storedHash) {
+				t.Error("stored hash does not match the new password")
+			}
+		})
+	}
+
+	t.Run("user not found", func(t *testing.T) {
+		service := NewUserService(&storage.MockUserStorage{}, "test-secret", time.Hour)
+		if err := service.ChangePassword(ctx, uuid.New(), oldPassword, "new-password"); !errors.Is(err, storage.ErrUserNotFound) {
+			t.Fatalf("expected ErrUserNotFound, got %v", err)
+		}
+	})
+}
+
+func TestUserServiceImpl_DeactivateThenLogin(t *testing.T) {
+	ctx := context.Background()
+	password := "password123"
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	user := &models.User{ID: uuid.New(), Login: "test@example.com", PasswordHash: hash}
+
+	mockStorage := &storage.MockUserStorage{
+		GetByLoginFunc: func(ctx context.Context, login string) (*models.User, error) {
+			return user, nil
+		},
+		DeactivateFunc: func(ctx context.Context, id uuid.UUID) error {
+			now := time.Now()
+			user.DeactivatedAt = &now
+			return nil
+		},
+	}
+	service := NewUserService(mockStorage, "test-secret", time.Hour)
+
+	if _, _, err := service.Login(ctx, user.Login, password); err != nil {
+		t.Fatalf("Login() before deactivation error = %v", err)
+	}
+
+	if err := service.Deactivate(ctx, user.ID); err != nil {
+		t.Fatalf("Deactivate() error = %v", err)
+	}
+
+	if _, _, err := service.Login(ctx, user.Login, password); !errors.Is(err, ErrAccountDeactivated) {
+		t.Fatalf("Login() after deactivation error = %v, want ErrAccountDeactivated", err)
+	}
+
+	// Неверный пароль не раскрывает статус аккаунта
+	if _, _, err := service.Login(ctx, user.Login, "wrong-password"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Login() with wrong password error = %v, want ErrInvalidCredentials", err)
+	}
+}
+
+func TestUserServiceImpl_CaseInsensitiveLogin(t *testing.T) {
+	ctx := context.Background()
+
+	// newStorage хранит пользователей в памяти и сравнивает логины точно, как Postgres
+	newStorage := func() *storage.MockUserStorage {
+		users := make(map[string]*models.User)
+		return &storage.MockUserStorage{
+			CreateFunc: func(ctx context.Context, user *models.User) error {
+				if _, ok := users[user.Login]; ok {
+					return storage.ErrLoginExists
+				}
+				users[user.Login] = user
+				return nil
+			},
+			GetByLoginFunc: func(ctx context.Context, login string) (*models.User, error) {
+				if user, ok := users[login]; ok {
+					return user, nil
+				}
+				return nil, storage.ErrUserNotFound
+			},
+		}
+	}
+
+	t.Run("enabled", func(t *testing.T) {
+		svc := NewUserService(newStorage(), "test-secret", time.Hour, WithCaseInsensitiveLogin())
+
+		registered, _, err := svc.Register(ctx, "Test@Example.com", "password123")
+		if err != nil {
+			t.Fatalf("Register() error = %v", err)
+		}
+		if registered.Login != "test@example.com" {
+			t.Errorf("stored login = %q, want %q", registered.Login, "test@example.com")
+		}
+
+		for _, login := range []string{"test@example.com", "TEST@EXAMPLE.COM", "Test@Example.com"} {
+			user, _, err := svc.Login(ctx, login, "password123")
+			if err != nil {
+				t.Errorf("Login(%q) error = %v", login, err)
+				continue
+			}
+			if user.ID != registered.ID {
+				t.Errorf("Login(%q) returned another user", login)
+			}
+		}
+
+		if _, _, err := svc.Register(ctx, "test@EXAMPLE.com", "password123"); !errors.Is(err, storage.ErrLoginExists) {
+			t.Errorf("second Register() error = %v, want %v", err, storage.ErrLoginExists)
+		}
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		svc := NewUserService(newStorage(), "test-secret", time.Hour)
+
+		if _, _, err := svc.Register(ctx, "Test@Example.com", "password123"); err != nil {
+			t.Fatalf("Register() error = %v", err)
+		}
+		if _, _, err := svc.Login(ctx, "test@example.com", "password123"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("Login() error = %v, want %v", err, ErrInvalidCredentials)
+		}
+		if _, _, err := svc.Register(ctx, "test@example.com", "password123"); err != nil {
+			t.Errorf("Register() with other case error = %v, want nil", err)
+		}
+	})
+}
+
+func TestUserServiceImpl_RegisterExistingLoginSkipsHashing(t *testing.T) {
+	var created bool
+	userStorage := &storage.MockUserStorage{
+		GetByLoginFunc: func(ctx context.Context, login string) (*models.User, error) {
+			return &models.User{ID: uuid.New(), Login: login}, nil
+		},
+		CreateFunc: func(ctx context.Context, user *models.User) error {
+			created = true
+			return nil
+		},
+	}
+
+	svc := NewUserService(userStorage, "test-secret", time.Hour)
+	user, token, err := svc.Register(context.Background(), "taken@example.com", "password123")
+
+	if !errors.Is(err, storage.ErrLoginExists) {
+		t.Fatalf("Register() error = %v, want %v", err, storage.ErrLoginExists)
+	}
+	if user != nil || token != "" {
+		t.Errorf("Register() returned user = %v, token = %q on conflict", user, token)
+	}
+	if created {
+		t.Error("Create must not be called for a taken login")
+	}
+}
+
+func TestUserServiceImpl_RegisterConcurrentSameLogin(t *testing.T) {
+	const racers = 2
+
+	// Оба запроса проходят предварительную проверку до того, как кто-то из них создаст пользователя;
+	// уникальность, как и в БД, обеспечивает только Create.
+	var (
+		mu      sync.Mutex
+		users   = make(map[string]*models.User)
+		checked sync.WaitGroup
+	)
+	checked.Add(racers)
+	userStorage := &storage.MockUserStorage{
+		GetByLoginFunc: func(ctx context.Context, login string) (*models.User, error) {
+			mu.Lock()
+			_, exists := users[login]
+			mu.Unlock()
+
+			checked.Done()
+			checked.Wait()
+
+			if exists {
+				return &models.User{Login: login}, nil
+			}
+			return nil, storage.ErrUserNotFound
+		},
+		CreateFunc: func(ctx context.Context, user *models.User) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if _, ok := users[user.Login]; ok {
+				return storage.ErrLoginExists
+			}
+			users[user.Login] = user
+			return nil
+		},
+	}
+	svc := NewUserService(userStorage, "test-secret", time.Hour)
+
+	type result struct {
+		user  *models.User
+		token string
+		err   error
+	}
+	results := make(chan result, racers)
+	for i := 0; i < racers; i++ {
+		go func() {
+			user, token, err := svc.Register(context.Background(), "race@example.com", "password123")
+			results <- result{user: user, token: token, err: err}
+		}()
+	}
+
+	var winners, losers int
+	for i := 0; i < racers; i++ {
+		r := <-results
+		switch {
+		case r.err == nil:
+			winners++
+			if r.token == "" {
+				t.Error("winner must receive a token")
+			}
+		case errors.Is(r.err, storage.ErrLoginExists):
+			losers++
+			if r.user != nil || r.token != "" {
+				t.Errorf("loser received user = %v, token = %q", r.user, r.token)
+			}
+		default:
+			t.Errorf("unexpected error: %v", r.err)
+		}
+	}
+
+	if winners != 1 || losers != racers-1 {
+		t.Errorf("winners = %d, losers = %d; want 1 and %d", winners, losers, racers-1)
+	}
+}
+
+func TestUserServiceImpl_LoginLockout(t *testing.T) {
+	ctx := context.Background()
+	password := "password123"
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+
+	const maxAttempts = 3
+	newStorage := func(user *models.User) (*storage.MockUserStorage, *int) {
+		var failures int
+		// Мок повторяет логику UPDATE в PostgresUserStorage.RegisterLoginFailure
+		return &storage.MockUserStorage{
+			GetByLoginFunc: func(ctx context.Context, login string) (*models.User, error) {
+				copied := *user
+				return &copied, nil
+			},
+			RegisterLoginFailureFunc: func(ctx context.Context, id uuid.UUID, max int, lockFor time.Duration) (*time.Time, error) {
+				failures++
+				user.FailedLoginAttempts++
+				if user.FailedLoginAttempts < max {
+					return nil, nil
+				}
+				until := time.Now().Add(lockFor)
+				user.FailedLoginAttempts = 0
+				user.LockedUntil = &until
+				return &until, nil
+			},
+			ResetLoginFailuresFunc: func(ctx context.Context, id uuid.UUID) error {
+				user.FailedLoginAttempts = 0
+				user.LockedUntil = nil
+				return nil
+			},
+		}, &failures
+	}
+
+	t.Run("locks after max attempts", func(t *testing.T) {
+		user := &models.User{ID: uuid.New(), Login: "lock@example.com", PasswordHash: hash}
+		mockStorage, failures := newStorage(user)
+		service := NewUserService(mockStorage, "test-secret", time.Hour, WithLoginLockout(maxAttempts, time.Minute))
+
+		for i := 1; i < maxAttempts; i++ {
+			if _, _, err := service.Login(ctx, user.Login, "wrong-password"); !errors.Is(err, ErrInvalidCredentials) {
+				t.Fatalf("attempt %d: error = %v, want ErrInvalidCredentials", i, err)
+			}
+		}
+
+		_, _, err := service.Login(ctx, user.Login, "wrong-password")
+		var lockedErr *AccountLockedError
+		if !errors.As(err, &lockedErr) || !errors.Is(err, ErrAccountLocked) {
+			t.Fatalf("last attempt error = %v, want AccountLockedError", err)
+		}
+		if until := time.Until(lockedErr.Until); until <= 0 || until > time.Minute {
+			t.Errorf("locked for %v, want up to %v", until, time.Minute)
+		}
+
+		// Во время блокировки не принимается даже верный пароль, и попытки не учитываются
+		if _, _, err := service.Login(ctx, user.Login, password); !errors.Is(err, ErrAccountLocked) {
+			t.Fatalf("Login() while locked error = %v, want ErrAccountLocked", err)
+		}
+		if *failures != maxAttempts {
+			t.Errorf("registered failures = %d, want %d", *failures, maxAttempts)
+		}
+	})
+
+	t.Run("unlocks automatically", func(t *testing.T) {
+		expired := time.Now().Add(-time.Second)
+		user := &models.User{ID: uuid.New(), Login: "unlock@example.com", PasswordHash: hash, LockedUntil: &expired}
+		mockStorage, _ := newStorage(user)
+		service := NewUserService(mockStorage, "test-secret", time.Hour, WithLoginLockout(maxAttempts, time.Minute))
+
+		if _, _, err := service.Login(ctx, user.Login, password); err != nil {
+			t.Fatalf("Login() after lock expiry error = %v", err)
+		}
+		if user.LockedUntil != nil {
+			t.Error("expired lock was not cleared after successful login")
+		}
+	})
+
+	t.Run("success resets counter", func(t *testing.T) {
+		user := &models.User{ID: uuid.New(), Login: "reset@example.com", PasswordHash: hash}
+		mockStorage, _ := newStorage(user)
+		service := NewUserService(mockStorage, "test-secret", time.Hour, WithLoginLockout(maxAttempts, time.Minute))
+
+		for i := 0; i < maxAttempts-1; i++ {
+			_, _, _ = service.Login(ctx, user.Login, "wrong-password")
+		}
+		if _, _, err := service.Login(ctx, user.Login, password); err != nil {
+			t.Fatalf("Login() error = %v", err)
+		}
+		if user.FailedLoginAttempts != 0 {
+			t.Fatalf("FailedLoginAttempts = %d, want 0", user.FailedLoginAttempts)
+		}
+
+		// После сброса снова доступны все попытки
+		if _, _, err := service.Login(ctx, user.Login, "wrong-password"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("Login() error = %v, want ErrInvalidCredentials", err)
+		}
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		user := &models.User{ID: uuid.New(), Login: "nolock@example.com", PasswordHash: hash}
+		mockStorage, failures := newStorage(user)
+		service := NewUserService(mockStorage, "test-secret", time.Hour)
+
+		for i := 0; i < maxAttempts*2; i++ {
+			if _, _, err := service.Login(ctx, user.Login, "wrong-password"); !errors.Is(err, ErrInvalidCredentials) {
+				t.Fatalf("attempt %d: error = %v, want ErrInvalidCredentials", i, err)
+			}
+		}
+		if *failures != 0 {
+			t.Errorf("registered failures = %d, want 0", *failures)
+		}
+	})
+}
+
+func TestUserServiceImpl_InMemoryStorage(t *testing.T) {
+	ctx := context.Background()
+	userStorage := storage.NewInMemoryUserStorage()
+	svc := NewUserService(userStorage, "test-secret", time.Hour, WithLoginLockout(2, time.Minute))
+
+	registered, token, err := svc.Register(ctx, "alice", "password123")
+	if err != nil || token == "" {
+		t.Fatalf("Register() = %v, %q, %v", registered, token, err)
+	}
+	if _, _, err := svc.Register(ctx, "alice", "other"); !errors.Is(err, storage.ErrLoginExists) {
+		t.Errorf("second Register() error = %v, want ErrLoginExists", err)
+	}
+
+	loggedIn, _, err := svc.Login(ctx, "alice", "password123")
+	if err != nil || loggedIn.ID != registered.ID {
+		t.Fatalf("Login() = %v, %v; want user %s", loggedIn, err, registered.ID)
+	}
+
+	// Две неудачные попытки подряд блокируют вход даже с верным паролем
+	for i := 0; i < 2; i++ {
+		if _, _, err := svc.Login(ctx, "alice", "wrong"); err == nil {
+			t.Fatal("Login() with a wrong password succeeded")
+		}
+	}
+	if _, _, err := svc.Login(ctx, "alice", "password123"); !errors.Is(err, ErrAccountLocked) {
+		t.Errorf("Login() after lockout error = %v, want ErrAccountLocked", err)
+	}
+}
+
+func TestUserServiceImpl_SetTokenExpiration(t *testing.T) {
+	ctx := context.Background()
+	svc := NewUserService(storage.NewInMemoryUserStorage(), "test-secret", time.Hour)
+
+	// expiresIn регистрирует пользователя и возвращает оставшийся срок действия его токена.
+	expiresIn := func(login string) time.Duration {
+		t.Helper()
+		_, token, err := svc.Register(ctx, login, "password123")
+		if err != nil {
+			t.Fatalf("Register() error = %v", err)
+		}
+		expiresAt, err := auth.TokenExpiresAt(token)
+		if err != nil {
+			t.Fatalf("TokenExpiresAt() error = %v", err)
+		}
+		return time.Until(expiresAt)
+	}
+	near := func(got, want time.Duration) bool {
+		diff := got - want
+		return diff < 5*time.Second && diff > -5*time.Second
+	}
+
+	if got := expiresIn("alice"); !near(got, time.Hour) {
+		t.Fatalf("initial token expires in %v, want about 1h", got)
+	}
+
+	if err := svc.SetTokenExpiration(15 * time.Minute); err != nil {
+		t.Fatalf("SetTokenExpiration() error = %v", err)
+	}
+	if svc.TokenExpiration() != 15*time.Minute {
+		t.Errorf("TokenExpiration() = %v, want 15m", svc.TokenExpiration())
+	}
+	if got := expiresIn("bob"); !near(got, 15*time.Minute) {
+		t.Errorf("token after the change expires in %v, want about 15m", got)
+	}
+
+	for _, exp := range []time.Duration{0, -time.Minute} {
+		if err := svc.SetTokenExpiration(exp); !errors.Is(err, ErrInvalidTokenExpiration) {
+			t.Errorf("SetTokenExpiration(%v) error = %v, want ErrInvalidTokenExpiration", exp, err)
+		}
+	}
+	if got := expiresIn("carol"); !near(got, 15*time.Minute) {
+		t.Errorf("token after a rejected change expires in %v, want about 15m", got)
+	}
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/agamariel/gofermart/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/shopspring/decimal"
+)
+
+// PostgresAuditStorage реализует AuditStorage для PostgreSQL. Таблица audit_log
+// только пополняется: изменение и удаление записей запрещены триггером.
+type PostgresAuditStorage struct {
+	pool *pgxpool.Pool
+}
+
+// NewPostgresAuditStorage создаёт новый экземпляр.
+func NewPostgresAuditStorage(pool *pgxpool.Pool) *PostgresAuditStorage {
+	return &PostgresAuditStorage{pool: pool}
+}
+
+// Record добавляет запись журнала в рамках транзакции tx. Вызывается после изменения баланса
+// в той же транзакции: balance_after - текущий баланс пользователя, balance_before
+// вычисляется из него и суммы операции. Заполняет ID, балансы и CreatedAt записи.
+func (s *PostgresAuditStorage) Record(ctx context.Context, tx pgx.Tx, entry *models.AuditEntry) error {
+	defer trackQuery(queryAuditRecord)()
+
+	query := `
+		INSERT INTO audit_log (user_id, order_number, kind, amount, balance_before, balance_after, admin_id, reason)
+		SELECT id, $2, $3, $4, balance - $5, balance, $6, $7
+		FROM users
+		WHERE id = $1
+		RETURNING id, balance_before, balance_after, created_at
+	`
+
+	err := tx.QueryRow(ctx, query, entry.UserID, entry.OrderNumber, entry.Kind, entry.Amount, entry.BalanceDelta(), entry.AdminID, entry.Reason).
+		Scan(&entry.ID, &entry.BalanceBefore, &entry.BalanceAfter, &entry.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrUserNotFound
+		}
+		return fmt.Errorf("failed to record audit entry: %w", wrapInternal(err))
+	}
+	return nil
+}
+
+// GetManualAdjustmentTotal возвращает сумму ручных корректировок баланса пользователя
+// администраторами с учётом знака. Корректировки без admin_id (автоматическая сверка
+// балансов) не учитываются: они лишь приводят баланс к сумме остальных операций.
+func (s *PostgresAuditStorage) GetManualAdjustmentTotal(ctx context.Context, userID uuid.UUID) (decimal.Decimal, error) {
+	defer trackQuery(queryAuditManualTotal)()
+
+	query := `
+		SELECT COALESCE(SUM(CASE WHEN kind = 'ADJUSTMENT_DEBIT' THEN -amount ELSE amount END), 0)
+		FROM audit_log
+		WHERE user_id = $1 AND kind IN ('ADJUSTMENT_CREDIT', 'ADJUSTMENT_DEBIT') AND admin_id IS NOT NULL
+	`
+
+	var total decimal.Decimal
+	if err := s.pool.QueryRow(ctx, query, userID).Scan(&total); err != nil {
+		return decimal.Zero, fmt.Errorf("failed to get adjustment total: %w", wrapInternal(err))
+	}
+	return total, nil
+}
+
+// GetByUserID возвращает записи журнала пользователя в порядке их создания.
+func (s *PostgresAuditStorage) GetByUserID(ctx context.Context, userID uuid.UUID) ([]*models.AuditEntry, error) {
+	defer trackQuery(queryAuditGetByUserID)()
+
+	query := `
+		SELECT id, user_id, order_number, admin_id, reason, kind, amount, balance_before, balance_after, created_at
+		FROM audit_log
+		WHERE user_id = $1
+		ORDER BY created_at, id
+	`
+
+	rows, err := s.pool.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %w", wrapInternal(err))
+	}
+	defer rows.Close()
+
+	var entries []*models.AuditEntry
+	for rows.Next() {
+		var e models.AuditEntry
+		if err := rows.Scan(&e.ID, &e.UserID, &e.OrderNumber, &e.AdminID, &e.Reason, &e.Kind, &e.Amount, &e.BalanceBefore, &e.BalanceAfter, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %w", wrapInternal(err))
+		}
+		entries = append(entries, &e)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("rows error: %w", wrapInternal(rows.Err()))
+	}
+	return entries, nil
+}
@@ -0,0 +1,266 @@
+//go:build integration
+// +build integration
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/agamariel/gofermart/internal/models"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+func TestPostgresAuditStorage_RecordInTransaction(t *testing.T) {
+	ts := newTestStorage(t)
+	ctx := context.Background()
+
+	user := &models.User{
+		ID:           uuid.New(),
+		Login:        "audit_" + uuid.New().String() + "@example.com",
+		PasswordHash: "hashed_password",
+	}
+	if err := ts.users.Create(ctx, user); err != nil {
+		t.Fatalf("Create user error = %v", err)
+	}
+	if err := ts.users.UpdateBalance(ctx, user.ID, decimal.NewFromInt(100)); err != nil {
+		t.Fatalf("UpdateBalance() error = %v", err)
+	}
+
+	// withdraw списывает sum и пишет журнал в одной транзакции, завершая её commit или откатом.
+	withdraw := func(orderNumber string, sum decimal.Decimal, commit bool) *models.AuditEntry {
+		t.Helper()
+		tx, err := ts.pool.Begin(ctx)
+		if err != nil {
+			t.Fatalf("Begin() error = %v", err)
+		}
+		defer tx.Rollback(ctx)
+
+		if err := ts.users.WithdrawTx(ctx, tx, user.ID, sum); err != nil {
+			t.Fatalf("WithdrawTx() error = %v", err)
+		}
+		if err := ts.withdrawals.CreateWithTx(ctx, tx, &models.Withdrawal{UserID: user.ID, OrderNumber: orderNumber, Sum: sum}); err != nil {
+			t.Fatalf("CreateWithTx() error = %v", err)
+		}
+		entry := &models.AuditEntry{UserID: user.ID, OrderNumber: orderNumber, Kind: models.AuditKindWithdrawal, Amount: sum}
+		if err := ts.audit.Record(ctx, tx, entry); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+		if commit {
+			if err := tx.Commit(ctx); err != nil {
+				t.Fatalf("Commit() error = %v", err)
+			}
+		}
+		return entry
+	}
+
+	entry := withdraw("2377225624", decimal.NewFromInt(30), true)
+	if entry.ID == uuid.Nil || entry.CreatedAt.IsZero() {
+		t.Fatalf("Record() did not fill ID and CreatedAt: %+v", entry)
+	}
+	if !entry.BalanceBefore.Equal(decimal.NewFromInt(100)) || !entry.BalanceAfter.Equal(decimal.NewFromInt(70)) {
+		t.Errorf("balances = %s -> %s, want 100 -> 70", entry.BalanceBefore, entry.BalanceAfter)
+	}
+
+	t.Run("rollback discards both the balance change and the entry", func(t *testing.T) {
+		withdraw("12345678903", decimal.NewFromInt(20), false)
+
+		got, err := ts.users.GetByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("GetByID() error = %v", err)
+		}
+		if !got.Balance.Equal(decimal.NewFromInt(70)) {
+			t.Errorf("balance = %s, want 70", got.Balance)
+		}
+		entries, err := ts.audit.GetByUserID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("GetByUserID() error = %v", err)
+		}
+		if len(entries) != 1 || entries[0].ID != entry.ID {
+			t.Errorf("entries = %+v, want only the committed withdrawal", entries)
+		}
+	})
+
+	t.Run("accrual", func(t *testing.T) {
+		tx, err := ts.pool.Begin(ctx)
+		if err != nil {
+			t.Fatalf("Begin() error = %v", err)
+		}
+		defer tx.Rollback(ctx)
+
+		amount := decimal.RequireFromString("50.25")
+		if err := ts.users.CreditTx(ctx, tx, user.ID, amount); err != nil {
+			t.Fatalf("CreditTx() error = %v", err)
+		}
+		accrual := &models.AuditEntry{UserID: user.ID, OrderNumber: "79927398713", Kind: models.AuditKindAccrual, Amount: amount}
+		if err := ts.audit.Record(ctx, tx, accrual); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+		if err := tx.Commit(ctx); err != nil {
+			t.Fatalf("Commit() error = %v", err)
+		}
+		if !accrual.BalanceBefore.Equal(decimal.NewFromInt(70)) || !accrual.BalanceAfter.Equal(decimal.RequireFromString("120.25")) {
+			t.Errorf("balances = %s -> %s, want 70 -> 120.25", accrual.BalanceBefore, accrual.BalanceAfter)
+		}
+	})
+
+	t.Run("refund", func(t *testing.T) {
+		tx, err := ts.pool.Begin(ctx)
+		if err != nil {
+			t.Fatalf("Begin() error = %v", err)
+		}
+		defer tx.Rollback(ctx)
+
+		amount := decimal.NewFromInt(30)
+		if err := ts.users.RefundTx(ctx, tx, user.ID, amount); err != nil {
+			t.Fatalf("RefundTx() error = %v", err)
+		}
+		refund := &models.AuditEntry{UserID: user.ID, OrderNumber: "2377225624", Kind: models.AuditKindRefund, Amount: amount}
+		if err := ts.audit.Record(ctx, tx, refund); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+		if err := tx.Commit(ctx); err != nil {
+			t.Fatalf("Commit() error = %v", err)
+		}
+		if !refund.BalanceAfter.Sub(refund.BalanceBefore).Equal(amount) {
+			t.Errorf("balances = %s -> %s, want an increase by %s", refund.BalanceBefore, refund.BalanceAfter, amount)
+		}
+	})
+
+	t.Run("log is append-only", func(t *testing.T) {
+		if _, err := ts.pool.Exec(ctx, "UPDATE audit_log SET amount = 0 WHERE id = $1", entry.ID); err == nil {
+			t.Error("UPDATE audit_log succeeded, want error")
+		}
+		if _, err := ts.pool.Exec(ctx, "DELETE FROM audit_log WHERE id = $1", entry.ID); err == nil {
+			t.Error("DELETE FROM audit_log succeeded, want error")
+		}
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		tx, err := ts.pool.Begin(ctx)
+		if err != nil {
+			t.Fatalf("Begin() error = %v", err)
+		}
+		defer tx.Rollback(ctx)
+
+		err = ts.audit.Record(ctx, tx, &models.AuditEntry{UserID: uuid.New(), OrderNumber: "1", Kind: models.AuditKindAccrual, Amount: decimal.NewFromInt(1)})
+		if !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Record() error = %v, want ErrUserNotFound", err)
+		}
+	})
+}
+
+func TestPostgresAuditStorage_Adjustment(t *testing.T) {
+	ts := newTestStorage(t)
+	ctx := context.Background()
+
+	newUser := func(prefix string) *models.User {
+		t.Helper()
+		user := &models.User{
+			ID:           uuid.New(),
+			Login:        prefix + "_" + uuid.New().String() + "@example.com",
+			PasswordHash: "hashed_password",
+		}
+		if err := ts.users.Create(ctx, user); err != nil {
+			t.Fatalf("Create user error = %v", err)
+		}
+		return user
+	}
+	user := newUser("adjusted")
+	admin := newUser("admin")
+	if err := ts.users.UpdateBalance(ctx, user.ID, decimal.NewFromInt(100)); err != nil {
+		t.Fatalf("UpdateBalance() error = %v", err)
+	}
+
+	tx, err := ts.pool.Begin(ctx)
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	delta := decimal.RequireFromString("-40.25")
+	if err := ts.users.AdjustBalanceTx(ctx, tx, user.ID, delta); err != nil {
+		t.Fatalf("AdjustBalanceTx() error = %v", err)
+	}
+	entry := &models.AuditEntry{UserID: user.ID, AdminID: &admin.ID, Reason: "chargeback", Kind: models.AuditKindAdjustmentDebit, Amount: delta.Abs()}
+	if err := ts.audit.Record(ctx, tx, entry); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	if !entry.BalanceBefore.Equal(decimal.NewFromInt(100)) || !entry.BalanceAfter.Equal(decimal.RequireFromString("59.75")) {
+		t.Errorf("balances = %s -> %s, want 100 -> 59.75", entry.BalanceBefore, entry.BalanceAfter)
+	}
+
+	entries, err := ts.audit.GetByUserID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetByUserID() error = %v", err)
+	}
+	if len(entries) != 1 || entries[0].AdminID == nil || *entries[0].AdminID != admin.ID || entries[0].Reason != "chargeback" {
+		t.Fatalf("entries = %+v, want one adjustment by admin", entries)
+	}
+
+	got, err := ts.users.GetByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetByID() error = %v", err)
+	}
+	if !got.Withdrawn.IsZero() {
+		t.Errorf("withdrawn = %s, adjustment must not count as a withdrawal", got.Withdrawn)
+	}
+
+	t.Run("manual adjustment total skips automatic ones", func(t *testing.T) {
+		tx, err := ts.pool.Begin(ctx)
+		if err != nil {
+			t.Fatalf("Begin() error = %v", err)
+		}
+		defer tx.Rollback(ctx)
+
+		auto := decimal.NewFromInt(5)
+		if err := ts.users.AdjustBalanceTx(ctx, tx, user.ID, auto); err != nil {
+			t.Fatalf("AdjustBalanceTx() error = %v", err)
+		}
+		if err := ts.audit.Record(ctx, tx, &models.AuditEntry{UserID: user.ID, Reason: "reconciliation", Kind: models.AuditKindAdjustmentCredit, Amount: auto}); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+		if err := tx.Commit(ctx); err != nil {
+			t.Fatalf("Commit() error = %v", err)
+		}
+
+		total, err := ts.audit.GetManualAdjustmentTotal(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("GetManualAdjustmentTotal() error = %v", err)
+		}
+		if !total.Equal(delta) {
+			t.Errorf("GetManualAdjustmentTotal() = %s, want %s", total, delta)
+		}
+	})
+
+	t.Run("below zero is rejected", func(t *testing.T) {
+		tx, err := ts.pool.Begin(ctx)
+		if err != nil {
+			t.Fatalf("Begin() error = %v", err)
+		}
+		defer tx.Rollback(ctx)
+
+		err = ts.users.AdjustBalanceTx(ctx, tx, user.ID, decimal.RequireFromString("-59.76"))
+		var balanceErr *InsufficientBalanceError
+		if !errors.As(err, &balanceErr) || !balanceErr.Available.Equal(decimal.RequireFromString("59.75")) {
+			t.Fatalf("AdjustBalanceTx() error = %v, want InsufficientBalanceError", err)
+		}
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		tx, err := ts.pool.Begin(ctx)
+		if err != nil {
+			t.Fatalf("Begin() error = %v", err)
+		}
+		defer tx.Rollback(ctx)
+
+		if err := ts.users.AdjustBalanceTx(ctx, tx, uuid.New(), decimal.NewFromInt(1)); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("AdjustBalanceTx() error = %v, want ErrUserNotFound", err)
+		}
+	})
+}
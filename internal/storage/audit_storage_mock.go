@@ -0,0 +1,31 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/agamariel/gofermart/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+)
+
+// MockAuditStorage - мок для тестов.
+type MockAuditStorage struct {
+	RecordFunc func(ctx context.Context, tx pgx.Tx, entry *models.AuditEntry) error
+
+	GetManualAdjustmentTotalFunc func(ctx context.Context, userID uuid.UUID) (decimal.Decimal, error)
+}
+
+func (m *MockAuditStorage) Record(ctx context.Context, tx pgx.Tx, entry *models.AuditEntry) error {
+	if m.RecordFunc != nil {
+		return m.RecordFunc(ctx, tx, entry)
+	}
+	return nil
+}
+
+func (m *MockAuditStorage) GetManualAdjustmentTotal(ctx context.Context, userID uuid.UUID) (decimal.Decimal, error) {
+	if m.GetManualAdjustmentTotalFunc != nil {
+		return m.GetManualAdjustmentTotalFunc(ctx, userID)
+	}
+	return decimal.Zero, nil
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrStorageInternal - непредвиденная ошибка БД (сбой соединения, ошибка запроса или чтения строк).
+// Её оборачивают все такие ошибки хранилищ; именованные ошибки (ErrUserNotFound, ErrOrderAlreadyExists
+// и другие) её не содержат. Исходная ошибка pgx доступна через errors.Is и errors.As.
+var ErrStorageInternal = errors.New("storage internal error")
+
+// ErrRequestCancelled - запрос к БД прерван отменой контекста вызывающего (context.Canceled):
+// клиент ушёл, и это не сбой БД, поэтому ErrStorageInternal она не содержит. Истёкший дедлайн
+// (context.DeadlineExceeded) означает медленную БД и считается ErrStorageInternal.
+// Исходная ошибка контекста доступна через errors.Is.
+var ErrRequestCancelled = errors.New("request cancelled")
+
+// internalError помечает ошибку БД как ErrStorageInternal, не меняя её текста.
+type internalError struct {
+	err error
+}
+
+func (e *internalError) Error() string {
+	return e.err.Error()
+}
+
+func (e *internalError) Unwrap() []error {
+	return []error{ErrStorageInternal, e.err}
+}
+
+// cancelledError помечает ошибку отменённого контекста как ErrRequestCancelled, не меняя её текста.
+type cancelledError struct {
+	err error
+}
+
+func (e *cancelledError) Error() string {
+	return e.err.Error()
+}
+
+func (e *cancelledError) Unwrap() []error {
+	return []error{ErrRequestCancelled, e.err}
+}
+
+// WrapInternal классифицирует ошибку БД, полученную вне хранилищ (открытие и фиксация
+// транзакции в сервисах), так же, как хранилища классифицируют свои.
+func WrapInternal(err error) error {
+	return wrapInternal(err)
+}
+
+// wrapInternal оборачивает непредвиденную ошибку БД в ErrStorageInternal, а ошибку
+// отменённого контекста - в ErrRequestCancelled.
+func wrapInternal(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, context.Canceled) {
+		return &cancelledError{err: err}
+	}
+	return &internalError{err: err}
+}
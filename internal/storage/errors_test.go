@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/shopspring/decimal"
+)
+
+func TestErrStorageInternal(t *testing.T) {
+	pgErr := &pgconn.PgError{Code: "40P01", Message: "deadlock detected"}
+
+	t.Run("unexpected db errors are internal", func(t *testing.T) {
+		err := fmt.Errorf("withdraw: %w", fmt.Errorf("failed to check balance: %w", wrapInternal(pgErr)))
+
+		if !errors.Is(err, ErrStorageInternal) {
+			t.Errorf("errors.Is(%v, ErrStorageInternal) = false", err)
+		}
+		var gotPgErr *pgconn.PgError
+		if !errors.As(err, &gotPgErr) || gotPgErr.Code != "40P01" {
+			t.Errorf("errors.As() did not find the original PgError in %v", err)
+		}
+		if want := "withdraw: failed to check balance: " + pgErr.Error(); err.Error() != want {
+			t.Errorf("Error() = %q, want %q", err.Error(), want)
+		}
+	})
+
+	t.Run("nil stays nil", func(t *testing.T) {
+		if err := wrapInternal(nil); err != nil {
+			t.Errorf("wrapInternal(nil) = %v, want nil", err)
+		}
+	})
+
+	t.Run("named errors are not internal", func(t *testing.T) {
+		named := []error{
+			ErrUserNotFound,
+			ErrLoginExists,
+			ErrInsufficientBalance,
+			ErrAmountTooLarge,
+			ErrOrderNotFound,
+			ErrOrderAlreadyExists,
+			ErrWithdrawalExists,
+			ErrWithdrawalNotFound,
+			&InsufficientBalanceError{Requested: decimal.NewFromInt(2), Available: decimal.NewFromInt(1)},
+		}
+		for _, err := range named {
+			if errors.Is(err, ErrStorageInternal) {
+				t.Errorf("errors.Is(%v, ErrStorageInternal) = true", err)
+			}
+		}
+	})
+
+	t.Run("scan errors are classified", func(t *testing.T) {
+		if _, err := scanOrder(errRow{err: pgErr}); !errors.Is(err, ErrStorageInternal) {
+			t.Errorf("scan failure: errors.Is(%v, ErrStorageInternal) = false", err)
+		}
+		_, err := scanOrder(errRow{err: pgx.ErrNoRows})
+		if !errors.Is(err, ErrOrderNotFound) || errors.Is(err, ErrStorageInternal) {
+			t.Errorf("no rows: error = %v, want ErrOrderNotFound only", err)
+		}
+	})
+
+	t.Run("corrupt accrual is internal", func(t *testing.T) {
+		err := &CorruptAccrualError{Number: "79927398713", Err: errors.New("can't convert NaN to decimal")}
+		if !errors.Is(err, ErrStorageInternal) || !errors.Is(err, ErrCorruptAccrual) {
+			t.Errorf("CorruptAccrualError must satisfy ErrStorageInternal and ErrCorruptAccrual: %v", err)
+		}
+	})
+}
+
+func TestErrRequestCancelled(t *testing.T) {
+	t.Run("cancelled context is a cancellation", func(t *testing.T) {
+		err := fmt.Errorf("get user orders: %w", wrapInternal(fmt.Errorf("query: %w", context.Canceled)))
+
+		if !errors.Is(err, ErrRequestCancelled) {
+			t.Errorf("errors.Is(%v, ErrRequestCancelled) = false", err)
+		}
+		if errors.Is(err, ErrStorageInternal) {
+			t.Errorf("errors.Is(%v, ErrStorageInternal) = true", err)
+		}
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("errors.Is(%v, context.Canceled) = false", err)
+		}
+	})
+
+	t.Run("exceeded deadline is internal", func(t *testing.T) {
+		err := fmt.Errorf("get user orders: %w", wrapInternal(fmt.Errorf("query: %w", context.DeadlineExceeded)))
+
+		if errors.Is(err, ErrRequestCancelled) {
+			t.Errorf("errors.Is(%v, ErrRequestCancelled) = true", err)
+		}
+		if !errors.Is(err, ErrStorageInternal) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("error = %v, want ErrStorageInternal wrapping context.DeadlineExceeded", err)
+		}
+	})
+
+	t.Run("db errors are not cancellations", func(t *testing.T) {
+		err := wrapInternal(&pgconn.PgError{Code: "40P01", Message: "deadlock detected"})
+		if errors.Is(err, ErrRequestCancelled) {
+			t.Errorf("errors.Is(%v, ErrRequestCancelled) = true", err)
+		}
+	})
+
+	// Пул не подключается до первого запроса, а отменённый контекст обрывает его раньше сети
+	pool, err := pgxpool.New(context.Background(), "postgres://gophermart@127.0.0.1:1/gophermart")
+	if err != nil {
+		t.Fatalf("pgxpool.New() error = %v", err)
+	}
+	t.Cleanup(pool.Close)
+	users := NewPostgresUserStorage(pool)
+
+	t.Run("cancelled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := users.GetByID(ctx, uuid.New())
+		if !errors.Is(err, ErrRequestCancelled) || !errors.Is(err, context.Canceled) {
+			t.Errorf("GetByID() error = %v, want ErrRequestCancelled", err)
+		}
+		if errors.Is(err, ErrStorageInternal) {
+			t.Errorf("GetByID() error = %v must not be ErrStorageInternal", err)
+		}
+	})
+
+	t.Run("expired context", func(t *testing.T) {
+		ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+		defer cancel()
+
+		err := users.UpdateBalance(ctx, uuid.New(), decimal.NewFromInt(10))
+		if !errors.Is(err, ErrStorageInternal) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("UpdateBalance() error = %v, want ErrStorageInternal", err)
+		}
+		if errors.Is(err, ErrRequestCancelled) {
+			t.Errorf("UpdateBalance() error = %v must not be ErrRequestCancelled", err)
+		}
+	})
+}
+
+// errRow - строка результата, чтение которой завершается ошибкой.
+type errRow struct {
+	err error
+}
+
+func (r errRow) Scan(...any) error {
+	return r.err
+}
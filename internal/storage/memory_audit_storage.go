@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/agamariel/gofermart/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+)
+
+// InMemoryAuditStorage реализует AuditStorage в памяти процесса (STORAGE=memory).
+// Балансы записи берутся из InMemoryUserStorage так же, как PostgresAuditStorage берёт их
+// из users, а запись удаляется при откате транзакции MemoryTxBeginner.
+// Методы безопасны для конкурентного вызова.
+type InMemoryAuditStorage struct {
+	users *InMemoryUserStorage
+
+	mu sync.RWMutex
+	// entries хранит записи в порядке создания.
+	entries []*models.AuditEntry
+	now     func() time.Time
+}
+
+// NewInMemoryAuditStorage создаёт пустой журнал аудита для пользователей users.
+func NewInMemoryAuditStorage(users *InMemoryUserStorage) *InMemoryAuditStorage {
+	return &InMemoryAuditStorage{users: users, now: time.Now}
+}
+
+// Record добавляет запись журнала в рамках транзакции tx. Вызывается после изменения баланса:
+// balance_after - текущий баланс пользователя, balance_before вычисляется из него и суммы операции.
+func (s *InMemoryAuditStorage) Record(ctx context.Context, tx pgx.Tx, entry *models.AuditEntry) error {
+	user, err := s.users.GetByID(ctx, entry.UserID)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry.ID = uuid.New()
+	entry.BalanceAfter = user.Balance
+	entry.BalanceBefore = user.Balance.Sub(entry.BalanceDelta())
+	entry.CreatedAt = s.now()
+
+	stored := *entry
+	s.entries = append(s.entries, &stored)
+	onRollback(tx, func() { s.remove(stored.ID) })
+	return nil
+}
+
+// GetByUserID возвращает записи журнала пользователя в порядке их создания.
+func (s *InMemoryAuditStorage) GetByUserID(ctx context.Context, userID uuid.UUID) ([]*models.AuditEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var entries []*models.AuditEntry
+	for _, stored := range s.entries {
+		if stored.UserID == userID {
+			e := *stored
+			entries = append(entries, &e)
+		}
+	}
+	return entries, nil
+}
+
+// GetManualAdjustmentTotal возвращает сумму ручных корректировок баланса пользователя
+// администраторами с учётом знака, как и PostgresAuditStorage.GetManualAdjustmentTotal.
+func (s *InMemoryAuditStorage) GetManualAdjustmentTotal(ctx context.Context, userID uuid.UUID) (decimal.Decimal, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	total := decimal.Zero
+	for _, e := range s.entries {
+		if e.UserID != userID || e.AdminID == nil {
+			continue
+		}
+		if e.Kind == models.AuditKindAdjustmentCredit || e.Kind == models.AuditKindAdjustmentDebit {
+			total = total.Add(e.BalanceDelta())
+		}
+	}
+	return total, nil
+}
+
+// remove удаляет запись по ID; используется только откатом транзакции.
+func (s *InMemoryAuditStorage) remove(id uuid.UUID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, e := range s.entries {
+		if e.ID == id {
+			s.entries = append(s.entries[:i], s.entries[i+1:]...)
+			return
+		}
+	}
+}
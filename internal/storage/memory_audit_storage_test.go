@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/agamariel/gofermart/internal/models"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+func TestInMemoryAuditStorage_Record(t *testing.T) {
+	ctx := context.Background()
+	users := NewInMemoryUserStorage()
+	audit := NewInMemoryAuditStorage(users)
+	user := newMemoryUser(t, users, "alice", 100)
+	beginner := NewMemoryTxBeginner()
+
+	tx, _ := beginner.Begin(ctx)
+	if err := users.WithdrawTx(ctx, tx, user.ID, decimal.NewFromInt(30)); err != nil {
+		t.Fatalf("WithdrawTx() error = %v", err)
+	}
+	entry := &models.AuditEntry{UserID: user.ID, OrderNumber: "2377225624", Kind: models.AuditKindWithdrawal, Amount: decimal.NewFromInt(30)}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	if entry.ID == uuid.Nil || entry.CreatedAt.IsZero() {
+		t.Fatalf("Record() did not fill ID and CreatedAt: %+v", entry)
+	}
+	if !entry.BalanceBefore.Equal(decimal.NewFromInt(100)) || !entry.BalanceAfter.Equal(decimal.NewFromInt(70)) {
+		t.Errorf("withdrawal balances = %s -> %s, want 100 -> 70", entry.BalanceBefore, entry.BalanceAfter)
+	}
+
+	t.Run("rollback removes the entry", func(t *testing.T) {
+		tx, _ := beginner.Begin(ctx)
+		accrual := decimal.NewFromInt(50)
+		if err := users.CreditTx(ctx, tx, user.ID, accrual); err != nil {
+			t.Fatalf("CreditTx() error = %v", err)
+		}
+		entry := &models.AuditEntry{UserID: user.ID, OrderNumber: "12345678903", Kind: models.AuditKindAccrual, Amount: accrual}
+		if err := audit.Record(ctx, tx, entry); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+		if !entry.BalanceBefore.Equal(decimal.NewFromInt(70)) || !entry.BalanceAfter.Equal(decimal.NewFromInt(120)) {
+			t.Errorf("accrual balances = %s -> %s, want 70 -> 120", entry.BalanceBefore, entry.BalanceAfter)
+		}
+		if err := tx.Rollback(ctx); err != nil {
+			t.Fatalf("Rollback() error = %v", err)
+		}
+
+		entries, _ := audit.GetByUserID(ctx, user.ID)
+		if len(entries) != 1 || entries[0].Kind != models.AuditKindWithdrawal {
+			t.Errorf("entries = %+v, want only the committed withdrawal", entries)
+		}
+		got, _ := users.GetByID(ctx, user.ID)
+		if !got.Balance.Equal(decimal.NewFromInt(70)) {
+			t.Errorf("balance = %s, want 70", got.Balance)
+		}
+	})
+
+	t.Run("manual adjustment total", func(t *testing.T) {
+		adminID := uuid.New()
+		entries := []*models.AuditEntry{
+			{UserID: user.ID, AdminID: &adminID, Kind: models.AuditKindAdjustmentCredit, Amount: decimal.NewFromInt(20)},
+			{UserID: user.ID, AdminID: &adminID, Kind: models.AuditKindAdjustmentDebit, Amount: decimal.RequireFromString("5.50")},
+			// Автоматическая корректировка без администратора не учитывается
+			{UserID: user.ID, Kind: models.AuditKindAdjustmentCredit, Amount: decimal.NewFromInt(7)},
+		}
+		for _, e := range entries {
+			if err := audit.Record(ctx, nil, e); err != nil {
+				t.Fatalf("Record() error = %v", err)
+			}
+		}
+
+		total, err := audit.GetManualAdjustmentTotal(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("GetManualAdjustmentTotal() error = %v", err)
+		}
+		if !total.Equal(decimal.RequireFromString("14.50")) {
+			t.Errorf("GetManualAdjustmentTotal() = %s, want 14.50", total)
+		}
+		if other, _ := audit.GetManualAdjustmentTotal(ctx, uuid.New()); !other.IsZero() {
+			t.Errorf("total of another user = %s, want 0", other)
+		}
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		err := audit.Record(ctx, nil, &models.AuditEntry{UserID: uuid.New(), Kind: models.AuditKindAccrual, Amount: decimal.NewFromInt(1)})
+		if !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Record() error = %v, want ErrUserNotFound", err)
+		}
+	})
+}
@@ -0,0 +1,350 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/agamariel/gofermart/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+)
+
+// InMemoryOrderStorage реализует OrderStorage в памяти процесса (STORAGE=memory).
+// Семантика повторяет PostgresOrderStorage: номер заказа уникален глобально, выборки
+// сортируются так же, ApplyAccrualTx откатывается вместе с транзакцией MemoryTxBeginner.
+// Существование пользователя не проверяется. Методы безопасны для конкурентного вызова.
+type InMemoryOrderStorage struct {
+	mu       sync.RWMutex
+	byNumber map[string]*models.Order
+	// numbers хранит номера в порядке загрузки: при равном uploaded_at
+	// выборки сохраняют этот порядок.
+	numbers []string
+	now     func() time.Time
+}
+
+// NewInMemoryOrderStorage создаёт пустое хранилище заказов в памяти.
+func NewInMemoryOrderStorage() *InMemoryOrderStorage {
+	return &InMemoryOrderStorage{
+		byNumber: make(map[string]*models.Order),
+		now:      time.Now,
+	}
+}
+
+// Ping всегда успешен: хранилище не зависит от внешних систем.
+func (s *InMemoryOrderStorage) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Create создаёт новый заказ. Если номер уже загружен, возвращается ErrOrderAlreadyExists.
+func (s *InMemoryOrderStorage) Create(ctx context.Context, order *models.Order) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.byNumber[order.Number]; ok {
+		return ErrOrderAlreadyExists
+	}
+	s.insert(order)
+	return nil
+}
+
+// CreateBatch создаёт заказы, пропуская уже существующие номера; для каждого заказа
+// возвращается признак того, что он был создан. Как и в PostgreSQL, accrual не сохраняется.
+func (s *InMemoryOrderStorage) CreateBatch(ctx context.Context, orders []*models.Order) ([]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	created := make([]bool, len(orders))
+	for i, order := range orders {
+		if _, ok := s.byNumber[order.Number]; ok {
+			continue
+		}
+		order.Accrual = nil
+		s.insert(order)
+		created[i] = true
+	}
+	return created, nil
+}
+
+// insert сохраняет копию заказа, заполняя ID и даты. Вызывается под s.mu.
+func (s *InMemoryOrderStorage) insert(order *models.Order) {
+	now := s.now()
+	order.ID = uuid.New()
+	order.UploadedAt = now
+	order.UpdatedAt = now
+
+	s.byNumber[order.Number] = copyOrder(order)
+	s.numbers = append(s.numbers, order.Number)
+}
+
+// GetByNumber возвращает заказ по номеру.
+func (s *InMemoryOrderStorage) GetByNumber(ctx context.Context, number string) (*models.Order, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	order, ok := s.byNumber[number]
+	if !ok {
+		return nil, ErrOrderNotFound
+	}
+	return copyOrder(order), nil
+}
+
+// GetByNumberForUser возвращает заказ пользователя userID по номеру.
+// Если заказа нет или он загружен другим пользователем, возвращается ErrOrderNotFound.
+func (s *InMemoryOrderStorage) GetByNumberForUser(ctx context.Context, number string, userID uuid.UUID) (*models.Order, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	order, ok := s.byNumber[number]
+	if !ok || order.UserID != userID {
+		return nil, ErrOrderNotFound
+	}
+	return copyOrder(order), nil
+}
+
+// OrderOwner возвращает владельца заказа. Второе значение false, если заказа нет.
+func (s *InMemoryOrderStorage) OrderOwner(ctx context.Context, number string) (uuid.UUID, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	order, ok := s.byNumber[number]
+	if !ok {
+		return uuid.Nil, false, nil
+	}
+	return order.UserID, true, nil
+}
+
+// GetByUserID возвращает список заказов пользователя (сортировка по uploaded_at DESC).
+func (s *InMemoryOrderStorage) GetByUserID(ctx context.Context, userID uuid.UUID) ([]*models.Order, error) {
+	return s.GetByUserIDSorted(ctx, userID, false)
+}
+
+// GetByUserIDSorted возвращает список заказов пользователя, отсортированный по uploaded_at
+// по возрастанию (asc = true) или по убыванию.
+func (s *InMemoryOrderStorage) GetByUserIDSorted(ctx context.Context, userID uuid.UUID, asc bool) ([]*models.Order, error) {
+	orders := s.filter(func(o *models.Order) bool { return o.UserID == userID })
+	sort.SliceStable(orders, func(i, j int) bool {
+		if asc {
+			return orders[i].UploadedAt.Before(orders[j].UploadedAt)
+		}
+		return orders[i].UploadedAt.After(orders[j].UploadedAt)
+	})
+	return orders, nil
+}
+
+// EachByUserID вызывает fn для каждого заказа пользователя от новых к старым.
+// Ошибка fn прерывает обход и возвращается.
+func (s *InMemoryOrderStorage) EachByUserID(ctx context.Context, userID uuid.UUID, fn func(order *models.Order) error) error {
+	orders := s.filter(func(o *models.Order) bool { return o.UserID == userID })
+	for _, order := range newestFirst(orders, -1) {
+		if err := fn(order); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetByUserIDAfter возвращает до limit заказов пользователя, загруженных раньше заказа
+// (afterUploadedAt, afterID), от новых к старым. Нулевой afterUploadedAt означает первую страницу.
+func (s *InMemoryOrderStorage) GetByUserIDAfter(ctx context.Context, userID uuid.UUID, afterUploadedAt time.Time, afterID uuid.UUID, limit int) ([]*models.Order, error) {
+	orders := s.filter(func(o *models.Order) bool {
+		if o.UserID != userID {
+			return false
+		}
+		return afterUploadedAt.IsZero() || orderKeyLess(o, afterUploadedAt, afterID)
+	})
+	return newestFirst(orders, limit), nil
+}
+
+// SearchByNumberPrefix возвращает до limit заказов пользователя, номер которых начинается
+// с prefix, от новых к старым.
+func (s *InMemoryOrderStorage) SearchByNumberPrefix(ctx context.Context, userID uuid.UUID, prefix string, limit int) ([]*models.Order, error) {
+	orders := s.filter(func(o *models.Order) bool {
+		return o.UserID == userID && strings.HasPrefix(o.Number, prefix)
+	})
+	return newestFirst(orders, limit), nil
+}
+
+// UpdateStatus обновляет статус и начисление заказа. Заказ в окончательном статусе
+// (PROCESSED, INVALID) не меняется, как и в PostgresOrderStorage.
+func (s *InMemoryOrderStorage) UpdateStatus(ctx context.Context, number string, status models.OrderStatus, accrual *decimal.Decimal) error {
+	_, err := s.setStatusUnless(number, status, accrual, models.OrderStatusProcessed, models.OrderStatusInvalid)
+	return err
+}
+
+// ApplyAccrualTx отмечает заказ обработанным с начислением accrual в рамках транзакции tx;
+// при откате транзакции заказ возвращается в прежнее состояние. Уже обработанный заказ
+// не меняется, и возвращается false.
+func (s *InMemoryOrderStorage) ApplyAccrualTx(ctx context.Context, tx pgx.Tx, number string, accrual decimal.Decimal) (bool, error) {
+	prev, err := s.setStatusUnless(number, models.OrderStatusProcessed, &accrual, models.OrderStatusProcessed)
+	if err != nil || prev == nil {
+		return false, err
+	}
+	onRollback(tx, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.byNumber[number]; ok {
+			s.byNumber[number] = prev
+		}
+	})
+	return true, nil
+}
+
+// ResetToNew возвращает заказ в статус NEW, чтобы воркер заново запросил начисление.
+// Обработанный заказ не сбрасывается: возвращается ErrOrderAlreadyProcessed.
+func (s *InMemoryOrderStorage) ResetToNew(ctx context.Context, number string) error {
+	prev, err := s.setStatusUnless(number, models.OrderStatusNew, nil, models.OrderStatusProcessed)
+	if err != nil {
+		return err
+	}
+	if prev == nil {
+		return ErrOrderAlreadyProcessed
+	}
+	return nil
+}
+
+// ResetToNewIfInvalid возвращает заказ пользователя userID в статус NEW, только если
+// сервис начислений признал его INVALID. Сообщает, был ли заказ сброшен.
+func (s *InMemoryOrderStorage) ResetToNewIfInvalid(ctx context.Context, number string, userID uuid.UUID) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, ok := s.byNumber[number]
+	if !ok || order.UserID != userID || order.Status != models.OrderStatusInvalid {
+		return false, nil
+	}
+	updated := copyOrder(order)
+	updated.Status = models.OrderStatusNew
+	updated.Accrual = nil
+	updated.UpdatedAt = s.now()
+	s.byNumber[number] = updated
+	return true, nil
+}
+
+// setStatusUnless заменяет статус и начисление заказа и возвращает его прежнюю версию.
+// Заказ в одном из статусов skip не меняется, и тогда возвращается nil без ошибки.
+func (s *InMemoryOrderStorage) setStatusUnless(number string, status models.OrderStatus, accrual *decimal.Decimal, skip ...models.OrderStatus) (*models.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev, ok := s.byNumber[number]
+	if !ok {
+		return nil, ErrOrderNotFound
+	}
+	if slices.Contains(skip, prev.Status) {
+		return nil, nil
+	}
+	// Прежние статус и начисление: updated_at не сдвигается, как и в PostgresOrderStorage.UpdateStatus
+	if prev.Status == status && sameAccrual(prev.Accrual, accrual) {
+		return prev, nil
+	}
+	updated := copyOrder(prev)
+	updated.Status = status
+	updated.Accrual = nil
+	if accrual != nil {
+		a := *accrual
+		updated.Accrual = &a
+	}
+	updated.UpdatedAt = s.now()
+	s.byNumber[number] = updated
+	return prev, nil
+}
+
+// sameAccrual сообщает, что начисления совпадают; nil совпадает только с nil.
+func sameAccrual(a, b *decimal.Decimal) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.Equal(*b)
+}
+
+// GetPendingOrders возвращает заказы в статусах NEW и PROCESSING, от старых к новым.
+func (s *InMemoryOrderStorage) GetPendingOrders(ctx context.Context) ([]*models.Order, error) {
+	orders := s.filter(func(o *models.Order) bool {
+		return o.Status == models.OrderStatusNew || o.Status == models.OrderStatusProcessing
+	})
+	sort.SliceStable(orders, func(i, j int) bool {
+		return orders[i].UploadedAt.Before(orders[j].UploadedAt)
+	})
+	return orders, nil
+}
+
+// GetStuckProcessing возвращает заказы, которые находятся в статусе PROCESSING
+// и не обновлялись дольше olderThan.
+func (s *InMemoryOrderStorage) GetStuckProcessing(ctx context.Context, olderThan time.Duration) ([]*models.Order, error) {
+	cutoff := s.now().Add(-olderThan)
+	orders := s.filter(func(o *models.Order) bool {
+		return o.Status == models.OrderStatusProcessing && o.UpdatedAt.Before(cutoff)
+	})
+	sort.SliceStable(orders, func(i, j int) bool {
+		return orders[i].UpdatedAt.Before(orders[j].UpdatedAt)
+	})
+	return orders, nil
+}
+
+// GetAccruedTotal возвращает сумму начислений по обработанным заказам пользователя.
+func (s *InMemoryOrderStorage) GetAccruedTotal(ctx context.Context, userID uuid.UUID) (decimal.Decimal, error) {
+	total := decimal.Zero
+	for _, o := range s.filter(func(o *models.Order) bool {
+		return o.UserID == userID && o.Status == models.OrderStatusProcessed && o.Accrual != nil
+	}) {
+		total = total.Add(*o.Accrual)
+	}
+	return total, nil
+}
+
+// CountByUser возвращает число заказов пользователя.
+func (s *InMemoryOrderStorage) CountByUser(ctx context.Context, userID uuid.UUID) (int, error) {
+	orders := s.filter(func(o *models.Order) bool { return o.UserID == userID })
+	return len(orders), nil
+}
+
+// filter возвращает копии заказов, для которых keep вернула true, в порядке загрузки.
+func (s *InMemoryOrderStorage) filter(keep func(o *models.Order) bool) []*models.Order {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var orders []*models.Order
+	for _, number := range s.numbers {
+		if order := s.byNumber[number]; keep(order) {
+			orders = append(orders, copyOrder(order))
+		}
+	}
+	return orders
+}
+
+// orderKeyLess сообщает, что заказ предшествует ключу (uploadedAt, id) в порядке
+// (uploaded_at, id) - так же, как сравнение строк в PostgreSQL.
+func orderKeyLess(o *models.Order, uploadedAt time.Time, id uuid.UUID) bool {
+	if !o.UploadedAt.Equal(uploadedAt) {
+		return o.UploadedAt.Before(uploadedAt)
+	}
+	return bytes.Compare(o.ID[:], id[:]) < 0
+}
+
+// newestFirst сортирует заказы по (uploaded_at, id) от новых к старым и оставляет первые limit;
+// отрицательный limit оставляет все.
+func newestFirst(orders []*models.Order, limit int) []*models.Order {
+	sort.Slice(orders, func(i, j int) bool {
+		return orderKeyLess(orders[j], orders[i].UploadedAt, orders[i].ID)
+	})
+	if limit >= 0 && len(orders) > limit {
+		orders = orders[:limit]
+	}
+	return orders
+}
+
+// copyOrder возвращает копию заказа, не разделяющую с оригиналом начисление.
+func copyOrder(order *models.Order) *models.Order {
+	c := *order
+	if order.Accrual != nil {
+		a := *order.Accrual
+		c.Accrual = &a
+	}
+	return &c
+}
@@ -0,0 +1,309 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/agamariel/gofermart/internal/models"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// newClockedOrderStorage возвращает хранилище, часы которого сдвигаются на секунду при каждом чтении.
+func newClockedOrderStorage() *InMemoryOrderStorage {
+	s := NewInMemoryOrderStorage()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+	return s
+}
+
+func orderNumbers(orders []*models.Order) []string {
+	numbers := make([]string, 0, len(orders))
+	for _, o := range orders {
+		numbers = append(numbers, o.Number)
+	}
+	return numbers
+}
+
+func equalNumbers(got []*models.Order, want ...string) bool {
+	numbers := orderNumbers(got)
+	if len(numbers) != len(want) {
+		return false
+	}
+	for i := range want {
+		if numbers[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestInMemoryOrderStorage_CreateAndGet(t *testing.T) {
+	ctx := context.Background()
+	s := newClockedOrderStorage()
+	userID := uuid.New()
+
+	order := &models.Order{UserID: userID, Number: "12345678903", Status: models.OrderStatusNew}
+	if err := s.Create(ctx, order); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if order.ID == uuid.Nil || order.UploadedAt.IsZero() {
+		t.Fatalf("Create() did not fill ID and UploadedAt: %+v", order)
+	}
+
+	t.Run("number is unique across users", func(t *testing.T) {
+		err := s.Create(ctx, &models.Order{UserID: uuid.New(), Number: "12345678903", Status: models.OrderStatusNew})
+		if !errors.Is(err, ErrOrderAlreadyExists) {
+			t.Fatalf("Create() error = %v, want ErrOrderAlreadyExists", err)
+		}
+	})
+
+	t.Run("owner", func(t *testing.T) {
+		owner, ok, err := s.OrderOwner(ctx, "12345678903")
+		if err != nil || !ok || owner != userID {
+			t.Errorf("OrderOwner() = %v, %v, %v; want %v", owner, ok, err, userID)
+		}
+		if _, ok, err := s.OrderOwner(ctx, "79927398713"); ok || err != nil {
+			t.Errorf("OrderOwner() of unknown order = %v, %v; want not found", ok, err)
+		}
+	})
+
+	t.Run("get for user", func(t *testing.T) {
+		if got, err := s.GetByNumberForUser(ctx, "12345678903", userID); err != nil || got.ID != order.ID {
+			t.Errorf("GetByNumberForUser() = %+v, %v; want the user's order", got, err)
+		}
+		if _, err := s.GetByNumberForUser(ctx, "12345678903", uuid.New()); !errors.Is(err, ErrOrderNotFound) {
+			t.Errorf("GetByNumberForUser() for another user error = %v, want ErrOrderNotFound", err)
+		}
+	})
+
+	t.Run("unknown order", func(t *testing.T) {
+		if _, err := s.GetByNumber(ctx, "79927398713"); !errors.Is(err, ErrOrderNotFound) {
+			t.Errorf("GetByNumber() error = %v, want ErrOrderNotFound", err)
+		}
+		if err := s.UpdateStatus(ctx, "79927398713", models.OrderStatusProcessing, nil); !errors.Is(err, ErrOrderNotFound) {
+			t.Errorf("UpdateStatus() error = %v, want ErrOrderNotFound", err)
+		}
+	})
+
+	t.Run("batch skips existing numbers", func(t *testing.T) {
+		created, err := s.CreateBatch(ctx, []*models.Order{
+			{UserID: userID, Number: "12345678903", Status: models.OrderStatusNew},
+			{UserID: userID, Number: "79927398713", Status: models.OrderStatusNew},
+		})
+		if err != nil {
+			t.Fatalf("CreateBatch() error = %v", err)
+		}
+		if created[0] || !created[1] {
+			t.Errorf("CreateBatch() = %v, want [false true]", created)
+		}
+		if count, _ := s.CountByUser(ctx, userID); count != 2 {
+			t.Errorf("CountByUser() = %d, want 2", count)
+		}
+	})
+}
+
+func TestInMemoryOrderStorage_Listing(t *testing.T) {
+	ctx := context.Background()
+	s := newClockedOrderStorage()
+	userID := uuid.New()
+	for _, number := range []string{"111", "222", "123", "333"} {
+		if err := s.Create(ctx, &models.Order{UserID: userID, Number: number, Status: models.OrderStatusNew}); err != nil {
+			t.Fatalf("Create(%s) error = %v", number, err)
+		}
+	}
+	if err := s.Create(ctx, &models.Order{UserID: uuid.New(), Number: "124", Status: models.OrderStatusNew}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	if got, _ := s.GetByUserID(ctx, userID); !equalNumbers(got, "333", "123", "222", "111") {
+		t.Errorf("GetByUserID() = %v, want newest first", orderNumbers(got))
+	}
+	if got, _ := s.GetByUserIDSorted(ctx, userID, true); !equalNumbers(got, "111", "222", "123", "333") {
+		t.Errorf("GetByUserIDSorted(asc) = %v, want oldest first", orderNumbers(got))
+	}
+	if got, _ := s.SearchByNumberPrefix(ctx, userID, "12", 10); !equalNumbers(got, "123") {
+		t.Errorf("SearchByNumberPrefix() = %v, want only the user's 123", orderNumbers(got))
+	}
+
+	var streamed []*models.Order
+	err := s.EachByUserID(ctx, userID, func(o *models.Order) error {
+		streamed = append(streamed, o)
+		return nil
+	})
+	if err != nil || !equalNumbers(streamed, "333", "123", "222", "111") {
+		t.Errorf("EachByUserID() = %v, %v; want newest first", orderNumbers(streamed), err)
+	}
+	errStop := errors.New("stop")
+	calls := 0
+	err = s.EachByUserID(ctx, userID, func(o *models.Order) error {
+		calls++
+		return errStop
+	})
+	if !errors.Is(err, errStop) || calls != 1 {
+		t.Errorf("EachByUserID() = %v after %d calls, want the callback error after 1", err, calls)
+	}
+
+	page, _ := s.GetByUserIDAfter(ctx, userID, time.Time{}, uuid.Nil, 2)
+	if !equalNumbers(page, "333", "123") {
+		t.Fatalf("first page = %v, want [333 123]", orderNumbers(page))
+	}
+	last := page[len(page)-1]
+	page, _ = s.GetByUserIDAfter(ctx, userID, last.UploadedAt, last.ID, 2)
+	if !equalNumbers(page, "222", "111") {
+		t.Errorf("second page = %v, want [222 111]", orderNumbers(page))
+	}
+}
+
+func TestInMemoryOrderStorage_StatusLifecycle(t *testing.T) {
+	ctx := context.Background()
+	s := newClockedOrderStorage()
+	userID := uuid.New()
+	for _, number := range []string{"111", "222", "333"} {
+		if err := s.Create(ctx, &models.Order{UserID: userID, Number: number, Status: models.OrderStatusNew}); err != nil {
+			t.Fatalf("Create(%s) error = %v", number, err)
+		}
+	}
+
+	if err := s.UpdateStatus(ctx, "111", models.OrderStatusProcessing, nil); err != nil {
+		t.Fatalf("UpdateStatus() error = %v", err)
+	}
+	accrual := decimal.RequireFromString("120.5")
+	if err := s.UpdateStatus(ctx, "222", models.OrderStatusProcessed, &accrual); err != nil {
+		t.Fatalf("UpdateStatus() error = %v", err)
+	}
+	if err := s.UpdateStatus(ctx, "333", models.OrderStatusInvalid, nil); err != nil {
+		t.Fatalf("UpdateStatus() error = %v", err)
+	}
+
+	if got, _ := s.GetPendingOrders(ctx); !equalNumbers(got, "111") {
+		t.Errorf("GetPendingOrders() = %v, want [111]", orderNumbers(got))
+	}
+	if total, _ := s.GetAccruedTotal(ctx, userID); !total.Equal(accrual) {
+		t.Errorf("GetAccruedTotal() = %s, want %s", total, accrual)
+	}
+
+	t.Run("accrual is copied", func(t *testing.T) {
+		accrual = decimal.NewFromInt(1)
+		got, _ := s.GetByNumber(ctx, "222")
+		if got.Accrual == nil || !got.Accrual.Equal(decimal.RequireFromString("120.5")) {
+			t.Errorf("stored accrual = %v, want 120.5", got.Accrual)
+		}
+	})
+
+	t.Run("stuck processing", func(t *testing.T) {
+		if got, _ := s.GetStuckProcessing(ctx, time.Hour); len(got) != 0 {
+			t.Errorf("GetStuckProcessing(1h) = %v, want none", orderNumbers(got))
+		}
+		if got, _ := s.GetStuckProcessing(ctx, 0); !equalNumbers(got, "111") {
+			t.Errorf("GetStuckProcessing(0) = %v, want [111]", orderNumbers(got))
+		}
+	})
+
+	t.Run("repeated polling does not refresh a stuck order", func(t *testing.T) {
+		s := NewInMemoryOrderStorage()
+		now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+		s.now = func() time.Time { return now }
+		if err := s.Create(ctx, &models.Order{Number: "444", UserID: userID, Status: models.OrderStatusNew}); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+		if err := s.UpdateStatus(ctx, "444", models.OrderStatusProcessing, nil); err != nil {
+			t.Fatalf("UpdateStatus() error = %v", err)
+		}
+		// Воркер опрашивает заказ на каждом тике, а сервис начислений отвечает PROCESSING
+		for i := 0; i < 5; i++ {
+			now = now.Add(time.Minute)
+			if err := s.UpdateStatus(ctx, "444", models.OrderStatusProcessing, nil); err != nil {
+				t.Fatalf("UpdateStatus() error = %v", err)
+			}
+		}
+		if got, _ := s.GetStuckProcessing(ctx, 3*time.Minute); !equalNumbers(got, "444") {
+			t.Errorf("GetStuckProcessing(3m) = %v, want [444]", orderNumbers(got))
+		}
+		if err := s.UpdateStatus(ctx, "missing", models.OrderStatusProcessing, nil); !errors.Is(err, ErrOrderNotFound) {
+			t.Errorf("UpdateStatus(missing) error = %v, want ErrOrderNotFound", err)
+		}
+	})
+
+	t.Run("terminal statuses are not overwritten", func(t *testing.T) {
+		// Запоздавший промежуточный ответ не возвращает заказ в опрос
+		for _, number := range []string{"222", "333"} {
+			before, _ := s.GetByNumber(ctx, number)
+			if err := s.UpdateStatus(ctx, number, models.OrderStatusProcessing, nil); err != nil {
+				t.Fatalf("UpdateStatus(%s) error = %v", number, err)
+			}
+			after, _ := s.GetByNumber(ctx, number)
+			if after.Status != before.Status || !sameAccrual(after.Accrual, before.Accrual) || !after.UpdatedAt.Equal(before.UpdatedAt) {
+				t.Errorf("order %s = %+v, want unchanged %+v", number, after, before)
+			}
+		}
+	})
+
+	t.Run("reset invalid", func(t *testing.T) {
+		if reset, _ := s.ResetToNewIfInvalid(ctx, "333", uuid.New()); reset {
+			t.Error("ResetToNewIfInvalid() reset another user's order")
+		}
+		if reset, _ := s.ResetToNewIfInvalid(ctx, "222", userID); reset {
+			t.Error("ResetToNewIfInvalid() reset a processed order")
+		}
+		if reset, err := s.ResetToNewIfInvalid(ctx, "333", userID); !reset || err != nil {
+			t.Fatalf("ResetToNewIfInvalid() = %v, %v; want true", reset, err)
+		}
+		if got, _ := s.GetPendingOrders(ctx); !equalNumbers(got, "111", "333") {
+			t.Errorf("GetPendingOrders() = %v, want [111 333]", orderNumbers(got))
+		}
+	})
+
+	t.Run("reset to new", func(t *testing.T) {
+		if err := s.ResetToNew(ctx, "222"); !errors.Is(err, ErrOrderAlreadyProcessed) {
+			t.Fatalf("ResetToNew(processed) error = %v, want ErrOrderAlreadyProcessed", err)
+		}
+		if got, _ := s.GetByNumber(ctx, "222"); got.Status != models.OrderStatusProcessed || got.Accrual == nil {
+			t.Errorf("processed order = %+v, want unchanged", got)
+		}
+		if err := s.ResetToNew(ctx, "999"); !errors.Is(err, ErrOrderNotFound) {
+			t.Errorf("ResetToNew(missing) error = %v, want ErrOrderNotFound", err)
+		}
+		if err := s.ResetToNew(ctx, "111"); err != nil {
+			t.Fatalf("ResetToNew() error = %v", err)
+		}
+		got, _ := s.GetByNumber(ctx, "111")
+		if got.Status != models.OrderStatusNew || got.Accrual != nil {
+			t.Errorf("order = %+v, want NEW without accrual", got)
+		}
+	})
+}
+
+func TestInMemoryOrderStorage_ApplyAccrualTx(t *testing.T) {
+	ctx := context.Background()
+	s := newClockedOrderStorage()
+	beginner := NewMemoryTxBeginner()
+	if err := s.Create(ctx, &models.Order{UserID: uuid.New(), Number: "111", Status: models.OrderStatusProcessing}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	tx, _ := beginner.Begin(ctx)
+	if applied, err := s.ApplyAccrualTx(ctx, tx, "111", decimal.NewFromInt(50)); !applied || err != nil {
+		t.Fatalf("ApplyAccrualTx() = %v, %v; want applied", applied, err)
+	}
+	_ = tx.Commit(ctx)
+
+	tx, _ = beginner.Begin(ctx)
+	if applied, err := s.ApplyAccrualTx(ctx, tx, "111", decimal.NewFromInt(70)); applied || err != nil {
+		t.Errorf("second ApplyAccrualTx() = %v, %v; want not applied", applied, err)
+	}
+	_ = tx.Rollback(ctx)
+	got, _ := s.GetByNumber(ctx, "111")
+	if got.Status != models.OrderStatusProcessed || got.Accrual == nil || !got.Accrual.Equal(decimal.NewFromInt(50)) {
+		t.Errorf("order = %+v, want PROCESSED with accrual 50", got)
+	}
+
+	if _, err := s.ApplyAccrualTx(ctx, nil, "222", decimal.NewFromInt(1)); !errors.Is(err, ErrOrderNotFound) {
+		t.Errorf("ApplyAccrualTx() of unknown order error = %v, want ErrOrderNotFound", err)
+	}
+}
@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// errMemoryTxSQL - SQL-запрос в транзакции хранилищ в памяти.
+var errMemoryTxSQL = errors.New("sql is not supported in memory storage transaction")
+
+// MemoryTxBeginner открывает транзакции для хранилищ в памяти (STORAGE=memory)
+// и заменяет *pgxpool.Pool в сервисах, которым нужна транзакция.
+type MemoryTxBeginner struct{}
+
+// NewMemoryTxBeginner создаёт MemoryTxBeginner.
+func NewMemoryTxBeginner() *MemoryTxBeginner {
+	return &MemoryTxBeginner{}
+}
+
+// Begin открывает транзакцию хранилищ в памяти.
+func (b *MemoryTxBeginner) Begin(ctx context.Context) (pgx.Tx, error) {
+	return &memoryTx{}, nil
+}
+
+// memoryTx - транзакция хранилищ в памяти. Tx-методы хранилищ применяют изменения сразу
+// и регистрируют обратные действия; Rollback выполняет их в обратном порядке, Commit - забывает.
+// Изоляции нет: до фиксации изменения видны другим запросам.
+// Методы pgx.Tx, не переопределённые здесь, не используются.
+type memoryTx struct {
+	pgx.Tx
+
+	mu     sync.Mutex
+	undo   []func()
+	closed bool
+}
+
+// onRollback регистрирует действие, отменяющее уже применённое изменение.
+func (tx *memoryTx) onRollback(undo func()) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	tx.undo = append(tx.undo, undo)
+}
+
+func (tx *memoryTx) Commit(ctx context.Context) error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.closed {
+		return pgx.ErrTxClosed
+	}
+	tx.closed = true
+	tx.undo = nil
+	return nil
+}
+
+// Rollback после Commit ничего не откатывает, как и в pgx.
+func (tx *memoryTx) Rollback(ctx context.Context) error {
+	tx.mu.Lock()
+	if tx.closed {
+		tx.mu.Unlock()
+		return pgx.ErrTxClosed
+	}
+	tx.closed = true
+	undo := tx.undo
+	tx.undo = nil
+	tx.mu.Unlock()
+
+	for i := len(undo) - 1; i >= 0; i-- {
+		undo[i]()
+	}
+	return nil
+}
+
+func (tx *memoryTx) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, errMemoryTxSQL
+}
+
+func (tx *memoryTx) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return nil, errMemoryTxSQL
+}
+
+func (tx *memoryTx) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return memoryTxRow{}
+}
+
+// memoryTxRow - результат QueryRow в транзакции хранилищ в памяти.
+type memoryTxRow struct{}
+
+func (memoryTxRow) Scan(dest ...any) error {
+	return errMemoryTxSQL
+}
+
+// onRollback регистрирует undo в tx, если это транзакция хранилищ в памяти.
+// Вне такой транзакции (например, tx == nil) изменение просто остаётся применённым.
+func onRollback(tx pgx.Tx, undo func()) {
+	if mtx, ok := tx.(*memoryTx); ok {
+		mtx.onRollback(undo)
+	}
+}
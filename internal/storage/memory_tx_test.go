@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/agamariel/gofermart/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+)
+
+func TestMemoryTx_RollbackUndoesChanges(t *testing.T) {
+	ctx := context.Background()
+	users := NewInMemoryUserStorage()
+	orders := NewInMemoryOrderStorage()
+	withdrawals := NewInMemoryWithdrawalStorage()
+	user := newMemoryUser(t, users, "alice", 100)
+	if err := orders.Create(ctx, &models.Order{UserID: user.ID, Number: "12345678903", Status: models.OrderStatusProcessing}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := withdrawals.Create(ctx, &models.Withdrawal{UserID: user.ID, OrderNumber: "111", Sum: decimal.NewFromInt(5)}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	tx, _ := NewMemoryTxBeginner().Begin(ctx)
+	accrual := decimal.NewFromInt(50)
+	steps := []error{
+		users.CreditTx(ctx, tx, user.ID, accrual),
+		users.WithdrawTx(ctx, tx, user.ID, decimal.NewFromInt(30)),
+		withdrawals.CreateWithTx(ctx, tx, &models.Withdrawal{UserID: user.ID, OrderNumber: "2377225624", Sum: decimal.NewFromInt(30)}),
+		users.RefundTx(ctx, tx, user.ID, decimal.NewFromInt(5)),
+	}
+	_, err := withdrawals.DeleteByOrder(ctx, tx, user.ID, "111")
+	steps = append(steps, err)
+	applied, err := orders.ApplyAccrualTx(ctx, tx, "12345678903", accrual)
+	if !applied {
+		t.Fatal("ApplyAccrualTx() did not apply the accrual")
+	}
+	steps = append(steps, err)
+	for i, err := range steps {
+		if err != nil {
+			t.Fatalf("step %d error = %v", i, err)
+		}
+	}
+
+	if err := tx.Rollback(ctx); err != nil {
+		t.Fatalf("Rollback() error = %v", err)
+	}
+
+	got, _ := users.GetByID(ctx, user.ID)
+	if !got.Balance.Equal(decimal.NewFromInt(100)) || !got.Withdrawn.IsZero() {
+		t.Errorf("balance = %s, withdrawn = %s; want 100 and 0", got.Balance, got.Withdrawn)
+	}
+	order, _ := orders.GetByNumber(ctx, "12345678903")
+	if order.Status != models.OrderStatusProcessing || order.Accrual != nil {
+		t.Errorf("order = %+v, want PROCESSING without accrual", order)
+	}
+	list, _ := withdrawals.GetByUserID(ctx, user.ID)
+	if len(list) != 1 || list[0].OrderNumber != "111" {
+		t.Errorf("withdrawals = %+v, want only 111", list)
+	}
+}
+
+func TestMemoryTx_CommitKeepsChanges(t *testing.T) {
+	ctx := context.Background()
+	users := NewInMemoryUserStorage()
+	user := newMemoryUser(t, users, "alice", 0)
+
+	tx, _ := NewMemoryTxBeginner().Begin(ctx)
+	if err := users.CreditTx(ctx, tx, user.ID, decimal.NewFromInt(50)); err != nil {
+		t.Fatalf("CreditTx() error = %v", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	if err := tx.Rollback(ctx); !errors.Is(err, pgx.ErrTxClosed) {
+		t.Errorf("Rollback() after Commit error = %v, want pgx.ErrTxClosed", err)
+	}
+
+	got, _ := users.GetByID(ctx, user.ID)
+	if !got.Balance.Equal(decimal.NewFromInt(50)) {
+		t.Errorf("balance = %s, want 50", got.Balance)
+	}
+	if _, err := tx.Exec(ctx, "SELECT 1"); !errors.Is(err, errMemoryTxSQL) {
+		t.Errorf("Exec() error = %v, want errMemoryTxSQL", err)
+	}
+}
@@ -0,0 +1,306 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/agamariel/gofermart/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+)
+
+// InMemoryUserStorage реализует UserStorage в памяти процесса - для локальной разработки
+// и быстрых тестов без PostgreSQL. Семантика повторяет PostgresUserStorage: уникальность логина,
+// проверка баланса при списании, блокировка входа. Методы безопасны для конкурентного вызова.
+//
+// Tx-методы применяют изменения сразу; если tx открыта MemoryTxBeginner, откат транзакции
+// их отменяет, иначе tx не используется.
+type InMemoryUserStorage struct {
+	mu      sync.RWMutex
+	users   map[uuid.UUID]*models.User
+	byLogin map[string]uuid.UUID
+	now     func() time.Time
+	userStorageOptions
+}
+
+// NewInMemoryUserStorage создаёт пустое хранилище пользователей в памяти.
+func NewInMemoryUserStorage(opts ...UserStorageOption) *InMemoryUserStorage {
+	s := &InMemoryUserStorage{
+		users:   make(map[uuid.UUID]*models.User),
+		byLogin: make(map[string]uuid.UUID),
+		now:     time.Now,
+	}
+	for _, opt := range opts {
+		opt(&s.userStorageOptions)
+	}
+	return s
+}
+
+// Ping всегда успешен: хранилище не зависит от внешних систем.
+func (s *InMemoryUserStorage) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Create создаёт нового пользователя.
+func (s *InMemoryUserStorage) Create(ctx context.Context, user *models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.byLogin[user.Login]; ok {
+		return ErrLoginExists
+	}
+
+	if user.ID == uuid.Nil {
+		user.ID = uuid.New()
+	}
+	if _, ok := s.users[user.ID]; ok {
+		return fmt.Errorf("failed to create user: duplicate id %s", user.ID)
+	}
+	if user.Role == "" {
+		user.Role = models.RoleUser
+	}
+	now := s.now()
+	user.CreatedAt = now
+	user.UpdatedAt = now
+
+	s.users[user.ID] = copyUser(user)
+	s.byLogin[user.Login] = user.ID
+	return nil
+}
+
+// GetByLogin ищет пользователя по логину.
+func (s *InMemoryUserStorage) GetByLogin(ctx context.Context, login string) (*models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.byLogin[login]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return copyUser(s.users[id]), nil
+}
+
+// GetByID ищет пользователя по ID.
+func (s *InMemoryUserStorage) GetByID(ctx context.Context, id uuid.UUID) (*models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return copyUser(user), nil
+}
+
+// GetByIDs возвращает пользователей по списку идентификаторов.
+// Отсутствующие идентификаторы в результат не попадают.
+func (s *InMemoryUserStorage) GetByIDs(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]*models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := make(map[uuid.UUID]*models.User, len(ids))
+	for _, id := range ids {
+		if user, ok := s.users[id]; ok {
+			users[id] = copyUser(user)
+		}
+	}
+	return users, nil
+}
+
+// ListIDs возвращает идентификаторы всех пользователей в порядке регистрации.
+func (s *InMemoryUserStorage) ListIDs(ctx context.Context) ([]uuid.UUID, error) {
+	s.mu.RLock()
+	users := make([]*models.User, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, user)
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(users, func(i, j int) bool {
+		if !users[i].CreatedAt.Equal(users[j].CreatedAt) {
+			return users[i].CreatedAt.Before(users[j].CreatedAt)
+		}
+		return users[i].ID.String() < users[j].ID.String()
+	})
+	ids := make([]uuid.UUID, len(users))
+	for i, user := range users {
+		ids[i] = user.ID
+	}
+	return ids, nil
+}
+
+// GetByIDForUpdateTx ищет пользователя по ID. Строки в памяти не блокируются:
+// MemoryTxBeginner не изолирует транзакции, поэтому метод равносилен GetByID.
+func (s *InMemoryUserStorage) GetByIDForUpdateTx(ctx context.Context, tx pgx.Tx, id uuid.UUID) (*models.User, error) {
+	return s.GetByID(ctx, id)
+}
+
+// UpdatePasswordHash заменяет хеш пароля пользователя.
+func (s *InMemoryUserStorage) UpdatePasswordHash(ctx context.Context, id uuid.UUID, hash string) error {
+	return s.update(id, func(user *models.User) error {
+		user.PasswordHash = hash
+		return nil
+	})
+}
+
+// RegisterLoginFailure учитывает неудачную попытку входа так же, как PostgresUserStorage.RegisterLoginFailure.
+func (s *InMemoryUserStorage) RegisterLoginFailure(ctx context.Context, id uuid.UUID, maxAttempts int, lockFor time.Duration) (*time.Time, error) {
+	var lockedUntil *time.Time
+	err := s.update(id, func(user *models.User) error {
+		if user.FailedLoginAttempts+1 < maxAttempts {
+			user.FailedLoginAttempts++
+			return nil
+		}
+		until := s.now().Add(lockFor)
+		user.FailedLoginAttempts = 0
+		user.LockedUntil = &until
+		lockedUntil = &until
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return lockedUntil, nil
+}
+
+// ResetLoginFailures обнуляет счётчик неудачных попыток входа и снимает блокировку.
+func (s *InMemoryUserStorage) ResetLoginFailures(ctx context.Context, id uuid.UUID) error {
+	return s.update(id, func(user *models.User) error {
+		user.FailedLoginAttempts = 0
+		user.LockedUntil = nil
+		return nil
+	})
+}
+
+// Deactivate помечает пользователя деактивированным; повторная деактивация не меняет исходную дату.
+func (s *InMemoryUserStorage) Deactivate(ctx context.Context, id uuid.UUID) error {
+	return s.update(id, func(user *models.User) error {
+		if user.DeactivatedAt == nil {
+			now := s.now()
+			user.DeactivatedAt = &now
+		}
+		return nil
+	})
+}
+
+// UpdateBalance увеличивает баланс пользователя на указанную сумму.
+// Сумма больше заданного WithMaxBalanceUpdate отклоняется с ErrAmountTooLarge.
+func (s *InMemoryUserStorage) UpdateBalance(ctx context.Context, id uuid.UUID, amount decimal.Decimal) error {
+	if s.maxBalanceUpdate.IsPositive() && amount.GreaterThan(s.maxBalanceUpdate) {
+		return fmt.Errorf("%w: %s > %s", ErrAmountTooLarge, amount, s.maxBalanceUpdate)
+	}
+	return s.credit(id, amount)
+}
+
+// Withdraw списывает средства с баланса пользователя.
+func (s *InMemoryUserStorage) Withdraw(ctx context.Context, id uuid.UUID, amount decimal.Decimal) error {
+	return s.update(id, func(user *models.User) error {
+		if user.Balance.LessThan(amount) {
+			return &InsufficientBalanceError{Requested: amount, Available: user.Balance}
+		}
+		user.Balance = user.Balance.Sub(amount)
+		user.Withdrawn = user.Withdrawn.Add(amount)
+		return nil
+	})
+}
+
+// WithdrawTx списывает средства так же, как Withdraw, в рамках транзакции tx.
+func (s *InMemoryUserStorage) WithdrawTx(ctx context.Context, tx pgx.Tx, id uuid.UUID, amount decimal.Decimal) error {
+	if err := s.Withdraw(ctx, id, amount); err != nil {
+		return err
+	}
+	onRollback(tx, func() { s.refund(id, amount) })
+	return nil
+}
+
+// RefundTx возвращает на баланс ранее списанную сумму и уменьшает сумму списаний
+// в рамках транзакции tx.
+func (s *InMemoryUserStorage) RefundTx(ctx context.Context, tx pgx.Tx, id uuid.UUID, amount decimal.Decimal) error {
+	if err := s.refund(id, amount); err != nil {
+		return err
+	}
+	onRollback(tx, func() { s.refund(id, amount.Neg()) })
+	return nil
+}
+
+// CreditTx начисляет сумму на баланс пользователя в рамках транзакции tx.
+// Ограничение WithMaxBalanceUpdate не применяется, как и в PostgresUserStorage.CreditTx.
+func (s *InMemoryUserStorage) CreditTx(ctx context.Context, tx pgx.Tx, id uuid.UUID, amount decimal.Decimal) error {
+	if err := s.credit(id, amount); err != nil {
+		return err
+	}
+	onRollback(tx, func() { s.credit(id, amount.Neg()) })
+	return nil
+}
+
+// AdjustBalanceTx изменяет баланс на delta в рамках транзакции tx, не допуская
+// отрицательного баланса, как и PostgresUserStorage.AdjustBalanceTx.
+func (s *InMemoryUserStorage) AdjustBalanceTx(ctx context.Context, tx pgx.Tx, id uuid.UUID, delta decimal.Decimal) error {
+	err := s.update(id, func(user *models.User) error {
+		if user.Balance.Add(delta).IsNegative() {
+			return &InsufficientBalanceError{Requested: delta.Neg(), Available: user.Balance}
+		}
+		user.Balance = user.Balance.Add(delta)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	onRollback(tx, func() { s.credit(id, delta.Neg()) })
+	return nil
+}
+
+// refund переносит amount из суммы списаний обратно на баланс.
+func (s *InMemoryUserStorage) refund(id uuid.UUID, amount decimal.Decimal) error {
+	return s.update(id, func(user *models.User) error {
+		user.Balance = user.Balance.Add(amount)
+		user.Withdrawn = user.Withdrawn.Sub(amount)
+		return nil
+	})
+}
+
+// credit увеличивает баланс на amount.
+func (s *InMemoryUserStorage) credit(id uuid.UUID, amount decimal.Decimal) error {
+	return s.update(id, func(user *models.User) error {
+		user.Balance = user.Balance.Add(amount)
+		return nil
+	})
+}
+
+// update применяет fn к пользователю под блокировкой. Если fn вернула ошибку,
+// пользователь не меняется.
+func (s *InMemoryUserStorage) update(id uuid.UUID, fn func(user *models.User) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.users[id]
+	if !ok {
+		return ErrUserNotFound
+	}
+
+	user := copyUser(stored)
+	if err := fn(user); err != nil {
+		return err
+	}
+	user.UpdatedAt = s.now()
+	s.users[id] = user
+	return nil
+}
+
+// copyUser возвращает копию пользователя, не разделяющую с оригиналом указатели.
+func copyUser(user *models.User) *models.User {
+	c := *user
+	if user.DeactivatedAt != nil {
+		t := *user.DeactivatedAt
+		c.DeactivatedAt = &t
+	}
+	if user.LockedUntil != nil {
+		t := *user.LockedUntil
+		c.LockedUntil = &t
+	}
+	return &c
+}
@@ -0,0 +1,290 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/agamariel/gofermart/internal/models"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+func newMemoryUser(t *testing.T, s *InMemoryUserStorage, login string, balance int64) *models.User {
+	t.Helper()
+	user := &models.User{Login: login, PasswordHash: "hashed_password"}
+	if err := s.Create(context.Background(), user); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if balance > 0 {
+		if err := s.UpdateBalance(context.Background(), user.ID, decimal.NewFromInt(balance)); err != nil {
+			t.Fatalf("UpdateBalance() error = %v", err)
+		}
+	}
+	return user
+}
+
+func TestInMemoryUserStorage_CreateAndGet(t *testing.T) {
+	ctx := context.Background()
+	s := NewInMemoryUserStorage()
+
+	user := newMemoryUser(t, s, "alice", 0)
+	if user.ID == uuid.Nil {
+		t.Fatal("Create() did not assign an ID")
+	}
+	if user.Role != models.RoleUser || user.CreatedAt.IsZero() {
+		t.Errorf("Create() role = %q, created_at = %v; want defaults", user.Role, user.CreatedAt)
+	}
+
+	t.Run("duplicate login", func(t *testing.T) {
+		if err := s.Create(ctx, &models.User{Login: "alice"}); !errors.Is(err, ErrLoginExists) {
+			t.Fatalf("Create() error = %v, want ErrLoginExists", err)
+		}
+	})
+
+	t.Run("get by login and id", func(t *testing.T) {
+		byLogin, err := s.GetByLogin(ctx, "alice")
+		if err != nil || byLogin.ID != user.ID {
+			t.Fatalf("GetByLogin() = %+v, %v", byLogin, err)
+		}
+		byID, err := s.GetByID(ctx, user.ID)
+		if err != nil || byID.Login != "alice" {
+			t.Fatalf("GetByID() = %+v, %v", byID, err)
+		}
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		if _, err := s.GetByLogin(ctx, "bob"); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("GetByLogin() error = %v, want ErrUserNotFound", err)
+		}
+		if _, err := s.GetByID(ctx, uuid.New()); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("GetByID() error = %v, want ErrUserNotFound", err)
+		}
+	})
+
+	t.Run("get by ids skips unknown", func(t *testing.T) {
+		users, err := s.GetByIDs(ctx, []uuid.UUID{user.ID, uuid.New()})
+		if err != nil {
+			t.Fatalf("GetByIDs() error = %v", err)
+		}
+		if len(users) != 1 || users[user.ID] == nil {
+			t.Errorf("GetByIDs() = %v, want only %s", users, user.ID)
+		}
+	})
+
+	t.Run("returned users are copies", func(t *testing.T) {
+		got, _ := s.GetByID(ctx, user.ID)
+		got.Balance = decimal.NewFromInt(1_000_000)
+		user.PasswordHash = "changed"
+
+		stored, _ := s.GetByID(ctx, user.ID)
+		if !stored.Balance.IsZero() || stored.PasswordHash != "hashed_password" {
+			t.Errorf("stored user changed through a returned pointer: %+v", stored)
+		}
+	})
+}
+
+func TestInMemoryUserStorage_ListIDs(t *testing.T) {
+	ctx := context.Background()
+	s := NewInMemoryUserStorage()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+
+	ids, err := s.ListIDs(ctx)
+	if err != nil || len(ids) != 0 {
+		t.Fatalf("ListIDs() = %v, %v, want empty", ids, err)
+	}
+
+	var want []uuid.UUID
+	for _, login := range []string{"carol", "alice", "bob"} {
+		want = append(want, newMemoryUser(t, s, login, 0).ID)
+	}
+
+	ids, err = s.ListIDs(ctx)
+	if err != nil {
+		t.Fatalf("ListIDs() error = %v", err)
+	}
+	if len(ids) != len(want) {
+		t.Fatalf("ListIDs() = %v, want %v", ids, want)
+	}
+	for i := range want {
+		if ids[i] != want[i] {
+			t.Errorf("ListIDs()[%d] = %s, want %s in registration order", i, ids[i], want[i])
+		}
+	}
+}
+
+func TestInMemoryUserStorage_Withdraw(t *testing.T) {
+	ctx := context.Background()
+	s := NewInMemoryUserStorage()
+	user := newMemoryUser(t, s, "alice", 100)
+
+	if err := s.Withdraw(ctx, user.ID, decimal.RequireFromString("40.50")); err != nil {
+		t.Fatalf("Withdraw() error = %v", err)
+	}
+	if err := s.WithdrawTx(ctx, nil, user.ID, decimal.RequireFromString("9.50")); err != nil {
+		t.Fatalf("WithdrawTx() error = %v", err)
+	}
+
+	t.Run("insufficient balance", func(t *testing.T) {
+		err := s.Withdraw(ctx, user.ID, decimal.RequireFromString("50.01"))
+		var balanceErr *InsufficientBalanceError
+		if !errors.As(err, &balanceErr) || !errors.Is(err, ErrInsufficientBalance) {
+			t.Fatalf("Withdraw() error = %v, want InsufficientBalanceError", err)
+		}
+		if !balanceErr.Available.Equal(decimal.NewFromInt(50)) {
+			t.Errorf("Available = %s, want 50", balanceErr.Available)
+		}
+	})
+
+	got, _ := s.GetByID(ctx, user.ID)
+	if !got.Balance.Equal(decimal.NewFromInt(50)) || !got.Withdrawn.Equal(decimal.NewFromInt(50)) {
+		t.Errorf("balance = %s, withdrawn = %s; want 50 and 50", got.Balance, got.Withdrawn)
+	}
+
+	t.Run("refund", func(t *testing.T) {
+		if err := s.RefundTx(ctx, nil, user.ID, decimal.RequireFromString("9.50")); err != nil {
+			t.Fatalf("RefundTx() error = %v", err)
+		}
+		got, _ := s.GetByID(ctx, user.ID)
+		if !got.Balance.Equal(decimal.RequireFromString("59.50")) || !got.Withdrawn.Equal(decimal.RequireFromString("40.50")) {
+			t.Errorf("balance = %s, withdrawn = %s; want 59.50 and 40.50", got.Balance, got.Withdrawn)
+		}
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		if err := s.Withdraw(ctx, uuid.New(), decimal.NewFromInt(1)); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Withdraw() error = %v, want ErrUserNotFound", err)
+		}
+	})
+
+	t.Run("concurrent withdrawals never overdraw", func(t *testing.T) {
+		racer := newMemoryUser(t, s, "racer", 10)
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		succeeded := 0
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := s.Withdraw(ctx, racer.ID, decimal.NewFromInt(1)); err == nil {
+					mu.Lock()
+					succeeded++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+
+		got, _ := s.GetByID(ctx, racer.ID)
+		if succeeded != 10 || !got.Balance.IsZero() {
+			t.Errorf("succeeded = %d, balance = %s; want 10 and 0", succeeded, got.Balance)
+		}
+	})
+}
+
+func TestInMemoryUserStorage_AdjustBalanceTx(t *testing.T) {
+	ctx := context.Background()
+	s := NewInMemoryUserStorage()
+	user := newMemoryUser(t, s, "alice", 100)
+	beginner := NewMemoryTxBeginner()
+
+	if err := s.AdjustBalanceTx(ctx, nil, user.ID, decimal.RequireFromString("25.50")); err != nil {
+		t.Fatalf("AdjustBalanceTx(+25.50) error = %v", err)
+	}
+	if err := s.AdjustBalanceTx(ctx, nil, user.ID, decimal.RequireFromString("-125.50")); err != nil {
+		t.Fatalf("AdjustBalanceTx(-125.50) error = %v", err)
+	}
+	got, _ := s.GetByID(ctx, user.ID)
+	if !got.Balance.IsZero() || !got.Withdrawn.IsZero() {
+		t.Errorf("balance = %s, withdrawn = %s; want 0 and 0", got.Balance, got.Withdrawn)
+	}
+
+	t.Run("below zero", func(t *testing.T) {
+		err := s.AdjustBalanceTx(ctx, nil, user.ID, decimal.RequireFromString("-0.01"))
+		var balanceErr *InsufficientBalanceError
+		if !errors.As(err, &balanceErr) || !balanceErr.Available.IsZero() {
+			t.Fatalf("AdjustBalanceTx() error = %v, want InsufficientBalanceError", err)
+		}
+	})
+
+	t.Run("rollback restores the balance", func(t *testing.T) {
+		tx, _ := beginner.Begin(ctx)
+		if err := s.AdjustBalanceTx(ctx, tx, user.ID, decimal.NewFromInt(10)); err != nil {
+			t.Fatalf("AdjustBalanceTx() error = %v", err)
+		}
+		_ = tx.Rollback(ctx)
+
+		got, _ := s.GetByID(ctx, user.ID)
+		if !got.Balance.IsZero() {
+			t.Errorf("balance = %s after rollback, want 0", got.Balance)
+		}
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		if err := s.AdjustBalanceTx(ctx, nil, uuid.New(), decimal.NewFromInt(1)); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("AdjustBalanceTx() error = %v, want ErrUserNotFound", err)
+		}
+	})
+}
+
+func TestInMemoryUserStorage_UpdateBalanceMax(t *testing.T) {
+	ctx := context.Background()
+	s := NewInMemoryUserStorage(WithMaxBalanceUpdate(decimal.NewFromInt(1000)))
+	user := newMemoryUser(t, s, "alice", 0)
+
+	if err := s.UpdateBalance(ctx, user.ID, decimal.RequireFromString("1000.01")); !errors.Is(err, ErrAmountTooLarge) {
+		t.Fatalf("UpdateBalance() error = %v, want ErrAmountTooLarge", err)
+	}
+	if err := s.UpdateBalance(ctx, user.ID, decimal.NewFromInt(1000)); err != nil {
+		t.Fatalf("UpdateBalance() error = %v", err)
+	}
+}
+
+func TestInMemoryUserStorage_LoginFailuresAndDeactivation(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	s := NewInMemoryUserStorage()
+	s.now = func() time.Time { return now }
+	user := newMemoryUser(t, s, "alice", 0)
+
+	for i := 1; i < 3; i++ {
+		until, err := s.RegisterLoginFailure(ctx, user.ID, 3, time.Minute)
+		if err != nil || until != nil {
+			t.Fatalf("attempt %d: RegisterLoginFailure() = %v, %v; want no lock", i, until, err)
+		}
+	}
+	until, err := s.RegisterLoginFailure(ctx, user.ID, 3, time.Minute)
+	if err != nil || until == nil || !until.Equal(now.Add(time.Minute)) {
+		t.Fatalf("RegisterLoginFailure() = %v, %v; want lock until %v", until, err, now.Add(time.Minute))
+	}
+	got, _ := s.GetByID(ctx, user.ID)
+	if !got.IsLocked(now) || got.FailedLoginAttempts != 0 {
+		t.Errorf("locked = %v, attempts = %d; want locked with reset counter", got.IsLocked(now), got.FailedLoginAttempts)
+	}
+
+	if err := s.ResetLoginFailures(ctx, user.ID); err != nil {
+		t.Fatalf("ResetLoginFailures() error = %v", err)
+	}
+	if got, _ := s.GetByID(ctx, user.ID); got.LockedUntil != nil {
+		t.Errorf("LockedUntil = %v, want nil", got.LockedUntil)
+	}
+
+	if err := s.Deactivate(ctx, user.ID); err != nil {
+		t.Fatalf("Deactivate() error = %v", err)
+	}
+	now = now.Add(time.Hour)
+	if err := s.Deactivate(ctx, user.ID); err != nil {
+		t.Fatalf("second Deactivate() error = %v", err)
+	}
+	got, _ = s.GetByID(ctx, user.ID)
+	if got.DeactivatedAt == nil || !got.DeactivatedAt.Equal(now.Add(-time.Hour)) {
+		t.Errorf("DeactivatedAt = %v, want the first deactivation time", got.DeactivatedAt)
+	}
+}
@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/agamariel/gofermart/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+)
+
+// InMemoryWithdrawalStorage реализует WithdrawalStorage в памяти процесса (STORAGE=memory).
+// Как и в PostgreSQL, номер заказа уникален в пределах пользователя, а изменения
+// в транзакции MemoryTxBeginner отменяются её откатом. Методы безопасны для конкурентного вызова.
+type InMemoryWithdrawalStorage struct {
+	mu sync.RWMutex
+	// withdrawals хранит списания в порядке создания.
+	withdrawals []*models.Withdrawal
+	now         func() time.Time
+}
+
+// NewInMemoryWithdrawalStorage создаёт пустое хранилище списаний в памяти.
+func NewInMemoryWithdrawalStorage() *InMemoryWithdrawalStorage {
+	return &InMemoryWithdrawalStorage{now: time.Now}
+}
+
+// Ping всегда успешен: хранилище не зависит от внешних систем.
+func (s *InMemoryWithdrawalStorage) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Create создаёт списание вне явной транзакции.
+func (s *InMemoryWithdrawalStorage) Create(ctx context.Context, withdrawal *models.Withdrawal) error {
+	return s.CreateWithTx(ctx, nil, withdrawal)
+}
+
+// CreateWithTx создаёт списание в рамках транзакции tx. Если пользователь уже списывал
+// средства по этому номеру заказа, возвращается ErrWithdrawalExists.
+func (s *InMemoryWithdrawalStorage) CreateWithTx(ctx context.Context, tx pgx.Tx, withdrawal *models.Withdrawal) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.indexOf(withdrawal.UserID, withdrawal.OrderNumber) >= 0 {
+		return ErrWithdrawalExists
+	}
+	if withdrawal.ID == uuid.Nil {
+		withdrawal.ID = uuid.New()
+	}
+	withdrawal.ProcessedAt = s.now()
+
+	stored := *withdrawal
+	s.withdrawals = append(s.withdrawals, &stored)
+	onRollback(tx, func() { s.remove(stored.UserID, stored.OrderNumber) })
+	return nil
+}
+
+// ExistsByOrder сообщает, списывал ли пользователь средства по номеру заказа.
+func (s *InMemoryWithdrawalStorage) ExistsByOrder(ctx context.Context, tx pgx.Tx, userID uuid.UUID, orderNumber string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.indexOf(userID, orderNumber) >= 0, nil
+}
+
+// DeleteByOrder удаляет списание пользователя по номеру заказа в рамках транзакции tx
+// и возвращает удалённую запись. Если списания нет, возвращается ErrWithdrawalNotFound.
+func (s *InMemoryWithdrawalStorage) DeleteByOrder(ctx context.Context, tx pgx.Tx, userID uuid.UUID, orderNumber string) (*models.Withdrawal, error) {
+	deleted := s.remove(userID, orderNumber)
+	if deleted == nil {
+		return nil, ErrWithdrawalNotFound
+	}
+	onRollback(tx, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		restored := *deleted
+		s.withdrawals = append(s.withdrawals, &restored)
+	})
+
+	w := *deleted
+	return &w, nil
+}
+
+// GetByUserID возвращает списания пользователя, отсортированные по времени (новые первыми).
+func (s *InMemoryWithdrawalStorage) GetByUserID(ctx context.Context, userID uuid.UUID) ([]*models.Withdrawal, error) {
+	return s.GetByUserIDBetween(ctx, userID, time.Time{}, time.Time{})
+}
+
+// GetByUserIDBetween возвращает списания пользователя с processed_at в диапазоне [from, to],
+// новые первыми. Нулевая граница не ограничивает диапазон с этой стороны.
+func (s *InMemoryWithdrawalStorage) GetByUserIDBetween(ctx context.Context, userID uuid.UUID, from, to time.Time) ([]*models.Withdrawal, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var withdrawals []*models.Withdrawal
+	for _, stored := range s.withdrawals {
+		if stored.UserID != userID {
+			continue
+		}
+		if (!from.IsZero() && stored.ProcessedAt.Before(from)) || (!to.IsZero() && stored.ProcessedAt.After(to)) {
+			continue
+		}
+		w := *stored
+		withdrawals = append(withdrawals, &w)
+	}
+	sort.SliceStable(withdrawals, func(i, j int) bool {
+		return withdrawals[i].ProcessedAt.After(withdrawals[j].ProcessedAt)
+	})
+	return withdrawals, nil
+}
+
+// EachByUserIDBetween вызывает fn для каждого списания пользователя с processed_at в диапазоне
+// [from, to], новые первыми. Ошибка fn прерывает обход и возвращается.
+func (s *InMemoryWithdrawalStorage) EachByUserIDBetween(ctx context.Context, userID uuid.UUID, from, to time.Time, fn func(withdrawal *models.Withdrawal) error) error {
+	withdrawals, err := s.GetByUserIDBetween(ctx, userID, from, to)
+	if err != nil {
+		return err
+	}
+	for _, w := range withdrawals {
+		if err := fn(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetTotalByUser возвращает сумму всех списаний пользователя.
+func (s *InMemoryWithdrawalStorage) GetTotalByUser(ctx context.Context, userID uuid.UUID) (decimal.Decimal, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	total := decimal.Zero
+	for _, w := range s.withdrawals {
+		if w.UserID == userID {
+			total = total.Add(w.Sum)
+		}
+	}
+	return total, nil
+}
+
+// remove удаляет списание пользователя по номеру заказа и возвращает его; nil, если списания нет.
+func (s *InMemoryWithdrawalStorage) remove(userID uuid.UUID, orderNumber string) *models.Withdrawal {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.indexOf(userID, orderNumber)
+	if i < 0 {
+		return nil
+	}
+	deleted := s.withdrawals[i]
+	s.withdrawals = append(s.withdrawals[:i], s.withdrawals[i+1:]...)
+	return deleted
+}
+
+// indexOf возвращает индекс списания пользователя по номеру заказа или -1. Вызывается под s.mu.
+func (s *InMemoryWithdrawalStorage) indexOf(userID uuid.UUID, orderNumber string) int {
+	for i, w := range s.withdrawals {
+		if w.UserID == userID && w.OrderNumber == orderNumber {
+			return i
+		}
+	}
+	return -1
+}
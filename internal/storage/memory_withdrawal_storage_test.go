@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/agamariel/gofermart/internal/models"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+func TestInMemoryWithdrawalStorage(t *testing.T) {
+	ctx := context.Background()
+	s := NewInMemoryWithdrawalStorage()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time {
+		now = now.Add(time.Hour)
+		return now
+	}
+	userID := uuid.New()
+
+	for _, number := range []string{"111", "222", "333"} {
+		w := &models.Withdrawal{UserID: userID, OrderNumber: number, Sum: decimal.NewFromInt(10)}
+		if err := s.Create(ctx, w); err != nil {
+			t.Fatalf("Create(%s) error = %v", number, err)
+		}
+		if w.ID == uuid.Nil || w.ProcessedAt.IsZero() {
+			t.Fatalf("Create() did not fill ID and ProcessedAt: %+v", w)
+		}
+	}
+
+	t.Run("order number is unique per user", func(t *testing.T) {
+		err := s.Create(ctx, &models.Withdrawal{UserID: userID, OrderNumber: "111", Sum: decimal.NewFromInt(1)})
+		if !errors.Is(err, ErrWithdrawalExists) {
+			t.Fatalf("Create() error = %v, want ErrWithdrawalExists", err)
+		}
+		if err := s.Create(ctx, &models.Withdrawal{UserID: uuid.New(), OrderNumber: "111", Sum: decimal.NewFromInt(1)}); err != nil {
+			t.Fatalf("Create() for another user error = %v", err)
+		}
+	})
+
+	t.Run("exists by order", func(t *testing.T) {
+		if exists, err := s.ExistsByOrder(ctx, nil, userID, "111"); err != nil || !exists {
+			t.Errorf("ExistsByOrder(111) = %v, %v; want true", exists, err)
+		}
+		if exists, err := s.ExistsByOrder(ctx, nil, userID, "999"); err != nil || exists {
+			t.Errorf("ExistsByOrder(999) = %v, %v; want false", exists, err)
+		}
+		if exists, err := s.ExistsByOrder(ctx, nil, uuid.New(), "222"); err != nil || exists {
+			t.Errorf("ExistsByOrder() for another user = %v, %v; want false", exists, err)
+		}
+	})
+
+	t.Run("listing", func(t *testing.T) {
+		all, _ := s.GetByUserID(ctx, userID)
+		if len(all) != 3 || all[0].OrderNumber != "333" || all[2].OrderNumber != "111" {
+			t.Fatalf("GetByUserID() = %+v, want newest first", all)
+		}
+		between, _ := s.GetByUserIDBetween(ctx, userID, all[1].ProcessedAt, all[1].ProcessedAt)
+		if len(between) != 1 || between[0].OrderNumber != "222" {
+			t.Errorf("GetByUserIDBetween() = %+v, want only 222", between)
+		}
+		if total, _ := s.GetTotalByUser(ctx, userID); !total.Equal(decimal.NewFromInt(30)) {
+			t.Errorf("GetTotalByUser() = %s, want 30", total)
+		}
+
+		var streamed []string
+		err := s.EachByUserIDBetween(ctx, userID, all[1].ProcessedAt, time.Time{}, func(w *models.Withdrawal) error {
+			streamed = append(streamed, w.OrderNumber)
+			return nil
+		})
+		if err != nil || len(streamed) != 2 || streamed[0] != "333" || streamed[1] != "222" {
+			t.Errorf("EachByUserIDBetween() = %v, %v; want [333 222]", streamed, err)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		deleted, err := s.DeleteByOrder(ctx, nil, userID, "222")
+		if err != nil || deleted.OrderNumber != "222" {
+			t.Fatalf("DeleteByOrder() = %+v, %v", deleted, err)
+		}
+		if _, err := s.DeleteByOrder(ctx, nil, userID, "222"); !errors.Is(err, ErrWithdrawalNotFound) {
+			t.Errorf("second DeleteByOrder() error = %v, want ErrWithdrawalNotFound", err)
+		}
+		if total, _ := s.GetTotalByUser(ctx, userID); !total.Equal(decimal.NewFromInt(20)) {
+			t.Errorf("GetTotalByUser() = %s, want 20", total)
+		}
+	})
+}
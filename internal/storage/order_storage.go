@@ -2,9 +2,9 @@ package storage
 
 import (
 	"context"
-	"database/sql"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/agamariel/gofermart/internal/models"
 	"github.com/google/uuid"
@@ -17,8 +17,27 @@ import (
 var (
 	ErrOrderNotFound      = errors.New("order not found")
 	ErrOrderAlreadyExists = errors.New("order already exists")
+	// ErrOrderAlreadyProcessed - заказ уже обработан, и сбросить его нельзя.
+	ErrOrderAlreadyProcessed = errors.New("order already processed")
+	ErrCorruptAccrual        = errors.New("stored accrual is not a valid decimal")
 )
 
+// CorruptAccrualError - сохранённое в БД начисление заказа не разбирается как число.
+// Означает повреждение данных. Удовлетворяет errors.Is(err, ErrCorruptAccrual)
+// и errors.Is(err, ErrStorageInternal).
+type CorruptAccrualError struct {
+	Number string
+	Err    error
+}
+
+func (e *CorruptAccrualError) Error() string {
+	return fmt.Sprintf("%s: order %s: %v", ErrCorruptAccrual, e.Number, e.Err)
+}
+
+func (e *CorruptAccrualError) Unwrap() []error {
+	return []error{ErrCorruptAccrual, ErrStorageInternal, e.Err}
+}
+
 // PostgresOrderStorage реализует OrderStorage для PostgreSQL.
 type PostgresOrderStorage struct {
 	pool *pgxpool.Pool
@@ -29,24 +48,27 @@ func NewPostgresOrderStorage(pool *pgxpool.Pool) *PostgresOrderStorage {
 	return &PostgresOrderStorage{pool: pool}
 }
 
+// Ping проверяет доступность базы данных, с которой работает хранилище.
+func (s *PostgresOrderStorage) Ping(ctx context.Context) error {
+	return wrapInternal(s.pool.Ping(ctx))
+}
+
 // Create создаёт новый заказ.
 func (s *PostgresOrderStorage) Create(ctx context.Context, order *models.Order) error {
+	defer trackQuery(queryOrderCreate)()
+
 	query := `
 		INSERT INTO orders (user_id, number, status, accrual, uploaded_at, updated_at)
 		VALUES ($1, $2, $3, $4, NOW(), NOW())
 		RETURNING id, uploaded_at, updated_at
 	`
 
-	accrualVal := sql.NullString{}
-	if order.Accrual != nil {
-		accrualVal = sql.NullString{Valid: true, String: order.Accrual.String()}
-	}
-
+	// accrual - NUMERIC; nil-указатель записывается как NULL
 	err := s.pool.QueryRow(ctx, query,
 		order.UserID,
 		order.Number,
 		order.Status,
-		accrualVal,
+		order.Accrual,
 	).Scan(&order.ID, &order.UploadedAt, &order.UpdatedAt)
 
 	if err != nil {
@@ -54,14 +76,54 @@ func (s *PostgresOrderStorage) Create(ctx context.Context, order *models.Order)
 		if errors.As(err, &pgErr) && pgErr.Code == "23505" { // unique_violation
 			return ErrOrderAlreadyExists
 		}
-		return fmt.Errorf("failed to create order: %w", err)
+		return fmt.Errorf("failed to create order: %w", wrapInternal(err))
 	}
 
 	return nil
 }
 
+// CreateBatch создаёт заказы в одной транзакции. Заказы с уже существующими номерами
+// пропускаются; для каждого заказа возвращается признак того, что он был создан.
+func (s *PostgresOrderStorage) CreateBatch(ctx context.Context, orders []*models.Order) ([]bool, error) {
+	defer trackQuery(queryOrderCreateBatch)()
+
+	query := `
+		INSERT INTO orders (user_id, number, status, uploaded_at, updated_at)
+		VALUES ($1, $2, $3, NOW(), NOW())
+		ON CONFLICT (number) DO NOTHING
+		RETURNING id, uploaded_at, updated_at
+	`
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", wrapInternal(err))
+	}
+	defer tx.Rollback(ctx)
+
+	created := make([]bool, len(orders))
+	for i, order := range orders {
+		err := tx.QueryRow(ctx, query, order.UserID, order.Number, order.Status).
+			Scan(&order.ID, &order.UploadedAt, &order.UpdatedAt)
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to create order %s: %w", order.Number, wrapInternal(err))
+		}
+		created[i] = true
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", wrapInternal(err))
+	}
+
+	return created, nil
+}
+
 // GetByNumber возвращает заказ по номеру.
 func (s *PostgresOrderStorage) GetByNumber(ctx context.Context, number string) (*models.Order, error) {
+	defer trackQuery(queryOrderGetByNumber)()
+
 	query := `
 		SELECT id, user_id, number, status, accrual, uploaded_at, updated_at
 		FROM orders
@@ -71,18 +133,132 @@ func (s *PostgresOrderStorage) GetByNumber(ctx context.Context, number string) (
 	return scanOrder(s.pool.QueryRow(ctx, query, number))
 }
 
+// GetByNumberForUser возвращает заказ пользователя userID по номеру.
+// Если заказа нет или он загружен другим пользователем, возвращается ErrOrderNotFound.
+func (s *PostgresOrderStorage) GetByNumberForUser(ctx context.Context, number string, userID uuid.UUID) (*models.Order, error) {
+	defer trackQuery(queryOrderGetByNumberForUser)()
+
+	query := `
+		SELECT id, user_id, number, status, accrual, uploaded_at, updated_at
+		FROM orders
+		WHERE number = $1 AND user_id = $2
+	`
+
+	return scanOrder(s.pool.QueryRow(ctx, query, number, userID))
+}
+
+// OrderOwner возвращает владельца заказа. Второе значение false, если заказа нет.
+// В отличие от GetByNumber читает только user_id.
+func (s *PostgresOrderStorage) OrderOwner(ctx context.Context, number string) (uuid.UUID, bool, error) {
+	defer trackQuery(queryOrderOwner)()
+
+	query := `SELECT user_id FROM orders WHERE number = $1`
+
+	var userID uuid.UUID
+	if err := s.pool.QueryRow(ctx, query, number).Scan(&userID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return uuid.Nil, false, nil
+		}
+		return uuid.Nil, false, fmt.Errorf("failed to get order owner: %w", wrapInternal(err))
+	}
+
+	return userID, true, nil
+}
+
 // GetByUserID возвращает список заказов пользователя (сортировка по uploaded_at DESC).
 func (s *PostgresOrderStorage) GetByUserID(ctx context.Context, userID uuid.UUID) ([]*models.Order, error) {
+	return s.GetByUserIDSorted(ctx, userID, false)
+}
+
+// GetByUserIDSorted возвращает список заказов пользователя, отсортированный по uploaded_at
+// по возрастанию (asc = true) или по убыванию.
+func (s *PostgresOrderStorage) GetByUserIDSorted(ctx context.Context, userID uuid.UUID, asc bool) ([]*models.Order, error) {
+	defer trackQuery(queryOrderGetByUserID)()
+
+	direction := "DESC"
+	if asc {
+		direction = "ASC"
+	}
+
+	query := `
+		SELECT id, user_id, number, status, accrual, uploaded_at, updated_at
+		FROM orders
+		WHERE user_id = $1
+		ORDER BY uploaded_at ` + direction
+
+	rows, err := s.pool.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user orders: %w", wrapInternal(err))
+	}
+	defer rows.Close()
+
+	var orders []*models.Order
+	for rows.Next() {
+		order, err := scanOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("rows error: %w", wrapInternal(rows.Err()))
+	}
+
+	return orders, nil
+}
+
+// EachByUserID вызывает fn для каждого заказа пользователя от новых к старым по мере чтения
+// строк из БД, не собирая весь список в памяти. Ошибка fn прерывает чтение и возвращается.
+func (s *PostgresOrderStorage) EachByUserID(ctx context.Context, userID uuid.UUID, fn func(order *models.Order) error) error {
+	defer trackQuery(queryOrderEachByUserID)()
+
 	query := `
 		SELECT id, user_id, number, status, accrual, uploaded_at, updated_at
 		FROM orders
 		WHERE user_id = $1
-		ORDER BY uploaded_at DESC
+		ORDER BY uploaded_at DESC, id DESC
 	`
 
 	rows, err := s.pool.Query(ctx, query, userID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query user orders: %w", err)
+		return fmt.Errorf("failed to query user orders: %w", wrapInternal(err))
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		order, err := scanOrder(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(order); err != nil {
+			return err
+		}
+	}
+
+	if rows.Err() != nil {
+		return fmt.Errorf("rows error: %w", wrapInternal(rows.Err()))
+	}
+	return nil
+}
+
+// GetByUserIDAfter возвращает до limit заказов пользователя, загруженных раньше заказа
+// (afterUploadedAt, afterID), от новых к старым (keyset-пагинация).
+// Нулевой afterUploadedAt означает первую страницу.
+func (s *PostgresOrderStorage) GetByUserIDAfter(ctx context.Context, userID uuid.UUID, afterUploadedAt time.Time, afterID uuid.UUID, limit int) ([]*models.Order, error) {
+	defer trackQuery(queryOrderGetByUserIDAfter)()
+
+	query := `
+		SELECT id, user_id, number, status, accrual, uploaded_at, updated_at
+		FROM orders
+		WHERE user_id = $1 AND ($2::timestamptz IS NULL OR (uploaded_at, id) < ($2, $3))
+		ORDER BY uploaded_at DESC, id DESC
+		LIMIT $4
+	`
+
+	rows, err := s.pool.Query(ctx, query, userID, optionalTime(afterUploadedAt), afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user orders page: %w", wrapInternal(err))
 	}
 	defer rows.Close()
 
@@ -96,39 +272,181 @@ func (s *PostgresOrderStorage) GetByUserID(ctx context.Context, userID uuid.UUID
 	}
 
 	if rows.Err() != nil {
-		return nil, fmt.Errorf("rows error: %w", rows.Err())
+		return nil, fmt.Errorf("rows error: %w", wrapInternal(rows.Err()))
 	}
 
 	return orders, nil
 }
 
-// UpdateStatus обновляет статус и начисление заказа.
+// SearchByNumberPrefix возвращает до limit заказов пользователя, номер которых начинается
+// с prefix, от новых к старым. Префикс подставляется в LIKE как есть, поэтому вызывающий
+// код должен передавать только цифры.
+func (s *PostgresOrderStorage) SearchByNumberPrefix(ctx context.Context, userID uuid.UUID, prefix string, limit int) ([]*models.Order, error) {
+	defer trackQuery(queryOrderSearchByPrefix)()
+
+	query := `
+		SELECT id, user_id, number, status, accrual, uploaded_at, updated_at
+		FROM orders
+		WHERE user_id = $1 AND number LIKE $2 || '%'
+		ORDER BY uploaded_at DESC, id DESC
+		LIMIT $3
+	`
+
+	rows, err := s.pool.Query(ctx, query, userID, prefix, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search user orders: %w", wrapInternal(err))
+	}
+	defer rows.Close()
+
+	var orders []*models.Order
+	for rows.Next() {
+		order, err := scanOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("rows error: %w", wrapInternal(rows.Err()))
+	}
+
+	return orders, nil
+}
+
+// optionalTime передаёт нулевое время в запрос как NULL.
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// updateStatusQuery обновляет статус и начисление заказа по номеру. Заказ с теми же
+// статусом и начислением не меняется, чтобы updated_at отражал последнее изменение,
+// а не последний опрос: по нему GetStuckProcessing находит застрявшие заказы.
+// Окончательные статусы PROCESSED и INVALID не перезаписываются: запоздавший ответ
+// с промежуточным статусом иначе вернул бы обработанный заказ в опрос, и начисление
+// по нему было бы применено второй раз.
+const updateStatusQuery = `
+	UPDATE orders
+	SET status = $1, accrual = $2, updated_at = NOW()
+	WHERE number = $3 AND status NOT IN ('PROCESSED', 'INVALID')
+		AND (status, accrual) IS DISTINCT FROM ($1, $2)
+`
+
+// UpdateStatus обновляет статус и начисление заказа. Если они не изменились или заказ
+// уже в окончательном статусе (PROCESSED, INVALID), заказ (и его updated_at) остаётся прежним.
 func (s *PostgresOrderStorage) UpdateStatus(ctx context.Context, number string, status models.OrderStatus, accrual *decimal.Decimal) error {
+	defer trackQuery(queryOrderUpdateStatus)()
+
+	result, err := s.pool.Exec(ctx, updateStatusQuery, status, accrual, number)
+	if err != nil {
+		return fmt.Errorf("failed to update order status: %w", wrapInternal(err))
+	}
+	if result.RowsAffected() > 0 {
+		return nil
+	}
+
+	// Ни одной строки: заказа нет, статус не изменился или уже окончательный
+	var exists bool
+	if err := s.pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM orders WHERE number = $1)`, number).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check order: %w", wrapInternal(err))
+	}
+	if !exists {
+		return ErrOrderNotFound
+	}
+	return nil
+}
+
+// ApplyAccrualTx отмечает заказ обработанным с начислением accrual в рамках переданной транзакции.
+// Операция идемпотентна: если заказ уже PROCESSED (например, его обработала другая реплика),
+// он не меняется и возвращается false - транзакцию с начислением нужно откатить.
+// Это защищает от повторного начисления, только пока PROCESSED окончателен: UpdateStatus
+// его не перезаписывает, а ResetToNew отказывает с ErrOrderAlreadyProcessed.
+func (s *PostgresOrderStorage) ApplyAccrualTx(ctx context.Context, tx pgx.Tx, number string, accrual decimal.Decimal) (bool, error) {
+	defer trackQuery(queryOrderApplyAccrualTx)()
+
 	query := `
 		UPDATE orders
-		SET status = $1, accrual = $2, updated_at = NOW()
-		WHERE number = $3
+		SET status = 'PROCESSED', accrual = $1, updated_at = NOW()
+		WHERE number = $2 AND status <> 'PROCESSED'
 	`
 
-	accrualVal := sql.NullString{}
-	if accrual != nil {
-		accrualVal = sql.NullString{Valid: true, String: accrual.String()}
+	result, err := tx.Exec(ctx, query, accrual, number)
+	if err != nil {
+		return false, fmt.Errorf("failed to apply accrual: %w", wrapInternal(err))
+	}
+	if result.RowsAffected() > 0 {
+		return true, nil
+	}
+
+	// Ни одной строки: заказа нет или он уже обработан
+	var exists bool
+	if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM orders WHERE number = $1)`, number).Scan(&exists); err != nil {
+		return false, fmt.Errorf("failed to check order: %w", wrapInternal(err))
+	}
+	if !exists {
+		return false, ErrOrderNotFound
 	}
+	return false, nil
+}
 
-	result, err := s.pool.Exec(ctx, query, status, accrualVal, number)
+// ResetToNew возвращает заказ в статус NEW, чтобы воркер заново запросил начисление.
+// Обработанный заказ не сбрасывается (ErrOrderAlreadyProcessed): проверка выполняется
+// в самом UPDATE, поэтому воркер не может завершить заказ между проверкой и сбросом
+// и начислить баллы повторно.
+func (s *PostgresOrderStorage) ResetToNew(ctx context.Context, number string) error {
+	defer trackQuery(queryOrderResetToNew)()
+
+	query := `
+		UPDATE orders
+		SET status = 'NEW', accrual = NULL, updated_at = NOW()
+		WHERE number = $1 AND status <> 'PROCESSED'
+	`
+
+	result, err := s.pool.Exec(ctx, query, number)
 	if err != nil {
-		return fmt.Errorf("failed to update order status: %w", err)
+		return fmt.Errorf("failed to reset order: %w", wrapInternal(err))
+	}
+	if result.RowsAffected() > 0 {
+		return nil
 	}
 
-	if result.RowsAffected() == 0 {
+	// Ни одной строки: заказа нет или он уже обработан
+	var exists bool
+	if err := s.pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM orders WHERE number = $1)`, number).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check order: %w", wrapInternal(err))
+	}
+	if !exists {
 		return ErrOrderNotFound
 	}
+	return ErrOrderAlreadyProcessed
+}
 
-	return nil
+// ResetToNewIfInvalid возвращает заказ пользователя userID в статус NEW, только если
+// сервис начислений признал его INVALID. Сообщает, был ли заказ сброшен.
+func (s *PostgresOrderStorage) ResetToNewIfInvalid(ctx context.Context, number string, userID uuid.UUID) (bool, error) {
+	defer trackQuery(queryOrderResetIfInvalid)()
+
+	query := `
+		UPDATE orders
+		SET status = 'NEW', accrual = NULL, updated_at = NOW()
+		WHERE number = $1 AND user_id = $2 AND status = 'INVALID'
+	`
+
+	result, err := s.pool.Exec(ctx, query, number, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to reset invalid order: %w", wrapInternal(err))
+	}
+
+	return result.RowsAffected() > 0, nil
 }
 
 // GetPendingOrders возвращает заказы в статусах NEW и PROCESSING.
 func (s *PostgresOrderStorage) GetPendingOrders(ctx context.Context) ([]*models.Order, error) {
+	defer trackQuery(queryOrderGetPending)()
+
 	query := `
 		SELECT id, user_id, number, status, accrual, uploaded_at, updated_at
 		FROM orders
@@ -138,7 +456,41 @@ func (s *PostgresOrderStorage) GetPendingOrders(ctx context.Context) ([]*models.
 
 	rows, err := s.pool.Query(ctx, query)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query pending orders: %w", err)
+		return nil, fmt.Errorf("failed to query pending orders: %w", wrapInternal(err))
+	}
+	defer rows.Close()
+
+	var orders []*models.Order
+	for rows.Next() {
+		order, err := scanOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("rows error: %w", wrapInternal(rows.Err()))
+	}
+
+	return orders, nil
+}
+
+// GetStuckProcessing возвращает заказы, которые находятся в статусе PROCESSING
+// и не обновлялись дольше olderThan. Порог считается по часам БД, как и updated_at.
+func (s *PostgresOrderStorage) GetStuckProcessing(ctx context.Context, olderThan time.Duration) ([]*models.Order, error) {
+	defer trackQuery(queryOrderGetStuck)()
+
+	query := `
+		SELECT id, user_id, number, status, accrual, uploaded_at, updated_at
+		FROM orders
+		WHERE status = 'PROCESSING' AND updated_at < NOW() - $1::interval
+		ORDER BY updated_at ASC
+	`
+
+	rows, err := s.pool.Query(ctx, query, olderThan)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query stuck orders: %w", wrapInternal(err))
 	}
 	defer rows.Close()
 
@@ -152,17 +504,58 @@ func (s *PostgresOrderStorage) GetPendingOrders(ctx context.Context) ([]*models.
 	}
 
 	if rows.Err() != nil {
-		return nil, fmt.Errorf("rows error: %w", rows.Err())
+		return nil, fmt.Errorf("rows error: %w", wrapInternal(rows.Err()))
 	}
 
 	return orders, nil
 }
 
+// GetAccruedTotal возвращает сумму начислений по обработанным заказам пользователя.
+func (s *PostgresOrderStorage) GetAccruedTotal(ctx context.Context, userID uuid.UUID) (decimal.Decimal, error) {
+	defer trackQuery(queryOrderGetAccruedTotal)()
+
+	query := `
+		SELECT COALESCE(SUM(accrual), 0)
+		FROM orders
+		WHERE user_id = $1 AND status = 'PROCESSED'
+	`
+
+	var total decimal.Decimal
+	if err := s.pool.QueryRow(ctx, query, userID).Scan(&total); err != nil {
+		return decimal.Zero, fmt.Errorf("failed to get accrued total: %w", wrapInternal(err))
+	}
+
+	return total, nil
+}
+
+// CountByUser возвращает число заказов пользователя.
+func (s *PostgresOrderStorage) CountByUser(ctx context.Context, userID uuid.UUID) (int, error) {
+	defer trackQuery(queryOrderCountByUser)()
+
+	query := `
+		SELECT COUNT(*)
+		FROM orders
+		WHERE user_id = $1
+	`
+
+	var count int
+	if err := s.pool.QueryRow(ctx, query, userID).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count user orders: %w", wrapInternal(err))
+	}
+
+	return count, nil
+}
+
+// orderAccrualColumn - номер столбца accrual в строке, которую читает scanOrder.
+const orderAccrualColumn = 4
+
 // scanOrder помогает читать заказ из строки результата.
+// Ошибка чтения начисления возвращается как CorruptAccrualError с номером заказа:
+// pgx читает столбцы по порядку, и к этому моменту номер уже прочитан.
 func scanOrder(row pgx.Row) (*models.Order, error) {
 	var (
-		order      models.Order
-		accrualStr sql.NullString
+		order   models.Order
+		accrual decimal.NullDecimal
 	)
 
 	err := row.Scan(
@@ -170,7 +563,7 @@ func scanOrder(row pgx.Row) (*models.Order, error) {
 		&order.UserID,
 		&order.Number,
 		&order.Status,
-		&accrualStr,
+		&accrual,
 		&order.UploadedAt,
 		&order.UpdatedAt,
 	)
@@ -178,13 +571,15 @@ func scanOrder(row pgx.Row) (*models.Order, error) {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, ErrOrderNotFound
 		}
-		return nil, fmt.Errorf("failed to scan order: %w", err)
+		var argErr pgx.ScanArgError
+		if errors.As(err, &argErr) && argErr.ColumnIndex == orderAccrualColumn {
+			return nil, &CorruptAccrualError{Number: order.Number, Err: err}
+		}
+		return nil, fmt.Errorf("failed to scan order: %w", wrapInternal(err))
 	}
 
-	if accrualStr.Valid {
-		if dec, derr := decimal.NewFromString(accrualStr.String); derr == nil {
-			order.Accrual = &dec
-		}
+	if accrual.Valid {
+		order.Accrual = &accrual.Decimal
 	}
 
 	return &order, nil
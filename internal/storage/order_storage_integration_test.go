@@ -0,0 +1,854 @@
+//go:build integration
+// +build integration
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agamariel/gofermart/internal/models"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+func TestPostgresOrderStorage_GetByUserIDSorted(t *testing.T) {
+	ts := newTestStorage(t)
+	userStorage := ts.users
+	orderStorage := ts.orders
+	ctx := context.Background()
+
+	user := &models.User{
+		ID:           uuid.New(),
+		Login:        "orders_sorted_" + uuid.New().String() + "@example.com",
+		PasswordHash: "hashed_password",
+	}
+	if err := userStorage.Create(ctx, user); err != nil {
+		t.Fatalf("Create user error = %v", err)
+	}
+
+	numbers := []string{uuid.New().String(), uuid.New().String()}
+	for _, number := range numbers {
+		order := &models.Order{UserID: user.ID, Number: number, Status: models.OrderStatusNew}
+		if err := orderStorage.Create(ctx, order); err != nil {
+			t.Fatalf("Create order error = %v", err)
+		}
+	}
+
+	t.Run("asc", func(t *testing.T) {
+		orders, err := orderStorage.GetByUserIDSorted(ctx, user.ID, true)
+		if err != nil {
+			t.Fatalf("GetByUserIDSorted() error = %v", err)
+		}
+		if len(orders) != 2 {
+			t.Fatalf("expected 2 orders, got %d", len(orders))
+		}
+		if orders[0].Number != numbers[0] {
+			t.Errorf("first order = %v, want %v", orders[0].Number, numbers[0])
+		}
+	})
+
+	t.Run("desc", func(t *testing.T) {
+		orders, err := orderStorage.GetByUserIDSorted(ctx, user.ID, false)
+		if err != nil {
+			t.Fatalf("GetByUserIDSorted() error = %v", err)
+		}
+		if len(orders) != 2 {
+			t.Fatalf("expected 2 orders, got %d", len(orders))
+		}
+		if orders[0].Number != numbers[1] {
+			t.Errorf("first order = %v, want %v", orders[0].Number, numbers[1])
+		}
+	})
+}
+
+func TestPostgresOrderStorage_EachByUserID(t *testing.T) {
+	ts := newTestStorage(t)
+	ctx := context.Background()
+
+	user := &models.User{
+		ID:           uuid.New(),
+		Login:        "orders_each_" + uuid.New().String() + "@example.com",
+		PasswordHash: "hashed_password",
+	}
+	if err := ts.users.Create(ctx, user); err != nil {
+		t.Fatalf("Create user error = %v", err)
+	}
+
+	numbers := []string{uuid.New().String(), uuid.New().String(), uuid.New().String()}
+	for _, number := range numbers {
+		if err := ts.orders.Create(ctx, &models.Order{UserID: user.ID, Number: number, Status: models.OrderStatusNew}); err != nil {
+			t.Fatalf("Create order error = %v", err)
+		}
+	}
+
+	var got []string
+	err := ts.orders.EachByUserID(ctx, user.ID, func(order *models.Order) error {
+		got = append(got, order.Number)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("EachByUserID() error = %v", err)
+	}
+	if len(got) != 3 || got[0] != numbers[2] || got[2] != numbers[0] {
+		t.Errorf("EachByUserID() = %v, want newest first", got)
+	}
+
+	t.Run("callback error stops reading", func(t *testing.T) {
+		errStop := errors.New("stop")
+		calls := 0
+		err := ts.orders.EachByUserID(ctx, user.ID, func(order *models.Order) error {
+			calls++
+			return errStop
+		})
+		if !errors.Is(err, errStop) || calls != 1 {
+			t.Errorf("EachByUserID() = %v after %d calls, want the callback error after 1", err, calls)
+		}
+	})
+}
+
+func TestPostgresOrderStorage_GetAccruedTotal(t *testing.T) {
+	ts := newTestStorage(t)
+	userStorage := ts.users
+	orderStorage := ts.orders
+	ctx := context.Background()
+
+	user := &models.User{
+		ID:           uuid.New(),
+		Login:        "accrued_total_" + uuid.New().String() + "@example.com",
+		PasswordHash: "hashed_password",
+	}
+	if err := userStorage.Create(ctx, user); err != nil {
+		t.Fatalf("Create user error = %v", err)
+	}
+
+	t.Run("no orders", func(t *testing.T) {
+		total, err := orderStorage.GetAccruedTotal(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("GetAccruedTotal() error = %v", err)
+		}
+		if !total.IsZero() {
+			t.Errorf("total = %v, want 0", total)
+		}
+	})
+
+	accruals := []struct {
+		status  models.OrderStatus
+		accrual decimal.Decimal
+	}{
+		{models.OrderStatusProcessed, decimal.NewFromInt(100)},
+		{models.OrderStatusProcessed, decimal.NewFromFloat(250.50)},
+		{models.OrderStatusInvalid, decimal.NewFromInt(1000)},
+	}
+	for _, a := range accruals {
+		number := uuid.New().String()
+		order := &models.Order{UserID: user.ID, Number: number, Status: models.OrderStatusNew}
+		if err := orderStorage.Create(ctx, order); err != nil {
+			t.Fatalf("Create order error = %v", err)
+		}
+		accrual := a.accrual
+		if err := orderStorage.UpdateStatus(ctx, number, a.status, &accrual); err != nil {
+			t.Fatalf("UpdateStatus() error = %v", err)
+		}
+	}
+
+	t.Run("only processed orders", func(t *testing.T) {
+		total, err := orderStorage.GetAccruedTotal(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("GetAccruedTotal() error = %v", err)
+		}
+		if want := decimal.NewFromFloat(350.50); !total.Equal(want) {
+			t.Errorf("total = %v, want %v", total, want)
+		}
+	})
+}
+
+func TestPostgresOrderStorage_GetStuckProcessing(t *testing.T) {
+	ts := newTestStorage(t)
+	userStorage := ts.users
+	orderStorage := ts.orders
+	ctx := context.Background()
+
+	user := &models.User{
+		ID:           uuid.New(),
+		Login:        "stuck_" + uuid.New().String() + "@example.com",
+		PasswordHash: "hashed_password",
+	}
+	if err := userStorage.Create(ctx, user); err != nil {
+		t.Fatalf("Create user error = %v", err)
+	}
+
+	// Заказы по обе стороны от порога в 10 минут
+	staleNumber := uuid.New().String()
+	freshNumber := uuid.New().String()
+	ages := map[string]string{
+		staleNumber: "11 minutes",
+		freshNumber: "9 minutes",
+	}
+	for number, age := range ages {
+		order := &models.Order{UserID: user.ID, Number: number, Status: models.OrderStatusProcessing}
+		if err := orderStorage.Create(ctx, order); err != nil {
+			t.Fatalf("Create order error = %v", err)
+		}
+		if _, err := ts.pool.Exec(ctx, `UPDATE orders SET updated_at = NOW() - $1::interval WHERE number = $2`, age, number); err != nil {
+			t.Fatalf("set updated_at error = %v", err)
+		}
+	}
+
+	orders, err := orderStorage.GetStuckProcessing(ctx, 10*time.Minute)
+	if err != nil {
+		t.Fatalf("GetStuckProcessing() error = %v", err)
+	}
+
+	found := map[string]bool{}
+	for _, o := range orders {
+		found[o.Number] = true
+	}
+	if !found[staleNumber] {
+		t.Error("stale order should be returned")
+	}
+	if found[freshNumber] {
+		t.Error("fresh order should not be returned")
+	}
+
+	t.Run("repeatedly polled order is reaped", func(t *testing.T) {
+		number := uuid.New().String()
+		order := &models.Order{UserID: user.ID, Number: number, Status: models.OrderStatusProcessing}
+		if err := orderStorage.Create(ctx, order); err != nil {
+			t.Fatalf("Create order error = %v", err)
+		}
+		if _, err := ts.pool.Exec(ctx, `UPDATE orders SET updated_at = NOW() - '11 minutes'::interval WHERE number = $1`, number); err != nil {
+			t.Fatalf("set updated_at error = %v", err)
+		}
+
+		// Воркер опрашивает заказ на каждом тике, а сервис начислений отвечает PROCESSING
+		for i := 0; i < 3; i++ {
+			if err := orderStorage.UpdateStatus(ctx, number, models.OrderStatusProcessing, nil); err != nil {
+				t.Fatalf("UpdateStatus() error = %v", err)
+			}
+		}
+
+		orders, err := orderStorage.GetStuckProcessing(ctx, 10*time.Minute)
+		if err != nil {
+			t.Fatalf("GetStuckProcessing() error = %v", err)
+		}
+		reaped := false
+		for _, o := range orders {
+			reaped = reaped || o.Number == number
+		}
+		if !reaped {
+			t.Error("polled order should still be returned as stuck")
+		}
+
+		if err := orderStorage.UpdateStatus(ctx, uuid.New().String(), models.OrderStatusProcessing, nil); !errors.Is(err, ErrOrderNotFound) {
+			t.Errorf("UpdateStatus(missing) error = %v, want ErrOrderNotFound", err)
+		}
+	})
+}
+
+func TestPostgresOrderStorage_CreateBatch(t *testing.T) {
+	ts := newTestStorage(t)
+	ctx := context.Background()
+
+	user := &models.User{
+		ID:           uuid.New(),
+		Login:        "batch_" + uuid.New().String() + "@example.com",
+		PasswordHash: "hashed_password",
+	}
+	if err := ts.users.Create(ctx, user); err != nil {
+		t.Fatalf("Create user error = %v", err)
+	}
+
+	existingNumber := uuid.New().String()
+	if err := ts.orders.Create(ctx, &models.Order{UserID: user.ID, Number: existingNumber, Status: models.OrderStatusNew}); err != nil {
+		t.Fatalf("Create order error = %v", err)
+	}
+
+	newNumber := uuid.New().String()
+	orders := []*models.Order{
+		{UserID: user.ID, Number: newNumber, Status: models.OrderStatusNew},
+		{UserID: user.ID, Number: existingNumber, Status: models.OrderStatusNew},
+	}
+
+	created, err := ts.orders.CreateBatch(ctx, orders)
+	if err != nil {
+		t.Fatalf("CreateBatch() error = %v", err)
+	}
+	if !created[0] || created[1] {
+		t.Errorf("created = %v, want [true false]", created)
+	}
+	if orders[0].ID == uuid.Nil {
+		t.Error("created order ID should be set")
+	}
+
+	if _, err := ts.orders.GetByNumber(ctx, newNumber); err != nil {
+		t.Errorf("GetByNumber() error = %v", err)
+	}
+}
+
+func TestPostgresOrderStorage_OrderOwner(t *testing.T) {
+	ts := newTestStorage(t)
+	ctx := context.Background()
+
+	user := &models.User{
+		ID:           uuid.New(),
+		Login:        "owner_" + uuid.New().String() + "@example.com",
+		PasswordHash: "hashed_password",
+	}
+	if err := ts.users.Create(ctx, user); err != nil {
+		t.Fatalf("Create user error = %v", err)
+	}
+
+	number := uuid.New().String()
+	if err := ts.orders.Create(ctx, &models.Order{UserID: user.ID, Number: number, Status: models.OrderStatusNew}); err != nil {
+		t.Fatalf("Create order error = %v", err)
+	}
+
+	owner, found, err := ts.orders.OrderOwner(ctx, number)
+	if err != nil {
+		t.Fatalf("OrderOwner() error = %v", err)
+	}
+	if !found || owner != user.ID {
+		t.Errorf("OrderOwner() = (%v, %v), want (%v, true)", owner, found, user.ID)
+	}
+
+	if _, found, err := ts.orders.OrderOwner(ctx, uuid.New().String()); err != nil || found {
+		t.Errorf("OrderOwner() for missing order = (found %v, err %v), want (false, nil)", found, err)
+	}
+
+	if got, err := ts.orders.GetByNumberForUser(ctx, number, user.ID); err != nil || got.UserID != user.ID {
+		t.Errorf("GetByNumberForUser() = (%+v, %v), want the user's order", got, err)
+	}
+	if _, err := ts.orders.GetByNumberForUser(ctx, number, uuid.New()); !errors.Is(err, ErrOrderNotFound) {
+		t.Errorf("GetByNumberForUser() for another user error = %v, want ErrOrderNotFound", err)
+	}
+}
+
+// benchmarkOrderLookup создаёт заказ с начислением и замеряет функцию поиска по его номеру.
+func benchmarkOrderLookup(b *testing.B, lookup func(ctx context.Context, s *PostgresOrderStorage, number string) error) {
+	ts := newTestStorage(b)
+	ctx := context.Background()
+
+	user := &models.User{
+		ID:           uuid.New(),
+		Login:        "bench_" + uuid.New().String() + "@example.com",
+		PasswordHash: "hashed_password",
+	}
+	if err := ts.users.Create(ctx, user); err != nil {
+		b.Fatalf("Create user error = %v", err)
+	}
+	number := uuid.New().String()
+	if err := ts.orders.Create(ctx, &models.Order{UserID: user.ID, Number: number, Status: models.OrderStatusNew}); err != nil {
+		b.Fatalf("Create order error = %v", err)
+	}
+	accrual := decimal.NewFromFloat(123.45)
+	if err := ts.orders.UpdateStatus(ctx, number, models.OrderStatusProcessed, &accrual); err != nil {
+		b.Fatalf("UpdateStatus() error = %v", err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := lookup(ctx, ts.orders, number); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPostgresOrderStorage_GetByNumber(b *testing.B) {
+	benchmarkOrderLookup(b, func(ctx context.Context, s *PostgresOrderStorage, number string) error {
+		_, err := s.GetByNumber(ctx, number)
+		return err
+	})
+}
+
+func BenchmarkPostgresOrderStorage_OrderOwner(b *testing.B) {
+	benchmarkOrderLookup(b, func(ctx context.Context, s *PostgresOrderStorage, number string) error {
+		_, _, err := s.OrderOwner(ctx, number)
+		return err
+	})
+}
+
+func TestPostgresOrderStorage_AccrualRoundTrip(t *testing.T) {
+	ts := newTestStorage(t)
+	ctx := context.Background()
+
+	user := &models.User{
+		ID:           uuid.New(),
+		Login:        "accrual_numeric_" + uuid.New().String() + "@example.com",
+		PasswordHash: "hashed_password",
+	}
+	if err := ts.users.Create(ctx, user); err != nil {
+		t.Fatalf("Create user error = %v", err)
+	}
+
+	t.Run("null accrual", func(t *testing.T) {
+		number := uuid.New().String()
+		if err := ts.orders.Create(ctx, &models.Order{UserID: user.ID, Number: number, Status: models.OrderStatusNew}); err != nil {
+			t.Fatalf("Create order error = %v", err)
+		}
+		order, err := ts.orders.GetByNumber(ctx, number)
+		if err != nil {
+			t.Fatalf("GetByNumber() error = %v", err)
+		}
+		if order.Accrual != nil {
+			t.Errorf("Accrual = %v, want nil", order.Accrual)
+		}
+	})
+
+	t.Run("accrual set on create", func(t *testing.T) {
+		number := uuid.New().String()
+		accrual := decimal.RequireFromString("729.98")
+		if err := ts.orders.Create(ctx, &models.Order{UserID: user.ID, Number: number, Status: models.OrderStatusProcessed, Accrual: &accrual}); err != nil {
+			t.Fatalf("Create order error = %v", err)
+		}
+		order, err := ts.orders.GetByNumber(ctx, number)
+		if err != nil {
+			t.Fatalf("GetByNumber() error = %v", err)
+		}
+		if order.Accrual == nil || !order.Accrual.Equal(accrual) {
+			t.Errorf("Accrual = %v, want %v", order.Accrual, accrual)
+		}
+	})
+
+	t.Run("sum is exact", func(t *testing.T) {
+		// 0.1 + 0.2 во float64 даёт 0.30000000000000004
+		for _, value := range []string{"0.10", "0.20"} {
+			number := uuid.New().String()
+			if err := ts.orders.Create(ctx, &models.Order{UserID: user.ID, Number: number, Status: models.OrderStatusNew}); err != nil {
+				t.Fatalf("Create order error = %v", err)
+			}
+			accrual := decimal.RequireFromString(value)
+			if err := ts.orders.UpdateStatus(ctx, number, models.OrderStatusProcessed, &accrual); err != nil {
+				t.Fatalf("UpdateStatus() error = %v", err)
+			}
+		}
+
+		total, err := ts.orders.GetAccruedTotal(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("GetAccruedTotal() error = %v", err)
+		}
+		if want := decimal.RequireFromString("730.28"); !total.Equal(want) {
+			t.Errorf("total = %v, want %v", total, want)
+		}
+	})
+
+	t.Run("unparseable accrual is surfaced", func(t *testing.T) {
+		// NaN - единственное значение NUMERIC, которое не разбирается в decimal
+		number := uuid.New().String()
+		if err := ts.orders.Create(ctx, &models.Order{UserID: user.ID, Number: number, Status: models.OrderStatusNew}); err != nil {
+			t.Fatalf("Create order error = %v", err)
+		}
+		if _, err := ts.pool.Exec(ctx, `UPDATE orders SET status = 'PROCESSED', accrual = 'NaN' WHERE number = $1`, number); err != nil {
+			t.Fatalf("corrupt accrual: %v", err)
+		}
+
+		order, err := ts.orders.GetByNumber(ctx, number)
+		if !errors.Is(err, ErrCorruptAccrual) {
+			t.Errorf("GetByNumber() = %+v, %v; want ErrCorruptAccrual", order, err)
+		}
+	})
+}
+
+func TestPostgresOrderStorage_GetByUserIDAfter(t *testing.T) {
+	ts := newTestStorage(t)
+	ctx := context.Background()
+
+	user := &models.User{
+		ID:           uuid.New(),
+		Login:        "orders_keyset_" + uuid.New().String() + "@example.com",
+		PasswordHash: "hashed_password",
+	}
+	if err := ts.users.Create(ctx, user); err != nil {
+		t.Fatalf("Create user error = %v", err)
+	}
+
+	const total = 5
+	for i := 0; i < total; i++ {
+		order := &models.Order{UserID: user.ID, Number: uuid.New().String(), Status: models.OrderStatusNew}
+		if err := ts.orders.Create(ctx, order); err != nil {
+			t.Fatalf("Create order error = %v", err)
+		}
+	}
+
+	seen := make(map[string]bool)
+	var afterUploadedAt time.Time
+	var afterID uuid.UUID
+	for pages := 0; ; pages++ {
+		if pages > total {
+			t.Fatal("pagination does not terminate")
+		}
+		orders, err := ts.orders.GetByUserIDAfter(ctx, user.ID, afterUploadedAt, afterID, 2)
+		if err != nil {
+			t.Fatalf("GetByUserIDAfter() error = %v", err)
+		}
+		if len(orders) == 0 {
+			break
+		}
+		for _, o := range orders {
+			if seen[o.Number] {
+				t.Fatalf("order %s returned twice", o.Number)
+			}
+			seen[o.Number] = true
+		}
+		last := orders[len(orders)-1]
+		afterUploadedAt, afterID = last.UploadedAt, last.ID
+	}
+
+	if len(seen) != total {
+		t.Errorf("received %d orders, want %d", len(seen), total)
+	}
+}
+
+func TestPostgresOrderStorage_ResetToNewIfInvalid(t *testing.T) {
+	ts := newTestStorage(t)
+	ctx := context.Background()
+
+	owner := &models.User{ID: uuid.New(), Login: "reset_invalid_" + uuid.New().String() + "@example.com", PasswordHash: "hashed_password"}
+	if err := ts.users.Create(ctx, owner); err != nil {
+		t.Fatalf("Create user error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		status    models.OrderStatus
+		userID    uuid.UUID
+		wantReset bool
+	}{
+		{name: "own invalid order", status: models.OrderStatusInvalid, userID: owner.ID, wantReset: true},
+		{name: "invalid order of another user", status: models.OrderStatusInvalid, userID: uuid.New()},
+		{name: "processed order", status: models.OrderStatusProcessed, userID: owner.ID},
+		{name: "processing order", status: models.OrderStatusProcessing, userID: owner.ID},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order := &models.Order{UserID: owner.ID, Number: uuid.New().String(), Status: tt.status}
+			if err := ts.orders.Create(ctx, order); err != nil {
+				t.Fatalf("Create order error = %v", err)
+			}
+
+			reset, err := ts.orders.ResetToNewIfInvalid(ctx, order.Number, tt.userID)
+			if err != nil {
+				t.Fatalf("ResetToNewIfInvalid() error = %v", err)
+			}
+			if reset != tt.wantReset {
+				t.Errorf("reset = %v, want %v", reset, tt.wantReset)
+			}
+
+			stored, err := ts.orders.GetByNumber(ctx, order.Number)
+			if err != nil {
+				t.Fatalf("GetByNumber() error = %v", err)
+			}
+			wantStatus := tt.status
+			if tt.wantReset {
+				wantStatus = models.OrderStatusNew
+			}
+			if stored.Status != wantStatus {
+				t.Errorf("status = %s, want %s", stored.Status, wantStatus)
+			}
+		})
+	}
+}
+
+func TestPostgresOrderStorage_ResetToNew(t *testing.T) {
+	ts := newTestStorage(t)
+	ctx := context.Background()
+
+	owner := &models.User{ID: uuid.New(), Login: "reset_new_" + uuid.New().String() + "@example.com", PasswordHash: "hashed_password"}
+	if err := ts.users.Create(ctx, owner); err != nil {
+		t.Fatalf("Create user error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		status  models.OrderStatus
+		wantErr error
+	}{
+		{name: "processing order", status: models.OrderStatusProcessing},
+		{name: "invalid order", status: models.OrderStatusInvalid},
+		{name: "processed order", status: models.OrderStatusProcessed, wantErr: ErrOrderAlreadyProcessed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order := &models.Order{UserID: owner.ID, Number: uuid.New().String(), Status: tt.status}
+			if err := ts.orders.Create(ctx, order); err != nil {
+				t.Fatalf("Create order error = %v", err)
+			}
+
+			if err := ts.orders.ResetToNew(ctx, order.Number); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ResetToNew() error = %v, want %v", err, tt.wantErr)
+			}
+
+			stored, err := ts.orders.GetByNumber(ctx, order.Number)
+			if err != nil {
+				t.Fatalf("GetByNumber() error = %v", err)
+			}
+			wantStatus := models.OrderStatusNew
+			if tt.wantErr != nil {
+				wantStatus = tt.status
+			}
+			if stored.Status != wantStatus {
+				t.Errorf("status = %s, want %s", stored.Status, wantStatus)
+			}
+		})
+	}
+
+	t.Run("missing order", func(t *testing.T) {
+		if err := ts.orders.ResetToNew(ctx, uuid.New().String()); !errors.Is(err, ErrOrderNotFound) {
+			t.Errorf("ResetToNew() error = %v, want ErrOrderNotFound", err)
+		}
+	})
+}
+
+func TestPostgresOrderStorage_ApplyAccrualTx(t *testing.T) {
+	ts := newTestStorage(t)
+	ctx := context.Background()
+
+	owner := &models.User{ID: uuid.New(), Login: "apply_accrual_" + uuid.New().String() + "@example.com", PasswordHash: "hashed_password"}
+	if err := ts.users.Create(ctx, owner); err != nil {
+		t.Fatalf("Create user error = %v", err)
+	}
+	order := &models.Order{UserID: owner.ID, Number: uuid.New().String(), Status: models.OrderStatusProcessing}
+	if err := ts.orders.Create(ctx, order); err != nil {
+		t.Fatalf("Create order error = %v", err)
+	}
+
+	// apply выполняет ApplyAccrualTx в отдельной транзакции и фиксирует её
+	apply := func(number string, accrual decimal.Decimal) (bool, error) {
+		tx, err := ts.pool.Begin(ctx)
+		if err != nil {
+			t.Fatalf("Begin() error = %v", err)
+		}
+		defer tx.Rollback(ctx)
+		applied, err := ts.orders.ApplyAccrualTx(ctx, tx, number, accrual)
+		if err != nil {
+			return false, err
+		}
+		if err := tx.Commit(ctx); err != nil {
+			t.Fatalf("Commit() error = %v", err)
+		}
+		return applied, nil
+	}
+
+	t.Run("rollback keeps the order pending", func(t *testing.T) {
+		tx, err := ts.pool.Begin(ctx)
+		if err != nil {
+			t.Fatalf("Begin() error = %v", err)
+		}
+		if applied, err := ts.orders.ApplyAccrualTx(ctx, tx, order.Number, decimal.NewFromInt(10)); !applied || err != nil {
+			t.Fatalf("ApplyAccrualTx() = %v, %v; want applied", applied, err)
+		}
+		if err := tx.Rollback(ctx); err != nil {
+			t.Fatalf("Rollback() error = %v", err)
+		}
+		stored, _ := ts.orders.GetByNumber(ctx, order.Number)
+		if stored.Status != models.OrderStatusProcessing || stored.Accrual != nil {
+			t.Errorf("order = %+v, want PROCESSING without accrual", stored)
+		}
+	})
+
+	if applied, err := apply(order.Number, decimal.RequireFromString("120.5")); !applied || err != nil {
+		t.Fatalf("ApplyAccrualTx() = %v, %v; want applied", applied, err)
+	}
+
+	t.Run("second apply is a no-op", func(t *testing.T) {
+		applied, err := apply(order.Number, decimal.NewFromInt(999))
+		if err != nil || applied {
+			t.Fatalf("ApplyAccrualTx() = %v, %v; want not applied", applied, err)
+		}
+		stored, _ := ts.orders.GetByNumber(ctx, order.Number)
+		if stored.Status != models.OrderStatusProcessed || stored.Accrual == nil || !stored.Accrual.Equal(decimal.RequireFromString("120.5")) {
+			t.Errorf("order = %+v, want PROCESSED with the first accrual", stored)
+		}
+	})
+
+	t.Run("unknown order", func(t *testing.T) {
+		if _, err := apply(uuid.New().String(), decimal.NewFromInt(1)); !errors.Is(err, ErrOrderNotFound) {
+			t.Errorf("ApplyAccrualTx() error = %v, want ErrOrderNotFound", err)
+		}
+	})
+}
+
+func TestPostgresOrderStorage_TerminalStatusIsSticky(t *testing.T) {
+	ts := newTestStorage(t)
+	ctx := context.Background()
+
+	owner := &models.User{ID: uuid.New(), Login: "sticky_" + uuid.New().String() + "@example.com", PasswordHash: "hashed_password"}
+	if err := ts.users.Create(ctx, owner); err != nil {
+		t.Fatalf("Create user error = %v", err)
+	}
+	newOrder := func() string {
+		t.Helper()
+		order := &models.Order{UserID: owner.ID, Number: uuid.New().String(), Status: models.OrderStatusProcessing}
+		if err := ts.orders.Create(ctx, order); err != nil {
+			t.Fatalf("Create order error = %v", err)
+		}
+		return order.Number
+	}
+
+	// credit начисляет accrual так же, как воркер: баланс меняется, только если заказ отмечен обработанным
+	credit := func(number string, accrual decimal.Decimal) {
+		t.Helper()
+		tx, err := ts.pool.Begin(ctx)
+		if err != nil {
+			t.Fatalf("Begin() error = %v", err)
+		}
+		defer tx.Rollback(ctx)
+		applied, err := ts.orders.ApplyAccrualTx(ctx, tx, number, accrual)
+		if err != nil {
+			t.Fatalf("ApplyAccrualTx() error = %v", err)
+		}
+		if !applied {
+			return
+		}
+		if err := ts.users.CreditTx(ctx, tx, owner.ID, accrual); err != nil {
+			t.Fatalf("CreditTx() error = %v", err)
+		}
+		if err := tx.Commit(ctx); err != nil {
+			t.Fatalf("Commit() error = %v", err)
+		}
+	}
+
+	t.Run("stale update after PROCESSED credits once", func(t *testing.T) {
+		number := newOrder()
+		accrual := decimal.RequireFromString("120.5")
+
+		credit(number, accrual)
+		// Запоздавший ответ PROCESSING от другой реплики
+		if err := ts.orders.UpdateStatus(ctx, number, models.OrderStatusProcessing, nil); err != nil {
+			t.Fatalf("UpdateStatus() error = %v", err)
+		}
+		credit(number, accrual)
+
+		stored, _ := ts.orders.GetByNumber(ctx, number)
+		if stored.Status != models.OrderStatusProcessed || stored.Accrual == nil || !stored.Accrual.Equal(accrual) {
+			t.Errorf("order = %+v, want PROCESSED with accrual %s", stored, accrual)
+		}
+		user, err := ts.users.GetByID(ctx, owner.ID)
+		if err != nil {
+			t.Fatalf("GetByID() error = %v", err)
+		}
+		if !user.Balance.Equal(accrual) {
+			t.Errorf("balance = %s, want %s credited once", user.Balance, accrual)
+		}
+	})
+
+	t.Run("INVALID is not overwritten", func(t *testing.T) {
+		number := newOrder()
+		if err := ts.orders.UpdateStatus(ctx, number, models.OrderStatusInvalid, nil); err != nil {
+			t.Fatalf("UpdateStatus() error = %v", err)
+		}
+		if err := ts.orders.UpdateStatus(ctx, number, models.OrderStatusProcessing, nil); err != nil {
+			t.Fatalf("UpdateStatus() error = %v", err)
+		}
+		if stored, _ := ts.orders.GetByNumber(ctx, number); stored.Status != models.OrderStatusInvalid {
+			t.Errorf("status = %s, want INVALID", stored.Status)
+		}
+	})
+}
+
+func TestPostgresOrderStorage_SearchByNumberPrefix(t *testing.T) {
+	ts := newTestStorage(t)
+	ctx := context.Background()
+
+	newUser := func() *models.User {
+		user := &models.User{
+			ID:           uuid.New(),
+			Login:        "orders_search_" + uuid.New().String() + "@example.com",
+			PasswordHash: "hashed_password",
+		}
+		if err := ts.users.Create(ctx, user); err != nil {
+			t.Fatalf("Create user error = %v", err)
+		}
+		return user
+	}
+	owner := newUser()
+	other := newUser()
+
+	// Уникальный цифровой префикс отделяет заказы теста от данных других тестов
+	base := strconv.FormatInt(time.Now().UnixNano(), 10)
+	create := func(userID uuid.UUID, number string) {
+		if err := ts.orders.Create(ctx, &models.Order{UserID: userID, Number: number, Status: models.OrderStatusNew}); err != nil {
+			t.Fatalf("Create order error = %v", err)
+		}
+	}
+	create(owner.ID, base+"11")
+	create(owner.ID, base+"12")
+	create(owner.ID, base+"21")
+	create(other.ID, base+"13")
+
+	tests := []struct {
+		name   string
+		prefix string
+		limit  int
+		want   int
+	}{
+		{name: "common prefix", prefix: base, limit: 10, want: 3},
+		{name: "narrow prefix", prefix: base + "1", limit: 10, want: 2},
+		{name: "full number", prefix: base + "21", limit: 10, want: 1},
+		{name: "limit applies", prefix: base, limit: 2, want: 2},
+		{name: "non-matching prefix", prefix: base + "3", limit: 10, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orders, err := ts.orders.SearchByNumberPrefix(ctx, owner.ID, tt.prefix, tt.limit)
+			if err != nil {
+				t.Fatalf("SearchByNumberPrefix() error = %v", err)
+			}
+			if len(orders) != tt.want {
+				t.Fatalf("got %d orders, want %d", len(orders), tt.want)
+			}
+			for _, o := range orders {
+				if o.UserID != owner.ID {
+					t.Errorf("order %s belongs to another user", o.Number)
+				}
+				if !strings.HasPrefix(o.Number, tt.prefix) {
+					t.Errorf("order %s does not match prefix %s", o.Number, tt.prefix)
+				}
+			}
+		})
+	}
+}
+
+func TestPostgresOrderStorage_CountByUser(t *testing.T) {
+	ts := newTestStorage(t)
+	ctx := context.Background()
+
+	user := &models.User{
+		ID:           uuid.New(),
+		Login:        "orders_count_" + uuid.New().String() + "@example.com",
+		PasswordHash: "hashed_password",
+	}
+	if err := ts.users.Create(ctx, user); err != nil {
+		t.Fatalf("Create user error = %v", err)
+	}
+
+	count, err := ts.orders.CountByUser(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("CountByUser() error = %v", err)
+	}
+	if count != 0 {
+		t.Errorf("count without orders = %d, want 0", count)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := ts.orders.Create(ctx, &models.Order{UserID: user.ID, Number: uuid.New().String(), Status: models.OrderStatusNew}); err != nil {
+			t.Fatalf("Create order error = %v", err)
+		}
+	}
+
+	count, err = ts.orders.CountByUser(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("CountByUser() error = %v", err)
+	}
+	if count != 3 {
+		t.Errorf("count = %d, want 3", count)
+	}
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agamariel/gofermart/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// fakeOrderRow отдаёт в scanOrder заранее заданные значения столбцов заказа.
+type fakeOrderRow struct {
+	number  string
+	accrual any
+}
+
+func (r fakeOrderRow) Scan(dest ...any) error {
+	if len(dest) != 7 {
+		return fmt.Errorf("unexpected number of columns: %d", len(dest))
+	}
+	*dest[0].(*uuid.UUID) = uuid.New()
+	*dest[1].(*uuid.UUID) = uuid.New()
+	*dest[2].(*string) = r.number
+	*dest[3].(*models.OrderStatus) = models.OrderStatusProcessed
+	// pgx сообщает об ошибке столбца через ScanArgError с его номером
+	if err := dest[4].(sql.Scanner).Scan(r.accrual); err != nil {
+		return pgx.ScanArgError{ColumnIndex: 4, Err: err}
+	}
+	*dest[5].(*time.Time) = time.Now()
+	*dest[6].(*time.Time) = time.Now()
+	return nil
+}
+
+func TestScanOrder_Accrual(t *testing.T) {
+	t.Run("valid accrual", func(t *testing.T) {
+		order, err := scanOrder(fakeOrderRow{number: "79927398713", accrual: "500.25"})
+		if err != nil {
+			t.Fatalf("scanOrder() error = %v", err)
+		}
+		if order.Accrual == nil || order.Accrual.String() != "500.25" {
+			t.Errorf("Accrual = %v, want 500.25", order.Accrual)
+		}
+	})
+
+	t.Run("null accrual", func(t *testing.T) {
+		order, err := scanOrder(fakeOrderRow{number: "79927398713", accrual: nil})
+		if err != nil {
+			t.Fatalf("scanOrder() error = %v", err)
+		}
+		if order.Accrual != nil {
+			t.Errorf("Accrual = %v, want nil", order.Accrual)
+		}
+	})
+
+	t.Run("malformed accrual is surfaced", func(t *testing.T) {
+		order, err := scanOrder(fakeOrderRow{number: "79927398713", accrual: []byte("12.3.4")})
+		if err == nil {
+			t.Fatalf("scanOrder() = %+v, want error", order)
+		}
+		if !errors.Is(err, ErrCorruptAccrual) {
+			t.Errorf("errors.Is(%v, ErrCorruptAccrual) = false", err)
+		}
+
+		var corruptErr *CorruptAccrualError
+		if !errors.As(err, &corruptErr) {
+			t.Fatalf("errors.As() = false for %v", err)
+		}
+		if corruptErr.Number != "79927398713" {
+			t.Errorf("Number = %q, want %q", corruptErr.Number, "79927398713")
+		}
+		if !strings.Contains(corruptErr.Error(), "12.3.4") {
+			t.Errorf("Error() = %q, want the stored value mentioned", corruptErr.Error())
+		}
+	})
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// Имена запросов для журнала медленных запросов.
+const (
+	queryOrderCreate             = "OrderStorage.Create"
+	queryOrderCreateBatch        = "OrderStorage.CreateBatch"
+	queryOrderGetByNumber        = "OrderStorage.GetByNumber"
+	queryOrderGetByNumberForUser = "OrderStorage.GetByNumberForUser"
+	queryOrderOwner              = "OrderStorage.OrderOwner"
+	queryOrderGetByUserID        = "OrderStorage.GetByUserIDSorted"
+	queryOrderGetByUserIDAfter   = "OrderStorage.GetByUserIDAfter"
+	queryOrderEachByUserID       = "OrderStorage.EachByUserID"
+	queryOrderSearchByPrefix     = "OrderStorage.SearchByNumberPrefix"
+	queryOrderUpdateStatus       = "OrderStorage.UpdateStatus"
+	queryOrderApplyAccrualTx     = "OrderStorage.ApplyAccrualTx"
+	queryOrderResetToNew         = "OrderStorage.ResetToNew"
+	queryOrderResetIfInvalid     = "OrderStorage.ResetToNewIfInvalid"
+	queryOrderGetPending         = "OrderStorage.GetPendingOrders"
+	queryOrderGetStuck           = "OrderStorage.GetStuckProcessing"
+	queryOrderCountByUser        = "OrderStorage.CountByUser"
+	queryOrderGetAccruedTotal    = "OrderStorage.GetAccruedTotal"
+
+	queryUserCreate         = "UserStorage.Create"
+	queryUserGetByLogin     = "UserStorage.GetByLogin"
+	queryUserGetByID        = "UserStorage.GetByID"
+	queryUserGetByIDs       = "UserStorage.GetByIDs"
+	queryUserListIDs        = "UserStorage.ListIDs"
+	queryUserGetForUpdateTx = "UserStorage.GetByIDForUpdateTx"
+	queryUserUpdatePassword = "UserStorage.UpdatePasswordHash"
+	queryUserLoginFailure   = "UserStorage.RegisterLoginFailure"
+	queryUserLoginReset     = "UserStorage.ResetLoginFailures"
+	queryUserDeactivate     = "UserStorage.Deactivate"
+	queryUserUpdateBalance  = "UserStorage.UpdateBalance"
+	queryUserWithdrawTx     = "UserStorage.WithdrawTx"
+	queryUserRefundTx       = "UserStorage.RefundTx"
+	queryUserCreditTx       = "UserStorage.CreditTx"
+	queryUserAdjustTx       = "UserStorage.AdjustBalanceTx"
+
+	queryWithdrawalCreateWithTx  = "WithdrawalStorage.CreateWithTx"
+	queryWithdrawalGetByUserID   = "WithdrawalStorage.GetByUserID"
+	queryWithdrawalGetBetween    = "WithdrawalStorage.GetByUserIDBetween"
+	queryWithdrawalEachBetween   = "WithdrawalStorage.EachByUserIDBetween"
+	queryWithdrawalTotalByUser   = "WithdrawalStorage.GetTotalByUser"
+	queryWithdrawalDeleteByOrder = "WithdrawalStorage.DeleteByOrder"
+	queryWithdrawalExistsByOrder = "WithdrawalStorage.ExistsByOrder"
+
+	queryAuditRecord      = "AuditStorage.Record"
+	queryAuditGetByUserID = "AuditStorage.GetByUserID"
+	queryAuditManualTotal = "AuditStorage.GetManualAdjustmentTotal"
+)
+
+// slowQueryLog - настройки журнала медленных запросов.
+type slowQueryLog struct {
+	threshold time.Duration
+	logger    *slog.Logger
+}
+
+var (
+	slowQueries atomic.Pointer[slowQueryLog]
+
+	// queryNow подменяется в тестах.
+	queryNow = time.Now
+)
+
+// SetSlowQueryLog включает журнал запросов, выполняющихся дольше threshold.
+// Нулевой threshold или nil logger отключают журнал.
+func SetSlowQueryLog(threshold time.Duration, logger *slog.Logger) {
+	if threshold <= 0 || logger == nil {
+		slowQueries.Store(nil)
+		return
+	}
+	slowQueries.Store(&slowQueryLog{threshold: threshold, logger: logger})
+}
+
+// trackQuery засекает время запроса name; возвращаемую функцию нужно вызвать по его завершении:
+//
+//	defer trackQuery(queryOrderCreate)()
+func trackQuery(name string) func() {
+	cfg := slowQueries.Load()
+	if cfg == nil {
+		return func() {}
+	}
+
+	start := queryNow()
+	return func() {
+		if elapsed := queryNow().Sub(start); elapsed >= cfg.threshold {
+			cfg.logger.Warn("slow query",
+				slog.String("query", name),
+				slog.Duration("duration", elapsed),
+				slog.Duration("threshold", cfg.threshold),
+			)
+		}
+	}
+}
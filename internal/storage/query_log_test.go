@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+// slowQueryStep имитирует запрос заданной длительности, сдвигая подменённые часы.
+func slowQueryStep(t *testing.T, name string, duration time.Duration) {
+	t.Helper()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	queryNow = func() time.Time { return now }
+
+	done := trackQuery(name)
+	now = now.Add(duration)
+	done()
+}
+
+func setupSlowQueryLog(t *testing.T, threshold time.Duration) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	SetSlowQueryLog(threshold, slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() {
+		SetSlowQueryLog(0, nil)
+		queryNow = time.Now
+	})
+	return &buf
+}
+
+func TestTrackQuery_LogsSlowQuery(t *testing.T) {
+	buf := setupSlowQueryLog(t, 500*time.Millisecond)
+
+	slowQueryStep(t, queryOrderGetByNumber, 750*time.Millisecond)
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("expected JSON log entry, got %q: %v", buf.String(), err)
+	}
+	if entry["msg"] != "slow query" {
+		t.Errorf("msg = %v, want %q", entry["msg"], "slow query")
+	}
+	if entry["query"] != queryOrderGetByNumber {
+		t.Errorf("query = %v, want %q", entry["query"], queryOrderGetByNumber)
+	}
+	if got, want := entry["duration"], float64(750*time.Millisecond); got != want {
+		t.Errorf("duration = %v, want %v", got, want)
+	}
+}
+
+func TestTrackQuery_FastQueryNotLogged(t *testing.T) {
+	buf := setupSlowQueryLog(t, 500*time.Millisecond)
+
+	slowQueryStep(t, queryUserGetByLogin, 100*time.Millisecond)
+
+	if buf.Len() != 0 {
+		t.Errorf("expected no log output, got %q", buf.String())
+	}
+}
+
+func TestTrackQuery_DisabledWithZeroThreshold(t *testing.T) {
+	buf := setupSlowQueryLog(t, 0)
+
+	slowQueryStep(t, queryWithdrawalGetByUserID, time.Hour)
+
+	if buf.Len() != 0 {
+		t.Errorf("expected no log output, got %q", buf.String())
+	}
+}
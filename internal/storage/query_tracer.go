@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// queryTraceSQLLimit - сколько символов текста запроса попадает в журнал трассировки.
+const queryTraceSQLLimit = 200
+
+// QueryTracer пишет в журнал каждый запрос пула pgx: текст запроса без лишних пробелов,
+// длительность, число затронутых строк и ошибку. Аргументы запроса не пишутся,
+// так как могут содержать персональные данные и хеши паролей.
+type QueryTracer struct {
+	logger *slog.Logger
+}
+
+// NewQueryTracer создаёт трассировщик запросов; подключается через pgx.ConnConfig.Tracer.
+func NewQueryTracer(logger *slog.Logger) *QueryTracer {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &QueryTracer{logger: logger}
+}
+
+// queryTraceKey - ключ контекста, в котором запрос переносится от TraceQueryStart к TraceQueryEnd.
+type queryTraceKey struct{}
+
+// queryTrace - запрос, начатый в TraceQueryStart.
+type queryTrace struct {
+	sql   string
+	start time.Time
+}
+
+// TraceQueryStart запоминает текст запроса и время начала в контексте вызова.
+func (t *QueryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, queryTraceKey{}, queryTrace{sql: data.SQL, start: queryNow()})
+}
+
+// TraceQueryEnd пишет в журнал запрос, начатый в TraceQueryStart.
+func (t *QueryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	trace, ok := ctx.Value(queryTraceKey{}).(queryTrace)
+	if !ok {
+		return
+	}
+
+	attrs := []slog.Attr{
+		slog.String("sql", compactSQL(trace.sql)),
+		slog.Duration("duration", queryNow().Sub(trace.start)),
+		slog.Int64("rows", data.CommandTag.RowsAffected()),
+	}
+	if data.Err != nil {
+		attrs = append(attrs, slog.String("error", data.Err.Error()))
+	}
+	t.logger.LogAttrs(ctx, slog.LevelInfo, "db query", attrs...)
+}
+
+// compactSQL схлопывает пробелы и переносы строк и обрезает запрос до queryTraceSQLLimit символов.
+func compactSQL(sql string) string {
+	sql = strings.Join(strings.Fields(sql), " ")
+	if runes := []rune(sql); len(runes) > queryTraceSQLLimit {
+		return string(runes[:queryTraceSQLLimit]) + "..."
+	}
+	return sql
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestQueryTracer(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	queryNow = func() time.Time { return now }
+	t.Cleanup(func() { queryNow = time.Now })
+
+	var buf bytes.Buffer
+	tracer := NewQueryTracer(slog.New(slog.NewJSONHandler(&buf, nil)))
+
+	t.Run("records query and duration", func(t *testing.T) {
+		buf.Reset()
+		ctx := tracer.TraceQueryStart(context.Background(), nil, pgx.TraceQueryStartData{
+			SQL:  "\n\t\tSELECT id, login\n\t\tFROM users\n\t\tWHERE login = $1\n\t",
+			Args: []any{"secret-login"},
+		})
+		now = now.Add(42 * time.Millisecond)
+		tracer.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{CommandTag: pgconn.NewCommandTag("SELECT 1")})
+
+		var entry map[string]any
+		if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+			t.Fatalf("expected JSON log entry, got %q: %v", buf.String(), err)
+		}
+		if entry["msg"] != "db query" {
+			t.Errorf("msg = %v, want %q", entry["msg"], "db query")
+		}
+		if want := "SELECT id, login FROM users WHERE login = $1"; entry["sql"] != want {
+			t.Errorf("sql = %v, want %q", entry["sql"], want)
+		}
+		if got, want := entry["duration"], float64(42*time.Millisecond); got != want {
+			t.Errorf("duration = %v, want %v", got, want)
+		}
+		if entry["rows"] != float64(1) {
+			t.Errorf("rows = %v, want 1", entry["rows"])
+		}
+		if _, ok := entry["error"]; ok {
+			t.Errorf("unexpected error field: %v", entry["error"])
+		}
+		if strings.Contains(buf.String(), "secret-login") {
+			t.Errorf("query arguments must not be logged: %s", buf.String())
+		}
+	})
+
+	t.Run("records error", func(t *testing.T) {
+		buf.Reset()
+		ctx := tracer.TraceQueryStart(context.Background(), nil, pgx.TraceQueryStartData{SQL: "UPDATE users SET balance = 0"})
+		tracer.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{Err: errors.New("deadlock detected")})
+
+		if !strings.Contains(buf.String(), `"error":"deadlock detected"`) {
+			t.Errorf("error is not logged: %s", buf.String())
+		}
+	})
+
+	t.Run("long query is truncated", func(t *testing.T) {
+		buf.Reset()
+		ctx := tracer.TraceQueryStart(context.Background(), nil, pgx.TraceQueryStartData{SQL: "SELECT " + strings.Repeat("x", 500)})
+		tracer.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{})
+
+		var entry map[string]any
+		if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+			t.Fatalf("decode log entry: %v", err)
+		}
+		if sql, _ := entry["sql"].(string); len(sql) != queryTraceSQLLimit+len("...") {
+			t.Errorf("sql length = %d, want %d", len(sql), queryTraceSQLLimit+len("..."))
+		}
+	})
+
+	t.Run("end without start is ignored", func(t *testing.T) {
+		buf.Reset()
+		tracer.TraceQueryEnd(context.Background(), nil, pgx.TraceQueryEndData{})
+		if buf.Len() != 0 {
+			t.Errorf("unexpected log output: %s", buf.String())
+		}
+	})
+}
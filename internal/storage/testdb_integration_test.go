@@ -0,0 +1,191 @@
+//go:build integration
+// +build integration
+
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/agamariel/gofermart/internal/migrations"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// Общая для всех тестов пакета база: либо DATABASE_URI, либо контейнер,
+// поднятый при первом обращении и остановленный в TestMain.
+var (
+	testDBOnce      sync.Once
+	testDBURI       string
+	testDBErr       error
+	testDBContainer *postgres.PostgresContainer
+)
+
+// testStorage объединяет хранилища, работающие с тестовой базой.
+type testStorage struct {
+	pool        *pgxpool.Pool
+	users       *PostgresUserStorage
+	orders      *PostgresOrderStorage
+	withdrawals *PostgresWithdrawalStorage
+	audit       *PostgresAuditStorage
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+
+	if testDBContainer != nil {
+		if err := testDBContainer.Terminate(context.Background()); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to terminate postgres container: %v\n", err)
+		}
+	}
+
+	os.Exit(code)
+}
+
+// newTestStorage возвращает хранилища поверх тестовой базы с применёнными миграциями.
+// Пул закрывается автоматически по завершении теста.
+func newTestStorage(t testing.TB) *testStorage {
+	t.Helper()
+
+	pool := getTestDBPool(t)
+	t.Cleanup(pool.Close)
+
+	return &testStorage{
+		pool:        pool,
+		users:       NewPostgresUserStorage(pool),
+		orders:      NewPostgresOrderStorage(pool),
+		withdrawals: NewPostgresWithdrawalStorage(pool),
+		audit:       NewPostgresAuditStorage(pool),
+	}
+}
+
+// getTestDBPool создаёт пул соединений к тестовой базе.
+// Если DATABASE_URI не задан, поднимает Postgres в контейнере.
+func getTestDBPool(t testing.TB) *pgxpool.Pool {
+	t.Helper()
+
+	testDBOnce.Do(func() {
+		testDBURI, testDBErr = setupTestDB(context.Background())
+	})
+	if testDBErr != nil {
+		t.Fatalf("Unable to set up test database: %v", testDBErr)
+	}
+
+	pool, err := pgxpool.New(context.Background(), testDBURI)
+	if err != nil {
+		t.Fatalf("Unable to connect to database: %v", err)
+	}
+
+	return pool
+}
+
+// setupTestDB определяет адрес тестовой базы и применяет к ней миграции.
+func setupTestDB(ctx context.Context) (string, error) {
+	dbURI := os.Getenv("DATABASE_URI")
+	if dbURI == "" {
+		container, err := postgres.RunContainer(ctx,
+			testcontainers.WithImage("postgres:16-alpine"),
+			postgres.WithDatabase("gophermart_test"),
+			postgres.WithUsername("gophermart"),
+			postgres.WithPassword("gophermart"),
+			testcontainers.WithWaitStrategy(
+				wait.ForLog("database system is ready to accept connections").
+					WithOccurrence(2).
+					WithStartupTimeout(time.Minute),
+			),
+		)
+		if err != nil {
+			return "", fmt.Errorf("start postgres container: %w", err)
+		}
+		testDBContainer = container
+
+		dbURI, err = container.ConnectionString(ctx, "sslmode=disable")
+		if err != nil {
+			return "", fmt.Errorf("get container connection string: %w", err)
+		}
+	}
+
+	sqlDB, err := sql.Open("pgx", dbURI)
+	if err != nil {
+		return "", fmt.Errorf("open database: %w", err)
+	}
+	defer sqlDB.Close()
+
+	if err := migrations.Run(sqlDB); err != nil {
+		return "", err
+	}
+
+	return dbURI, nil
+}
+
+// TestMigrationsConcurrentRun проверяет, что одновременный запуск миграций
+// несколькими экземплярами на чистой базе не приводит к ошибкам.
+func TestMigrationsConcurrentRun(t *testing.T) {
+	pool := getTestDBPool(t)
+	defer pool.Close()
+	ctx := context.Background()
+
+	dbName := "migrate_" + strings.ReplaceAll(uuid.New().String(), "-", "")
+	if _, err := pool.Exec(ctx, "CREATE DATABASE "+dbName); err != nil {
+		t.Fatalf("create database: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := pool.Exec(context.Background(), "DROP DATABASE IF EXISTS "+dbName+" WITH (FORCE)"); err != nil {
+			t.Logf("drop database: %v", err)
+		}
+	})
+
+	connCfg, err := pgx.ParseConfig(testDBURI)
+	if err != nil {
+		t.Fatalf("parse database URI: %v", err)
+	}
+	connCfg.Database = dbName
+
+	const replicas = 2
+	dbs := make([]*sql.DB, replicas)
+	for i := range dbs {
+		dbs[i] = stdlib.OpenDB(*connCfg)
+		defer dbs[i].Close()
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, replicas)
+	for i := range dbs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = migrations.Run(dbs[i])
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("replica %d: Run() error = %v", i, err)
+		}
+	}
+
+	var versions [replicas]int64
+	for i, db := range dbs {
+		if versions[i], err = migrations.Version(db); err != nil {
+			t.Fatalf("replica %d: Version() error = %v", i, err)
+		}
+	}
+	if versions[0] == 0 || versions[0] != versions[1] {
+		t.Errorf("versions = %v, want equal non-zero", versions)
+	}
+	if err := migrations.Verify(dbs[0]); err != nil {
+		t.Errorf("Verify() after Run() error = %v", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/agamariel/gofermart/internal/models"
 	"github.com/google/uuid"
@@ -17,23 +18,69 @@ var (
 	ErrUserNotFound        = errors.New("user not found")
 	ErrLoginExists         = errors.New("login already exists")
 	ErrInsufficientBalance = errors.New("insufficient balance")
+	ErrAmountTooLarge      = errors.New("amount exceeds the allowed maximum")
 )
 
+// InsufficientBalanceError - запрошенная к списанию сумма превышает доступный баланс.
+// Удовлетворяет errors.Is(err, ErrInsufficientBalance).
+type InsufficientBalanceError struct {
+	Requested decimal.Decimal
+	Available decimal.Decimal
+}
+
+func (e *InsufficientBalanceError) Error() string {
+	return fmt.Sprintf("%s: requested %s, available %s", ErrInsufficientBalance, e.Requested, e.Available)
+}
+
+func (e *InsufficientBalanceError) Unwrap() error {
+	return ErrInsufficientBalance
+}
+
 // PostgresUserStorage реализует UserStorage для PostgreSQL.
 type PostgresUserStorage struct {
 	pool *pgxpool.Pool
+	userStorageOptions
+}
+
+// userStorageOptions - настройки, общие для реализаций UserStorage.
+type userStorageOptions struct {
+	// Пополнения баланса больше maxBalanceUpdate отклоняются; ноль - без ограничения.
+	maxBalanceUpdate decimal.Decimal
+}
+
+// UserStorageOption настраивает PostgresUserStorage и InMemoryUserStorage.
+type UserStorageOption func(*userStorageOptions)
+
+// WithMaxBalanceUpdate ограничивает сумму одного пополнения баланса в UpdateBalance.
+func WithMaxBalanceUpdate(maxAmount decimal.Decimal) UserStorageOption {
+	return func(o *userStorageOptions) {
+		if maxAmount.IsPositive() {
+			o.maxBalanceUpdate = maxAmount
+		}
+	}
 }
 
 // NewPostgresUserStorage создаёт новый экземпляр PostgresUserStorage.
-func NewPostgresUserStorage(pool *pgxpool.Pool) *PostgresUserStorage {
-	return &PostgresUserStorage{pool: pool}
+func NewPostgresUserStorage(pool *pgxpool.Pool, opts ...UserStorageOption) *PostgresUserStorage {
+	s := &PostgresUserStorage{pool: pool}
+	for _, opt := range opts {
+		opt(&s.userStorageOptions)
+	}
+	return s
+}
+
+// Ping проверяет доступность базы данных, с которой работает хранилище.
+func (s *PostgresUserStorage) Ping(ctx context.Context) error {
+	return wrapInternal(s.pool.Ping(ctx))
 }
 
 // Create создаёт нового пользователя.
 func (s *PostgresUserStorage) Create(ctx context.Context, user *models.User) error {
+	defer trackQuery(queryUserCreate)()
+
 	query := `
-		INSERT INTO users (id, login, password_hash, balance, withdrawn, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, NOW(), NOW())
+		INSERT INTO users (id, login, password_hash, balance, withdrawn, role, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW(), NOW())
 		RETURNING id, created_at, updated_at
 	`
 
@@ -49,6 +96,9 @@ func (s *PostgresUserStorage) Create(ctx context.Context, user *models.User) err
 	if user.Withdrawn.IsZero() {
 		user.Withdrawn = decimal.Zero
 	}
+	if user.Role == "" {
+		user.Role = models.RoleUser
+	}
 
 	err := s.pool.QueryRow(ctx, query,
 		user.ID,
@@ -56,6 +106,7 @@ func (s *PostgresUserStorage) Create(ctx context.Context, user *models.User) err
 		user.PasswordHash,
 		user.Balance,
 		user.Withdrawn,
+		user.Role,
 	).Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt)
 
 	if err != nil {
@@ -64,7 +115,7 @@ func (s *PostgresUserStorage) Create(ctx context.Context, user *models.User) err
 		if errors.As(err, &pgErr) && pgErr.Code == "23505" { // unique_violation
 			return ErrLoginExists
 		}
-		return fmt.Errorf("failed to create user: %w", err)
+		return fmt.Errorf("failed to create user: %w", wrapInternal(err))
 	}
 
 	return nil
@@ -72,8 +123,11 @@ func (s *PostgresUserStorage) Create(ctx context.Context, user *models.User) err
 
 // GetByLogin ищет пользователя по логину.
 func (s *PostgresUserStorage) GetByLogin(ctx context.Context, login string) (*models.User, error) {
+	defer trackQuery(queryUserGetByLogin)()
+
 	query := `
-		SELECT id, login, password_hash, balance, withdrawn, created_at, updated_at
+		SELECT id, login, password_hash, balance, withdrawn, role, created_at, updated_at, deactivated_at,
+			failed_login_attempts, locked_until
 		FROM users
 		WHERE login = $1
 	`
@@ -85,15 +139,19 @@ func (s *PostgresUserStorage) GetByLogin(ctx context.Context, login string) (*mo
 		&user.PasswordHash,
 		&user.Balance,
 		&user.Withdrawn,
+		&user.Role,
 		&user.CreatedAt,
 		&user.UpdatedAt,
+		&user.DeactivatedAt,
+		&user.FailedLoginAttempts,
+		&user.LockedUntil,
 	)
 
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, ErrUserNotFound
 		}
-		return nil, fmt.Errorf("failed to get user by login: %w", err)
+		return nil, fmt.Errorf("failed to get user by login: %w", wrapInternal(err))
 	}
 
 	return user, nil
@@ -101,8 +159,11 @@ func (s *PostgresUserStorage) GetByLogin(ctx context.Context, login string) (*mo
 
 // GetByID ищет пользователя по ID.
 func (s *PostgresUserStorage) GetByID(ctx context.Context, id uuid.UUID) (*models.User, error) {
+	defer trackQuery(queryUserGetByID)()
+
 	query := `
-		SELECT id, login, password_hash, balance, withdrawn, created_at, updated_at
+		SELECT id, login, password_hash, balance, withdrawn, role, created_at, updated_at, deactivated_at,
+			failed_login_attempts, locked_until
 		FROM users
 		WHERE id = $1
 	`
@@ -114,22 +175,244 @@ func (s *PostgresUserStorage) GetByID(ctx context.Context, id uuid.UUID) (*model
 		&user.PasswordHash,
 		&user.Balance,
 		&user.Withdrawn,
+		&user.Role,
 		&user.CreatedAt,
 		&user.UpdatedAt,
+		&user.DeactivatedAt,
+		&user.FailedLoginAttempts,
+		&user.LockedUntil,
 	)
 
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, ErrUserNotFound
 		}
-		return nil, fmt.Errorf("failed to get user by id: %w", err)
+		return nil, fmt.Errorf("failed to get user by id: %w", wrapInternal(err))
 	}
 
 	return user, nil
 }
 
+// GetByIDs возвращает пользователей по списку идентификаторов одним запросом.
+// Отсутствующие в базе идентификаторы в результат не попадают.
+func (s *PostgresUserStorage) GetByIDs(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]*models.User, error) {
+	defer trackQuery(queryUserGetByIDs)()
+
+	users := make(map[uuid.UUID]*models.User, len(ids))
+	if len(ids) == 0 {
+		return users, nil
+	}
+
+	query := `
+		SELECT id, login, password_hash, balance, withdrawn, role, created_at, updated_at, deactivated_at,
+			failed_login_attempts, locked_until
+		FROM users
+		WHERE id = ANY($1)
+	`
+
+	rows, err := s.pool.Query(ctx, query, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get users by ids: %w", wrapInternal(err))
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		user := &models.User{}
+		if err := rows.Scan(
+			&user.ID,
+			&user.Login,
+			&user.PasswordHash,
+			&user.Balance,
+			&user.Withdrawn,
+			&user.Role,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+			&user.DeactivatedAt,
+			&user.FailedLoginAttempts,
+			&user.LockedUntil,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", wrapInternal(err))
+		}
+		users[user.ID] = user
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate users: %w", wrapInternal(err))
+	}
+
+	return users, nil
+}
+
+// ListIDs возвращает идентификаторы всех пользователей в порядке регистрации.
+func (s *PostgresUserStorage) ListIDs(ctx context.Context) ([]uuid.UUID, error) {
+	defer trackQuery(queryUserListIDs)()
+
+	rows, err := s.pool.Query(ctx, `SELECT id FROM users ORDER BY created_at, id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list user ids: %w", wrapInternal(err))
+	}
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan user id: %w", wrapInternal(err))
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate user ids: %w", wrapInternal(err))
+	}
+
+	return ids, nil
+}
+
+// GetByIDForUpdateTx ищет пользователя по ID и блокирует его строку до конца транзакции tx,
+// так что баланс не меняется, пока вызывающий код сверяет его с начислениями и списаниями.
+func (s *PostgresUserStorage) GetByIDForUpdateTx(ctx context.Context, tx pgx.Tx, id uuid.UUID) (*models.User, error) {
+	defer trackQuery(queryUserGetForUpdateTx)()
+
+	query := `
+		SELECT id, login, password_hash, balance, withdrawn, role, created_at, updated_at, deactivated_at,
+			failed_login_attempts, locked_until
+		FROM users
+		WHERE id = $1
+		FOR UPDATE
+	`
+
+	user := &models.User{}
+	err := tx.QueryRow(ctx, query, id).Scan(
+		&user.ID,
+		&user.Login,
+		&user.PasswordHash,
+		&user.Balance,
+		&user.Withdrawn,
+		&user.Role,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&user.DeactivatedAt,
+		&user.FailedLoginAttempts,
+		&user.LockedUntil,
+	)
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to lock user: %w", wrapInternal(err))
+	}
+
+	return user, nil
+}
+
+// UpdatePasswordHash заменяет хеш пароля пользователя.
+func (s *PostgresUserStorage) UpdatePasswordHash(ctx context.Context, id uuid.UUID, hash string) error {
+	defer trackQuery(queryUserUpdatePassword)()
+
+	query := `
+		UPDATE users
+		SET password_hash = $1, updated_at = NOW()
+		WHERE id = $2
+	`
+
+	result, err := s.pool.Exec(ctx, query, hash, id)
+	if err != nil {
+		return fmt.Errorf("failed to update password: %w", wrapInternal(err))
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// RegisterLoginFailure учитывает неудачную попытку входа. Когда число попыток подряд
+// достигает maxAttempts, вход блокируется на lockFor, а счётчик обнуляется, чтобы после
+// окончания блокировки снова было доступно maxAttempts попыток.
+// Возвращает момент окончания блокировки или nil, если эта попытка вход не заблокировала.
+func (s *PostgresUserStorage) RegisterLoginFailure(ctx context.Context, id uuid.UUID, maxAttempts int, lockFor time.Duration) (*time.Time, error) {
+	defer trackQuery(queryUserLoginFailure)()
+
+	query := `
+		UPDATE users
+		SET failed_login_attempts = CASE WHEN failed_login_attempts + 1 >= $2 THEN 0 ELSE failed_login_attempts + 1 END,
+			locked_until = CASE WHEN failed_login_attempts + 1 >= $2 THEN NOW() + $3 * INTERVAL '1 millisecond' ELSE locked_until END,
+			updated_at = NOW()
+		WHERE id = $1
+		RETURNING failed_login_attempts = 0, locked_until
+	`
+
+	var locked bool
+	var lockedUntil *time.Time
+	err := s.pool.QueryRow(ctx, query, id, maxAttempts, lockFor.Milliseconds()).Scan(&locked, &lockedUntil)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to register login failure: %w", wrapInternal(err))
+	}
+
+	if !locked {
+		return nil, nil
+	}
+	return lockedUntil, nil
+}
+
+// ResetLoginFailures обнуляет счётчик неудачных попыток входа и снимает блокировку.
+func (s *PostgresUserStorage) ResetLoginFailures(ctx context.Context, id uuid.UUID) error {
+	defer trackQuery(queryUserLoginReset)()
+
+	query := `
+		UPDATE users
+		SET failed_login_attempts = 0, locked_until = NULL, updated_at = NOW()
+		WHERE id = $1
+	`
+
+	result, err := s.pool.Exec(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("failed to reset login failures: %w", wrapInternal(err))
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// Deactivate помечает пользователя деактивированным. Данные пользователя сохраняются;
+// повторная деактивация не меняет исходную дату.
+func (s *PostgresUserStorage) Deactivate(ctx context.Context, id uuid.UUID) error {
+	defer trackQuery(queryUserDeactivate)()
+
+	query := `
+		UPDATE users
+		SET deactivated_at = COALESCE(deactivated_at, NOW()), updated_at = NOW()
+		WHERE id = $1
+	`
+
+	result, err := s.pool.Exec(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("failed to deactivate user: %w", wrapInternal(err))
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
 // UpdateBalance увеличивает баланс пользователя на указанную сумму.
+// Сумма больше заданного WithMaxBalanceUpdate отклоняется с ErrAmountTooLarge.
 func (s *PostgresUserStorage) UpdateBalance(ctx context.Context, id uuid.UUID, amount decimal.Decimal) error {
+	defer trackQuery(queryUserUpdateBalance)()
+
+	if s.maxBalanceUpdate.IsPositive() && amount.GreaterThan(s.maxBalanceUpdate) {
+		return fmt.Errorf("%w: %s > %s", ErrAmountTooLarge, amount, s.maxBalanceUpdate)
+	}
+
 	query := `
 		UPDATE users
 		SET balance = balance + $1, updated_at = NOW()
@@ -138,7 +421,7 @@ func (s *PostgresUserStorage) UpdateBalance(ctx context.Context, id uuid.UUID, a
 
 	result, err := s.pool.Exec(ctx, query, amount, id)
 	if err != nil {
-		return fmt.Errorf("failed to update balance: %w", err)
+		return fmt.Errorf("failed to update balance: %w", wrapInternal(err))
 	}
 
 	if result.RowsAffected() == 0 {
@@ -152,7 +435,7 @@ func (s *PostgresUserStorage) UpdateBalance(ctx context.Context, id uuid.UUID, a
 func (s *PostgresUserStorage) Withdraw(ctx context.Context, id uuid.UUID, amount decimal.Decimal) error {
 	tx, err := s.pool.Begin(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
+		return fmt.Errorf("failed to begin transaction: %w", wrapInternal(err))
 	}
 	defer tx.Rollback(ctx)
 
@@ -161,14 +444,18 @@ func (s *PostgresUserStorage) Withdraw(ctx context.Context, id uuid.UUID, amount
 	}
 
 	if err = tx.Commit(ctx); err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
+		return fmt.Errorf("failed to commit transaction: %w", wrapInternal(err))
 	}
 
 	return nil
 }
 
 // WithdrawTx списывает средства в рамках переданной транзакции.
+// Строка пользователя блокируется первой операцией транзакции: все транзакции,
+// меняющие баланс, должны начинать с неё, чтобы не возникало взаимных блокировок.
 func (s *PostgresUserStorage) WithdrawTx(ctx context.Context, tx pgx.Tx, id uuid.UUID, amount decimal.Decimal) error {
+	defer trackQuery(queryUserWithdrawTx)()
+
 	// Проверяем текущий баланс
 	var currentBalance decimal.Decimal
 	checkQuery := `SELECT balance FROM users WHERE id = $1 FOR UPDATE`
@@ -177,12 +464,12 @@ func (s *PostgresUserStorage) WithdrawTx(ctx context.Context, tx pgx.Tx, id uuid
 		if errors.Is(err, pgx.ErrNoRows) {
 			return ErrUserNotFound
 		}
-		return fmt.Errorf("failed to check balance: %w", err)
+		return fmt.Errorf("failed to check balance: %w", wrapInternal(err))
 	}
 
 	// Проверяем достаточность средств
 	if currentBalance.LessThan(amount) {
-		return ErrInsufficientBalance
+		return &InsufficientBalanceError{Requested: amount, Available: currentBalance}
 	}
 
 	// Списываем средства
@@ -193,7 +480,81 @@ func (s *PostgresUserStorage) WithdrawTx(ctx context.Context, tx pgx.Tx, id uuid
 	`
 	_, err = tx.Exec(ctx, updateQuery, amount, id)
 	if err != nil {
-		return fmt.Errorf("failed to withdraw: %w", err)
+		return fmt.Errorf("failed to withdraw: %w", wrapInternal(err))
+	}
+
+	return nil
+}
+
+// RefundTx возвращает на баланс ранее списанную сумму и уменьшает сумму списаний
+// в рамках переданной транзакции.
+func (s *PostgresUserStorage) RefundTx(ctx context.Context, tx pgx.Tx, id uuid.UUID, amount decimal.Decimal) error {
+	defer trackQuery(queryUserRefundTx)()
+
+	query := `
+		UPDATE users
+		SET balance = balance + $1, withdrawn = withdrawn - $1, updated_at = NOW()
+		WHERE id = $2
+	`
+	result, err := tx.Exec(ctx, query, amount, id)
+	if err != nil {
+		return fmt.Errorf("failed to refund: %w", wrapInternal(err))
+	}
+	if result.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// AdjustBalanceTx изменяет баланс пользователя на delta (положительную или отрицательную)
+// в рамках переданной транзакции. Сумма списаний не меняется: это ручная корректировка,
+// а не списание. Если баланс стал бы отрицательным, возвращается InsufficientBalanceError.
+func (s *PostgresUserStorage) AdjustBalanceTx(ctx context.Context, tx pgx.Tx, id uuid.UUID, delta decimal.Decimal) error {
+	defer trackQuery(queryUserAdjustTx)()
+
+	var currentBalance decimal.Decimal
+	checkQuery := `SELECT balance FROM users WHERE id = $1 FOR UPDATE`
+	err := tx.QueryRow(ctx, checkQuery, id).Scan(&currentBalance)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrUserNotFound
+		}
+		return fmt.Errorf("failed to check balance: %w", wrapInternal(err))
+	}
+
+	if currentBalance.Add(delta).IsNegative() {
+		return &InsufficientBalanceError{Requested: delta.Neg(), Available: currentBalance}
+	}
+
+	updateQuery := `
+		UPDATE users
+		SET balance = balance + $1, updated_at = NOW()
+		WHERE id = $2
+	`
+	if _, err := tx.Exec(ctx, updateQuery, delta, id); err != nil {
+		return fmt.Errorf("failed to adjust balance: %w", wrapInternal(err))
+	}
+
+	return nil
+}
+
+// CreditTx начисляет сумму на баланс пользователя в рамках переданной транзакции.
+// Ограничение WithMaxBalanceUpdate не применяется: начисления проверяет вызывающий код.
+func (s *PostgresUserStorage) CreditTx(ctx context.Context, tx pgx.Tx, id uuid.UUID, amount decimal.Decimal) error {
+	defer trackQuery(queryUserCreditTx)()
+
+	query := `
+		UPDATE users
+		SET balance = balance + $1, updated_at = NOW()
+		WHERE id = $2
+	`
+	result, err := tx.Exec(ctx, query, amount, id)
+	if err != nil {
+		return fmt.Errorf("failed to credit balance: %w", wrapInternal(err))
+	}
+	if result.RowsAffected() == 0 {
+		return ErrUserNotFound
 	}
 
 	return nil
@@ -5,29 +5,19 @@ package storage
 
 import (
 	"context"
-	"os"
+	"database/sql"
+	"errors"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/agamariel/gofermart/internal/migrations"
 	"github.com/agamariel/gofermart/internal/models"
 	"github.com/google/uuid"
-	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/shopspring/decimal"
 )
 
-func getTestDBPool(t *testing.T) *pgxpool.Pool {
-	dbURI := os.Getenv("DATABASE_URI")
-	if dbURI == "" {
-		t.Skip("DATABASE_URI not set, skipping integration tests")
-	}
-
-	pool, err := pgxpool.New(context.Background(), dbURI)
-	if err != nil {
-		t.Fatalf("Unable to connect to database: %v", err)
-	}
-
-	return pool
-}
-
 func TestPostgresUserStorage_Create(t *testing.T) {
 	pool := getTestDBPool(t)
 	defer pool.Close()
@@ -162,6 +152,99 @@ func TestPostgresUserStorage_GetByID(t *testing.T) {
 	})
 }
 
+func TestPostgresUserStorage_GetByIDs(t *testing.T) {
+	ts := newTestStorage(t)
+	ctx := context.Background()
+
+	var present []uuid.UUID
+	for i := 0; i < 3; i++ {
+		user := &models.User{
+			ID:           uuid.New(),
+			Login:        "getbyids_" + uuid.New().String() + "@example.com",
+			PasswordHash: "hashed_password",
+		}
+		if err := ts.users.Create(ctx, user); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+		present = append(present, user.ID)
+	}
+	absent := []uuid.UUID{uuid.New(), uuid.New()}
+
+	t.Run("mixed present and absent", func(t *testing.T) {
+		ids := []uuid.UUID{present[0], absent[0], present[1], absent[1], present[2], present[0]}
+		users, err := ts.users.GetByIDs(ctx, ids)
+		if err != nil {
+			t.Fatalf("GetByIDs() error = %v", err)
+		}
+		if len(users) != len(present) {
+			t.Fatalf("got %d users, want %d", len(users), len(present))
+		}
+		for _, id := range present {
+			if user, ok := users[id]; !ok || user.ID != id {
+				t.Errorf("user %s missing from result", id)
+			}
+		}
+		for _, id := range absent {
+			if _, ok := users[id]; ok {
+				t.Errorf("unexpected user %s in result", id)
+			}
+		}
+	})
+
+	t.Run("empty ids", func(t *testing.T) {
+		users, err := ts.users.GetByIDs(ctx, nil)
+		if err != nil {
+			t.Fatalf("GetByIDs() error = %v", err)
+		}
+		if len(users) != 0 {
+			t.Errorf("got %d users, want 0", len(users))
+		}
+	})
+}
+
+func TestPostgresUserStorage_ListIDsAndLock(t *testing.T) {
+	ts := newTestStorage(t)
+	ctx := context.Background()
+
+	user := &models.User{
+		ID:           uuid.New(),
+		Login:        "listids_" + uuid.New().String() + "@example.com",
+		PasswordHash: "hashed_password",
+	}
+	if err := ts.users.Create(ctx, user); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	ids, err := ts.users.ListIDs(ctx)
+	if err != nil {
+		t.Fatalf("ListIDs() error = %v", err)
+	}
+	found := false
+	for _, id := range ids {
+		found = found || id == user.ID
+	}
+	if !found {
+		t.Errorf("ListIDs() does not contain %s", user.ID)
+	}
+
+	tx, err := ts.pool.Begin(ctx)
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	locked, err := ts.users.GetByIDForUpdateTx(ctx, tx, user.ID)
+	if err != nil {
+		t.Fatalf("GetByIDForUpdateTx() error = %v", err)
+	}
+	if locked.ID != user.ID || locked.Login != user.Login {
+		t.Errorf("GetByIDForUpdateTx() = %+v, want %s", locked, user.ID)
+	}
+	if _, err := ts.users.GetByIDForUpdateTx(ctx, tx, uuid.New()); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("GetByIDForUpdateTx() error = %v, want ErrUserNotFound", err)
+	}
+}
+
 func TestPostgresUserStorage_UpdateBalance(t *testing.T) {
 	pool := getTestDBPool(t)
 	defer pool.Close()
@@ -206,6 +289,25 @@ func TestPostgresUserStorage_UpdateBalance(t *testing.T) {
 			t.Errorf("Expected ErrUserNotFound, got %v", err)
 		}
 	})
+
+	t.Run("amount above cap", func(t *testing.T) {
+		capped := NewPostgresUserStorage(pool, WithMaxBalanceUpdate(decimal.NewFromInt(1000)))
+
+		if err := capped.UpdateBalance(ctx, user.ID, decimal.RequireFromString("1000.01")); !errors.Is(err, ErrAmountTooLarge) {
+			t.Fatalf("Expected ErrAmountTooLarge, got %v", err)
+		}
+		if err := capped.UpdateBalance(ctx, user.ID, decimal.NewFromInt(1000)); err != nil {
+			t.Fatalf("UpdateBalance() at cap error = %v", err)
+		}
+
+		retrieved, err := capped.GetByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("GetByID() error = %v", err)
+		}
+		if want := decimal.NewFromInt(1150); !retrieved.Balance.Equal(want) {
+			t.Errorf("Balance = %v, want %v", retrieved.Balance, want)
+		}
+	})
 }
 
 func TestPostgresUserStorage_Withdraw(t *testing.T) {
@@ -267,8 +369,15 @@ func TestPostgresUserStorage_Withdraw(t *testing.T) {
 
 		withdrawAmount := decimal.NewFromFloat(20)
 		err = storage.Withdraw(ctx, user.ID, withdrawAmount)
-		if err != ErrInsufficientBalance {
-			t.Errorf("Expected ErrInsufficientBalance, got %v", err)
+		if !errors.Is(err, ErrInsufficientBalance) {
+			t.Fatalf("Expected ErrInsufficientBalance, got %v", err)
+		}
+		var balanceErr *InsufficientBalanceError
+		if !errors.As(err, &balanceErr) {
+			t.Fatalf("Expected *InsufficientBalanceError, got %T", err)
+		}
+		if !balanceErr.Requested.Equal(withdrawAmount) || !balanceErr.Available.Equal(user.Balance) {
+			t.Errorf("amounts = %s/%s, want %s/%s", balanceErr.Requested, balanceErr.Available, withdrawAmount, user.Balance)
 		}
 	})
 
@@ -279,3 +388,220 @@ func TestPostgresUserStorage_Withdraw(t *testing.T) {
 		}
 	})
 }
+
+func TestEnsureCaseInsensitiveLogins(t *testing.T) {
+	ts := newTestStorage(t)
+	ctx := context.Background()
+
+	sqlDB, err := sql.Open("pgx", testDBURI)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	defer sqlDB.Close()
+
+	suffix := uuid.New().String()
+	mixed := &models.User{Login: "Mixed_" + suffix + "@Example.com", PasswordHash: "hashed_password"}
+	if err := ts.users.Create(ctx, mixed); err != nil {
+		t.Fatalf("Create user error = %v", err)
+	}
+
+	t.Run("conflicting logins are reported", func(t *testing.T) {
+		twin := &models.User{Login: strings.ToUpper(mixed.Login), PasswordHash: "hashed_password"}
+		if err := ts.users.Create(ctx, twin); err != nil {
+			t.Fatalf("Create user error = %v", err)
+		}
+		t.Cleanup(func() {
+			_, _ = ts.pool.Exec(ctx, "DELETE FROM users WHERE id = $1", twin.ID)
+		})
+
+		if err := migrations.EnsureCaseInsensitiveLogins(ctx, sqlDB); !errors.Is(err, migrations.ErrLoginCaseConflict) {
+			t.Fatalf("expected ErrLoginCaseConflict, got %v", err)
+		}
+		if _, err := ts.users.GetByLogin(ctx, mixed.Login); err != nil {
+			t.Errorf("login must stay unchanged on conflict: %v", err)
+		}
+	})
+
+	t.Run("existing logins are normalized", func(t *testing.T) {
+		if err := migrations.EnsureCaseInsensitiveLogins(ctx, sqlDB); err != nil {
+			t.Fatalf("EnsureCaseInsensitiveLogins() error = %v", err)
+		}
+
+		user, err := ts.users.GetByLogin(ctx, strings.ToLower(mixed.Login))
+		if err != nil {
+			t.Fatalf("GetByLogin() error = %v", err)
+		}
+		if user.ID != mixed.ID {
+			t.Errorf("GetByLogin() returned another user")
+		}
+
+		twin := &models.User{Login: strings.ToUpper(mixed.Login), PasswordHash: "hashed_password"}
+		if err := ts.users.Create(ctx, twin); !errors.Is(err, ErrLoginExists) {
+			t.Errorf("expected ErrLoginExists for login differing by case, got %v", err)
+		}
+	})
+
+	// Индекс по lower(login) влияет на другие тесты пакета, поэтому удаляем его
+	t.Cleanup(func() {
+		_, _ = ts.pool.Exec(ctx, "DROP INDEX IF EXISTS users_login_lower_key")
+	})
+}
+
+func TestPostgresUserStorage_ConcurrentWithdrawAndAccrual(t *testing.T) {
+	ts := newTestStorage(t)
+	ctx := context.Background()
+
+	user := &models.User{
+		Login:        "contention_" + uuid.New().String() + "@example.com",
+		PasswordHash: "hashed_password",
+		Balance:      decimal.NewFromInt(1000),
+	}
+	if err := ts.users.Create(ctx, user); err != nil {
+		t.Fatalf("Create user error = %v", err)
+	}
+
+	const rounds = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, rounds*2)
+
+	for i := 0; i < rounds; i++ {
+		wg.Add(2)
+
+		// Списание: users FOR UPDATE, затем вставка в withdrawals
+		go func() {
+			defer wg.Done()
+			tx, err := ts.pool.Begin(ctx)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer tx.Rollback(ctx)
+			if err := ts.users.WithdrawTx(ctx, tx, user.ID, decimal.NewFromInt(10)); err != nil {
+				errs <- err
+				return
+			}
+			if err := ts.withdrawals.CreateWithTx(ctx, tx, &models.Withdrawal{
+				UserID: user.ID, OrderNumber: uuid.New().String(), Sum: decimal.NewFromInt(10),
+			}); err != nil {
+				errs <- err
+				return
+			}
+			errs <- tx.Commit(ctx)
+		}()
+
+		// Начисление в том же порядке, что и в AccrualWorker: сначала users, затем orders
+		go func() {
+			defer wg.Done()
+			number := uuid.New().String()
+			if err := ts.orders.Create(ctx, &models.Order{UserID: user.ID, Number: number, Status: models.OrderStatusProcessing}); err != nil {
+				errs <- err
+				return
+			}
+			tx, err := ts.pool.Begin(ctx)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer tx.Rollback(ctx)
+			accrual := decimal.NewFromInt(5)
+			if err := ts.users.CreditTx(ctx, tx, user.ID, accrual); err != nil {
+				errs <- err
+				return
+			}
+			if _, err := ts.orders.ApplyAccrualTx(ctx, tx, number, accrual); err != nil {
+				errs <- err
+				return
+			}
+			errs <- tx.Commit(ctx)
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("transaction error = %v", err)
+		}
+	}
+
+	got, err := ts.users.GetByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetByID() error = %v", err)
+	}
+	if want := decimal.NewFromInt(1000 - rounds*10 + rounds*5); !got.Balance.Equal(want) {
+		t.Errorf("balance = %s, want %s", got.Balance, want)
+	}
+}
+
+func TestPostgresUserStorage_LoginFailures(t *testing.T) {
+	pool := getTestDBPool(t)
+	defer pool.Close()
+
+	storage := NewPostgresUserStorage(pool)
+	ctx := context.Background()
+
+	user := &models.User{
+		ID:           uuid.New(),
+		Login:        "lockout_" + uuid.New().String() + "@example.com",
+		PasswordHash: "hashed_password",
+	}
+	if err := storage.Create(ctx, user); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	const maxAttempts = 3
+	for i := 1; i < maxAttempts; i++ {
+		lockedUntil, err := storage.RegisterLoginFailure(ctx, user.ID, maxAttempts, time.Minute)
+		if err != nil {
+			t.Fatalf("RegisterLoginFailure() error = %v", err)
+		}
+		if lockedUntil != nil {
+			t.Fatalf("attempt %d locked the account", i)
+		}
+	}
+
+	retrieved, err := storage.GetByLogin(ctx, user.Login)
+	if err != nil {
+		t.Fatalf("GetByLogin() error = %v", err)
+	}
+	if retrieved.FailedLoginAttempts != maxAttempts-1 || retrieved.LockedUntil != nil {
+		t.Fatalf("attempts = %d, locked_until = %v; want %d and nil", retrieved.FailedLoginAttempts, retrieved.LockedUntil, maxAttempts-1)
+	}
+
+	lockedUntil, err := storage.RegisterLoginFailure(ctx, user.ID, maxAttempts, time.Minute)
+	if err != nil {
+		t.Fatalf("RegisterLoginFailure() error = %v", err)
+	}
+	if lockedUntil == nil {
+		t.Fatal("last attempt did not lock the account")
+	}
+	if until := time.Until(*lockedUntil); until <= 0 || until > time.Minute+5*time.Second {
+		t.Errorf("locked for %v, want about %v", until, time.Minute)
+	}
+
+	retrieved, err = storage.GetByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetByID() error = %v", err)
+	}
+	if !retrieved.IsLocked(time.Now()) || retrieved.FailedLoginAttempts != 0 {
+		t.Errorf("after lock: locked = %v, attempts = %d; want locked and 0", retrieved.IsLocked(time.Now()), retrieved.FailedLoginAttempts)
+	}
+
+	if err := storage.ResetLoginFailures(ctx, user.ID); err != nil {
+		t.Fatalf("ResetLoginFailures() error = %v", err)
+	}
+	retrieved, err = storage.GetByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetByID() error = %v", err)
+	}
+	if retrieved.LockedUntil != nil || retrieved.FailedLoginAttempts != 0 {
+		t.Errorf("after reset: attempts = %d, locked_until = %v; want 0 and nil", retrieved.FailedLoginAttempts, retrieved.LockedUntil)
+	}
+
+	if _, err := storage.RegisterLoginFailure(ctx, uuid.New(), maxAttempts, time.Minute); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("RegisterLoginFailure() for unknown user error = %v, want ErrUserNotFound", err)
+	}
+	if err := storage.ResetLoginFailures(ctx, uuid.New()); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("ResetLoginFailures() for unknown user error = %v, want ErrUserNotFound", err)
+	}
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"time"
 
 	"github.com/agamariel/gofermart/internal/models"
 	"github.com/google/uuid"
@@ -11,12 +12,25 @@ import (
 
 // MockUserStorage - мок для тестирования
 type MockUserStorage struct {
-	CreateFunc        func(ctx context.Context, user *models.User) error
-	GetByLoginFunc    func(ctx context.Context, login string) (*models.User, error)
-	GetByIDFunc       func(ctx context.Context, id uuid.UUID) (*models.User, error)
-	UpdateBalanceFunc func(ctx context.Context, id uuid.UUID, amount decimal.Decimal) error
-	WithdrawFunc      func(ctx context.Context, id uuid.UUID, amount decimal.Decimal) error
-	WithdrawTxFunc    func(ctx context.Context, tx pgx.Tx, id uuid.UUID, amount decimal.Decimal) error
+	CreateFunc          func(ctx context.Context, user *models.User) error
+	GetByLoginFunc      func(ctx context.Context, login string) (*models.User, error)
+	GetByIDFunc         func(ctx context.Context, id uuid.UUID) (*models.User, error)
+	GetByIDsFunc        func(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]*models.User, error)
+	UpdateBalanceFunc   func(ctx context.Context, id uuid.UUID, amount decimal.Decimal) error
+	WithdrawFunc        func(ctx context.Context, id uuid.UUID, amount decimal.Decimal) error
+	WithdrawTxFunc      func(ctx context.Context, tx pgx.Tx, id uuid.UUID, amount decimal.Decimal) error
+	RefundTxFunc        func(ctx context.Context, tx pgx.Tx, id uuid.UUID, amount decimal.Decimal) error
+	CreditTxFunc        func(ctx context.Context, tx pgx.Tx, id uuid.UUID, amount decimal.Decimal) error
+	AdjustBalanceTxFunc func(ctx context.Context, tx pgx.Tx, id uuid.UUID, delta decimal.Decimal) error
+
+	ListIDsFunc            func(ctx context.Context) ([]uuid.UUID, error)
+	GetByIDForUpdateTxFunc func(ctx context.Context, tx pgx.Tx, id uuid.UUID) (*models.User, error)
+
+	UpdatePasswordHashFunc func(ctx context.Context, id uuid.UUID, hash string) error
+	DeactivateFunc         func(ctx context.Context, id uuid.UUID) error
+
+	RegisterLoginFailureFunc func(ctx context.Context, id uuid.UUID, maxAttempts int, lockFor time.Duration) (*time.Time, error)
+	ResetLoginFailuresFunc   func(ctx context.Context, id uuid.UUID) error
 }
 
 func (m *MockUserStorage) Create(ctx context.Context, user *models.User) error {
@@ -40,6 +54,28 @@ func (m *MockUserStorage) GetByID(ctx context.Context, id uuid.UUID) (*models.Us
 	return nil, ErrUserNotFound
 }
 
+func (m *MockUserStorage) GetByIDs(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]*models.User, error) {
+	if m.GetByIDsFunc != nil {
+		return m.GetByIDsFunc(ctx, ids)
+	}
+	return map[uuid.UUID]*models.User{}, nil
+}
+
+func (m *MockUserStorage) ListIDs(ctx context.Context) ([]uuid.UUID, error) {
+	if m.ListIDsFunc != nil {
+		return m.ListIDsFunc(ctx)
+	}
+	return nil, nil
+}
+
+// GetByIDForUpdateTx без GetByIDForUpdateTxFunc делегирует GetByID.
+func (m *MockUserStorage) GetByIDForUpdateTx(ctx context.Context, tx pgx.Tx, id uuid.UUID) (*models.User, error) {
+	if m.GetByIDForUpdateTxFunc != nil {
+		return m.GetByIDForUpdateTxFunc(ctx, tx, id)
+	}
+	return m.GetByID(ctx, id)
+}
+
 func (m *MockUserStorage) UpdateBalance(ctx context.Context, id uuid.UUID, amount decimal.Decimal) error {
 	if m.UpdateBalanceFunc != nil {
 		return m.UpdateBalanceFunc(ctx, id, amount)
@@ -60,3 +96,52 @@ func (m *MockUserStorage) WithdrawTx(ctx context.Context, tx pgx.Tx, id uuid.UUI
 	}
 	return nil
 }
+
+func (m *MockUserStorage) RefundTx(ctx context.Context, tx pgx.Tx, id uuid.UUID, amount decimal.Decimal) error {
+	if m.RefundTxFunc != nil {
+		return m.RefundTxFunc(ctx, tx, id, amount)
+	}
+	return nil
+}
+
+func (m *MockUserStorage) CreditTx(ctx context.Context, tx pgx.Tx, id uuid.UUID, amount decimal.Decimal) error {
+	if m.CreditTxFunc != nil {
+		return m.CreditTxFunc(ctx, tx, id, amount)
+	}
+	return nil
+}
+
+func (m *MockUserStorage) AdjustBalanceTx(ctx context.Context, tx pgx.Tx, id uuid.UUID, delta decimal.Decimal) error {
+	if m.AdjustBalanceTxFunc != nil {
+		return m.AdjustBalanceTxFunc(ctx, tx, id, delta)
+	}
+	return nil
+}
+
+func (m *MockUserStorage) UpdatePasswordHash(ctx context.Context, id uuid.UUID, hash string) error {
+	if m.UpdatePasswordHashFunc != nil {
+		return m.UpdatePasswordHashFunc(ctx, id, hash)
+	}
+	return nil
+}
+
+func (m *MockUserStorage) Deactivate(ctx context.Context, id uuid.UUID) error {
+	if m.DeactivateFunc != nil {
+		return m.DeactivateFunc(ctx, id)
+	}
+	return nil
+}
+
+func (m *MockUserStorage) RegisterLoginFailure(ctx context.Context, id uuid.UUID, maxAttempts int, lockFor time.Duration) (*time.Time, error) {
+	if m.RegisterLoginFailureFunc != nil {
+		return m.RegisterLoginFailureFunc(ctx, id, maxAttempts, lockFor)
+	}
+	return nil, nil
+}
+
+func (m *MockUserStorage) ResetLoginFailures(ctx context.Context, id uuid.UUID) error {
+	if m.ResetLoginFailuresFunc != nil {
+		return m.ResetLoginFailuresFunc(ctx, id)
+	}
+	return nil
+}
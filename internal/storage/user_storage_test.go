@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestInsufficientBalanceError(t *testing.T) {
+	requested := decimal.RequireFromString("100.50")
+	available := decimal.RequireFromString("42")
+
+	// Ошибка проходит через обёртки сервисного слоя
+	err := fmt.Errorf("withdraw: %w", &InsufficientBalanceError{Requested: requested, Available: available})
+
+	if !errors.Is(err, ErrInsufficientBalance) {
+		t.Errorf("errors.Is(%v, ErrInsufficientBalance) = false", err)
+	}
+
+	var balanceErr *InsufficientBalanceError
+	if !errors.As(err, &balanceErr) {
+		t.Fatalf("errors.As() = false for %v", err)
+	}
+	if !balanceErr.Requested.Equal(requested) {
+		t.Errorf("Requested = %s, want %s", balanceErr.Requested, requested)
+	}
+	if !balanceErr.Available.Equal(available) {
+		t.Errorf("Available = %s, want %s", balanceErr.Available, available)
+	}
+}
@@ -4,18 +4,28 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/agamariel/gofermart/internal/models"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/shopspring/decimal"
 )
 
 var (
+	// ErrWithdrawalExists - пользователь уже списывал средства по этому номеру заказа.
+	// Уникальность номера проверяется в пределах пользователя.
 	ErrWithdrawalExists = errors.New("withdrawal already exists for order")
+	// ErrWithdrawalNotFound - у пользователя нет списания по этому номеру заказа
+	// (в том числе если списание уже отменено).
+	ErrWithdrawalNotFound = errors.New("withdrawal not found")
 )
 
+// withdrawalUserOrderConstraint - ограничение уникальности (user_id, order_number).
+const withdrawalUserOrderConstraint = "withdrawals_user_id_order_number_key"
+
 // PostgresWithdrawalStorage реализует WithdrawalStorage для PostgreSQL.
 type PostgresWithdrawalStorage struct {
 	pool *pgxpool.Pool
@@ -26,11 +36,16 @@ func NewPostgresWithdrawalStorage(pool *pgxpool.Pool) *PostgresWithdrawalStorage
 	return &PostgresWithdrawalStorage{pool: pool}
 }
 
+// Ping проверяет доступность базы данных, с которой работает хранилище.
+func (s *PostgresWithdrawalStorage) Ping(ctx context.Context) error {
+	return wrapInternal(s.pool.Ping(ctx))
+}
+
 // Create создаёт списание вне явной транзакции.
 func (s *PostgresWithdrawalStorage) Create(ctx context.Context, withdrawal *models.Withdrawal) error {
 	tx, err := s.pool.Begin(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to begin tx: %w", err)
+		return fmt.Errorf("failed to begin tx: %w", wrapInternal(err))
 	}
 	defer tx.Rollback(ctx)
 
@@ -39,13 +54,15 @@ func (s *PostgresWithdrawalStorage) Create(ctx context.Context, withdrawal *mode
 	}
 
 	if err := tx.Commit(ctx); err != nil {
-		return fmt.Errorf("failed to commit withdrawal: %w", err)
+		return fmt.Errorf("failed to commit withdrawal: %w", wrapInternal(err))
 	}
 	return nil
 }
 
 // CreateWithTx создаёт списание в рамках переданной транзакции.
 func (s *PostgresWithdrawalStorage) CreateWithTx(ctx context.Context, tx pgx.Tx, withdrawal *models.Withdrawal) error {
+	defer trackQuery(queryWithdrawalCreateWithTx)()
+
 	if withdrawal.ID == uuid.Nil {
 		withdrawal.ID = uuid.New()
 	}
@@ -59,17 +76,63 @@ func (s *PostgresWithdrawalStorage) CreateWithTx(ctx context.Context, tx pgx.Tx,
 	_, err := tx.Exec(ctx, query, withdrawal.ID, withdrawal.UserID, withdrawal.OrderNumber, withdrawal.Sum)
 	if err != nil {
 		var pgErr *pgconn.PgError
-		if errors.As(err, &pgErr) && pgErr.Code == "23505" { // unique_violation
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" && // unique_violation
+			pgErr.ConstraintName == withdrawalUserOrderConstraint {
 			return ErrWithdrawalExists
 		}
-		return fmt.Errorf("failed to create withdrawal: %w", err)
+		return fmt.Errorf("failed to create withdrawal: %w", wrapInternal(err))
 	}
 
 	return nil
 }
 
+// ExistsByOrder сообщает, списывал ли пользователь средства по номеру заказа, в рамках
+// переданной транзакции.
+func (s *PostgresWithdrawalStorage) ExistsByOrder(ctx context.Context, tx pgx.Tx, userID uuid.UUID, orderNumber string) (bool, error) {
+	defer trackQuery(queryWithdrawalExistsByOrder)()
+
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM withdrawals
+			WHERE user_id = $1 AND order_number = $2
+		)
+	`
+
+	var exists bool
+	if err := tx.QueryRow(ctx, query, userID, orderNumber).Scan(&exists); err != nil {
+		return false, fmt.Errorf("failed to check withdrawal: %w", wrapInternal(err))
+	}
+
+	return exists, nil
+}
+
+// DeleteByOrder удаляет списание пользователя по номеру заказа в рамках переданной транзакции
+// и возвращает удалённую запись. Если списания нет, возвращается ErrWithdrawalNotFound.
+func (s *PostgresWithdrawalStorage) DeleteByOrder(ctx context.Context, tx pgx.Tx, userID uuid.UUID, orderNumber string) (*models.Withdrawal, error) {
+	defer trackQuery(queryWithdrawalDeleteByOrder)()
+
+	query := `
+		DELETE FROM withdrawals
+		WHERE user_id = $1 AND order_number = $2
+		RETURNING id, user_id, order_number, sum, processed_at
+	`
+
+	var w models.Withdrawal
+	err := tx.QueryRow(ctx, query, userID, orderNumber).Scan(&w.ID, &w.UserID, &w.OrderNumber, &w.Sum, &w.ProcessedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrWithdrawalNotFound
+		}
+		return nil, fmt.Errorf("failed to delete withdrawal: %w", wrapInternal(err))
+	}
+
+	return &w, nil
+}
+
 // GetByUserID возвращает списания пользователя, отсортированные по времени (новые первыми).
 func (s *PostgresWithdrawalStorage) GetByUserID(ctx context.Context, userID uuid.UUID) ([]*models.Withdrawal, error) {
+	defer trackQuery(queryWithdrawalGetByUserID)()
+
 	query := `
 		SELECT id, user_id, order_number, sum, processed_at
 		FROM withdrawals
@@ -79,7 +142,7 @@ func (s *PostgresWithdrawalStorage) GetByUserID(ctx context.Context, userID uuid
 
 	rows, err := s.pool.Query(ctx, query, userID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query withdrawals: %w", err)
+		return nil, fmt.Errorf("failed to query withdrawals: %w", wrapInternal(err))
 	}
 	defer rows.Close()
 
@@ -87,14 +150,105 @@ func (s *PostgresWithdrawalStorage) GetByUserID(ctx context.Context, userID uuid
 	for rows.Next() {
 		var w models.Withdrawal
 		if err := rows.Scan(&w.ID, &w.UserID, &w.OrderNumber, &w.Sum, &w.ProcessedAt); err != nil {
-			return nil, fmt.Errorf("failed to scan withdrawal: %w", err)
+			return nil, fmt.Errorf("failed to scan withdrawal: %w", wrapInternal(err))
 		}
 		withdrawals = append(withdrawals, &w)
 	}
 
 	if rows.Err() != nil {
-		return nil, fmt.Errorf("rows error: %w", rows.Err())
+		return nil, fmt.Errorf("rows error: %w", wrapInternal(rows.Err()))
 	}
 
 	return withdrawals, nil
 }
+
+// GetByUserIDBetween возвращает списания пользователя с processed_at в диапазоне [from, to],
+// новые первыми. Нулевая граница не ограничивает диапазон с этой стороны.
+func (s *PostgresWithdrawalStorage) GetByUserIDBetween(ctx context.Context, userID uuid.UUID, from, to time.Time) ([]*models.Withdrawal, error) {
+	defer trackQuery(queryWithdrawalGetBetween)()
+
+	query := `
+		SELECT id, user_id, order_number, sum, processed_at
+		FROM withdrawals
+		WHERE user_id = $1
+			AND ($2::timestamptz IS NULL OR processed_at >= $2)
+			AND ($3::timestamptz IS NULL OR processed_at <= $3)
+		ORDER BY processed_at DESC
+	`
+
+	rows, err := s.pool.Query(ctx, query, userID, optionalTime(from), optionalTime(to))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query withdrawals: %w", wrapInternal(err))
+	}
+	defer rows.Close()
+
+	var withdrawals []*models.Withdrawal
+	for rows.Next() {
+		var w models.Withdrawal
+		if err := rows.Scan(&w.ID, &w.UserID, &w.OrderNumber, &w.Sum, &w.ProcessedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan withdrawal: %w", wrapInternal(err))
+		}
+		withdrawals = append(withdrawals, &w)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("rows error: %w", wrapInternal(rows.Err()))
+	}
+
+	return withdrawals, nil
+}
+
+// EachByUserIDBetween вызывает fn для каждого списания пользователя с processed_at в диапазоне
+// [from, to], новые первыми, по мере чтения строк из БД. Нулевая граница не ограничивает
+// диапазон; ошибка fn прерывает чтение и возвращается.
+func (s *PostgresWithdrawalStorage) EachByUserIDBetween(ctx context.Context, userID uuid.UUID, from, to time.Time, fn func(withdrawal *models.Withdrawal) error) error {
+	defer trackQuery(queryWithdrawalEachBetween)()
+
+	query := `
+		SELECT id, user_id, order_number, sum, processed_at
+		FROM withdrawals
+		WHERE user_id = $1
+			AND ($2::timestamptz IS NULL OR processed_at >= $2)
+			AND ($3::timestamptz IS NULL OR processed_at <= $3)
+		ORDER BY processed_at DESC
+	`
+
+	rows, err := s.pool.Query(ctx, query, userID, optionalTime(from), optionalTime(to))
+	if err != nil {
+		return fmt.Errorf("failed to query withdrawals: %w", wrapInternal(err))
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var w models.Withdrawal
+		if err := rows.Scan(&w.ID, &w.UserID, &w.OrderNumber, &w.Sum, &w.ProcessedAt); err != nil {
+			return fmt.Errorf("failed to scan withdrawal: %w", wrapInternal(err))
+		}
+		if err := fn(&w); err != nil {
+			return err
+		}
+	}
+
+	if rows.Err() != nil {
+		return fmt.Errorf("rows error: %w", wrapInternal(rows.Err()))
+	}
+	return nil
+}
+
+// GetTotalByUser возвращает сумму всех списаний пользователя.
+func (s *PostgresWithdrawalStorage) GetTotalByUser(ctx context.Context, userID uuid.UUID) (decimal.Decimal, error) {
+	defer trackQuery(queryWithdrawalTotalByUser)()
+
+	query := `
+		SELECT COALESCE(SUM(sum), 0)
+		FROM withdrawals
+		WHERE user_id = $1
+	`
+
+	var total decimal.Decimal
+	if err := s.pool.QueryRow(ctx, query, userID).Scan(&total); err != nil {
+		return decimal.Zero, fmt.Errorf("failed to get withdrawals total: %w", wrapInternal(err))
+	}
+
+	return total, nil
+}
@@ -0,0 +1,308 @@
+//go:build integration
+// +build integration
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/agamariel/gofermart/internal/models"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+func TestPostgresWithdrawalStorage_UniquePerUser(t *testing.T) {
+	ts := newTestStorage(t)
+	ctx := context.Background()
+
+	newUser := func() *models.User {
+		user := &models.User{
+			ID:           uuid.New(),
+			Login:        "withdrawal_" + uuid.New().String() + "@example.com",
+			PasswordHash: "hashed_password",
+		}
+		if err := ts.users.Create(ctx, user); err != nil {
+			t.Fatalf("Create user error = %v", err)
+		}
+		return user
+	}
+	userA := newUser()
+	userB := newUser()
+
+	orderNumber := uuid.New().String()
+	withdraw := func(userID uuid.UUID) error {
+		return ts.withdrawals.Create(ctx, &models.Withdrawal{
+			UserID:      userID,
+			OrderNumber: orderNumber,
+			Sum:         decimal.NewFromInt(10),
+		})
+	}
+
+	if err := withdraw(userA.ID); err != nil {
+		t.Fatalf("first withdrawal error = %v", err)
+	}
+
+	t.Run("other user can use the same order number", func(t *testing.T) {
+		if err := withdraw(userB.ID); err != nil {
+			t.Fatalf("withdrawal for another user error = %v", err)
+		}
+	})
+
+	t.Run("same user cannot withdraw twice", func(t *testing.T) {
+		if err := withdraw(userA.ID); !errors.Is(err, ErrWithdrawalExists) {
+			t.Fatalf("expected ErrWithdrawalExists, got %v", err)
+		}
+	})
+
+	t.Run("exists by order", func(t *testing.T) {
+		tx, err := ts.pool.Begin(ctx)
+		if err != nil {
+			t.Fatalf("Begin() error = %v", err)
+		}
+		defer tx.Rollback(ctx)
+
+		if exists, err := ts.withdrawals.ExistsByOrder(ctx, tx, userA.ID, orderNumber); err != nil || !exists {
+			t.Errorf("ExistsByOrder() = %v, %v; want true", exists, err)
+		}
+		if exists, err := ts.withdrawals.ExistsByOrder(ctx, tx, userA.ID, uuid.New().String()); err != nil || exists {
+			t.Errorf("ExistsByOrder() for an unknown order = %v, %v; want false", exists, err)
+		}
+	})
+}
+
+func TestPostgresWithdrawalStorage_GetTotalByUser(t *testing.T) {
+	ts := newTestStorage(t)
+	ctx := context.Background()
+
+	user := &models.User{
+		ID:           uuid.New(),
+		Login:        "withdrawal_total_" + uuid.New().String() + "@example.com",
+		PasswordHash: "hashed_password",
+	}
+	if err := ts.users.Create(ctx, user); err != nil {
+		t.Fatalf("Create user error = %v", err)
+	}
+
+	total, err := ts.withdrawals.GetTotalByUser(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetTotalByUser() error = %v", err)
+	}
+	if !total.IsZero() {
+		t.Errorf("total without withdrawals = %s, want 0", total)
+	}
+
+	for _, sum := range []string{"100.50", "20.25"} {
+		if err := ts.withdrawals.Create(ctx, &models.Withdrawal{
+			UserID:      user.ID,
+			OrderNumber: uuid.New().String(),
+			Sum:         decimal.RequireFromString(sum),
+		}); err != nil {
+			t.Fatalf("Create withdrawal error = %v", err)
+		}
+	}
+
+	total, err = ts.withdrawals.GetTotalByUser(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetTotalByUser() error = %v", err)
+	}
+	if want := decimal.RequireFromString("120.75"); !total.Equal(want) {
+		t.Errorf("total = %s, want %s", total, want)
+	}
+}
+
+func TestPostgresWithdrawalStorage_GetByUserIDBetween(t *testing.T) {
+	ts := newTestStorage(t)
+	ctx := context.Background()
+
+	user := &models.User{
+		ID:           uuid.New(),
+		Login:        "withdrawal_range_" + uuid.New().String() + "@example.com",
+		PasswordHash: "hashed_password",
+	}
+	if err := ts.users.Create(ctx, user); err != nil {
+		t.Fatalf("Create user error = %v", err)
+	}
+
+	// Create всегда ставит NOW(), поэтому даты списаний выставляем напрямую
+	march := func(day int) time.Time { return time.Date(2024, 3, day, 12, 0, 0, 0, time.UTC) }
+	for i, day := range []int{1, 10, 20} {
+		number := fmt.Sprintf("range-%d-%s", i, uuid.New())
+		if err := ts.withdrawals.Create(ctx, &models.Withdrawal{
+			UserID:      user.ID,
+			OrderNumber: number,
+			Sum:         decimal.NewFromInt(int64(day)),
+		}); err != nil {
+			t.Fatalf("Create withdrawal error = %v", err)
+		}
+		if _, err := ts.pool.Exec(ctx,
+			`UPDATE withdrawals SET processed_at = $1 WHERE user_id = $2 AND order_number = $3`,
+			march(day), user.ID, number,
+		); err != nil {
+			t.Fatalf("set processed_at error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		from, to time.Time
+		wantSums []int64
+	}{
+		{name: "no bounds", wantSums: []int64{20, 10, 1}},
+		{name: "closed range", from: march(5), to: march(15), wantSums: []int64{10}},
+		{name: "only from", from: march(10), wantSums: []int64{20, 10}},
+		{name: "only to", to: march(10), wantSums: []int64{10, 1}},
+		{name: "empty range", from: march(21), wantSums: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, err := ts.withdrawals.GetByUserIDBetween(ctx, user.ID, tt.from, tt.to)
+			if err != nil {
+				t.Fatalf("GetByUserIDBetween() error = %v", err)
+			}
+			if len(list) != len(tt.wantSums) {
+				t.Fatalf("got %d withdrawals, want %d", len(list), len(tt.wantSums))
+			}
+			for i, w := range list {
+				if !w.Sum.Equal(decimal.NewFromInt(tt.wantSums[i])) {
+					t.Errorf("withdrawal[%d].Sum = %s, want %d", i, w.Sum, tt.wantSums[i])
+				}
+			}
+
+			// Потоковое чтение отдаёт те же списания в том же порядке
+			var streamed []*models.Withdrawal
+			err = ts.withdrawals.EachByUserIDBetween(ctx, user.ID, tt.from, tt.to, func(w *models.Withdrawal) error {
+				streamed = append(streamed, w)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("EachByUserIDBetween() error = %v", err)
+			}
+			if len(streamed) != len(list) {
+				t.Fatalf("EachByUserIDBetween() streamed %d withdrawals, want %d", len(streamed), len(list))
+			}
+			for i := range list {
+				if streamed[i].OrderNumber != list[i].OrderNumber {
+					t.Errorf("streamed[%d] = %s, want %s", i, streamed[i].OrderNumber, list[i].OrderNumber)
+				}
+			}
+		})
+	}
+}
+
+func TestPostgresWithdrawalStorage_DeleteByOrderRefund(t *testing.T) {
+	ts := newTestStorage(t)
+	ctx := context.Background()
+
+	user := &models.User{
+		ID:           uuid.New(),
+		Login:        "withdrawal_reverse_" + uuid.New().String() + "@example.com",
+		PasswordHash: "hashed_password",
+	}
+	if err := ts.users.Create(ctx, user); err != nil {
+		t.Fatalf("Create user error = %v", err)
+	}
+	if err := ts.users.UpdateBalance(ctx, user.ID, decimal.NewFromInt(500)); err != nil {
+		t.Fatalf("UpdateBalance() error = %v", err)
+	}
+
+	orderNumber := uuid.New().String()
+	sum := decimal.RequireFromString("120.50")
+	tx, err := ts.pool.Begin(ctx)
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	if err := ts.users.WithdrawTx(ctx, tx, user.ID, sum); err != nil {
+		t.Fatalf("WithdrawTx() error = %v", err)
+	}
+	if err := ts.withdrawals.CreateWithTx(ctx, tx, &models.Withdrawal{UserID: user.ID, OrderNumber: orderNumber, Sum: sum}); err != nil {
+		t.Fatalf("CreateWithTx() error = %v", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+
+	// reverse удаляет списание и возвращает сумму на баланс в одной транзакции
+	reverse := func(commit bool) (*models.Withdrawal, error) {
+		tx, err := ts.pool.Begin(ctx)
+		if err != nil {
+			t.Fatalf("Begin() error = %v", err)
+		}
+		defer tx.Rollback(ctx)
+
+		w, err := ts.withdrawals.DeleteByOrder(ctx, tx, user.ID, orderNumber)
+		if err != nil {
+			return nil, err
+		}
+		if err := ts.users.RefundTx(ctx, tx, user.ID, w.Sum); err != nil {
+			return nil, err
+		}
+		if commit {
+			if err := tx.Commit(ctx); err != nil {
+				t.Fatalf("Commit() error = %v", err)
+			}
+		}
+		return w, nil
+	}
+
+	assertState := func(t *testing.T, wantBalance, wantWithdrawn string, wantWithdrawals int) {
+		t.Helper()
+		got, err := ts.users.GetByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("GetByID() error = %v", err)
+		}
+		if !got.Balance.Equal(decimal.RequireFromString(wantBalance)) {
+			t.Errorf("balance = %s, want %s", got.Balance, wantBalance)
+		}
+		if !got.Withdrawn.Equal(decimal.RequireFromString(wantWithdrawn)) {
+			t.Errorf("withdrawn = %s, want %s", got.Withdrawn, wantWithdrawn)
+		}
+		list, err := ts.withdrawals.GetByUserID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("GetByUserID() error = %v", err)
+		}
+		if len(list) != wantWithdrawals {
+			t.Errorf("withdrawals = %d, want %d", len(list), wantWithdrawals)
+		}
+	}
+
+	t.Run("rolled back reversal changes nothing", func(t *testing.T) {
+		if _, err := reverse(false); err != nil {
+			t.Fatalf("reverse() error = %v", err)
+		}
+		assertState(t, "379.50", "120.50", 1)
+	})
+
+	t.Run("reversal refunds balance", func(t *testing.T) {
+		w, err := reverse(true)
+		if err != nil {
+			t.Fatalf("reverse() error = %v", err)
+		}
+		if w.OrderNumber != orderNumber || !w.Sum.Equal(sum) {
+			t.Errorf("deleted withdrawal = %+v, want order %s sum %s", w, orderNumber, sum)
+		}
+		assertState(t, "500", "0", 0)
+	})
+
+	t.Run("second reversal is rejected", func(t *testing.T) {
+		if _, err := reverse(true); !errors.Is(err, ErrWithdrawalNotFound) {
+			t.Fatalf("reverse() error = %v, want ErrWithdrawalNotFound", err)
+		}
+		assertState(t, "500", "0", 0)
+	})
+
+	t.Run("refund of unknown user", func(t *testing.T) {
+		tx, err := ts.pool.Begin(ctx)
+		if err != nil {
+			t.Fatalf("Begin() error = %v", err)
+		}
+		defer tx.Rollback(ctx)
+		if err := ts.users.RefundTx(ctx, tx, uuid.New(), sum); !errors.Is(err, ErrUserNotFound) {
+			t.Fatalf("RefundTx() error = %v, want ErrUserNotFound", err)
+		}
+	})
+}
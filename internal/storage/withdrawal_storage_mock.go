@@ -2,10 +2,12 @@ package storage
 
 import (
 	"context"
+	"time"
 
 	"github.com/agamariel/gofermart/internal/models"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
 )
 
 // MockWithdrawalStorage - мок для тестов.
@@ -13,6 +15,12 @@ type MockWithdrawalStorage struct {
 	CreateFunc       func(ctx context.Context, w *models.Withdrawal) error
 	CreateWithTxFunc func(ctx context.Context, tx pgx.Tx, w *models.Withdrawal) error
 	GetByUserIDFunc  func(ctx context.Context, userID uuid.UUID) ([]*models.Withdrawal, error)
+	GetBetweenFunc   func(ctx context.Context, userID uuid.UUID, from, to time.Time) ([]*models.Withdrawal, error)
+	EachBetweenFunc  func(ctx context.Context, userID uuid.UUID, from, to time.Time, fn func(w *models.Withdrawal) error) error
+	DeleteFunc       func(ctx context.Context, tx pgx.Tx, userID uuid.UUID, orderNumber string) (*models.Withdrawal, error)
+	ExistsFunc       func(ctx context.Context, tx pgx.Tx, userID uuid.UUID, orderNumber string) (bool, error)
+
+	GetTotalByUserFunc func(ctx context.Context, userID uuid.UUID) (decimal.Decimal, error)
 }
 
 func (m *MockWithdrawalStorage) Create(ctx context.Context, w *models.Withdrawal) error {
@@ -35,3 +43,38 @@ func (m *MockWithdrawalStorage) GetByUserID(ctx context.Context, userID uuid.UUI
 	}
 	return []*models.Withdrawal{}, nil
 }
+
+func (m *MockWithdrawalStorage) GetByUserIDBetween(ctx context.Context, userID uuid.UUID, from, to time.Time) ([]*models.Withdrawal, error) {
+	if m.GetBetweenFunc != nil {
+		return m.GetBetweenFunc(ctx, userID, from, to)
+	}
+	return []*models.Withdrawal{}, nil
+}
+
+func (m *MockWithdrawalStorage) EachByUserIDBetween(ctx context.Context, userID uuid.UUID, from, to time.Time, fn func(w *models.Withdrawal) error) error {
+	if m.EachBetweenFunc != nil {
+		return m.EachBetweenFunc(ctx, userID, from, to, fn)
+	}
+	return nil
+}
+
+func (m *MockWithdrawalStorage) ExistsByOrder(ctx context.Context, tx pgx.Tx, userID uuid.UUID, orderNumber string) (bool, error) {
+	if m.ExistsFunc != nil {
+		return m.ExistsFunc(ctx, tx, userID, orderNumber)
+	}
+	return false, nil
+}
+
+func (m *MockWithdrawalStorage) DeleteByOrder(ctx context.Context, tx pgx.Tx, userID uuid.UUID, orderNumber string) (*models.Withdrawal, error) {
+	if m.DeleteFunc != nil {
+		return m.DeleteFunc(ctx, tx, userID, orderNumber)
+	}
+	return nil, ErrWithdrawalNotFound
+}
+
+func (m *MockWithdrawalStorage) GetTotalByUser(ctx context.Context, userID uuid.UUID) (decimal.Decimal, error) {
+	if m.GetTotalByUserFunc != nil {
+		return m.GetTotalByUserFunc(ctx, userID)
+	}
+	return decimal.Zero, nil
+}